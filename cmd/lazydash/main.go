@@ -0,0 +1,477 @@
+// Command lazydash generates Grafana dashboards from Prometheus metrics
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/hemzaz/lazydash/internal/config"
+	"github.com/hemzaz/lazydash/internal/util"
+	"github.com/hemzaz/lazydash/pkg/alerting"
+	"github.com/hemzaz/lazydash/pkg/grafana"
+	"github.com/hemzaz/lazydash/pkg/metrics"
+	"github.com/hemzaz/lazydash/pkg/prometheus"
+	"github.com/hemzaz/lazydash/pkg/query"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// version is the lazydash release reported by --version
+const version = "1.0.0"
+
+// Exit codes returned by lazydash
+const (
+	exitOK      = 0 // Dashboard generated successfully
+	exitDiff    = 1 // Dashboard differs from the existing one (diff command)
+	exitUsage   = 2 // Invalid command line
+	exitInput   = 3 // Input could not be read
+	exitParse   = 4 // Input contained no usable metrics
+	exitGrafana = 5 // Dashboard could not be sent to Grafana
+	exitOutput  = 6 // Dashboard could not be written
+	exitRules   = 7 // Alert rules failed validation
+)
+
+func main() {
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout))
+}
+
+// run executes lazydash with the given arguments and returns the exit code
+func run(args []string, stdin *os.File, stdout io.Writer) int {
+	cfg := config.New()
+
+	app := kingpin.New("lazydash", "Generate a Grafana dashboard from Prometheus metrics data via file, stdin or HTTP url")
+	app.Version(version)
+	app.HelpFlag.Short('h')
+	cfg.RegisterFlags(app)
+
+	command, err := app.Parse(args)
+	if err != nil {
+		log.Error().Err(err).Msg("Invalid command line")
+		return exitUsage
+	}
+	if command == "diff" && cfg.DiffAgainst == "" && cfg.GrafanaHost == "" {
+		log.Error().Msg("diff requires a dashboard file (--against) or a Grafana host (-H)")
+		return exitUsage
+	}
+	if cfg.AutoCorrelateThreshold < 0 || cfg.AutoCorrelateThreshold > 1 {
+		log.Error().Float64("threshold", cfg.AutoCorrelateThreshold).Msg("Correlation threshold must be between 0.0 and 1.0")
+		return exitUsage
+	}
+	if cfg.SpecFile != "" {
+		spec, err := config.LoadSpec(cfg.SpecFile)
+		if err != nil {
+			log.Error().Err(err).Msg("Invalid spec")
+			return exitUsage
+		}
+		cfg.Spec = spec
+		if spec.Filters != nil {
+			cfg.Include = append(cfg.Include, spec.Filters.Include...)
+			cfg.Exclude = append(cfg.Exclude, spec.Filters.Exclude...)
+		}
+	}
+	filters, err := metrics.NewFilterSet(cfg.Include, cfg.Exclude)
+	if err != nil {
+		log.Error().Err(err).Msg("Invalid metric filter")
+		return exitUsage
+	}
+	if cfg.GenerateAlerts && !cfg.LegacyPanels {
+		log.Warn().Msg("Legacy dashboard alerts only work on graph panels, use --legacy-panels or --grafana-alerts")
+	}
+	if cfg.GrafanaAlerts && cfg.GrafanaHost == "" {
+		log.Error().Msg("--grafana-alerts requires a Grafana host (-H)")
+		return exitUsage
+	}
+
+	registry, err := loadRegistry(cfg, stdin)
+	var parseErr *prometheus.ParseError
+	if errors.As(err, &parseErr) {
+		log.Error().Err(err).Msg("Malformed input, drop --strict-parse to skip it")
+		return exitParse
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to read metrics")
+		return exitInput
+	}
+	if registry.Count() == 0 {
+		log.Error().Msg("No metrics found in input")
+		return exitParse
+	}
+
+	registry, decisions := filters.Apply(registry)
+	if cfg.List {
+		if err := writeMetricList(stdout, decisions, cfg); err != nil {
+			log.Error().Err(err).Msg("Failed to write metric list")
+			return exitOutput
+		}
+		return exitOK
+	}
+	if registry.Count() == 0 {
+		log.Error().Int("metrics", len(decisions)).Msg("Filters dropped every metric, see --list")
+		return exitParse
+	}
+
+	queryBuilder := query.NewBuilder(cfg)
+	dashboard := grafana.NewDashboard(cfg.Title)
+	dashboard.UID = cfg.DashboardUID
+	if dashboard.UID == "" {
+		dashboard.UID = grafana.StableUID(cfg.Title)
+	}
+	dashboard.Generate(registry, cfg, queryBuilder)
+	if command == "diff" {
+		return diffDashboard(cfg, dashboard, stdout)
+	}
+
+	if cfg.AlertRules != "" {
+		if code := writeAlertRules(cfg, registry); code != exitOK {
+			return code
+		}
+	}
+
+	if cfg.GrafanaHost != "" {
+		client, err := grafana.NewClientFromConfig(cfg)
+		if err != nil {
+			log.Error().Err(err).Str("host", cfg.GrafanaHost).Msg("Invalid Grafana connection settings")
+			return exitGrafana
+		}
+		options := grafana.SaveOptions{
+			Folder:  cfg.FolderConfig,
+			Message: fmt.Sprintf("%s %s: generated from %s", grafana.CommitMessagePrefix, version, strings.Join(inputSources(cfg), ", ")),
+			Force:   cfg.Force,
+		}
+		if _, err := client.PostDashboard(context.Background(), dashboard, options); err != nil {
+			if errors.Is(err, grafana.ErrModified) || errors.Is(err, grafana.ErrConflict) {
+				log.Error().Err(err).Str("uid", dashboard.UID).Msg("Dashboard was changed in Grafana, use --force to overwrite it")
+			} else {
+				log.Error().Err(err).Msg("Failed to post dashboard")
+			}
+			return exitGrafana
+		}
+		return provisionGrafanaAlerts(cfg, client, registry, dashboard)
+	}
+
+	if code := provisionGrafanaAlerts(cfg, nil, registry, dashboard); code != exitOK {
+		return code
+	}
+
+	if err := dashboard.WriteJSON(stdout, cfg.Pretty); err != nil {
+		log.Error().Err(err).Msg("Failed to write dashboard")
+		return exitOutput
+	}
+
+	return exitOK
+}
+
+// writeMetricList prints every metric with whether it is shown and why not
+func writeMetricList(w io.Writer, decisions []metrics.FilterDecision, cfg *config.Config) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "METRIC\tTYPE\tSTATUS\tREASON")
+
+	kept := 0
+	for _, decision := range decisions {
+		name := decision.Metric.Name()
+		status, reason := "kept", decision.Reason
+		if !decision.Kept {
+			status = "dropped"
+		} else if cfg.Spec.Hidden(name) {
+			status, reason = "dropped", "hidden by spec"
+		} else {
+			kept++
+		}
+		mtype := decision.Metric.Type()
+		if decision.Metric.TypeInferred() {
+			mtype += " (inferred)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", name, mtype, status, reason)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	log.Info().Int("kept", kept).Int("metrics", len(decisions)).Msg("Listed metrics")
+	return nil
+}
+
+// diffDashboard compares the generated dashboard with the existing one and
+// writes the differences, returning exitDiff when there are any
+func diffDashboard(cfg *config.Config, dashboard *grafana.Dashboard, stdout io.Writer) int {
+	existing, code := existingDashboard(cfg, dashboard.UID)
+	if code != exitOK {
+		return code
+	}
+
+	diff := grafana.Diff(existing, dashboard)
+	var err error
+	if cfg.DiffOutput == "json" {
+		err = diff.WriteJSON(stdout, cfg.Pretty)
+	} else {
+		err = diff.WriteText(stdout)
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to write diff")
+		return exitOutput
+	}
+
+	if diff.Empty() {
+		log.Info().Str("uid", dashboard.UID).Msg("Dashboard is up to date")
+		return exitOK
+	}
+	log.Info().Int("added", diff.Added).Int("removed", diff.Removed).Int("changed", diff.Changed).Msg("Dashboard differs")
+	return exitDiff
+}
+
+// existingDashboard reads the dashboard to compare with from --against, or
+// gets it from Grafana by UID. A dashboard missing in Grafana has no panels.
+func existingDashboard(cfg *config.Config, uid string) (*grafana.Dashboard, int) {
+	if cfg.DiffAgainst != "" {
+		data, err := util.LoadFromFile(cfg.DiffAgainst)
+		if err != nil {
+			log.Error().Err(err).Str("file", cfg.DiffAgainst).Msg("Failed to read dashboard")
+			return nil, exitInput
+		}
+		existing, err := grafana.ParseDashboard(data)
+		if err != nil {
+			log.Error().Err(err).Str("file", cfg.DiffAgainst).Msg("Invalid dashboard")
+			return nil, exitInput
+		}
+		return existing, exitOK
+	}
+
+	client, err := grafana.NewClientFromConfig(cfg)
+	if err != nil {
+		log.Error().Err(err).Str("host", cfg.GrafanaHost).Msg("Invalid Grafana connection settings")
+		return nil, exitGrafana
+	}
+	existing, _, err := client.GetDashboard(context.Background(), uid)
+	if errors.Is(err, grafana.ErrNotFound) {
+		log.Warn().Str("uid", uid).Msg("Dashboard not found in Grafana, every panel is new")
+		return &grafana.Dashboard{}, exitOK
+	}
+	if err != nil {
+		log.Error().Err(err).Str("uid", uid).Msg("Failed to get dashboard")
+		return nil, exitGrafana
+	}
+	return existing, exitOK
+}
+
+// writeAlertRules validates and writes the Prometheus alerting rules
+func writeAlertRules(cfg *config.Config, registry *metrics.Registry) int {
+	rules := alerting.GenerateRules(registry, cfg)
+	if err := alerting.Validate(rules); err != nil {
+		log.Error().Err(err).Msg("Generated alert rules are invalid")
+		return exitRules
+	}
+
+	f, err := os.Create(cfg.AlertRules)
+	if err != nil {
+		log.Error().Err(err).Str("file", cfg.AlertRules).Msg("Failed to create alert rules file")
+		return exitOutput
+	}
+	defer f.Close()
+
+	if err := rules.WriteYAML(f); err != nil {
+		log.Error().Err(err).Str("file", cfg.AlertRules).Msg("Failed to write alert rules")
+		return exitOutput
+	}
+
+	log.Info().Str("file", cfg.AlertRules).Int("rules", len(rules.Groups[0].Rules)).Msg("Wrote alert rules")
+	return exitOK
+}
+
+// provisionGrafanaAlerts posts the unified alert rules to Grafana with
+// --grafana-alerts and writes them with --grafana-alerts-file. Rules link to
+// their panels when the dashboard was posted first; client is nil without -H.
+func provisionGrafanaAlerts(cfg *config.Config, client *grafana.Client, registry *metrics.Registry, dashboard *grafana.Dashboard) int {
+	if !cfg.GrafanaAlerts && cfg.GrafanaAlertsFile == "" {
+		return exitOK
+	}
+	rules := grafana.GenerateAlertRules(registry, cfg, dashboard)
+
+	if cfg.GrafanaAlerts && client != nil {
+		if err := client.PostAlertRules(context.Background(), rules, cfg); err != nil {
+			log.Error().Err(err).Msg("Failed to provision alert rules")
+			return exitGrafana
+		}
+	}
+
+	if cfg.GrafanaAlertsFile != "" {
+		f, err := os.Create(cfg.GrafanaAlertsFile)
+		if err != nil {
+			log.Error().Err(err).Str("file", cfg.GrafanaAlertsFile).Msg("Failed to create alert provisioning file")
+			return exitOutput
+		}
+		defer f.Close()
+
+		if err := grafana.NewAlertProvisioning(rules, cfg).WriteYAML(f); err != nil {
+			log.Error().Err(err).Str("file", cfg.GrafanaAlertsFile).Msg("Failed to write alert provisioning file")
+			return exitOutput
+		}
+		log.Info().Str("file", cfg.GrafanaAlertsFile).Int("rules", len(rules)).Msg("Wrote alert provisioning file")
+	}
+	return exitOK
+}
+
+// inputSources names the inputs of the dashboard for its version message
+func inputSources(cfg *config.Config) []string {
+	if cfg.PrometheusURL != "" {
+		return []string{cfg.PrometheusURL + cfg.Match}
+	}
+	sources := append(append([]string(nil), cfg.URLs...), cfg.Files...)
+	if len(sources) == 0 {
+		return []string{"stdin"}
+	}
+	return sources
+}
+
+// loadRegistry reads the metrics of the configured inputs into one registry.
+// --prometheus-url takes precedence, otherwise every --url and --file is
+// merged, each metric tagged with the inputs it was read from.
+func loadRegistry(cfg *config.Config, stdin *os.File) (*metrics.Registry, error) {
+	if cfg.PrometheusURL != "" {
+		if !util.IsURL(cfg.PrometheusURL) {
+			return nil, fmt.Errorf("invalid Prometheus URL: %s", cfg.PrometheusURL)
+		}
+		return prometheus.LoadFromAPI(cfg)
+	}
+
+	inputs, err := loadInputs(cfg, stdin)
+	if err != nil {
+		return nil, err
+	}
+
+	registry := metrics.NewRegistry()
+	for _, in := range inputs {
+		parsed, err := in.parse(cfg)
+		var report *prometheus.ParseReport
+		if errors.As(err, &report) {
+			for _, skipped := range report.Errors {
+				log.Warn().Err(skipped.Err).Str("source", in.source).Int("line", skipped.Line).Int("column", skipped.Column).
+					Str("text", skipped.Text).Str("family", skipped.Family).Msg("Skipped malformed metric family")
+			}
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", in.source, err)
+		}
+		if in.earlier != nil {
+			inferFromScrapes(parsed, in, cfg)
+		}
+		parsed.TagSource(in.source)
+		for _, conflict := range registry.Merge(parsed) {
+			if conflict.Field == "type" && cfg.StrictMerge {
+				return nil, fmt.Errorf("inputs disagree on %s", conflict)
+			}
+			log.Warn().Str("metric", conflict.Metric).Str(conflict.Field, conflict.Kept).
+				Str("dropped", conflict.Dropped).Str("source", conflict.Source).
+				Msgf("Inputs disagree on the %s, keeping the first", conflict.Field)
+		}
+	}
+	if len(inputs) > 1 {
+		log.Info().Int("inputs", len(inputs)).Int("metrics", registry.Count()).Msg("Merged inputs")
+	}
+	return registry, nil
+}
+
+// inferFromScrapes types the untyped metrics of a target by comparing them
+// with its earlier scrape
+func inferFromScrapes(registry *metrics.Registry, in input, cfg *config.Config) {
+	earlier, err := prometheus.ParseResponse(in.earlier, in.contentType, cfg)
+	if earlier == nil {
+		log.Warn().Err(err).Str("source", in.source).Msg("Failed to parse the earlier scrape, types are inferred from names only")
+		return
+	}
+	for _, metric := range metrics.InferFromScrapes(registry, earlier) {
+		log.Debug().Str("metric", metric.Name()).Str("type", metric.Type()).Str("reason", metric.TypeReason()).
+			Float64("confidence", metric.TypeConfidence()).Msg("Inferred metric type")
+	}
+}
+
+// input is the exposition of a single source. Fetched targets are held in
+// memory, files and stdin are streamed when parsed.
+type input struct {
+	source      string
+	data        []byte
+	contentType string // Content-Type of a fetched target, naming its format
+	earlier     []byte // An earlier scrape of a target with --infer-interval
+	path        string
+	reader      io.Reader
+}
+
+// parse reads the metrics of the input
+func (in input) parse(cfg *config.Config) (*metrics.Registry, error) {
+	switch {
+	case in.reader != nil:
+		return prometheus.ParseReaderWithConfig(in.reader, cfg)
+	case in.path != "":
+		f, err := os.Open(in.path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return prometheus.ParseReaderWithConfig(f, cfg)
+	default:
+		return prometheus.ParseResponse(in.data, in.contentType, cfg)
+	}
+}
+
+// loadInputs fetches every --url concurrently and then checks every --file.
+// Targets that fail are skipped as long as another input remains. Stdin is
+// only read when neither is given.
+func loadInputs(cfg *config.Config, stdin *os.File) ([]input, error) {
+	var inputs []input
+	if len(cfg.URLs) > 0 {
+		fetcher := util.NewFetcher(cfg.FetchWorkers, cfg.FetchTimeout, cfg.FetchRetries, cfg.InsecureSkipVerify)
+		var errs []error
+		results := fetcher.FetchAll(context.Background(), cfg.URLs)
+		var earlier []util.FetchResult
+		if cfg.InferInterval > 0 {
+			log.Info().Dur("interval", cfg.InferInterval).Msg("Waiting to scrape the targets again")
+			time.Sleep(cfg.InferInterval)
+			earlier, results = results, fetcher.FetchAll(context.Background(), cfg.URLs)
+		}
+		for i, result := range results {
+			if result.Err != nil {
+				log.Warn().Err(result.Err).Str("url", result.URL).Int("attempts", result.Attempts).Msg("Failed to fetch target")
+				errs = append(errs, result.Err)
+				continue
+			}
+			in := input{source: result.URL, data: result.Data, contentType: result.ContentType}
+			if earlier != nil && earlier[i].Err == nil {
+				in.earlier = earlier[i].Data
+			}
+			inputs = append(inputs, in)
+		}
+		if len(inputs) == 0 && len(cfg.Files) == 0 {
+			return nil, errors.Join(errs...)
+		}
+		if len(errs) > 0 {
+			log.Warn().Int("failed", len(errs)).Int("targets", len(cfg.URLs)).Msg("Continuing without the failed targets")
+		}
+	}
+	for _, file := range cfg.Files {
+		if _, err := os.Stat(file); err != nil {
+			return nil, err
+		}
+		inputs = append(inputs, input{source: file, path: file})
+	}
+	if len(inputs) > 0 {
+		return inputs, nil
+	}
+
+	if !cfg.Stdin {
+		return nil, fmt.Errorf("no input: use --url, --file or --stdin")
+	}
+	reader, err := util.OpenPipe(stdin)
+	if err != nil {
+		return nil, err
+	}
+	if reader == nil {
+		return nil, fmt.Errorf("no input: use --url, --file or pipe metrics to stdin")
+	}
+	return []input{{source: "stdin", reader: reader}}, nil
+}
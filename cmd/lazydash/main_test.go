@@ -0,0 +1,571 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// emptyStdin returns a file that behaves like a terminal-less, empty stdin
+func emptyStdin(t *testing.T) *os.File {
+	t.Helper()
+	f, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", os.DevNull, err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+// assertGolden compares got with the named file in testdata
+func assertGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatalf("Failed to update golden file %s: %v", path, err)
+		}
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read golden file %s: %v", path, err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Output does not match %s; run go test -update to regenerate", path)
+	}
+}
+
+func TestRunGolden(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		golden string
+	}{
+		{
+			name:   "Default",
+			args:   []string{"-f", "../../promdata.txt", "-p"},
+			golden: "promdata.json",
+		},
+		{
+			name:   "Graphs only with table legend",
+			args:   []string{"-f", "../../promdata.txt", "-p", "--table", "--no-stat-for-gauges", "--viz-gauges=graph", "-t", "Docker"},
+			golden: "promdata_table.json",
+		},
+		{
+			name:   "Auto-correlated",
+			args:   []string{"-f", "../../promdata.txt", "-p", "--auto-correlate"},
+			golden: "promdata_correlated.json",
+		},
+		{
+			name:   "OpenMetrics",
+			args:   []string{"-f", "testdata/openmetrics.txt", "-p"},
+			golden: "openmetrics.json",
+		},
+		{
+			name:   "Grouped by label",
+			args:   []string{"-f", "../../promdata.txt", "-p", "--group-by=action", "--panels-per-row=3"},
+			golden: "promdata_grouped.json",
+		},
+		{
+			name:   "Legacy graph panels",
+			args:   []string{"-f", "../../promdata.txt", "-p", "--table", "--legacy-panels"},
+			golden: "promdata_legacy.json",
+		},
+		{
+			name:   "Spec rules",
+			args:   []string{"-f", "../../promdata.txt", "-p", "--spec", "testdata/spec.yaml"},
+			golden: "promdata_spec.json",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if code := run(tt.args, emptyStdin(t), &out); code != exitOK {
+				t.Fatalf("run(%v) = %d; want %d", tt.args, code, exitOK)
+			}
+			assertGolden(t, tt.golden, out.Bytes())
+		})
+	}
+}
+
+func TestRunInputPrecedence(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("# HELP from_url Served over HTTP\n# TYPE from_url gauge\nfrom_url 1\n"))
+	}))
+	defer server.Close()
+
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/labels":
+			w.Write([]byte(`{"status":"success","data":["__name__","job"]}`))
+		case "/api/v1/label/__name__/values":
+			w.Write([]byte(`{"status":"success","data":["from_api"]}`))
+		case "/api/v1/metadata":
+			w.Write([]byte(`{"status":"success","data":{"from_api":[{"type":"gauge","help":"Listed by the API","unit":""}]}}`))
+		case "/api/v1/series":
+			w.Write([]byte(`{"status":"success","data":[{"__name__":"from_api","job":"node"}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer api.Close()
+
+	stdin, err := os.CreateTemp("", "stdin")
+	if err != nil {
+		t.Fatalf("Failed to create temporary file: %v", err)
+	}
+	defer os.Remove(stdin.Name())
+	defer stdin.Close()
+	stdin.WriteString("# HELP from_stdin Piped in\n# TYPE from_stdin gauge\nfrom_stdin 1\n")
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"Prometheus API wins over URL", []string{"--prometheus-url", api.URL, "--url", server.URL}, "from api"},
+		{"URL merged with file", []string{"--url", server.URL, "-f", "../../promdata.txt"}, "from url"},
+		{"File merged with URL", []string{"--url", server.URL, "-f", "../../promdata.txt"}, "builder builds failed total"},
+		{"File wins over stdin", []string{"-f", "../../promdata.txt"}, "builder builds failed total"},
+		{"Stdin as fallback", []string{}, "from stdin"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdin.Seek(0, 0)
+			var out bytes.Buffer
+			if code := run(tt.args, stdin, &out); code != exitOK {
+				t.Fatalf("run(%v) = %d; want %d", tt.args, code, exitOK)
+			}
+			if !bytes.Contains(out.Bytes(), []byte(tt.want)) {
+				t.Errorf("Expected output to contain %q", tt.want)
+			}
+		})
+	}
+}
+
+func TestRunExitCodes(t *testing.T) {
+	empty, err := os.CreateTemp("", "empty")
+	if err != nil {
+		t.Fatalf("Failed to create temporary file: %v", err)
+	}
+	defer os.Remove(empty.Name())
+	empty.WriteString("# just a comment\n")
+	empty.Close()
+
+	grafana := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"message":"database is locked"}`, http.StatusInternalServerError)
+	}))
+	defer grafana.Close()
+
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"Unknown flag", []string{"--no-such-flag"}, exitUsage},
+		{"Correlation threshold out of range", []string{"--correlation-threshold=1.5"}, exitUsage},
+		{"Missing file", []string{"-f", "testdata/does-not-exist.txt"}, exitInput},
+		{"Invalid Prometheus URL", []string{"--prometheus-url", "not-a-url"}, exitInput},
+		{"No input", []string{}, exitInput},
+		{"No metrics", []string{"-f", empty.Name()}, exitParse},
+		{"Missing spec", []string{"-f", "../../promdata.txt", "--spec", "testdata/does-not-exist.yaml"}, exitUsage},
+		{"Invalid filter", []string{"-f", "../../promdata.txt", "--include", "/(unclosed/"}, exitUsage},
+		{"Everything filtered out", []string{"-f", "../../promdata.txt", "--include", "no_such_metric"}, exitParse},
+		{"Invalid Grafana URL", []string{"-f", "../../promdata.txt", "-H", "not-a-url"}, exitGrafana},
+		{"Grafana error", []string{"-f", "../../promdata.txt", "-H", grafana.URL, "--grafana-retries=0"}, exitGrafana},
+		{"Missing Grafana CA", []string{"-f", "../../promdata.txt", "-H", grafana.URL, "--grafana-ca", "testdata/does-not-exist.pem"}, exitGrafana},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if code := run(tt.args, emptyStdin(t), &out); code != tt.want {
+				t.Errorf("run(%v) = %d; want %d", tt.args, code, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunAlertRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yml")
+
+	var out bytes.Buffer
+	args := []string{"-f", "../../promdata.txt", "--alert-rules", path}
+	if code := run(args, emptyStdin(t), &out); code != exitOK {
+		t.Fatalf("run(%v) = %d; want %d", args, code, exitOK)
+	}
+
+	rules, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read alert rules: %v", err)
+	}
+	for _, want := range []string{"groups:\n", "alert: BuilderBuildsFailedTotalHigh", "severity: critical", "for: 5m"} {
+		if !bytes.Contains(rules, []byte(want)) {
+			t.Errorf("Expected alert rules to contain %q", want)
+		}
+	}
+	if out.Len() == 0 {
+		t.Errorf("Expected the dashboard to be written as well")
+	}
+}
+
+func TestRunGrafanaAlerts(t *testing.T) {
+	t.Run("Provisioning file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "alerts.yml")
+
+		var out bytes.Buffer
+		args := []string{"-f", "../../promdata.txt", "--grafana-alerts-file", path, "--datasource-uid", "prom"}
+		if code := run(args, emptyStdin(t), &out); code != exitOK {
+			t.Fatalf("run(%v) = %d; want %d", args, code, exitOK)
+		}
+
+		file, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read alert provisioning file: %v", err)
+		}
+		for _, want := range []string{"apiVersion: 1\n", "folder: Generated alerts", "title: BuilderBuildsFailedTotalHigh (critical)", "datasourceUid: prom\n"} {
+			if !bytes.Contains(file, []byte(want)) {
+				t.Errorf("Expected alert provisioning file to contain %q", want)
+			}
+		}
+		if out.Len() == 0 {
+			t.Errorf("Expected the dashboard to be written as well")
+		}
+	})
+
+	t.Run("Without Grafana host", func(t *testing.T) {
+		args := []string{"-f", "../../promdata.txt", "--grafana-alerts"}
+		if code := run(args, emptyStdin(t), &bytes.Buffer{}); code != exitUsage {
+			t.Errorf("run(%v) = %d; want %d", args, code, exitUsage)
+		}
+	})
+}
+
+func TestRunFilters(t *testing.T) {
+	t.Run("List", func(t *testing.T) {
+		var out bytes.Buffer
+		args := []string{"-f", "../../promdata.txt", "--include", "go_*", "--exclude", "type:counter", "--list"}
+		if code := run(args, emptyStdin(t), &out); code != exitOK {
+			t.Fatalf("run(%v) = %d; want %d", args, code, exitOK)
+		}
+
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		if fields := strings.Fields(lines[0]); strings.Join(fields, " ") != "METRIC TYPE STATUS REASON" {
+			t.Errorf("Unexpected header %q", lines[0])
+		}
+		want := map[string]string{
+			"go_goroutines":                 "gauge kept included by name:go_*",
+			"go_memstats_alloc_bytes_total": "counter dropped excluded by type:counter",
+			"builder_builds_failed_total":   "counter dropped excluded by type:counter",
+			"engine_daemon_engine_info":     "gauge dropped matches no include filter",
+		}
+		for _, line := range lines[1:] {
+			fields := strings.Fields(line)
+			if reason, ok := want[fields[0]]; ok {
+				if got := strings.Join(fields[1:], " "); got != reason {
+					t.Errorf("%s: got %q; want %q", fields[0], got, reason)
+				}
+				delete(want, fields[0])
+			}
+		}
+		for name := range want {
+			t.Errorf("Expected %s to be listed", name)
+		}
+	})
+
+	t.Run("Dashboard", func(t *testing.T) {
+		var out bytes.Buffer
+		args := []string{"-f", "../../promdata.txt", "--include", "type:histogram", "--exclude", "etcd_*"}
+		if code := run(args, emptyStdin(t), &out); code != exitOK {
+			t.Fatalf("run(%v) = %d; want %d", args, code, exitOK)
+		}
+		if !strings.Contains(out.String(), "engine_daemon_container_actions_seconds") {
+			t.Errorf("Expected histogram panels in the dashboard")
+		}
+		for _, dropped := range []string{"etcd_disk_wal_fsync_duration_seconds", "go_goroutines"} {
+			if strings.Contains(out.String(), dropped) {
+				t.Errorf("Expected %s to be filtered out", dropped)
+			}
+		}
+	})
+}
+
+func TestRunMergedInputs(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+		return path
+	}
+	a := write("a.txt", "# HELP jobs_queued Queued jobs\n# TYPE jobs_queued gauge\njobs_queued{queue=\"a\"} 1\n# TYPE only_in_a gauge\nonly_in_a 1\n")
+	b := write("b.txt", "# HELP jobs_queued Jobs waiting\n# TYPE jobs_queued counter\njobs_queued{queue=\"b\"} 2\n")
+
+	t.Run("Rows per source", func(t *testing.T) {
+		var out bytes.Buffer
+		args := []string{"-f", a, "-f", b, "--group-by-source"}
+		if code := run(args, emptyStdin(t), &out); code != exitOK {
+			t.Fatalf("run(%v) = %d; want %d", args, code, exitOK)
+		}
+		for _, want := range []string{`"title":"` + a + `"`, `"title":"` + a + ", " + b + `"`, "Queued jobs"} {
+			if !strings.Contains(out.String(), want) {
+				t.Errorf("Expected output to contain %s", want)
+			}
+		}
+	})
+
+	t.Run("Failed target", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		defer server.Close()
+
+		var out bytes.Buffer
+		args := []string{"--url", server.URL, "-f", a}
+		if code := run(args, emptyStdin(t), &out); code != exitOK {
+			t.Fatalf("run(%v) = %d; want %d", args, code, exitOK)
+		}
+		if !strings.Contains(out.String(), "Queued jobs") {
+			t.Errorf("Expected the metrics of %s", a)
+		}
+
+		args = []string{"--url", server.URL, "--url", server.URL + "/other"}
+		if code := run(args, emptyStdin(t), &bytes.Buffer{}); code != exitInput {
+			t.Errorf("run(%v) = %d; want %d", args, code, exitInput)
+		}
+	})
+
+	t.Run("Strict merge", func(t *testing.T) {
+		args := []string{"-f", a, "-f", b, "--strict-merge"}
+		if code := run(args, emptyStdin(t), &bytes.Buffer{}); code != exitInput {
+			t.Errorf("run(%v) = %d; want %d", args, code, exitInput)
+		}
+	})
+}
+
+func TestRunInferTypes(t *testing.T) {
+	var scrapes int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&scrapes, 1)
+		fmt.Fprintf(w, "jobs_done %d\nworkers_busy %d\nrequests_total %d\nbuild_number 7\n", 10*n, 10-n, n)
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	args := []string{"--url", server.URL, "--infer-interval", "10ms", "--list"}
+	if code := run(args, emptyStdin(t), &out); code != exitOK {
+		t.Fatalf("run(%v) = %d; want %d", args, code, exitOK)
+	}
+	if scrapes != 2 {
+		t.Errorf("Expected the target to be scraped twice, got %d", scrapes)
+	}
+
+	want := map[string]string{
+		"jobs_done":      "counter (inferred)",
+		"workers_busy":   "gauge (inferred)",
+		"requests_total": "counter (inferred)",
+		"build_number":   "kept",
+	}
+	for _, line := range strings.Split(out.String(), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 1 {
+			if mtype, ok := want[fields[0]]; ok {
+				if got := strings.Join(fields[1:], " "); !strings.HasPrefix(got, mtype) {
+					t.Errorf("%s: got %q; want %q", fields[0], got, mtype)
+				}
+				delete(want, fields[0])
+			}
+		}
+	}
+	for name := range want {
+		t.Errorf("Expected %s to be listed", name)
+	}
+}
+
+func TestRunGrafanaEdited(t *testing.T) {
+	var posted []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/dashboards/db":
+			var body map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&body)
+			posted = append(posted, body)
+			w.Write([]byte(`{"id":1,"uid":"fleet","url":"/d/fleet","version":3}`))
+		case strings.HasSuffix(r.URL.Path, "/versions"):
+			w.Write([]byte(`[{"version":2,"createdBy":"alice","message":"Moved the CPU panel"}]`))
+		case r.URL.Path == "/api/dashboards/uid/fleet":
+			w.Write([]byte(`{"dashboard":{"id":1,"uid":"fleet","version":2},"meta":{"folderUid":"ops"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	args := []string{"-f", "../../promdata.txt", "-H", server.URL, "--uid", "fleet"}
+	if code := run(args, emptyStdin(t), &bytes.Buffer{}); code != exitGrafana {
+		t.Errorf("run(%v) = %d; want %d", args, code, exitGrafana)
+	}
+	if len(posted) != 0 {
+		t.Fatalf("Expected the edited dashboard to be left alone")
+	}
+
+	args = append(args, "--force")
+	if code := run(args, emptyStdin(t), &bytes.Buffer{}); code != exitOK {
+		t.Fatalf("run(%v) = %d; want %d", args, code, exitOK)
+	}
+	if len(posted) != 1 || posted[0]["overwrite"] != true || posted[0]["folderUid"] != "ops" {
+		t.Errorf("Expected a forced save in the same folder, got %+v", posted)
+	}
+	if message, _ := posted[0]["message"].(string); message != "lazydash "+version+": generated from ../../promdata.txt" {
+		t.Errorf("Unexpected version message %q", message)
+	}
+}
+
+func TestRunStableUID(t *testing.T) {
+	absolute, err := filepath.Abs("../../promdata.txt")
+	if err != nil {
+		t.Fatalf("Failed to resolve the input path: %v", err)
+	}
+	copied := filepath.Join(t.TempDir(), "metrics.txt")
+	data, _ := os.ReadFile(absolute)
+	os.WriteFile(copied, data, 0644)
+
+	uid := func(args ...string) string {
+		t.Helper()
+		var out bytes.Buffer
+		if code := run(args, emptyStdin(t), &out); code != exitOK {
+			t.Fatalf("run(%v) = %d; want %d", args, code, exitOK)
+		}
+		var dashboard struct {
+			UID string `json:"uid"`
+		}
+		json.Unmarshal(out.Bytes(), &dashboard)
+		return dashboard.UID
+	}
+
+	want := uid("-f", "../../promdata.txt")
+	for _, path := range []string{"./../../promdata.txt", absolute, copied} {
+		if got := uid("-f", path); got != want {
+			t.Errorf("Expected the UID of -f %s to be %q, got %q", path, want, got)
+		}
+	}
+	if got := uid("-f", copied, "--uid", "fleet"); got != "fleet" {
+		t.Errorf("Expected --uid to override the UID, got %q", got)
+	}
+}
+
+func TestRunDiff(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dashboard.json")
+	var generated bytes.Buffer
+	if code := run([]string{"-f", "../../promdata.txt"}, emptyStdin(t), &generated); code != exitOK {
+		t.Fatalf("Failed to generate the dashboard: exit %d", code)
+	}
+	if err := os.WriteFile(path, generated.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write dashboard: %v", err)
+	}
+
+	t.Run("Unchanged", func(t *testing.T) {
+		var stdout bytes.Buffer
+		if code := run([]string{"diff", "--against", path, "-f", "../../promdata.txt"}, emptyStdin(t), &stdout); code != exitOK {
+			t.Errorf("Expected exit %d, got %d: %s", exitOK, code, stdout.String())
+		}
+		if stdout.String() != "No differences\n" {
+			t.Errorf("Unexpected output %q", stdout.String())
+		}
+	})
+
+	t.Run("Changed expression", func(t *testing.T) {
+		var stdout bytes.Buffer
+		args := []string{"diff", "--against", path, "-f", "../../promdata.txt", "--set-counter-expr", "sum(rate(:METRIC:[5m]))"}
+		if code := run(args, emptyStdin(t), &stdout); code != exitDiff {
+			t.Errorf("Expected exit %d, got %d", exitDiff, code)
+		}
+		if !strings.Contains(stdout.String(), "~ builder builds failed total\n    target A: ") {
+			t.Errorf("Expected the changed counter target, got %s", stdout.String())
+		}
+	})
+
+	t.Run("JSON output", func(t *testing.T) {
+		var stdout bytes.Buffer
+		args := []string{"diff", "--against", path, "-f", "../../promdata.txt", "--include", "go_*", "--output", "json"}
+		if code := run(args, emptyStdin(t), &stdout); code != exitDiff {
+			t.Errorf("Expected exit %d, got %d", exitDiff, code)
+		}
+		var diff struct {
+			Added, Removed, Changed int
+		}
+		if err := json.Unmarshal(stdout.Bytes(), &diff); err != nil {
+			t.Fatalf("Invalid JSON output: %v", err)
+		}
+		if diff.Added != 0 || diff.Removed == 0 || diff.Changed != 0 {
+			t.Errorf("Expected only removed panels, got %+v", diff)
+		}
+	})
+
+	t.Run("Dashboard missing in Grafana", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				t.Errorf("Unexpected %s %s", r.Method, r.URL.Path)
+			}
+			http.Error(w, `{"message":"Dashboard not found"}`, http.StatusNotFound)
+		}))
+		defer server.Close()
+
+		var stdout bytes.Buffer
+		if code := run([]string{"diff", "-f", "../../promdata.txt", "-H", server.URL}, emptyStdin(t), &stdout); code != exitDiff {
+			t.Errorf("Expected exit %d, got %d", exitDiff, code)
+		}
+		if !strings.Contains(stdout.String(), "+ builder builds failed total\n") {
+			t.Errorf("Expected every panel to be added, got %s", stdout.String())
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		tests := []struct {
+			args []string
+			want int
+		}{
+			{[]string{"diff", "-f", "../../promdata.txt"}, exitUsage},
+			{[]string{"diff", "-f", "../../promdata.txt", "--against", "missing.json"}, exitInput},
+			{[]string{"diff", "-f", "../../promdata.txt", "--against", "../../promdata.txt"}, exitInput},
+		}
+		for _, tt := range tests {
+			if code := run(tt.args, emptyStdin(t), &bytes.Buffer{}); code != tt.want {
+				t.Errorf("run(%v) = %d; want %d", tt.args, code, tt.want)
+			}
+		}
+	})
+}
+
+func TestRunMalformedInput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "malformed.txt")
+	data := "# TYPE up gauge\nup 1\n# TYPE broken gauge\nbroken{x=1} 2\n# TYPE jobs gauge\njobs 3\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write input: %v", err)
+	}
+
+	var stdout bytes.Buffer
+	if code := run([]string{"-f", path}, emptyStdin(t), &stdout); code != exitOK {
+		t.Fatalf("Expected the malformed family to be skipped, got exit %d", code)
+	}
+	if strings.Contains(stdout.String(), "broken") || !strings.Contains(stdout.String(), "jobs") {
+		t.Errorf("Expected only the broken family to be left out")
+	}
+
+	args := []string{"-f", path, "--strict-parse"}
+	if code := run(args, emptyStdin(t), &bytes.Buffer{}); code != exitParse {
+		t.Errorf("run(%v) = %d; want %d", args, code, exitParse)
+	}
+}
@@ -2,6 +2,8 @@
 package config
 
 import (
+	"time"
+
 	"github.com/alecthomas/kingpin/v2"
 )
 
@@ -48,6 +50,14 @@ type LabelGroupConfig struct {
 	PanelsPerRow int
 }
 
+// TemplatingConfig defines dashboard template variables
+type TemplatingConfig struct {
+	// Generate template variables and filter queries by them
+	Enabled bool
+	// Extra labels exposed as variables after job and instance
+	Labels []string
+}
+
 // AdvancedVisualizationConfig provides configuration for advanced visualizations
 type AdvancedVisualizationConfig struct {
 	// Default visualization type
@@ -84,7 +94,7 @@ type Config struct {
 	Title              string
 	Description        string
 	Gauges             bool
-	File               string
+	Files              []string // Scrape files merged into one dashboard
 	Pretty             bool
 	Stdin              bool
 	CounterExprTmpl    string
@@ -95,18 +105,50 @@ type Config struct {
 	GaugeLegend        string
 	SummaryLegend      string
 	Table              bool
-	URL                string
-	Token              string
+	URLs               []string // Scrape URLs merged into one dashboard
+	Format             string // Input format: auto, prometheus, openmetrics or protobuf
+	PrometheusURL      string // Prometheus server whose HTTP API lists the metrics
+	Match              string // Series selector limiting the metrics listed by PrometheusURL
+	Token              string // Grafana API key or service account token
 	GrafanaHost        string
+	GrafanaUser        string // Basic auth user, used without a token
+	GrafanaPassword    string
+	GrafanaOrgID       int64  // Organization the dashboard is saved in
+	GrafanaCAFile      string // CA certificates the Grafana server is verified against
+	GrafanaCertFile    string // Client certificate for mutual TLS with Grafana
+	GrafanaKeyFile     string
+	GrafanaRetries     int    // Retries of Grafana requests failing with 429 or 5xx
+	DashboardUID       string // UID of the dashboard, derived from the title if empty
+	Force              bool   // Overwrite a dashboard changed in Grafana since lazydash saved it
+	DiffAgainst        string // Dashboard JSON file the diff command compares with, the one in Grafana if empty
+	DiffOutput         string // Output of the diff command: text or json
 	InsecureSkipVerify bool
+	FetchWorkers       int           // URLs fetched at once
+	FetchTimeout       time.Duration // Timeout of a single request to a URL
+	FetchRetries       int           // Retries of a failed request to a URL
+	InferInterval      time.Duration // Time between two scrapes of the URLs typing untyped metrics, 0 for one scrape
 	
 	// Advanced options
 	FolderConfig         *FolderConfig
 	LabelGrouping        *LabelGroupConfig
+	Templating           *TemplatingConfig
 	Visualizations       *AdvancedVisualizationConfig
 	Alerts               []*AlertThreshold
 	GenerateAlerts       bool
+	AlertRules           string // File the Prometheus alerting rules are written to
+	GrafanaAlerts        bool   // Provision unified alert rules in Grafana with the dashboard
+	GrafanaAlertsFile    string // File the unified alert rules are provisioned from
+	DatasourceUID        string // UID of the Prometheus datasource queried by unified alert rules
 	AutoCorrelate        bool
+	LegacyPanels         bool   // Emit graph panels and schema version 22 for Grafana before 7.4
+	SpecFile             string // Dashboard-as-code file with per-metric rules
+	Spec                 *Spec  // Rules loaded from SpecFile
+	Include              []string // Filters selecting the metrics to show, e.g. node_* or type:gauge
+	Exclude              []string // Filters dropping metrics
+	List                 bool     // Print which metrics the filters keep instead of a dashboard
+	GroupBySource        bool     // Place the metrics of each input in their own row
+	StrictMerge          bool     // Fail when inputs disagree on the type of a metric
+	StrictParse          bool     // Fail on malformed input instead of skipping the metric family
 	AutoCorrelateThreshold float64 // Correlation threshold (0.0-1.0)
 	
 	// Vendor-specific options
@@ -128,6 +170,11 @@ func New() *Config {
 		SummaryLegend:    "Job:[{{job}}]",
 		
 		AutoCorrelateThreshold: 0.7,
+		DatasourceUID:          "prometheus",
+		FetchWorkers:           8,
+		FetchTimeout:           30 * time.Second,
+		FetchRetries:           2,
+		GrafanaRetries:         3,
 		
 		// Initialize vendor config with defaults
 		VendorConfig: &VendorPrefixConfig{
@@ -147,11 +194,25 @@ func New() *Config {
 // RegisterFlags registers command line flags for all config options
 func (c *Config) RegisterFlags(app *kingpin.Application) {
 	// Basic options
-	app.Flag("file", "Parse metrics from file.").Default("").Short('f').StringVar(&c.File)
+	app.Flag("file", "Parse metrics from file, repeatable to merge several files").Short('f').StringsVar(&c.Files)
 	app.Flag("title", "Dashboard title").Short('t').Default("Prometheus Dashboard").StringVar(&c.Title)
 	app.Flag("description", "Dashboard description").Default("Generated by Lazydash").StringVar(&c.Description)
 	app.Flag("stdin", "Read from stdin").Default("true").BoolVar(&c.Stdin)
-	app.Flag("url", "Fetch Prometheus data from HTTP(S) url").Default("").StringVar(&c.URL)
+	app.Flag("url", "Fetch Prometheus data from HTTP(S) url, repeatable to merge several targets").StringsVar(&c.URLs)
+	app.Flag("fetch-workers", "Number of --url targets fetched at once").Default("8").IntVar(&c.FetchWorkers)
+	app.Flag("fetch-timeout", "Timeout of a single request to a --url target").Default("30s").DurationVar(&c.FetchTimeout)
+	app.Flag("fetch-retries", "Retries of a --url target after a failed request").Default("2").IntVar(&c.FetchRetries)
+	app.Flag("infer-interval", "Scrape --url targets twice this far apart to tell counters from gauges among untyped metrics e.g 15s").Default("0s").DurationVar(&c.InferInterval)
+	app.Flag("prometheus-url", "Generate from the metrics of a Prometheus server via its HTTP API e.g http://prometheus:9090").Default("").StringVar(&c.PrometheusURL)
+	app.Flag("match", "Series selector limiting --prometheus-url e.g {job=\"node\"}").Default("").StringVar(&c.Match)
+	app.Flag("format", "Input format, auto detects protobuf and OpenMetrics by their framing").Default("auto").EnumVar(&c.Format, "auto", "prometheus", "openmetrics", "protobuf")
+	app.Flag("spec", "YAML or JSON file with per-metric panel rules").Default("").StringVar(&c.SpecFile)
+	app.Flag("include", "Only show metrics matching a filter [name|type|vendor|label|help:]glob or /regex/, repeatable").StringsVar(&c.Include)
+	app.Flag("exclude", "Drop metrics matching a filter [name|type|vendor|label|help:]glob or /regex/, repeatable").StringsVar(&c.Exclude)
+	app.Flag("list", "List the metrics kept by the filters and why others were dropped, without generating a dashboard").Default("false").BoolVar(&c.List)
+	app.Flag("group-by-source", "Place the metrics of each --file and --url in their own row").Default("false").BoolVar(&c.GroupBySource)
+	app.Flag("strict-merge", "Fail when merged inputs disagree on the type of a metric").Default("false").BoolVar(&c.StrictMerge)
+	app.Flag("strict-parse", "Fail on malformed input instead of skipping the metric family").Default("false").BoolVar(&c.StrictParse)
 	app.Flag("pretty", "Print pretty indented JSON").Short('p').Default("false").BoolVar(&c.Pretty)
 	app.Flag("gauges", "Render gauge values as gauge panel type instead of graph").Short('g').Default("false").BoolVar(&c.Gauges)
 	app.Flag("table", "Render legend as a table").Default("false").BoolVar(&c.Table)
@@ -163,7 +224,22 @@ func (c *Config) RegisterFlags(app *kingpin.Application) {
 	app.Flag("set-gauge-legend", "Set the default counter panel legend format").Default("Job:[{{job}}]").StringVar(&c.GaugeLegend)
 	app.Flag("grafana-url", "Set the grafana api url e.g http://grafana.example.com:3000").Short('H').Default("").StringVar(&c.GrafanaHost)
 	app.Flag("insecure", "Skip ssl certificate verification").Short('I').Default("false").BoolVar(&c.InsecureSkipVerify)
-	app.Flag("token", "Set the grafana api token").Short('T').Default("").StringVar(&c.Token)
+	app.Flag("token", "Set the grafana api key or service account token").Short('T').Envar("GRAFANA_TOKEN").Default("").StringVar(&c.Token)
+	app.Flag("grafana-user", "Grafana basic auth user, used without --token").Default("").StringVar(&c.GrafanaUser)
+	app.Flag("grafana-password", "Grafana basic auth password").Envar("GRAFANA_PASSWORD").Default("").StringVar(&c.GrafanaPassword)
+	app.Flag("grafana-org-id", "Grafana organization the dashboard is saved in, the user's current one if 0").Default("0").Int64Var(&c.GrafanaOrgID)
+	app.Flag("grafana-ca", "PEM file with the CA certificates of the grafana server").Default("").StringVar(&c.GrafanaCAFile)
+	app.Flag("grafana-cert", "PEM client certificate for mutual TLS with grafana").Default("").StringVar(&c.GrafanaCertFile)
+	app.Flag("grafana-key", "PEM key of --grafana-cert").Default("").StringVar(&c.GrafanaKeyFile)
+	app.Flag("grafana-retries", "Retries of grafana requests failing with 429, 5xx or a connection error").Default("3").IntVar(&c.GrafanaRetries)
+	app.Flag("uid", "Dashboard UID, derived from the title by default").Default("").StringVar(&c.DashboardUID)
+	app.Flag("force", "Overwrite the dashboard even if it was changed in grafana since lazydash saved it").Default("false").BoolVar(&c.Force)
+	
+	// Commands, the dashboard is generated unless diff is given
+	app.Command("generate", "Generate the dashboard and print it or post it to grafana").Default()
+	diff := app.Command("diff", "Compare the generated dashboard with a JSON file or the one in grafana, exiting with 1 when they differ")
+	diff.Flag("against", "Dashboard JSON file to compare with instead of the dashboard in grafana (-H)").Default("").StringVar(&c.DiffAgainst)
+	diff.Flag("output", "Diff output format").Default("text").EnumVar(&c.DiffOutput, "text", "json")
 	
 	// Folder organization options
 	folderConfig := &FolderConfig{}
@@ -173,10 +249,15 @@ func (c *Config) RegisterFlags(app *kingpin.Application) {
 	
 	// Label grouping options
 	labelGrouping := &LabelGroupConfig{}
-	app.Flag("group-by", "Group panels by label").Default("").StringsVar(&labelGrouping.GroupByLabels)
+	app.Flag("group-by", "Group panels by label").StringsVar(&labelGrouping.GroupByLabels)
 	app.Flag("separate-rows", "Create separate rows for each label group").Default("true").BoolVar(&labelGrouping.SeparateRows)
 	app.Flag("panels-per-row", "Number of panels per row (0 for auto)").Default("2").IntVar(&labelGrouping.PanelsPerRow)
 	
+	// Template variable options
+	templating := &TemplatingConfig{}
+	app.Flag("template-vars", "Add job and instance template variables and filter queries by them").Default("true").BoolVar(&templating.Enabled)
+	app.Flag("template-label", "Add a template variable for a metric label").StringsVar(&templating.Labels)
+	
 	// Auto-correlation options
 	app.Flag("auto-correlate", "Automatically correlate related metrics").Default("false").BoolVar(&c.AutoCorrelate)
 	app.Flag("correlation-threshold", "Threshold for auto-correlation (0.0-1.0)").Default("0.7").Float64Var(&c.AutoCorrelateThreshold)
@@ -189,11 +270,16 @@ func (c *Config) RegisterFlags(app *kingpin.Application) {
 	app.Flag("viz-gauges", "Visualization type for gauges").Default("gauge").EnumVar((*string)(&visualizations.GaugeType), "graph", "stat", "bargauge", "gauge", "table", "heatmap")
 	app.Flag("viz-summaries", "Visualization type for summaries").Default("graph").EnumVar((*string)(&visualizations.SummaryType), "graph", "stat", "bargauge", "gauge", "table", "heatmap")
 	app.Flag("heatmap-for-histograms", "Use heatmap for histogram metrics").Default("true").BoolVar(&visualizations.UseHeatmapForHistograms)
-	app.Flag("stat-for-gauges", "Use stat panels for simple gauges").Default("true").BoolVar(&visualizations.UseStatForGauges)
-	app.Flag("table-for-multilabels", "Use tables for metrics with many labels").Default("true").BoolVar(&visualizations.UseTableForMultiLabels)
+	app.Flag("stat-for-gauges", "Use stat panels for single-series gauges").Default("true").BoolVar(&visualizations.UseStatForGauges)
+	app.Flag("table-for-multilabels", "Use tables for gauges with many series").Default("true").BoolVar(&visualizations.UseTableForMultiLabels)
+	app.Flag("legacy-panels", "Use graph panels and the old dashboard schema for Grafana before 7.4").Default("false").BoolVar(&c.LegacyPanels)
 	
 	// Alert options
 	app.Flag("generate-alerts", "Automatically generate alerts for common metrics").Default("false").BoolVar(&c.GenerateAlerts)
+	app.Flag("alert-rules", "Write Prometheus alerting rules for common metrics to a YAML file").Default("").StringVar(&c.AlertRules)
+	app.Flag("grafana-alerts", "Provision Grafana unified alert rules for common metrics with the dashboard (requires -H)").Default("false").BoolVar(&c.GrafanaAlerts)
+	app.Flag("grafana-alerts-file", "Write Grafana unified alert rules to a provisioning YAML file").Default("").StringVar(&c.GrafanaAlertsFile)
+	app.Flag("datasource-uid", "UID of the Prometheus datasource queried by Grafana alert rules").Default("prometheus").StringVar(&c.DatasourceUID)
 	
 	// Vendor-specific options
 	vendorConfig := c.VendorConfig
@@ -206,6 +292,7 @@ func (c *Config) RegisterFlags(app *kingpin.Application) {
 	// Assign the configurations
 	c.FolderConfig = folderConfig
 	c.LabelGrouping = labelGrouping
+	c.Templating = templating
 	c.Visualizations = visualizations
 	c.VendorConfig = vendorConfig
 }
\ No newline at end of file
@@ -106,6 +106,19 @@ func TestRegisterFlags(t *testing.T) {
 	if groupByFlag == nil {
 		t.Fatal("Expected 'group-by' flag to be registered")
 	}
+	
+	// Check templating flags
+	if app.GetFlag("template-vars") == nil {
+		t.Fatal("Expected 'template-vars' flag to be registered")
+	}
+	
+	if app.GetFlag("template-label") == nil {
+		t.Fatal("Expected 'template-label' flag to be registered")
+	}
+	
+	if config.Templating == nil {
+		t.Fatal("Expected Templating config to be set by RegisterFlags")
+	}
 }
 
 func TestVisualizationTypeEnums(t *testing.T) {
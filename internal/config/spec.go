@@ -0,0 +1,265 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Spec is a dashboard-as-code file whose rules adjust the generated panel
+// of every metric they match. JSON specs are read as YAML.
+type Spec struct {
+	Filters *SpecFilters  `yaml:"filters"`
+	Rules   []*MetricRule `yaml:"rules"`
+}
+
+// SpecFilters are metric filters added to --include and --exclude
+type SpecFilters struct {
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+}
+
+// MetricRule overrides the panel of the metrics matched by Match, a metric
+// name or a glob such as node_*. Unset fields keep the generated value.
+type MetricRule struct {
+	Match         string            `yaml:"match"`
+	Expr          string            `yaml:"expr"`   // Query, the delimiter is replaced with the series
+	Legend        string            `yaml:"legend"` // Legend format of the query
+	Unit          string            `yaml:"unit"`
+	Visualization VisualizationType `yaml:"visualization"`
+	Thresholds    *SpecThresholds   `yaml:"thresholds"`
+	Row           string            `yaml:"row"`     // Title of the row the panel is placed in
+	Hide          *bool             `yaml:"hide"`    // Leave the metric off the dashboard
+	Include       bool              `yaml:"include"` // Only metrics matched by an include rule are shown
+	Width         int               `yaml:"width"`   // Panel width in grid units (1-24)
+
+	line int // Line of the rule in the spec file
+}
+
+// SpecThresholds are the warning and critical values of a panel
+type SpecThresholds struct {
+	Warning  *float64 `yaml:"warning"`
+	Critical *float64 `yaml:"critical"`
+}
+
+// visualizations are the panel types a rule may choose
+var visualizations = []VisualizationType{
+	VisualizationGraph, VisualizationGauge, VisualizationStat,
+	VisualizationTable, VisualizationHeatmap, VisualizationBarGauge,
+}
+
+// LoadSpec reads a YAML or JSON spec. Unknown keys and invalid rules are
+// reported with their line number.
+func LoadSpec(file string) (*Spec, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spec: %w", err)
+	}
+
+	spec, err := ParseSpec(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return spec, nil
+}
+
+// ParseSpec parses and validates a YAML or JSON spec
+func ParseSpec(data []byte) (*Spec, error) {
+	spec := &Spec{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(spec); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("invalid spec: %w", err)
+	}
+
+	// Rule lines come from the document tree, which strict decoding drops
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err == nil && len(doc.Content) > 0 {
+		if rules := mappingValue(doc.Content[0], "rules"); rules != nil {
+			for i, node := range rules.Content {
+				if i < len(spec.Rules) && spec.Rules[i] != nil {
+					spec.Rules[i].line = node.Line
+				}
+			}
+		}
+	}
+
+	if err := spec.validate(); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// mappingValue returns the value of a key in a YAML mapping
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// validate checks every rule and returns all problems at once
+func (s *Spec) validate() error {
+	var errs []error
+	for i, rule := range s.Rules {
+		if rule == nil {
+			errs = append(errs, fmt.Errorf("rule %d: empty rule", i+1))
+			continue
+		}
+		invalid := func(format string, args ...interface{}) {
+			errs = append(errs, fmt.Errorf("line %d: %s", rule.line, fmt.Sprintf(format, args...)))
+		}
+
+		if rule.Match == "" {
+			invalid("rule without match")
+		} else if _, err := path.Match(rule.Match, ""); err != nil {
+			invalid("invalid glob %q", rule.Match)
+		}
+		if rule.Visualization != "" && !knownVisualization(rule.Visualization) {
+			invalid("unknown visualization %q", rule.Visualization)
+		}
+		if rule.Width < 0 || rule.Width > 24 {
+			invalid("width %d is outside 1-24", rule.Width)
+		}
+		if t := rule.Thresholds; t != nil && t.Warning != nil && t.Critical != nil && *t.Warning > *t.Critical {
+			invalid("warning threshold %g is above critical %g", *t.Warning, *t.Critical)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// knownVisualization reports whether a rule may choose the visualization
+func knownVisualization(v VisualizationType) bool {
+	for _, known := range visualizations {
+		if v == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches reports whether the rule applies to a metric
+func (r *MetricRule) Matches(name string) bool {
+	if !isGlob(r.Match) {
+		return r.Match == name
+	}
+	matched, _ := path.Match(r.Match, name)
+	return matched
+}
+
+// isGlob reports whether a match is a pattern rather than a metric name
+func isGlob(match string) bool {
+	return strings.ContainsAny(match, "*?[")
+}
+
+// RuleFor merges the rules matching a metric. Glob rules apply in file
+// order, then rules naming the metric, so later and exact rules win.
+func (s *Spec) RuleFor(name string) *MetricRule {
+	merged := &MetricRule{Match: name}
+	if s == nil {
+		return merged
+	}
+
+	for _, exact := range []bool{false, true} {
+		for _, rule := range s.Rules {
+			if isGlob(rule.Match) != exact && rule.Matches(name) {
+				merged.merge(rule)
+			}
+		}
+	}
+	return merged
+}
+
+// merge copies the fields set in other
+func (r *MetricRule) merge(other *MetricRule) {
+	if other.Expr != "" {
+		r.Expr = other.Expr
+	}
+	if other.Legend != "" {
+		r.Legend = other.Legend
+	}
+	if other.Unit != "" {
+		r.Unit = other.Unit
+	}
+	if other.Visualization != "" {
+		r.Visualization = other.Visualization
+	}
+	if other.Thresholds != nil {
+		r.Thresholds = other.Thresholds
+	}
+	if other.Row != "" {
+		r.Row = other.Row
+	}
+	if other.Hide != nil {
+		r.Hide = other.Hide
+	}
+	if other.Width != 0 {
+		r.Width = other.Width
+	}
+	r.Include = r.Include || other.Include
+}
+
+// Hidden reports whether a metric is left off the dashboard, either by a
+// hide rule or because include rules exist and none matches it
+func (s *Spec) Hidden(name string) bool {
+	if s == nil {
+		return false
+	}
+
+	rule := s.RuleFor(name)
+	if rule.Hide != nil {
+		return *rule.Hide
+	}
+	return s.hasIncludes() && !rule.Include
+}
+
+// hasIncludes reports whether the spec lists the metrics to show
+func (s *Spec) hasIncludes() bool {
+	for _, rule := range s.Rules {
+		if rule.Include {
+			return true
+		}
+	}
+	return false
+}
+
+// Rows returns the row titles used by rules in order of appearance
+func (s *Spec) Rows() []string {
+	if s == nil {
+		return nil
+	}
+
+	var rows []string
+	seen := make(map[string]bool)
+	for _, rule := range s.Rules {
+		if rule.Row != "" && !seen[rule.Row] {
+			seen[rule.Row] = true
+			rows = append(rows, rule.Row)
+		}
+	}
+	return rows
+}
+
+// HasWidths reports whether any rule sets a panel width
+func (s *Spec) HasWidths() bool {
+	if s == nil {
+		return false
+	}
+	for _, rule := range s.Rules {
+		if rule.Width != 0 {
+			return true
+		}
+	}
+	return false
+}
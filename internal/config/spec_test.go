@@ -0,0 +1,163 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseSpec(t *testing.T) {
+	spec, err := ParseSpec([]byte(`
+rules:
+  - match: node_*
+    unit: bytes
+    width: 8
+  - match: node_cpu_seconds_total
+    expr: "sum(rate(:METRIC: [5m]))"
+    legend: "{{mode}}"
+    visualization: stat
+    thresholds:
+      warning: 0.8
+      critical: 0.95
+    row: CPU
+`))
+	if err != nil {
+		t.Fatalf("ParseSpec() error = %v", err)
+	}
+	if len(spec.Rules) != 2 {
+		t.Fatalf("Expected 2 rules, got %d", len(spec.Rules))
+	}
+
+	rule := spec.RuleFor("node_cpu_seconds_total")
+	if rule.Unit != "bytes" || rule.Width != 8 {
+		t.Errorf("Expected glob rule unit and width, got %q %d", rule.Unit, rule.Width)
+	}
+	if rule.Expr != "sum(rate(:METRIC: [5m]))" || rule.Legend != "{{mode}}" || rule.Visualization != VisualizationStat || rule.Row != "CPU" {
+		t.Errorf("Expected exact rule fields, got %+v", rule)
+	}
+	if rule.Thresholds == nil || *rule.Thresholds.Warning != 0.8 || *rule.Thresholds.Critical != 0.95 {
+		t.Errorf("Expected thresholds 0.8 and 0.95, got %+v", rule.Thresholds)
+	}
+
+	if other := spec.RuleFor("go_goroutines"); other.Unit != "" || other.Width != 0 {
+		t.Errorf("Expected no rule for an unmatched metric, got %+v", other)
+	}
+	if rows := spec.Rows(); len(rows) != 1 || rows[0] != "CPU" {
+		t.Errorf("Expected row CPU, got %v", rows)
+	}
+}
+
+func TestParseSpecJSON(t *testing.T) {
+	spec, err := ParseSpec([]byte(`{"rules": [{"match": "up", "visualization": "stat"}]}`))
+	if err != nil {
+		t.Fatalf("ParseSpec() error = %v", err)
+	}
+	if spec.RuleFor("up").Visualization != VisualizationStat {
+		t.Errorf("Expected stat visualization, got %+v", spec.RuleFor("up"))
+	}
+}
+
+func TestParseSpecErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		spec string
+		want []string
+	}{
+		{
+			name: "Unknown key",
+			spec: "rules:\n  - match: up\n    colour: red\n",
+			want: []string{"line 3", "colour"},
+		},
+		{
+			name: "Unknown top-level key",
+			spec: "panels: []\n",
+			want: []string{"line 1", "panels"},
+		},
+		{
+			name: "Invalid rules",
+			spec: "rules:\n  - unit: bytes\n  - match: up\n    visualization: pie\n  - match: \"[\"\n    width: 30\n",
+			want: []string{
+				"line 2: rule without match",
+				`line 3: unknown visualization "pie"`,
+				`line 5: invalid glob "["`,
+				"line 5: width 30 is outside 1-24",
+			},
+		},
+		{
+			name: "Inverted thresholds",
+			spec: "rules:\n  - match: up\n    thresholds: {warning: 10, critical: 5}\n",
+			want: []string{"line 2: warning threshold 10 is above critical 5"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseSpec([]byte(tt.spec))
+			if err == nil {
+				t.Fatalf("Expected an error")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Expected error to contain %q, got %v", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestSpecHidden(t *testing.T) {
+	hide := true
+	show := false
+
+	t.Run("Hide rules", func(t *testing.T) {
+		spec := &Spec{Rules: []*MetricRule{
+			{Match: "go_*", Hide: &hide},
+			{Match: "go_goroutines", Hide: &show},
+		}}
+		if !spec.Hidden("go_threads") || spec.Hidden("go_goroutines") || spec.Hidden("up") {
+			t.Errorf("Expected only go_threads to be hidden")
+		}
+	})
+
+	t.Run("Include rules", func(t *testing.T) {
+		spec := &Spec{Rules: []*MetricRule{
+			{Match: "node_*", Include: true},
+			{Match: "node_boot_time_seconds", Hide: &hide},
+		}}
+		if spec.Hidden("node_load1") || !spec.Hidden("up") || !spec.Hidden("node_boot_time_seconds") {
+			t.Errorf("Expected only included metrics that are not hidden to be shown")
+		}
+	})
+
+	t.Run("No spec", func(t *testing.T) {
+		var spec *Spec
+		if spec.Hidden("up") || spec.RuleFor("up").Expr != "" {
+			t.Errorf("Expected a nil spec to change nothing")
+		}
+	})
+}
+
+func TestLoadSpec(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spec.yaml")
+	os.WriteFile(path, []byte("rules:\n  - match: up\n    hide: yes please\n"), 0o644)
+
+	_, err := LoadSpec(path)
+	if err == nil || !strings.Contains(err.Error(), path) || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("Expected an error naming the file and line, got %v", err)
+	}
+}
+
+func TestParseSpecFilters(t *testing.T) {
+	spec, err := ParseSpec([]byte(`
+filters:
+  include: [node_*, "type:gauge"]
+  exclude: ["label:/^device$/"]
+`))
+	if err != nil {
+		t.Fatalf("ParseSpec() error = %v", err)
+	}
+	if spec.Filters == nil || len(spec.Filters.Include) != 2 || spec.Filters.Exclude[0] != "label:/^device$/" {
+		t.Errorf("Expected two include and one exclude filter, got %+v", spec.Filters)
+	}
+}
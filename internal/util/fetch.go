@@ -0,0 +1,170 @@
+package util
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Fetcher scrapes many targets concurrently, retrying failed attempts with
+// exponential backoff
+type Fetcher struct {
+	Workers int           // Targets fetched at once
+	Timeout time.Duration // Timeout of a single attempt
+	Retries int           // Attempts after the first one failed
+	Backoff time.Duration // Wait before the first retry, doubled for each further one
+
+	client *http.Client
+}
+
+// FetchResult is the outcome of fetching a single target
+type FetchResult struct {
+	URL         string
+	Data        []byte
+	ContentType string // Content-Type of the response, naming the exposition format
+	Attempts    int
+	Err         error
+}
+
+// StatusError is a response with an unsuccessful HTTP status code
+type StatusError struct {
+	URL        string
+	StatusCode int
+}
+
+// Error describes the failed request
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("HTTP request to %s failed with status code %d", e.URL, e.StatusCode)
+}
+
+// NewFetcher creates a fetcher with at least one worker and a one second
+// initial backoff
+func NewFetcher(workers int, timeout time.Duration, retries int, insecureSkipVerify bool) *Fetcher {
+	client := &http.Client{}
+	if insecureSkipVerify {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		client.Transport = transport
+		log.Warn().Msg("TLS certificate verification disabled. This is insecure!")
+	}
+
+	return &Fetcher{
+		Workers: max(workers, 1),
+		Timeout: timeout,
+		Retries: max(retries, 0),
+		Backoff: time.Second,
+		client:  client,
+	}
+}
+
+// Fetch fetches a single target with retries
+func (f *Fetcher) Fetch(ctx context.Context, urlStr string) ([]byte, error) {
+	result := f.fetchWithRetries(ctx, urlStr)
+	return result.Data, result.Err
+}
+
+// FetchAll fetches the targets with at most Workers requests at once. The
+// results are in the order of urls; a failed target does not stop the others.
+func (f *Fetcher) FetchAll(ctx context.Context, urls []string) []FetchResult {
+	results := make([]FetchResult, len(urls))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < min(f.Workers, len(urls)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = f.fetchWithRetries(ctx, urls[i])
+			}
+		}()
+	}
+	for i := range urls {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// fetchWithRetries fetches a target until an attempt succeeds, fails for a
+// reason retrying cannot fix, or the retries are used up
+func (f *Fetcher) fetchWithRetries(ctx context.Context, urlStr string) FetchResult {
+	result := FetchResult{URL: urlStr}
+	if !IsURL(urlStr) {
+		result.Err = fmt.Errorf("invalid URL: %s", urlStr)
+		return result
+	}
+
+	backoff := f.Backoff
+	for {
+		result.Attempts++
+		result.Data, result.ContentType, result.Err = f.fetch(ctx, urlStr)
+		if result.Err == nil || result.Attempts > f.Retries || !retryable(ctx, result.Err) {
+			return result
+		}
+
+		log.Debug().Err(result.Err).Str("url", urlStr).Int("attempt", result.Attempts).Dur("backoff", backoff).Msg("Retrying target")
+		select {
+		case <-ctx.Done():
+			result.Err = fmt.Errorf("failed to fetch %s: %w", urlStr, ctx.Err())
+			return result
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// fetch makes a single attempt with the fetcher's timeout and returns the
+// body with its Content-Type
+func (f *Fetcher) fetch(ctx context.Context, urlStr string) ([]byte, string, error) {
+	if f.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, urlStr, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create request for %s: %w", urlStr, err)
+	}
+	// Prefer protobuf and OpenMetrics, which carry native histograms, units and exemplars
+	req.Header.Set("Accept", AcceptHeader)
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to connect to %s: %w", urlStr, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, "", &StatusError{URL: urlStr, StatusCode: resp.StatusCode}
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read response body from %s: %w", urlStr, err)
+	}
+	return body, resp.Header.Get("Content-Type"), nil
+}
+
+// retryable reports whether another attempt may succeed. Server errors, rate
+// limiting, timeouts and connection failures are retried unless ctx is done.
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var status *StatusError
+	if errors.As(err, &status) {
+		return status.StatusCode >= 500 || status.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
@@ -0,0 +1,110 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFetcherFetchAll(t *testing.T) {
+	var inFlight, maxInFlight, flaky atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Timed out requests keep the handler running, so /slow is not counted
+		if r.URL.Path != "/slow" {
+			n := inFlight.Add(1)
+			defer inFlight.Add(-1)
+			for {
+				highest := maxInFlight.Load()
+				if n <= highest || maxInFlight.CompareAndSwap(highest, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		switch r.URL.Path {
+		case "/flaky":
+			if flaky.Add(1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer server.Close()
+
+	fetcher := NewFetcher(2, 100*time.Millisecond, 2, false)
+	fetcher.Backoff = time.Millisecond
+
+	urls := []string{server.URL + "/a", server.URL + "/flaky", server.URL + "/missing", server.URL + "/slow", server.URL + "/b", "not-a-url"}
+	results := fetcher.FetchAll(context.Background(), urls)
+
+	if len(results) != len(urls) {
+		t.Fatalf("Expected %d results, got %d", len(urls), len(results))
+	}
+	if max := maxInFlight.Load(); max > 2 {
+		t.Errorf("Expected at most 2 requests at once, got %d", max)
+	}
+
+	tests := []struct {
+		name     string
+		data     string
+		attempts int
+		failed   bool
+	}{
+		{"Success", "/a", 1, false},
+		{"Retried until success", "/flaky", 3, false},
+		{"Client error is not retried", "", 1, true},
+		{"Timeout of every attempt", "", 3, true},
+		{"Success after failures", "/b", 1, false},
+		{"Invalid URL", "", 0, true},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := results[i]
+			if result.URL != urls[i] {
+				t.Errorf("Expected result for %s, got %s", urls[i], result.URL)
+			}
+			if (result.Err != nil) != tt.failed || string(result.Data) != tt.data || result.Attempts != tt.attempts {
+				t.Errorf("Got data %q after %d attempts with error %v; want %q after %d", result.Data, result.Attempts, result.Err, tt.data, tt.attempts)
+			}
+			if !tt.failed && result.ContentType != "text/plain; version=0.0.4" {
+				t.Errorf("Expected the response Content-Type, got %q", result.ContentType)
+			}
+		})
+	}
+
+	var status *StatusError
+	if !errors.As(results[2].Err, &status) || status.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected a 404 status error, got %v", results[2].Err)
+	}
+}
+
+func TestFetcherCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	fetcher := NewFetcher(1, time.Second, 5, false)
+	fetcher.Backoff = time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := fetcher.Fetch(ctx, server.URL)
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > time.Second {
+		t.Errorf("Expected the backoff to stop with the context, got %v after %s", err, time.Since(start))
+	}
+}
@@ -1,6 +1,7 @@
 package util
 
 import (
+	"bufio"
 	"io"
 	"os"
 )
@@ -23,7 +24,30 @@ func LoadFromFile(path string) ([]byte, error) {
 
 // LoadFromStdin loads data from stdin
 func LoadFromStdin() ([]byte, error) {
-	info, err := os.Stdin.Stat()
+	return LoadFromPipe(os.Stdin)
+}
+
+// LoadFromPipe loads data from a piped or redirected file such as stdin.
+// It returns nil data when f is an interactive terminal or empty.
+func LoadFromPipe(f *os.File) ([]byte, error) {
+	r, err := OpenPipe(f)
+	if r == nil || err != nil {
+		return nil, err
+	}
+
+	// Read from the pipe
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	
+	return data, nil
+}
+
+// OpenPipe returns a reader of a piped or redirected file such as stdin,
+// or nil when f is an interactive terminal or empty
+func OpenPipe(f *os.File) (io.Reader, error) {
+	info, err := f.Stat()
 	if err != nil {
 		return nil, err
 	}
@@ -32,12 +56,16 @@ func LoadFromStdin() ([]byte, error) {
 	if info.Mode()&os.ModeNamedPipe == 0 && (info.Mode()&os.ModeCharDevice != 0 || info.Size() <= 0) {
 		return nil, nil
 	}
+	if info.Mode().IsRegular() {
+		return f, nil
+	}
 
-	// Read from stdin
-	data, err := io.ReadAll(os.Stdin)
-	if err != nil {
+	// A pipe is empty when it closes before sending anything
+	r := bufio.NewReader(f)
+	if _, err := r.Peek(1); err == io.EOF {
+		return nil, nil
+	} else if err != nil {
 		return nil, err
 	}
-	
-	return data, nil
-}
\ No newline at end of file
+	return r, nil
+}
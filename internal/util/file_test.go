@@ -1,6 +1,7 @@
 package util
 
 import (
+	"io"
 	"os"
 	"path/filepath"
 	"testing"
@@ -70,4 +71,84 @@ func TestLoadFromStdin(t *testing.T) {
 			t.Logf("Note: LoadFromStdin() returned error: %v", err)
 		}
 	})
-}
\ No newline at end of file
+}
+func TestLoadFromPipe(t *testing.T) {
+	// A redirected regular file behaves like piped stdin
+	t.Run("Redirected file", func(t *testing.T) {
+		tmpfile, err := os.CreateTemp("", "testpipe")
+		if err != nil {
+			t.Fatalf("Failed to create temporary file: %v", err)
+		}
+		defer os.Remove(tmpfile.Name())
+		defer tmpfile.Close()
+
+		if _, err := tmpfile.WriteString("piped content"); err != nil {
+			t.Fatalf("Failed to write to temporary file: %v", err)
+		}
+		if _, err := tmpfile.Seek(0, 0); err != nil {
+			t.Fatalf("Failed to rewind temporary file: %v", err)
+		}
+
+		data, err := LoadFromPipe(tmpfile)
+		if err != nil {
+			t.Fatalf("LoadFromPipe returned error: %v", err)
+		}
+		if string(data) != "piped content" {
+			t.Errorf("LoadFromPipe = %q; want %q", string(data), "piped content")
+		}
+	})
+
+	// An empty redirect yields no data
+	t.Run("Empty file", func(t *testing.T) {
+		tmpfile, err := os.CreateTemp("", "testpipe")
+		if err != nil {
+			t.Fatalf("Failed to create temporary file: %v", err)
+		}
+		defer os.Remove(tmpfile.Name())
+		defer tmpfile.Close()
+
+		data, err := LoadFromPipe(tmpfile)
+		if err != nil {
+			t.Fatalf("LoadFromPipe returned error: %v", err)
+		}
+		if data != nil {
+			t.Errorf("LoadFromPipe on empty file = %q; want nil", string(data))
+		}
+	})
+}
+
+func TestOpenPipe(t *testing.T) {
+	t.Run("Pipe", func(t *testing.T) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatalf("Failed to create pipe: %v", err)
+		}
+		defer r.Close()
+		go func() {
+			w.WriteString("piped content")
+			w.Close()
+		}()
+
+		reader, err := OpenPipe(r)
+		if err != nil || reader == nil {
+			t.Fatalf("OpenPipe() = %v, %v", reader, err)
+		}
+		if data, _ := io.ReadAll(reader); string(data) != "piped content" {
+			t.Errorf("Read %q; want %q", string(data), "piped content")
+		}
+	})
+
+	// A pipe closed without data yields no reader
+	t.Run("Empty pipe", func(t *testing.T) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatalf("Failed to create pipe: %v", err)
+		}
+		defer r.Close()
+		w.Close()
+
+		if reader, err := OpenPipe(r); reader != nil || err != nil {
+			t.Errorf("OpenPipe() on empty pipe = %v, %v; want nil", reader, err)
+		}
+	})
+}
@@ -3,16 +3,16 @@ package util
 
 import (
 	"context"
-	"crypto/tls"
-	"io"
-	"fmt"
-	"net/http"
 	"net/url"
 	"time"
 
 	"github.com/rs/zerolog/log"
 )
 
+// AcceptHeader negotiates the protobuf format, the only one exposing native
+// histograms, with fallbacks to OpenMetrics and the Prometheus text format
+const AcceptHeader = "application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited,application/openmetrics-text;version=1.0.0;q=0.8,text/plain;version=0.0.4;q=0.5,*/*;q=0.1"
+
 // IsURL checks if a string is a valid URL
 func IsURL(str string) bool {
 	u, err := url.Parse(str)
@@ -21,52 +21,10 @@ func IsURL(str string) bool {
 
 // FetchURL fetches a HTTP URL with a timeout and returns the body as bytes
 func FetchURL(urlStr string, insecureSkipVerify bool) ([]byte, error) {
-	if !IsURL(urlStr) {
-		log.Error().Str("url", urlStr).Msg("URL is not valid")
-		return nil, fmt.Errorf("invalid URL: %s", urlStr)
-	}
-
-	// Create a context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-	
-	// Create a custom client if needed
-	client := &http.Client{}
-	if insecureSkipVerify {
-		customTransport := http.DefaultTransport.(*http.Transport).Clone()
-		customTransport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-		client.Transport = customTransport
-		log.Warn().Msg("TLS certificate verification disabled. This is insecure!")
-	}
-	
-	// Create request with context
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, urlStr, nil)
-	if err != nil {
-		log.Error().Err(err).Str("url", urlStr).Msg("Failed to create request")
-		return nil, fmt.Errorf("failed to create request for %s: %w", urlStr, err)
-	}
-	
-	// Execute request
-	resp, err := client.Do(req)
+	data, err := NewFetcher(1, 30*time.Second, 0, insecureSkipVerify).Fetch(context.Background(), urlStr)
 	if err != nil {
-		log.Error().Err(err).Str("url", urlStr).Msg("Failed to connect to url")
-		return nil, fmt.Errorf("failed to connect to %s: %w", urlStr, err)
+		log.Error().Err(err).Str("url", urlStr).Msg("Failed to fetch url")
+		return nil, err
 	}
-	
-	// Always close body when done
-	defer resp.Body.Close()
-	
-	// Check HTTP status code
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		log.Error().Int("status", resp.StatusCode).Str("url", urlStr).Msg("HTTP request failed")
-		return nil, fmt.Errorf("HTTP request to %s failed with status code %d", urlStr, resp.StatusCode)
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Error().Err(err).Str("url", urlStr).Msg("Failed to read response body")
-		return nil, fmt.Errorf("failed to read response body from %s: %w", urlStr, err)
-	}
-
-	return body, nil
-}
\ No newline at end of file
+	return data, nil
+}
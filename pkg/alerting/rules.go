@@ -0,0 +1,262 @@
+// Package alerting generates Prometheus alerting rules for metrics
+package alerting
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/hemzaz/lazydash/internal/config"
+	"github.com/hemzaz/lazydash/pkg/metrics"
+	"github.com/hemzaz/lazydash/pkg/query"
+	"gopkg.in/yaml.v3"
+)
+
+// Severities of the generated rules
+const (
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// PendingFor is how long a threshold must be exceeded before the alert fires
+const PendingFor = "5m"
+
+// RuleFile is a Prometheus rule file
+type RuleFile struct {
+	Groups []RuleGroup `yaml:"groups"`
+}
+
+// RuleGroup is a named group of rules evaluated together
+type RuleGroup struct {
+	Name  string `yaml:"name"`
+	Rules []Rule `yaml:"rules"`
+}
+
+// Rule is a Prometheus alerting rule
+type Rule struct {
+	Alert       string            `yaml:"alert"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// Alert is a threshold on the query of a metric at one severity
+type Alert struct {
+	Metric    *metrics.Metric
+	Name      string  // CamelCase alert name shared by all severities
+	Query     string  // PromQL query without the threshold comparison
+	Threshold float64 // Value above which the alert fires
+	Severity  string  // SeverityWarning or SeverityCritical
+}
+
+// Alerts returns a warning and a critical alert for every metric with an
+// alert threshold. Queries contain no dashboard template variables.
+func Alerts(registry *metrics.Registry, cfg *config.Config) []Alert {
+	builder := query.NewBuilder(ruleConfig(cfg))
+
+	var alerts []Alert
+	for _, name := range registry.List() {
+		metric := registry.Get(name)
+		threshold := Threshold(metric, cfg)
+		if threshold == nil {
+			continue
+		}
+
+		alert := Alert{Metric: metric, Name: AlertName(metric), Query: alertQuery(builder, metric)}
+		if alert.Query == "" {
+			continue
+		}
+		if threshold.Warning > 0 && threshold.Warning != threshold.Error {
+			alert.Threshold, alert.Severity = threshold.Warning, SeverityWarning
+			alerts = append(alerts, alert)
+		}
+		alert.Threshold, alert.Severity = threshold.Error, SeverityCritical
+		alerts = append(alerts, alert)
+	}
+	return alerts
+}
+
+// alertQuery returns the query compared to the threshold. Latencies of
+// summaries and histograms alert on their slowest observations.
+func alertQuery(builder *query.Builder, metric *metrics.Metric) string {
+	switch metric.Type() {
+	case "summary", "histogram":
+		return builder.TailQuery(metric)
+	default:
+		return builder.BuildQuery(metric)
+	}
+}
+
+// Summary returns a one line description of the alert
+func (a Alert) Summary() string {
+	return fmt.Sprintf("%s is above %s", a.Metric.Name(), formatValue(a.Threshold)+unitSuffix(a.Metric.Unit()))
+}
+
+// unitSuffix returns the symbol written after thresholds in a unit, for the
+// units of default thresholds whose symbol is known
+func unitSuffix(unit string) string {
+	if unit == "percent" {
+		return "%"
+	}
+	if _, ok := secondScales[unit]; ok {
+		return unit
+	}
+	return ""
+}
+
+// Description describes the alert starting with the metric HELP text
+func (a Alert) Description() string {
+	description := a.Summary() + "."
+	if help := strings.TrimSpace(a.Metric.Help()); help != "" {
+		description = strings.TrimSuffix(help, ".") + ". " + description
+	}
+	return description + " Current value is {{ $value }}."
+}
+
+// GenerateRules creates the Prometheus rules of all alerts in a single
+// group named after the dashboard
+func GenerateRules(registry *metrics.Registry, cfg *config.Config) *RuleFile {
+	group := RuleGroup{Name: cfg.Title, Rules: []Rule{}}
+	for _, alert := range Alerts(registry, cfg) {
+		group.Rules = append(group.Rules, newRule(alert))
+	}
+	return &RuleFile{Groups: []RuleGroup{group}}
+}
+
+// WriteYAML writes the rule file in the format loaded by Prometheus
+func (f *RuleFile) WriteYAML(w io.Writer) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(f); err != nil {
+		return fmt.Errorf("failed to encode alert rules: %w", err)
+	}
+	return encoder.Close()
+}
+
+// Threshold returns the alert threshold of a metric. Thresholds configured
+// for the metric take precedence over the defaults for common metrics.
+func Threshold(metric *metrics.Metric, cfg *config.Config) *config.AlertThreshold {
+	for _, threshold := range cfg.Alerts {
+		if threshold != nil && threshold.Metric == metric.Name() {
+			return threshold
+		}
+	}
+	return DefaultThreshold(metric)
+}
+
+// percentScales are the values of 100% in the units of percentages
+var percentScales = map[string]float64{
+	"percent":     100,
+	"percentunit": 1,
+}
+
+// secondScales are the values of one second in the units of durations
+var secondScales = map[string]float64{
+	"s":  1,
+	"ms": 1e3,
+	"µs": 1e6,
+	"ns": 1e9,
+}
+
+// DefaultThreshold returns the alert threshold of common metrics, in the
+// unit of the metric: error rates, CPU, memory and disk usage in percent and
+// latencies. Metrics whose unit doesn't match the threshold get none.
+func DefaultThreshold(metric *metrics.Metric) *config.AlertThreshold {
+	name := metric.Name()
+	unit := metric.Unit()
+
+	switch metric.Type() {
+	case "counter":
+		// Error counters alert on their rate per second
+		if (unit == "" || unit == "short") && hasToken(name, "error", "fail") {
+			return newThreshold(name, 0.1, 1)
+		}
+
+	case "gauge":
+		// Usage thresholds only mean something for percentages, not for the
+		// bytes or core counts of metrics named after the same resource
+		scale, ok := percentScales[unit]
+		if !ok {
+			return nil
+		}
+		switch {
+		case hasToken(name, "cpu"):
+			return newThreshold(name, 0.80*scale, 0.95*scale)
+		case hasToken(name, "memory", "mem"):
+			return newThreshold(name, 0.85*scale, 0.95*scale)
+		case hasToken(name, "disk", "filesystem"):
+			return newThreshold(name, 0.80*scale, 0.90*scale)
+		}
+
+	case "summary", "histogram":
+		// Latencies alert on their tail at 1s and 2s
+		scale, ok := secondScales[unit]
+		if ok && hasToken(name, "latency", "duration", "delay", "time") {
+			return newThreshold(name, 1*scale, 2*scale)
+		}
+	}
+	return nil
+}
+
+// newThreshold returns a notifying threshold
+func newThreshold(name string, warning, critical float64) *config.AlertThreshold {
+	return &config.AlertThreshold{Metric: name, Warning: warning, Error: critical, Notify: true}
+}
+
+// hasToken returns whether a word of a metric name starts with one of the
+// prefixes, e.g. failures for fail
+func hasToken(name string, prefixes ...string) bool {
+	for _, word := range strings.Split(name, "_") {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(word, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// newRule creates a rule that fires while the query is above the threshold
+func newRule(alert Alert) Rule {
+	return Rule{
+		Alert: alert.Name,
+		Expr:  fmt.Sprintf("%s > %s", alert.Query, formatValue(alert.Threshold)),
+		For:   PendingFor,
+		Labels: map[string]string{
+			"severity": alert.Severity,
+		},
+		Annotations: map[string]string{
+			"summary":     alert.Summary(),
+			"description": alert.Description(),
+		},
+	}
+}
+
+// formatValue formats a threshold without trailing zeros
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// AlertName returns the rule name for a metric in Prometheus' CamelCase
+// convention, e.g. node_cpu_usage -> NodeCpuUsageHigh
+func AlertName(metric *metrics.Metric) string {
+	var name strings.Builder
+	for _, part := range strings.Split(metric.Name(), "_") {
+		if part == "" {
+			continue
+		}
+		name.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	name.WriteString("High")
+	return name.String()
+}
+
+// ruleConfig returns the query config for rules. Rules are evaluated by
+// Prometheus, where dashboard template variables such as $job don't exist.
+func ruleConfig(cfg *config.Config) *config.Config {
+	rulesCfg := *cfg
+	rulesCfg.Templating = nil
+	return &rulesCfg
+}
@@ -0,0 +1,189 @@
+package alerting
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/hemzaz/lazydash/internal/config"
+	"github.com/hemzaz/lazydash/pkg/metrics"
+	"github.com/hemzaz/lazydash/pkg/prometheus"
+	"gopkg.in/yaml.v3"
+)
+
+// testRegistry returns metrics with and without default alert thresholds
+func testRegistry() *metrics.Registry {
+	registry := metrics.NewRegistry()
+	registry.Set("http_errors", metrics.New("http_errors", "HTTP errors served.", map[string]bool{"job": true}, "counter", "_total", "short"))
+	registry.Set("node_cpu_usage", metrics.New("node_cpu_usage", "", nil, "gauge", "", "percent"))
+	registry.Set("http_requests", metrics.New("http_requests", "", nil, "counter", "_total", "short"))
+	return registry
+}
+
+func TestGenerateRules(t *testing.T) {
+	cfg := config.New()
+	cfg.Templating = &config.TemplatingConfig{Enabled: true}
+
+	rules := GenerateRules(testRegistry(), cfg)
+	if len(rules.Groups) != 1 || rules.Groups[0].Name != cfg.Title {
+		t.Fatalf("Expected one group named %q, got %+v", cfg.Title, rules.Groups)
+	}
+
+	expected := []struct {
+		alert    string
+		expr     string
+		severity string
+	}{
+		{"HttpErrorsHigh", "sum(rate(http_errors_total [1m])) > 0.1", SeverityWarning},
+		{"HttpErrorsHigh", "sum(rate(http_errors_total [1m])) > 1", SeverityCritical},
+		{"NodeCpuUsageHigh", "node_cpu_usage > 80", SeverityWarning},
+		{"NodeCpuUsageHigh", "node_cpu_usage > 95", SeverityCritical},
+	}
+
+	got := rules.Groups[0].Rules
+	if len(got) != len(expected) {
+		t.Fatalf("Expected %d rules, got %d: %+v", len(expected), len(got), got)
+	}
+	for i, want := range expected {
+		rule := got[i]
+		if rule.Alert != want.alert || rule.Expr != want.expr || rule.Labels["severity"] != want.severity {
+			t.Errorf("Rule %d = %s %q %s; want %s %q %s", i, rule.Alert, rule.Expr, rule.Labels["severity"], want.alert, want.expr, want.severity)
+		}
+		if rule.For != "5m" {
+			t.Errorf("Rule %d for = %q; want 5m", i, rule.For)
+		}
+	}
+
+	// Template variables only exist in Grafana
+	if strings.Contains(got[0].Expr, "$job") {
+		t.Errorf("Expected no template variables in %q", got[0].Expr)
+	}
+	if description := got[0].Annotations["description"]; !strings.HasPrefix(description, "HTTP errors served. http_errors is above 0.1.") {
+		t.Errorf("Expected description from HELP, got %q", description)
+	}
+}
+
+func TestGenerateRulesPromdata(t *testing.T) {
+	data, err := os.ReadFile("../../promdata.txt")
+	if err != nil {
+		t.Fatalf("Failed to read promdata.txt: %v", err)
+	}
+	registry, err := prometheus.ParseMetrics(data)
+	if err != nil {
+		t.Fatalf("Failed to parse promdata.txt: %v", err)
+	}
+
+	// Error rates, and latencies at 1s and 2s in their own unit. Byte and
+	// core count gauges named after memory or CPUs get no rules.
+	expected := []string{
+		"sum(rate(builder_builds_failed_total [1m])) > 1",
+		"sum(rate(engine_daemon_health_checks_failed_total [1m])) > 1",
+		"histogram_quantile(0.99, sum by (le) (rate(etcd_debugging_snap_save_marshalling_duration_seconds_bucket[5m]))) > 2",
+		"histogram_quantile(0.99, sum by (le) (rate(etcd_debugging_snap_save_total_duration_seconds_bucket[5m]))) > 2",
+		"histogram_quantile(0.99, sum by (le) (rate(etcd_disk_wal_fsync_duration_seconds_bucket[5m]))) > 2",
+		"histogram_quantile(0.99, sum by (le) (rate(etcd_snap_db_fsync_duration_seconds_bucket[5m]))) > 2",
+		"histogram_quantile(0.99, sum by (le) (rate(etcd_snap_db_save_total_duration_seconds_bucket[5m]))) > 2",
+		"go_gc_duration_seconds{quantile=\"1\"} > 2",
+		"http_request_duration_microseconds{quantile=\"0.99\"} > 2000000",
+		"sum(rate(logger_log_read_operations_failed_total [1m])) > 1",
+		"sum(rate(logger_log_write_operations_failed_total [1m])) > 1",
+		"histogram_quantile(0.99, sum by (le) (rate(swarm_dispatcher_scheduling_delay_seconds_bucket[5m]))) > 2",
+		"histogram_quantile(0.99, sum by (le) (rate(swarm_raft_snapshot_latency_seconds_bucket[5m]))) > 2",
+		"histogram_quantile(0.99, sum by (le) (rate(swarm_raft_transaction_latency_seconds_bucket[5m]))) > 2",
+		"histogram_quantile(0.99, sum by (le) (rate(swarm_store_batch_latency_seconds_bucket[5m]))) > 2",
+		"histogram_quantile(0.99, sum by (le) (rate(swarm_store_lookup_latency_seconds_bucket[5m]))) > 2",
+		"histogram_quantile(0.99, sum by (le) (rate(swarm_store_memory_store_lock_duration_seconds_bucket[5m]))) > 2",
+		"histogram_quantile(0.99, sum by (le) (rate(swarm_store_read_tx_latency_seconds_bucket[5m]))) > 2",
+		"histogram_quantile(0.99, sum by (le) (rate(swarm_store_write_tx_latency_seconds_bucket[5m]))) > 2",
+	}
+
+	var critical []string
+	for _, rule := range GenerateRules(registry, config.New()).Groups[0].Rules {
+		if rule.Labels["severity"] == SeverityCritical {
+			critical = append(critical, rule.Expr)
+		}
+	}
+	if strings.Join(critical, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Critical rules =\n%s\nwant\n%s", strings.Join(critical, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestDefaultThreshold(t *testing.T) {
+	tests := []struct {
+		metric            *metrics.Metric
+		warning, critical float64
+	}{
+		{metrics.New("node_cpu_usage", "", nil, "gauge", "", "percent"), 80, 95},
+		{metrics.New("node_memory_utilisation_ratio", "", nil, "gauge", "", "percentunit"), 0.85, 0.95},
+		{metrics.New("rpc_duration_seconds", "", nil, "histogram", "", "s"), 1, 2},
+		{metrics.New("rpc_duration_milliseconds", "", nil, "summary", "", "ms"), 1000, 2000},
+		{metrics.New("jobs_failed", "", nil, "counter", "_total", "short"), 0.1, 1},
+	}
+	for _, tt := range tests {
+		threshold := DefaultThreshold(tt.metric)
+		if threshold == nil || threshold.Warning != tt.warning || threshold.Error != tt.critical {
+			t.Errorf("DefaultThreshold(%s) = %+v; want %v and %v", tt.metric.Name(), threshold, tt.warning, tt.critical)
+		}
+	}
+
+	// Unknown scales get no threshold
+	for _, metric := range []*metrics.Metric{
+		metrics.New("process_resident_memory_bytes", "", nil, "gauge", "", "decbytes"),
+		metrics.New("engine_daemon_engine_cpus_cpus", "", nil, "gauge", "", "short"),
+		metrics.New("go_memstats_last_gc_time_seconds", "", nil, "gauge", "", "s"),
+		metrics.New("rpc_duration", "", nil, "summary", "", ""),
+		metrics.New("failed_bytes", "", nil, "counter", "_total", "decbytes"),
+	} {
+		if threshold := DefaultThreshold(metric); threshold != nil {
+			t.Errorf("DefaultThreshold(%s) = %+v; want none", metric.Name(), threshold)
+		}
+	}
+}
+
+func TestThresholdOverride(t *testing.T) {
+	cfg := config.New()
+	cfg.Alerts = []*config.AlertThreshold{{Metric: "http_requests", Warning: 100, Error: 500}}
+
+	metric := testRegistry().Get("http_requests")
+	threshold := Threshold(metric, cfg)
+	if threshold == nil || threshold.Warning != 100 || threshold.Error != 500 {
+		t.Errorf("Expected configured threshold, got %+v", threshold)
+	}
+	if DefaultThreshold(metric) != nil {
+		t.Errorf("Expected no default threshold for %s", metric.Name())
+	}
+}
+
+func TestWriteYAML(t *testing.T) {
+	rules := GenerateRules(testRegistry(), config.New())
+
+	var buf bytes.Buffer
+	if err := rules.WriteYAML(&buf); err != nil {
+		t.Fatalf("WriteYAML() error = %v", err)
+	}
+	if !strings.HasPrefix(buf.String(), "groups:\n  - name: Prometheus Dashboard\n    rules:\n      - alert: HttpErrorsHigh\n") {
+		t.Errorf("Unexpected rule file:\n%s", buf.String())
+	}
+
+	var parsed RuleFile
+	if err := yaml.Unmarshal(buf.Bytes(), &parsed); err != nil {
+		t.Fatalf("Failed to read back rule file: %v", err)
+	}
+	if len(parsed.Groups[0].Rules) != 4 {
+		t.Errorf("Expected 4 rules, got %d", len(parsed.Groups[0].Rules))
+	}
+}
+
+func TestAlertName(t *testing.T) {
+	tests := map[string]string{
+		"node_cpu_usage":        "NodeCpuUsageHigh",
+		"_components_cpu_usage": "ComponentsCpuUsageHigh",
+		"up":                    "UpHigh",
+	}
+	for name, want := range tests {
+		if got := AlertName(metrics.New(name, "", nil, "gauge", "", "")); got != want {
+			t.Errorf("AlertName(%s) = %s; want %s", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package alerting
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// Validate parses the expression of every rule with the PromQL parser.
+// Alerting rules must evaluate to an instant vector, so scalar and range
+// vector expressions are rejected as well.
+func Validate(f *RuleFile) error {
+	var errs []error
+	for _, group := range f.Groups {
+		if group.Name == "" {
+			errs = append(errs, errors.New("rule group without name"))
+		}
+
+		for _, rule := range group.Rules {
+			expr, err := parser.ParseExpr(rule.Expr)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("rule %s: invalid expression %q: %w", rule.Alert, rule.Expr, err))
+				continue
+			}
+			if expr.Type() != parser.ValueTypeVector {
+				errs = append(errs, fmt.Errorf("rule %s: expression %q returns a %s, not an instant vector", rule.Alert, rule.Expr, expr.Type()))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
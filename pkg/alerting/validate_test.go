@@ -0,0 +1,39 @@
+package alerting
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hemzaz/lazydash/internal/config"
+)
+
+func TestValidate(t *testing.T) {
+	t.Run("Generated rules", func(t *testing.T) {
+		if err := Validate(GenerateRules(testRegistry(), config.New())); err != nil {
+			t.Errorf("Validate() error = %v", err)
+		}
+	})
+
+	tests := []struct {
+		name string
+		expr string
+		want string
+	}{
+		{"Syntax error", "sum(rate(http_errors_total[1m]) > 1", "invalid expression"},
+		{"Scalar", "1 > bool 0", "not an instant vector"},
+		{"Range vector", "http_errors_total[5m]", "not an instant vector"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := &RuleFile{Groups: []RuleGroup{{
+				Name:  "test",
+				Rules: []Rule{{Alert: "Broken", Expr: tt.expr}},
+			}}}
+			err := Validate(rules)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Validate(%q) error = %v; want %q", tt.expr, err, tt.want)
+			}
+		})
+	}
+}
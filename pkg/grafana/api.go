@@ -1,17 +1,15 @@
 package grafana
 
 import (
-	"bytes"
 	"context"
-	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"net/http"
-	"time"
+	"net/url"
+	"strings"
 
 	"github.com/hemzaz/lazydash/internal/config"
-	"github.com/hemzaz/lazydash/internal/util"
 	"github.com/rs/zerolog/log"
 )
 
@@ -38,236 +36,267 @@ type GrafanaFolderError struct {
 	Status  string `json:"status"`
 }
 
-// PostDashboard posts a dashboard to Grafana
-func PostDashboard(host string, insecureSkipVerify bool, token string, dashboard *Dashboard, cfg *config.Config) {
-	if !util.IsURL(host) {
-		log.Fatal().Str("host", host).Msg("Host URL is not valid")
+// CommitMessagePrefix starts the version message of every dashboard saved by
+// lazydash. Versions with other messages were saved by someone else.
+const CommitMessagePrefix = "lazydash"
+
+// ErrModified is matched by the error of saving a dashboard that was changed
+// outside lazydash since lazydash last saved it
+var ErrModified = errors.New("dashboard was changed outside lazydash")
+
+// ModifiedError is a dashboard whose latest version was not saved by lazydash
+type ModifiedError struct {
+	UID       string
+	Version   int
+	CreatedBy string
+	Message   string
+}
+
+// Error describes who changed the dashboard
+func (e *ModifiedError) Error() string {
+	if e.Version == 0 {
+		return fmt.Sprintf("dashboard %s has no version saved by lazydash", e.UID)
 	}
+	return fmt.Sprintf("dashboard %s version %d was saved by %s with message %q, not by lazydash", e.UID, e.Version, e.CreatedBy, e.Message)
+}
+
+// Is matches ErrModified
+func (e *ModifiedError) Is(target error) bool {
+	return target == ErrModified
+}
+
+// SaveOptions controls how PostDashboard saves a dashboard
+type SaveOptions struct {
+	Folder  *config.FolderConfig // Folder the dashboard is saved in, it stays where it is if unset
+	Message string               // Version message, starting with CommitMessagePrefix
+	Force   bool                 // Overwrite changes made outside lazydash
+}
+
+// DashboardResponse is the reply of Grafana to a saved dashboard
+type DashboardResponse struct {
+	ID      int    `json:"id"`
+	UID     string `json:"uid"`
+	URL     string `json:"url"`
+	Status  string `json:"status"`
+	Version int    `json:"version"`
+}
+
+// DashboardMeta is the metadata Grafana returns with a dashboard
+type DashboardMeta struct {
+	FolderID    int    `json:"folderId"`
+	FolderUID   string `json:"folderUid"`
+	FolderTitle string `json:"folderTitle"`
+	UpdatedBy   string `json:"updatedBy"`
+	Updated     string `json:"updated"`
+	Version     int    `json:"version"`
+	Provisioned bool   `json:"provisioned"`
+}
 
-	// Create a context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+// savedDashboard identifies the saved version of a dashboard
+type savedDashboard struct {
+	ID      int `json:"id"`
+	Version int `json:"version"`
+}
+
+// DashboardVersion is an entry of the version history of a dashboard
+type DashboardVersion struct {
+	Version   int    `json:"version"`
+	CreatedBy string `json:"createdBy"`
+	Created   string `json:"created"`
+	Message   string `json:"message"`
+}
 
-	// Create a custom client with optional TLS config
-	client := &http.Client{}
-	if insecureSkipVerify {
-		customTransport := http.DefaultTransport.(*http.Transport).Clone()
-		customTransport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-		client.Transport = customTransport
-		log.Warn().Msg("TLS certificate verification disabled. This is insecure!")
+// PostDashboard saves a dashboard. A dashboard with a UID that already exists
+// is only updated if lazydash saved its latest version, unless forced, and
+// Grafana rejects the update with ErrConflict if it changed meanwhile. The
+// dashboard takes the UID Grafana assigned unless it had one.
+func (c *Client) PostDashboard(ctx context.Context, dashboard *Dashboard, options SaveOptions) (*DashboardResponse, error) {
+	submission := struct {
+		Dashboard *Dashboard `json:"dashboard"`
+		FolderID  int        `json:"folderId,omitempty"`
+		FolderUID string     `json:"folderUid,omitempty"`
+		Message   string     `json:"message,omitempty"`
+		Overwrite bool       `json:"overwrite"`
+	}{
+		Dashboard: dashboard,
+		Message:   options.Message,
+		Overwrite: options.Force || dashboard.UID == "",
 	}
-	
+
+	if dashboard.UID != "" {
+		existing, meta, err := c.savedVersion(ctx, dashboard.UID)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			dashboard.ID, dashboard.Version = 0, 0
+		case err != nil:
+			return nil, fmt.Errorf("failed to get dashboard %s: %w", dashboard.UID, err)
+		default:
+			if !options.Force {
+				if err := c.checkUnmodified(ctx, dashboard.UID); err != nil {
+					return nil, err
+				}
+			}
+			// Grafana rejects the update if another version was saved since
+			dashboard.ID, dashboard.Version = existing.ID, existing.Version
+			submission.FolderUID = meta.FolderUID
+		}
+	}
+
 	// If folder config is provided, set up dashboard in the specified folder
-	if cfg != nil && cfg.FolderConfig != nil && cfg.FolderConfig.Name != "" {
-		// Get or create the folder
-		folder, err := GetOrCreateFolder(host, token, insecureSkipVerify, cfg.FolderConfig)
+	if options.Folder != nil && options.Folder.Name != "" {
+		folder, err := c.GetOrCreateFolder(ctx, options.Folder)
 		if err != nil {
 			log.Warn().Err(err).Msg("Failed to get/create folder. Using default folder")
 		} else {
-			// Set the folder ID in the dashboard
 			dashboard.FolderID = folder.ID
+			submission.FolderUID = folder.UID
 			log.Info().Str("folder", folder.Title).Int("id", folder.ID).Msg("Using folder")
 		}
 	}
+	submission.FolderID = dashboard.FolderID
 
-	// Prepare dashboard submission
-	dashboardSubmission := struct {
-		Dashboard *Dashboard `json:"dashboard"`
-		FolderID  int        `json:"folderId,omitempty"`
-		Overwrite bool       `json:"overwrite"`
-	}{
-		Dashboard: dashboard,
-		FolderID:  dashboard.FolderID,
-		Overwrite: true,
+	var response DashboardResponse
+	if err := c.do(ctx, http.MethodPost, "/api/dashboards/db", submission, &response, nil); err != nil {
+		return nil, fmt.Errorf("failed to post dashboard: %w", err)
+	}
+	if dashboard.UID == "" {
+		// Alert rules link to their panels by dashboard UID
+		dashboard.UID = response.UID
 	}
 
-	// Marshal to JSON
-	body, err := json.Marshal(dashboardSubmission)
-	if err != nil {
-		log.Fatal().Err(err).Msg("Could not create JSON body")
+	if response.URL != "" {
+		log.Info().Str("dashboard", dashboard.Title).Int("version", response.Version).Str("url", c.host+response.URL).Msg("Dashboard saved")
+	} else {
+		log.Info().Str("dashboard", dashboard.Title).Msg("Dashboard saved successfully")
+	}
+	return &response, nil
+}
+
+// GetDashboard gets a dashboard and its metadata by UID. The dashboard holds
+// the settings ParseDashboard reads.
+func (c *Client) GetDashboard(ctx context.Context, uid string) (*Dashboard, *DashboardMeta, error) {
+	var response struct {
+		Dashboard json.RawMessage `json:"dashboard"`
+		Meta      DashboardMeta   `json:"meta"`
 	}
-	
-	// Create request
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, host+"/api/dashboards/db", bytes.NewReader(body))
+	if err := c.do(ctx, http.MethodGet, "/api/dashboards/uid/"+url.PathEscape(uid), nil, &response, nil); err != nil {
+		return nil, nil, err
+	}
+	if len(response.Dashboard) == 0 || string(response.Dashboard) == "null" {
+		return nil, nil, fmt.Errorf("dashboard %s missing from the response", uid)
+	}
+	dashboard, err := ParseDashboard(response.Dashboard)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to create request")
+		return nil, nil, err
 	}
+	return dashboard, &response.Meta, nil
+}
 
-	// Set headers
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("Content-Type", "application/json")
+// savedVersion gets the ID and version of a saved dashboard and its metadata
+// by UID. Only these fields are decoded: dashboards edited in the Grafana UI
+// may hold a model lazydash does not write, such as datasource objects.
+func (c *Client) savedVersion(ctx context.Context, uid string) (*savedDashboard, *DashboardMeta, error) {
+	var response struct {
+		Dashboard *savedDashboard `json:"dashboard"`
+		Meta      DashboardMeta        `json:"meta"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/api/dashboards/uid/"+url.PathEscape(uid), nil, &response, nil); err != nil {
+		return nil, nil, err
+	}
+	if response.Dashboard == nil {
+		return nil, nil, fmt.Errorf("dashboard %s missing from the response", uid)
+	}
+	return response.Dashboard, &response.Meta, nil
+}
 
-	// Send request
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to post dashboard")
+// LatestVersion gets the newest entry of the version history of a dashboard,
+// or nil if it has none
+func (c *Client) LatestVersion(ctx context.Context, uid string) (*DashboardVersion, error) {
+	var response json.RawMessage
+	if err := c.do(ctx, http.MethodGet, "/api/dashboards/uid/"+url.PathEscape(uid)+"/versions?limit=1", nil, &response, nil); err != nil {
+		return nil, err
 	}
-	defer resp.Body.Close()
-	
-	// Check response status
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		responseBody, _ := ioutil.ReadAll(resp.Body)
-		log.Fatal().Int("status", resp.StatusCode).Str("body", string(responseBody)).Msg("HTTP request failed")
+
+	// Grafana 11 wraps the list in an object
+	var versions []DashboardVersion
+	if err := json.Unmarshal(response, &versions); err != nil {
+		var page struct {
+			Versions []DashboardVersion `json:"versions"`
+		}
+		if err := json.Unmarshal(response, &page); err != nil {
+			return nil, fmt.Errorf("failed to decode versions of dashboard %s: %w", uid, err)
+		}
+		versions = page.Versions
 	}
-	
-	// Read response
-	var dashboardResp struct {
-		ID      int    `json:"id"`
-		UID     string `json:"uid"`
-		URL     string `json:"url"`
-		Status  string `json:"status"`
-		Version int    `json:"version"`
+
+	if len(versions) == 0 {
+		return nil, nil
 	}
-	
-	responseBody, _ := ioutil.ReadAll(resp.Body)
-	if err := json.Unmarshal(responseBody, &dashboardResp); err == nil && dashboardResp.URL != "" {
-		log.Info().Str("dashboard", dashboard.Title).Str("url", host+dashboardResp.URL).Msg("Dashboard created")
-	} else {
-		log.Info().Str("dashboard", dashboard.Title).Msg("Dashboard created successfully")
+	latest := &versions[0]
+	for i := range versions {
+		if versions[i].Version > latest.Version {
+			latest = &versions[i]
+		}
 	}
+	return latest, nil
+}
+
+// checkUnmodified returns a ModifiedError unless lazydash saved the latest
+// version of a dashboard
+func (c *Client) checkUnmodified(ctx context.Context, uid string) error {
+	latest, err := c.LatestVersion(ctx, uid)
+	if err != nil {
+		return fmt.Errorf("failed to get versions of dashboard %s: %w", uid, err)
+	}
+	if latest == nil {
+		return &ModifiedError{UID: uid}
+	}
+	if !strings.HasPrefix(latest.Message, CommitMessagePrefix) {
+		return &ModifiedError{UID: uid, Version: latest.Version, CreatedBy: latest.CreatedBy, Message: latest.Message}
+	}
+	return nil
 }
 
 // GetOrCreateFolder gets a folder by title, or creates it if it doesn't exist
-func GetOrCreateFolder(host, token string, insecureSkipVerify bool, config *config.FolderConfig) (*GrafanaFolder, error) {
-	// First try to find existing folder
-	folders, err := GetFoldersByTitle(host, token, insecureSkipVerify, config.Name)
+func (c *Client) GetOrCreateFolder(ctx context.Context, config *config.FolderConfig) (*GrafanaFolder, error) {
+	folders, err := c.GetFoldersByTitle(ctx, config.Name)
 	if err != nil {
 		return nil, fmt.Errorf("error getting folders: %w", err)
 	}
-	
-	// Return existing folder if found
 	if len(folders) > 0 {
 		return &folders[0], nil
 	}
-	
-	// Create new folder if allowed
+
 	if config.Create {
-		folder := &GrafanaFolder{
-			Title: config.Name,
-		}
-		return CreateFolder(host, token, insecureSkipVerify, folder)
+		return c.CreateFolder(ctx, &GrafanaFolder{Title: config.Name})
 	}
-	
 	return nil, fmt.Errorf("folder '%s' not found and create=false", config.Name)
 }
 
 // GetFoldersByTitle gets folders from Grafana API filtered by title
-func GetFoldersByTitle(host, token string, insecureSkipVerify bool, title string) ([]GrafanaFolder, error) {
-	// Create a context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	// Create a custom client with optional TLS config
-	client := &http.Client{}
-	if insecureSkipVerify {
-		customTransport := http.DefaultTransport.(*http.Transport).Clone()
-		customTransport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-		client.Transport = customTransport
-	}
-	
-	// Create request
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, host+"/api/folders", nil)
-	if err != nil {
-		return nil, fmt.Errorf("error creating request: %w", err)
-	}
-	
-	// Set headers
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("Content-Type", "application/json")
-	
-	// Send request
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("error getting folders: %w", err)
-	}
-	defer resp.Body.Close()
-	
-	// Check response status
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		responseBody, _ := ioutil.ReadAll(resp.Body)
-		return nil, fmt.Errorf("HTTP request failed with status %d: %s", resp.StatusCode, string(responseBody))
-	}
-	
-	// Read response
-	responseBody, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("error reading response body: %w", err)
-	}
-	
-	// Parse response
+func (c *Client) GetFoldersByTitle(ctx context.Context, title string) ([]GrafanaFolder, error) {
 	var folders []GrafanaFolder
-	if err := json.Unmarshal(responseBody, &folders); err != nil {
-		return nil, fmt.Errorf("error parsing response: %w", err)
+	if err := c.do(ctx, http.MethodGet, "/api/folders", nil, &folders, nil); err != nil {
+		return nil, err
 	}
-	
-	// Filter by title if provided
-	if title != "" {
-		var filtered []GrafanaFolder
-		for _, folder := range folders {
-			if folder.Title == title {
-				filtered = append(filtered, folder)
-			}
+	if title == "" {
+		return folders, nil
+	}
+
+	var filtered []GrafanaFolder
+	for _, folder := range folders {
+		if folder.Title == title {
+			filtered = append(filtered, folder)
 		}
-		return filtered, nil
 	}
-	
-	return folders, nil
+	return filtered, nil
 }
 
 // CreateFolder creates a new folder in Grafana
-func CreateFolder(host, token string, insecureSkipVerify bool, folder *GrafanaFolder) (*GrafanaFolder, error) {
-	// Create a context with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	// Create a custom client with optional TLS config
-	client := &http.Client{}
-	if insecureSkipVerify {
-		customTransport := http.DefaultTransport.(*http.Transport).Clone()
-		customTransport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-		client.Transport = customTransport
-	}
-	
-	// Marshal folder to JSON
-	body, err := json.Marshal(folder)
-	if err != nil {
-		return nil, fmt.Errorf("error marshaling folder: %w", err)
-	}
-	
-	// Create request
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, host+"/api/folders", bytes.NewReader(body))
-	if err != nil {
-		return nil, fmt.Errorf("error creating request: %w", err)
-	}
-	
-	// Set headers
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("Content-Type", "application/json")
-	
-	// Send request
-	resp, err := client.Do(req)
-	if err != nil {
+func (c *Client) CreateFolder(ctx context.Context, folder *GrafanaFolder) (*GrafanaFolder, error) {
+	var created GrafanaFolder
+	if err := c.do(ctx, http.MethodPost, "/api/folders", folder, &created, nil); err != nil {
 		return nil, fmt.Errorf("error creating folder: %w", err)
 	}
-	defer resp.Body.Close()
-	
-	// Check response status
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		responseBody, _ := ioutil.ReadAll(resp.Body)
-		return nil, fmt.Errorf("HTTP request failed with status %d: %s", resp.StatusCode, string(responseBody))
-	}
-	
-	// Read response
-	responseBody, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("error reading response body: %w", err)
-	}
-	
-	// Parse response
-	var createdFolder GrafanaFolder
-	if err := json.Unmarshal(responseBody, &createdFolder); err != nil {
-		return nil, fmt.Errorf("error parsing response: %w", err)
-	}
-	
-	return &createdFolder, nil
-}
\ No newline at end of file
+	return &created, nil
+}
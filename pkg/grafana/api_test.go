@@ -0,0 +1,239 @@
+package grafana
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hemzaz/lazydash/internal/config"
+)
+
+// fakeDashboards is a Grafana keeping the version history and folder of its
+// dashboards
+type fakeDashboards struct {
+	mu        sync.Mutex
+	versions  map[string][]DashboardVersion
+	folders   map[string]string // Folder UID of each dashboard
+	saved     []map[string]interface{}
+	grafana11 bool // Wrap version lists in an object
+	uiEdited  bool // Return panels the Grafana 8+ UI saved, with datasource objects
+
+	concurrentSave bool // Another lazydash run saves right after each dashboard is read
+}
+
+func newFakeDashboards(t *testing.T) (*fakeDashboards, *httptest.Server) {
+	t.Helper()
+	f := &fakeDashboards{versions: map[string][]DashboardVersion{}, folders: map[string]string{}}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	return f, server
+}
+
+// edit records a version saved in the Grafana UI
+func (f *fakeDashboards) edit(uid, user, message string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.versions[uid] = append(f.versions[uid], DashboardVersion{Version: len(f.versions[uid]) + 1, CreatedBy: user, Message: message})
+}
+
+func (f *fakeDashboards) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/api/dashboards/uid/")
+	switch {
+	case r.URL.Path == "/api/dashboards/db":
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		f.saved = append(f.saved, body)
+
+		dashboard := body["dashboard"].(map[string]interface{})
+		uid := dashboard["uid"].(string)
+		versions := f.versions[uid]
+		if body["overwrite"] != true && len(versions) > 0 && int(dashboard["version"].(float64)) != len(versions) {
+			http.Error(w, `{"message":"version-mismatch"}`, http.StatusPreconditionFailed)
+			return
+		}
+		message, _ := body["message"].(string)
+		f.versions[uid] = append(versions, DashboardVersion{Version: len(versions) + 1, CreatedBy: "lazydash", Message: message})
+		if folder, ok := body["folderUid"].(string); ok {
+			f.folders[uid] = folder
+		}
+		json.NewEncoder(w).Encode(DashboardResponse{ID: 1, UID: uid, URL: "/d/" + uid, Version: len(versions) + 1})
+
+	case strings.HasSuffix(path, "/versions"):
+		versions := f.versions[strings.TrimSuffix(path, "/versions")]
+		if f.grafana11 {
+			json.NewEncoder(w).Encode(map[string]interface{}{"versions": versions, "continueToken": ""})
+			return
+		}
+		json.NewEncoder(w).Encode(versions)
+
+	case r.URL.Path != path:
+		versions, ok := f.versions[path]
+		if !ok {
+			http.Error(w, `{"message":"Dashboard not found"}`, http.StatusNotFound)
+			return
+		}
+		model := map[string]interface{}{"id": 1, "uid": path, "version": len(versions)}
+		if f.uiEdited {
+			model["panels"] = []interface{}{map[string]interface{}{
+				"id":         1,
+				"type":       "timeseries",
+				"datasource": map[string]interface{}{"type": "prometheus", "uid": "P1809F7CD0C75ACF3"},
+				"targets":    []interface{}{map[string]interface{}{"datasource": map[string]interface{}{"type": "prometheus", "uid": "P1809F7CD0C75ACF3"}, "expr": "up", "refId": "A"}},
+			}}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"dashboard": model,
+			"meta":      DashboardMeta{FolderUID: f.folders[path], Version: len(versions)},
+		})
+		if f.concurrentSave {
+			f.versions[path] = append(versions, DashboardVersion{Version: len(versions) + 1, CreatedBy: "lazydash", Message: CommitMessagePrefix})
+		}
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestPostDashboardUpdates(t *testing.T) {
+	save := func(t *testing.T, server *httptest.Server, force bool) error {
+		t.Helper()
+		dashboard := NewDashboard("Fleet")
+		dashboard.UID = StableUID("Fleet")
+		_, err := testClient(t, server.URL).PostDashboard(context.Background(), dashboard, SaveOptions{Message: "lazydash 1.0.0: generated from a.txt", Force: force})
+		return err
+	}
+
+	t.Run("Created then updated", func(t *testing.T) {
+		f, server := newFakeDashboards(t)
+		if err := save(t, server, false); err != nil {
+			t.Fatalf("First PostDashboard() error = %v", err)
+		}
+		if err := save(t, server, false); err != nil {
+			t.Fatalf("Second PostDashboard() error = %v", err)
+		}
+
+		if len(f.saved) != 2 || f.saved[0]["overwrite"] != false || f.saved[1]["overwrite"] != false {
+			t.Fatalf("Expected two saves without overwrite, got %+v", f.saved)
+		}
+		if version := f.saved[1]["dashboard"].(map[string]interface{})["version"]; version != 1.0 {
+			t.Errorf("Expected the update to be based on version 1, got %v", version)
+		}
+		if f.saved[1]["message"] != "lazydash 1.0.0: generated from a.txt" {
+			t.Errorf("Expected the commit message, got %v", f.saved[1]["message"])
+		}
+	})
+
+	t.Run("Refuses human edits", func(t *testing.T) {
+		for _, grafana11 := range []bool{false, true} {
+			f, server := newFakeDashboards(t)
+			f.grafana11 = grafana11
+			save(t, server, false)
+			f.edit(StableUID("Fleet"), "alice", "Moved the CPU panel")
+
+			err := save(t, server, false)
+			var modified *ModifiedError
+			if !errors.Is(err, ErrModified) || !errors.As(err, &modified) || modified.CreatedBy != "alice" || modified.Version != 2 {
+				t.Fatalf("Expected a modified error naming alice, got %v", err)
+			}
+			if len(f.saved) != 1 {
+				t.Errorf("Expected the edited dashboard to be left alone, got %d saves", len(f.saved))
+			}
+
+			if err := save(t, server, true); err != nil {
+				t.Fatalf("Forced PostDashboard() error = %v", err)
+			}
+			if f.saved[1]["overwrite"] != true {
+				t.Errorf("Expected a forced save to overwrite")
+			}
+		}
+	})
+
+	t.Run("Edited in the Grafana 8 UI", func(t *testing.T) {
+		f, server := newFakeDashboards(t)
+		save(t, server, false)
+		f.edit(StableUID("Fleet"), "alice", "")
+		f.uiEdited = true
+
+		if err := save(t, server, false); !errors.Is(err, ErrModified) {
+			t.Fatalf("Expected a modified error, got %v", err)
+		}
+		if err := save(t, server, true); err != nil {
+			t.Fatalf("Forced PostDashboard() error = %v", err)
+		}
+		if version := f.saved[1]["dashboard"].(map[string]interface{})["version"]; version != 2.0 {
+			t.Errorf("Expected the forced save to be based on version 2, got %v", version)
+		}
+	})
+
+	t.Run("Keeps the folder", func(t *testing.T) {
+		f, server := newFakeDashboards(t)
+		uid := StableUID("Fleet")
+		f.edit(uid, "lazydash", "lazydash 0.9.0: generated from a.txt")
+		f.folders[uid] = "ops"
+
+		if err := save(t, server, false); err != nil {
+			t.Fatalf("PostDashboard() error = %v", err)
+		}
+		if f.saved[0]["folderUid"] != "ops" {
+			t.Errorf("Expected the dashboard to stay in its folder, got %v", f.saved[0]["folderUid"])
+		}
+	})
+
+	t.Run("Version conflict", func(t *testing.T) {
+		f, server := newFakeDashboards(t)
+		save(t, server, false)
+		f.concurrentSave = true
+
+		if err := save(t, server, false); !errors.Is(err, ErrConflict) {
+			t.Errorf("Expected a conflict with the concurrent save, got %v", err)
+		}
+	})
+}
+
+func TestGetDashboardEdited(t *testing.T) {
+	f, server := newFakeDashboards(t)
+	f.edit("fleet", "alice", "")
+	f.uiEdited = true
+
+	dashboard, _, err := testClient(t, server.URL).GetDashboard(context.Background(), "fleet")
+	if err != nil {
+		t.Fatalf("GetDashboard() error = %v", err)
+	}
+	if len(dashboard.Panels) != 1 || dashboard.Panels[0].Targets[0].Expr != "up" {
+		t.Errorf("Expected the panel saved by the Grafana UI, got %+v", dashboard.Panels)
+	}
+}
+
+func TestStableUID(t *testing.T) {
+	uid := StableUID("Fleet")
+	if uid != StableUID("Fleet") || uid == StableUID("Other") {
+		t.Errorf("Expected the UID to depend on the title only")
+	}
+	if !strings.HasPrefix(uid, "lazydash-") || len(uid) > 40 {
+		t.Errorf("Expected a lazydash UID of at most 40 characters, got %q", uid)
+	}
+}
+
+func TestPostDashboardFolder(t *testing.T) {
+	g, server := newFakeGrafana(t, GrafanaFolder{ID: 4, UID: "infra", Title: "Infra"})
+	dashboard := NewDashboard("Fleet")
+
+	response, err := testClient(t, server.URL).PostDashboard(context.Background(), dashboard, SaveOptions{Folder: &config.FolderConfig{Name: "Infra"}})
+	if err != nil {
+		t.Fatalf("PostDashboard() error = %v", err)
+	}
+	if dashboard.FolderID != 4 || dashboard.UID != "dash-uid" || response.URL != "/d/dash-uid" {
+		t.Errorf("Expected folder 4 and the assigned UID, got %d %q", dashboard.FolderID, dashboard.UID)
+	}
+	if len(g.folders) != 1 {
+		t.Errorf("Expected the existing folder to be used, got %+v", g.folders)
+	}
+}
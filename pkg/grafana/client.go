@@ -0,0 +1,263 @@
+package grafana
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hemzaz/lazydash/internal/config"
+	"github.com/hemzaz/lazydash/internal/util"
+	"github.com/rs/zerolog/log"
+)
+
+// Errors matched by API errors of the corresponding status
+var (
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+)
+
+// APIError is an unsuccessful response of the Grafana API
+type APIError struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Message    string // Message of the response, or its body
+}
+
+// Error describes the failed request
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s %s failed with status %d: %s", e.Method, e.URL, e.StatusCode, e.Message)
+}
+
+// Is matches the sentinel error of the response status
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict || e.StatusCode == http.StatusPreconditionFailed
+	}
+	return false
+}
+
+// ClientOptions configures authentication, TLS and retries of a Client
+type ClientOptions struct {
+	Token              string        // API key or service account token, sent as a bearer token
+	Username           string        // Basic auth user, used without a token
+	Password           string        // Basic auth password
+	OrgID              int64         // Organization the requests act on, the user's current one if 0
+	CAFile             string        // PEM file with the CA certificates the server is verified against
+	CertFile           string        // PEM client certificate for mutual TLS
+	KeyFile            string        // PEM key of the client certificate
+	InsecureSkipVerify bool          // Skip verifying the server certificate
+	Timeout            time.Duration // Timeout of a single request
+	Retries            int           // Retries of requests failing with 429, 5xx or a connection error
+	Backoff            time.Duration // Wait before the first retry, doubled for each further one
+}
+
+// Client talks to the HTTP API of a Grafana instance
+type Client struct {
+	host    string
+	options ClientOptions
+	http    *http.Client
+}
+
+// NewClient creates a client for the Grafana at host
+func NewClient(host string, options ClientOptions) (*Client, error) {
+	if !util.IsURL(host) {
+		return nil, fmt.Errorf("invalid Grafana URL: %s", host)
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = 30 * time.Second
+	}
+	if options.Backoff <= 0 {
+		options.Backoff = time.Second
+	}
+
+	tlsConfig, err := options.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return &Client{
+		host:    strings.TrimSuffix(host, "/"),
+		options: options,
+		http:    &http.Client{Transport: transport},
+	}, nil
+}
+
+// NewClientFromConfig creates a client from the --grafana-* flags
+func NewClientFromConfig(cfg *config.Config) (*Client, error) {
+	return NewClient(cfg.GrafanaHost, ClientOptions{
+		Token:              cfg.Token,
+		Username:           cfg.GrafanaUser,
+		Password:           cfg.GrafanaPassword,
+		OrgID:              cfg.GrafanaOrgID,
+		CAFile:             cfg.GrafanaCAFile,
+		CertFile:           cfg.GrafanaCertFile,
+		KeyFile:            cfg.GrafanaKeyFile,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+		Retries:            cfg.GrafanaRetries,
+	})
+}
+
+// Host returns the base URL of the Grafana instance
+func (c *Client) Host() string {
+	return c.host
+}
+
+// tlsConfig builds the TLS settings from the CA and client certificate files
+func (o ClientOptions) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: o.InsecureSkipVerify}
+	if o.InsecureSkipVerify {
+		log.Warn().Msg("TLS certificate verification disabled. This is insecure!")
+	}
+
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", o.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if (o.CertFile == "") != (o.KeyFile == "") {
+		return nil, errors.New("client certificate and key must be given together")
+	}
+	if o.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// do sends a JSON request, retrying failures that may be temporary, and
+// decodes the response into out unless it is nil
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}, headers map[string]string) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return fmt.Errorf("failed to encode request to %s: %w", path, err)
+		}
+	}
+
+	backoff := c.options.Backoff
+	for attempt := 0; ; attempt++ {
+		response, err := c.send(ctx, method, path, body, headers)
+		if err == nil {
+			if out == nil || len(response) == 0 {
+				return nil
+			}
+			if err := json.Unmarshal(response, out); err != nil {
+				return fmt.Errorf("failed to decode response of %s %s: %w", method, path, err)
+			}
+			return nil
+		}
+		if attempt >= c.options.Retries || !temporary(ctx, err) {
+			return err
+		}
+
+		log.Debug().Err(err).Int("attempt", attempt+1).Dur("backoff", backoff).Msg("Retrying Grafana request")
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s %s: %w", method, path, ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// send makes a single request and returns the response body
+func (c *Client) send(ctx context.Context, method, path string, body []byte, headers map[string]string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.options.Timeout)
+	defer cancel()
+
+	url := c.host + path
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	c.authenticate(req)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending %s %s: %w", method, url, err)
+	}
+	defer resp.Body.Close()
+
+	response, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response body: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &APIError{Method: method, URL: url, StatusCode: resp.StatusCode, Message: errorMessage(response)}
+	}
+	return response, nil
+}
+
+// authenticate sets the credentials and organization of a request
+func (c *Client) authenticate(req *http.Request) {
+	switch {
+	case c.options.Token != "":
+		req.Header.Set("Authorization", "Bearer "+c.options.Token)
+	case c.options.Username != "":
+		req.SetBasicAuth(c.options.Username, c.options.Password)
+	}
+	if c.options.OrgID > 0 {
+		req.Header.Set("X-Grafana-Org-Id", strconv.FormatInt(c.options.OrgID, 10))
+	}
+}
+
+// errorMessage returns the message of a Grafana error response, or the body
+func errorMessage(body []byte) string {
+	var response struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &response); err == nil && response.Message != "" {
+		return response.Message
+	}
+	return strings.TrimSpace(string(body))
+}
+
+// temporary reports whether a failed request may succeed when retried
+func temporary(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= 500 || apiErr.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
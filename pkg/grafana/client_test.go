@@ -0,0 +1,220 @@
+package grafana
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClientAuthentication(t *testing.T) {
+	tests := []struct {
+		name    string
+		options ClientOptions
+		auth    string
+		org     string
+	}{
+		{"Service account token", ClientOptions{Token: "glsa_abc"}, "Bearer glsa_abc", ""},
+		{"Basic auth", ClientOptions{Username: "admin", Password: "pw"}, "Basic YWRtaW46cHc=", ""},
+		{"Token wins over basic auth", ClientOptions{Token: "key", Username: "admin"}, "Bearer key", ""},
+		{"Organization", ClientOptions{Token: "key", OrgID: 3}, "Bearer key", "3"},
+		{"Anonymous", ClientOptions{}, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if got := r.Header.Get("Authorization"); got != tt.auth {
+					t.Errorf("Authorization = %q; want %q", got, tt.auth)
+				}
+				if got := r.Header.Get("X-Grafana-Org-Id"); got != tt.org {
+					t.Errorf("X-Grafana-Org-Id = %q; want %q", got, tt.org)
+				}
+				w.Write([]byte("[]"))
+			}))
+			defer server.Close()
+
+			client, err := NewClient(server.URL, tt.options)
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+			if _, err := client.GetFoldersByTitle(context.Background(), ""); err != nil {
+				t.Errorf("GetFoldersByTitle() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		retries  int
+		requests int32
+		wantErr  error
+	}{
+		{"Retried until success", []int{503, 429, 200}, 3, 3, nil},
+		{"Retries used up", []int{502, 502, 502}, 1, 2, nil},
+		{"Client error is not retried", []int{404}, 3, 1, ErrNotFound},
+		{"Unauthorized", []int{401}, 3, 1, ErrUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(requests.Add(1)) - 1
+				status := tt.statuses[min(n, len(tt.statuses)-1)]
+				if status != http.StatusOK {
+					http.Error(w, `{"message":"try again"}`, status)
+					return
+				}
+				w.Write([]byte("[]"))
+			}))
+			defer server.Close()
+
+			client, err := NewClient(server.URL, ClientOptions{Retries: tt.retries, Backoff: time.Millisecond})
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+			_, err = client.GetFoldersByTitle(context.Background(), "")
+
+			if got := requests.Load(); got != tt.requests {
+				t.Errorf("Expected %d requests, got %d", tt.requests, got)
+			}
+			wantFailure := tt.statuses[min(int(tt.requests), len(tt.statuses))-1] != http.StatusOK
+			if (err != nil) != wantFailure {
+				t.Fatalf("GetFoldersByTitle() error = %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Expected %v, got %v", tt.wantErr, err)
+			}
+
+			var apiErr *APIError
+			if wantFailure && (!errors.As(err, &apiErr) || apiErr.Message != "try again") {
+				t.Errorf("Expected an API error with the response message, got %v", err)
+			}
+		})
+	}
+}
+
+func TestClientContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL, ClientOptions{Retries: 5, Backoff: time.Hour})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := client.PostDashboard(ctx, NewDashboard("test"), SaveOptions{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the context deadline, got %v", err)
+	}
+}
+
+func TestClientTLS(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			http.Error(w, "no client certificate", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("[]"))
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	writePEM(t, caFile, "CERTIFICATE", server.Certificate().Raw)
+	certFile, keyFile := writeClientCertificate(t, dir)
+
+	t.Run("CA and client certificate", func(t *testing.T) {
+		client, err := NewClient(server.URL, ClientOptions{CAFile: caFile, CertFile: certFile, KeyFile: keyFile})
+		if err != nil {
+			t.Fatalf("NewClient() error = %v", err)
+		}
+		if _, err := client.GetFoldersByTitle(context.Background(), ""); err != nil {
+			t.Errorf("GetFoldersByTitle() error = %v", err)
+		}
+	})
+
+	t.Run("Unknown CA", func(t *testing.T) {
+		client, err := NewClient(server.URL, ClientOptions{CertFile: certFile, KeyFile: keyFile})
+		if err != nil {
+			t.Fatalf("NewClient() error = %v", err)
+		}
+		if _, err := client.GetFoldersByTitle(context.Background(), ""); err == nil {
+			t.Errorf("Expected the server certificate to be rejected")
+		}
+	})
+
+	t.Run("Invalid options", func(t *testing.T) {
+		for name, options := range map[string]ClientOptions{
+			"missing CA":  {CAFile: filepath.Join(dir, "missing.pem")},
+			"CA not PEM":  {CAFile: keyFile + ".txt"},
+			"cert alone":  {CertFile: certFile},
+			"key as cert": {CertFile: keyFile, KeyFile: keyFile},
+		} {
+			if _, err := NewClient(server.URL, options); err == nil {
+				t.Errorf("%s: expected an error", name)
+			}
+		}
+		if _, err := NewClient("not-a-url", ClientOptions{}); err == nil {
+			t.Errorf("Expected an error for an invalid host")
+		}
+	})
+}
+
+// writeClientCertificate writes a self-signed client certificate and its key
+func writeClientCertificate(t *testing.T, dir string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	writePEM(t, certFile, "CERTIFICATE", cert)
+	writePEM(t, keyFile, "EC PRIVATE KEY", der)
+	os.WriteFile(keyFile+".txt", []byte("not a certificate"), 0600)
+	return certFile, keyFile
+}
+
+// writePEM writes a single PEM block
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+}
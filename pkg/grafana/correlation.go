@@ -0,0 +1,268 @@
+package grafana
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hemzaz/lazydash/pkg/metrics"
+)
+
+// Correlation weights for each similarity signal. They sum to 1.0 so a
+// score can be compared directly against the correlation threshold.
+const (
+	nameWeight      = 0.40
+	labelWeight     = 0.20
+	subsystemWeight = 0.25
+	unitWeight      = 0.15
+)
+
+// otherClusterTitle is the row title for metrics that did not correlate
+const otherClusterTitle = "Other Metrics"
+
+// unitTokens are trailing name tokens that describe units or series
+// suffixes rather than what is being measured
+var unitTokens = map[string]bool{
+	"total": true, "count": true, "sum": true, "bucket": true, "info": true,
+	"seconds": true, "milliseconds": true, "microseconds": true, "nanoseconds": true,
+	"bytes": true, "bits": true, "ratio": true, "percent": true,
+}
+
+// MetricCluster is a group of correlated metrics rendered as one dashboard row
+type MetricCluster struct {
+	Title   string
+	Metrics []*metrics.Metric
+}
+
+// CorrelationEngine scores metric pairs and clusters related metrics
+type CorrelationEngine struct {
+	Threshold float64
+}
+
+// correlationFeatures holds the precomputed inputs for scoring a metric
+type correlationFeatures struct {
+	metric    *metrics.Metric
+	tokens    []string
+	tokenSet  map[string]bool
+	labels    map[string]bool
+	subsystem string
+	category  string
+	unit      string
+}
+
+// NewCorrelationEngine creates a correlation engine with the given threshold (0.0-1.0)
+func NewCorrelationEngine(threshold float64) *CorrelationEngine {
+	return &CorrelationEngine{Threshold: threshold}
+}
+
+// Score returns how strongly two metrics are related, from 0.0 to 1.0
+func (e *CorrelationEngine) Score(a, b *metrics.Metric) float64 {
+	return scoreFeatures(newCorrelationFeatures(a), newCorrelationFeatures(b))
+}
+
+// Cluster groups the metrics in the registry into correlated clusters.
+// Metrics are linked when their score reaches the threshold, and linked
+// metrics are merged transitively. Clusters are ordered by their first
+// metric name and metrics that did not correlate with anything are
+// collected into a trailing "Other Metrics" cluster.
+func (e *CorrelationEngine) Cluster(registry *metrics.Registry) []MetricCluster {
+	var features []*correlationFeatures
+	registry.ForEach(func(name string, metric *metrics.Metric) {
+		features = append(features, newCorrelationFeatures(metric))
+	})
+
+	// Union-find over metric indexes, always keeping the lowest index as root
+	parent := make([]int, len(features))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	for i := 0; i < len(features); i++ {
+		for j := i + 1; j < len(features); j++ {
+			if scoreFeatures(features[i], features[j]) < e.Threshold {
+				continue
+			}
+			ri, rj := find(i), find(j)
+			if ri < rj {
+				parent[rj] = ri
+			} else if rj < ri {
+				parent[ri] = rj
+			}
+		}
+	}
+
+	// Collect members per root; registry order keeps members sorted
+	members := make(map[int][]*metrics.Metric)
+	var roots []int
+	for i, f := range features {
+		root := find(i)
+		if _, exists := members[root]; !exists {
+			roots = append(roots, root)
+		}
+		members[root] = append(members[root], f.metric)
+	}
+
+	var clusters []MetricCluster
+	var others []*metrics.Metric
+	titles := make(map[string]int)
+	for _, root := range roots {
+		group := members[root]
+		if len(group) == 1 {
+			others = append(others, group[0])
+			continue
+		}
+
+		title := clusterTitle(group)
+		titles[title]++
+		if n := titles[title]; n > 1 {
+			title = fmt.Sprintf("%s (%d)", title, n)
+		}
+		clusters = append(clusters, MetricCluster{Title: title, Metrics: group})
+	}
+
+	if len(others) > 0 {
+		clusters = append(clusters, MetricCluster{Title: otherClusterTitle, Metrics: others})
+	}
+
+	return clusters
+}
+
+// newCorrelationFeatures extracts the scoring inputs for a metric
+func newCorrelationFeatures(metric *metrics.Metric) *correlationFeatures {
+	tokens := nameTokens(metric.Name())
+
+	f := &correlationFeatures{
+		metric:   metric,
+		tokens:   tokens,
+		tokenSet: make(map[string]bool, len(tokens)),
+		labels:   make(map[string]bool),
+		category: metric.Category(),
+		unit:     metric.Unit(),
+	}
+	for _, token := range tokens {
+		f.tokenSet[token] = true
+	}
+	for _, label := range metric.Labels() {
+		f.labels[label] = true
+	}
+
+	// Prefer the detected subsystem, otherwise follow the Prometheus
+	// namespace_subsystem_name naming convention
+	f.subsystem = metric.Subsystem()
+	if f.subsystem == "" && len(tokens) > 1 {
+		f.subsystem = tokens[0] + "_" + tokens[1]
+	}
+
+	return f
+}
+
+// scoreFeatures combines the weighted similarity signals of two metrics
+func scoreFeatures(a, b *correlationFeatures) float64 {
+	score := nameWeight * nameSimilarity(a, b)
+	score += labelWeight * setSimilarity(a.labels, b.labels)
+
+	if a.subsystem != "" && a.subsystem == b.subsystem {
+		score += subsystemWeight
+	} else if a.category != "" && a.category == b.category {
+		score += subsystemWeight / 2
+	}
+
+	if a.unit != "" && a.unit != "short" && a.unit == b.unit {
+		score += unitWeight
+	}
+
+	return score
+}
+
+// nameSimilarity is the larger of the token overlap and the shared token prefix
+func nameSimilarity(a, b *correlationFeatures) float64 {
+	if len(a.tokens) == 0 || len(b.tokens) == 0 {
+		return 0
+	}
+
+	jaccard := setSimilarity(a.tokenSet, b.tokenSet)
+
+	prefix := 0
+	for prefix < len(a.tokens) && prefix < len(b.tokens) && a.tokens[prefix] == b.tokens[prefix] {
+		prefix++
+	}
+	longest := len(a.tokens)
+	if len(b.tokens) > longest {
+		longest = len(b.tokens)
+	}
+
+	if p := float64(prefix) / float64(longest); p > jaccard {
+		return p
+	}
+	return jaccard
+}
+
+// setSimilarity returns the Jaccard index of two sets; two empty sets are identical
+func setSimilarity(a, b map[string]bool) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+
+	shared := 0
+	for k := range a {
+		if b[k] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
+
+// nameTokens splits a metric name into tokens without trailing unit tokens
+func nameTokens(name string) []string {
+	var tokens []string
+	for _, token := range strings.Split(name, "_") {
+		if token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+
+	for len(tokens) > 1 && unitTokens[tokens[len(tokens)-1]] {
+		tokens = tokens[:len(tokens)-1]
+	}
+	return tokens
+}
+
+// clusterTitle derives a row title from the name tokens shared by all members.
+// Without a common prefix the most frequent token is used instead.
+func clusterTitle(group []*metrics.Metric) string {
+	prefix := nameTokens(group[0].Name())
+	counts := make(map[string]int)
+	for _, metric := range group {
+		tokens := nameTokens(metric.Name())
+		n := 0
+		for n < len(prefix) && n < len(tokens) && prefix[n] == tokens[n] {
+			n++
+		}
+		prefix = prefix[:n]
+		for _, token := range tokens {
+			counts[token]++
+		}
+	}
+
+	if len(prefix) == 0 {
+		best := ""
+		for token, count := range counts {
+			if count > counts[best] || (count == counts[best] && token < best) {
+				best = token
+			}
+		}
+		prefix = []string{best}
+	}
+
+	words := make([]string, len(prefix))
+	for i, token := range prefix {
+		words[i] = strings.ToUpper(token[:1]) + token[1:]
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,185 @@
+package grafana
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hemzaz/lazydash/pkg/metrics"
+)
+
+// clusterNames flattens clusters into title -> metric names
+func clusterNames(clusters []MetricCluster) map[string][]string {
+	result := make(map[string][]string)
+	for _, cluster := range clusters {
+		for _, metric := range cluster.Metrics {
+			result[cluster.Title] = append(result[cluster.Title], metric.Name())
+		}
+	}
+	return result
+}
+
+func TestCorrelationScore(t *testing.T) {
+	engine := NewCorrelationEngine(0.7)
+
+	heapAlloc := metrics.New("go_memstats_heap_alloc_bytes", "", nil, "gauge", "", "decbytes")
+	heapIdle := metrics.New("go_memstats_heap_idle_bytes", "", nil, "gauge", "", "decbytes")
+	openFds := metrics.New("process_open_fds", "", nil, "gauge", "", "short")
+
+	t.Run("Related metrics score high", func(t *testing.T) {
+		if score := engine.Score(heapAlloc, heapIdle); score < 0.7 {
+			t.Errorf("Expected related metrics to score at least 0.7, got %.2f", score)
+		}
+	})
+
+	t.Run("Unrelated metrics score low", func(t *testing.T) {
+		if score := engine.Score(heapAlloc, openFds); score >= 0.7 {
+			t.Errorf("Expected unrelated metrics to score below 0.7, got %.2f", score)
+		}
+	})
+
+	t.Run("Score is symmetric", func(t *testing.T) {
+		if engine.Score(heapAlloc, openFds) != engine.Score(openFds, heapAlloc) {
+			t.Error("Expected Score to be symmetric")
+		}
+	})
+
+	t.Run("Identical metrics score 1", func(t *testing.T) {
+		if score := engine.Score(heapAlloc, heapAlloc); score < 0.999 {
+			t.Errorf("Expected identical metrics to score 1.0, got %.2f", score)
+		}
+	})
+
+	t.Run("Shared labels raise the score", func(t *testing.T) {
+		a := metrics.New("engine_daemon_container_actions_seconds", "", map[string]bool{"action": true}, "histogram", "", "s")
+		b := metrics.New("engine_daemon_image_actions_seconds", "", map[string]bool{"action": true}, "histogram", "", "s")
+		c := metrics.New("engine_daemon_image_actions_seconds", "", map[string]bool{"state": true}, "histogram", "", "s")
+		if engine.Score(a, b) <= engine.Score(a, c) {
+			t.Errorf("Expected shared labels to score higher: %.2f <= %.2f", engine.Score(a, b), engine.Score(a, c))
+		}
+	})
+}
+
+func TestCorrelationCluster(t *testing.T) {
+	registry := loadPromdata(t)
+	engine := NewCorrelationEngine(0.7)
+
+	clusters := engine.Cluster(registry)
+	names := clusterNames(clusters)
+
+	t.Run("Expected clusters", func(t *testing.T) {
+		expected := map[string][]string{
+			"Engine Daemon": {
+				"engine_daemon_container_actions_seconds",
+				"engine_daemon_image_actions_seconds",
+				"engine_daemon_network_actions_seconds",
+			},
+			"Engine Daemon Health Checks": {
+				"engine_daemon_health_checks_failed_total",
+				"engine_daemon_health_checks_total",
+			},
+			"Swarm Raft": {
+				"swarm_raft_snapshot_latency_seconds",
+				"swarm_raft_transaction_latency_seconds",
+			},
+			"Swarm Store": {
+				"swarm_store_batch_latency_seconds",
+				"swarm_store_lookup_latency_seconds",
+				"swarm_store_memory_store_lock_duration_seconds",
+				"swarm_store_read_tx_latency_seconds",
+				"swarm_store_write_tx_latency_seconds",
+			},
+		}
+		for title, want := range expected {
+			if got := names[title]; !reflect.DeepEqual(got, want) {
+				t.Errorf("Cluster %q = %v; want %v", title, got, want)
+			}
+		}
+
+		if len(names["Go Memstats"]) != 22 {
+			t.Errorf("Expected 22 go_memstats metrics in one cluster, got %d", len(names["Go Memstats"]))
+		}
+	})
+
+	t.Run("Every metric is placed exactly once", func(t *testing.T) {
+		seen := make(map[string]bool)
+		for _, cluster := range clusters {
+			for _, metric := range cluster.Metrics {
+				if seen[metric.Name()] {
+					t.Errorf("Metric %q placed in more than one cluster", metric.Name())
+				}
+				seen[metric.Name()] = true
+			}
+		}
+		if len(seen) != registry.Count() {
+			t.Errorf("Expected %d clustered metrics, got %d", registry.Count(), len(seen))
+		}
+	})
+
+	t.Run("Uncorrelated metrics come last", func(t *testing.T) {
+		last := clusters[len(clusters)-1]
+		if last.Title != otherClusterTitle {
+			t.Errorf("Expected last cluster to be %q, got %q", otherClusterTitle, last.Title)
+		}
+		for _, metric := range last.Metrics {
+			if metric.Name() == "go_memstats_heap_alloc_bytes" {
+				t.Error("Correlated metric go_memstats_heap_alloc_bytes ended up in the other cluster")
+			}
+		}
+	})
+
+	t.Run("Deterministic", func(t *testing.T) {
+		for i := 0; i < 5; i++ {
+			again := clusterNames(engine.Cluster(loadPromdata(t)))
+			if !reflect.DeepEqual(again, names) {
+				t.Fatal("Clustering the same input produced different clusters")
+			}
+		}
+	})
+
+	t.Run("Threshold of 1 links only perfect scores", func(t *testing.T) {
+		strict := NewCorrelationEngine(1.0).Cluster(registry)
+		if len(strict) != 2 || strict[1].Title != otherClusterTitle {
+			t.Fatalf("Expected one cluster and %q, got %v", otherClusterTitle, clusterNames(strict))
+		}
+		// go_memstats_alloc_bytes and its _total counter share every name token
+		want := []string{"go_memstats_alloc_bytes", "go_memstats_alloc_bytes_total"}
+		if got := clusterNames(strict[:1])[strict[0].Title]; !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected the alloc gauge and counter, got %v", got)
+		}
+	})
+}
+
+func TestGenerateWithCorrelation(t *testing.T) {
+	registry := loadPromdata(t)
+	cfg := testConfig()
+	cfg.AutoCorrelate = true
+
+	dashboard := NewDashboard("Correlated")
+	dashboard.Generate(registry, cfg, testQueryBuilder(cfg))
+
+	rows := 0
+	panels := 0
+	for _, panel := range dashboard.Panels {
+		if panel.Type == "row" {
+			rows++
+			correlated := strings.HasSuffix(panel.Description, " correlated metrics")
+			if correlated == (panel.Title == otherClusterTitle) {
+				t.Errorf("Unexpected description %q of row %q", panel.Description, panel.Title)
+			}
+		} else {
+			panels++
+		}
+	}
+
+	clusters := NewCorrelationEngine(cfg.AutoCorrelateThreshold).Cluster(registry)
+	if rows != len(clusters) {
+		t.Errorf("Expected %d rows, got %d", len(clusters), rows)
+	}
+	if panels != registry.Count() {
+		t.Errorf("Expected %d metric panels, got %d", registry.Count(), panels)
+	}
+	if dashboard.Panels[0].Type != "row" || dashboard.Panels[0].Title != "Engine Daemon" {
+		t.Errorf("Expected first panel to be the %q row, got %q %q", "Engine Daemon", dashboard.Panels[0].Type, dashboard.Panels[0].Title)
+	}
+}
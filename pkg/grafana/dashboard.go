@@ -4,6 +4,10 @@ package grafana
 import (
 	"encoding/json"
 	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"sort"
 	"strings"
 
 	"github.com/hemzaz/lazydash/internal/config"
@@ -67,6 +71,7 @@ type TemplateVar struct {
 	Datasource     string             `json:"datasource,omitempty"`
 	Query          string             `json:"query,omitempty"`
 	Refresh        int                `json:"refresh,omitempty"`
+	Sort           int                `json:"sort,omitempty"`
 	IncludeAll     bool               `json:"includeAll,omitempty"`
 	Multi          bool               `json:"multi,omitempty"`
 	AllValue       string             `json:"allValue,omitempty"`
@@ -115,11 +120,21 @@ func NewDashboard(title string) *Dashboard {
 		Panels:        []Panel{},
 		Time:          TimeRange{From: "now-6h", To: "now"},
 		TimePicker:    TimePicker{RefreshIntervals: RefreshIntervals, TimeOptions: TimeOptions},
-		SchemaVersion: 22,
+		SchemaVersion: SchemaVersion,
 		Version:       0,
 	}
 }
 
+// StableUID derives a dashboard UID from its title, so that regenerating a
+// dashboard updates it in place instead of adding a copy. The inputs are left
+// out: the same dashboard is often generated from a file under another path
+// or a temporary file in CI, and Grafana keeps titles unique per folder anyway.
+func StableUID(title string) string {
+	h := fnv.New64a()
+	h.Write([]byte(title))
+	return fmt.Sprintf("lazydash-%x", h.Sum64())
+}
+
 // SetDescription sets the dashboard description
 func (d *Dashboard) SetDescription(desc string) {
 	d.Description = desc
@@ -132,8 +147,25 @@ func (d *Dashboard) AddPanel(panel Panel) {
 	d.Panels = append(d.Panels, panel)
 }
 
+// PanelForMetric returns the panel charting a metric, or nil if there is none
+func (d *Dashboard) PanelForMetric(name string) *Panel {
+	for i := range d.Panels {
+		if d.Panels[i].metric == name {
+			return &d.Panels[i]
+		}
+	}
+	return nil
+}
+
 // DumpJSON outputs the dashboard as JSON
 func (d *Dashboard) DumpJSON(pretty bool) {
+	if err := d.WriteJSON(os.Stdout, pretty); err != nil {
+		log.Fatal().Err(err).Msg("Could not marshal dashboard to JSON")
+	}
+}
+
+// WriteJSON writes the dashboard as JSON to w, followed by a newline
+func (d *Dashboard) WriteJSON(w io.Writer, pretty bool) error {
 	var b []byte
 	var err error
 
@@ -144,17 +176,42 @@ func (d *Dashboard) DumpJSON(pretty bool) {
 	}
 
 	if err != nil {
-		log.Fatal().Err(err).Msg("Could not marshal dashboard to JSON")
+		return fmt.Errorf("could not marshal dashboard to JSON: %w", err)
 	}
 
-	fmt.Println(string(b))
+	_, err = fmt.Fprintln(w, string(b))
+	return err
 }
 
 // Generate creates a dashboard based on the given metrics
-func (d *Dashboard) Generate(metrics *metrics.Registry, cfg *config.Config, queryBuilder *query.Builder) {
+func (d *Dashboard) Generate(registry *metrics.Registry, cfg *config.Config, queryBuilder *query.Builder) {
 	d.Description = cfg.Description
+	if cfg.LegacyPanels {
+		d.SchemaVersion = LegacySchemaVersion
+	}
+	
+	// Spec rules hide metrics and move others into their own rows
+	if cfg.Spec != nil {
+		registry = registry.Filter(func(name string, metric *metrics.Metric) bool {
+			return !cfg.Spec.Hidden(name)
+		})
+	}
+	d.Templating = NewTemplating(registry, cfg)
+	
+	d.generateLayout(specRows(registry, cfg, false), cfg, queryBuilder)
+	d.addSpecRows(specRows(registry, cfg, true), cfg, queryBuilder)
+	if cfg.Spec.HasWidths() {
+		d.reflow(cfg)
+	}
+}
 
+// generateLayout adds the panels of the metrics organized as configured
+func (d *Dashboard) generateLayout(metrics *metrics.Registry, cfg *config.Config, queryBuilder *query.Builder) {
 	// Check how to organize the dashboard
+	if cfg.GroupBySource {
+		d.generateWithSourceGroups(metrics, cfg, queryBuilder)
+		return
+	}
 	if cfg.VendorConfig != nil && cfg.VendorConfig.Enabled && cfg.VendorConfig.GroupByVendor {
 		vendors := metrics.ListVendors()
 		if len(vendors) > 0 {
@@ -223,8 +280,15 @@ func (d *Dashboard) generateWithLabelGroups(registry *metrics.Registry, cfg *con
 		groups[groupKey] = append(groups[groupKey], metric)
 	})
 	
-	// Process each group
-	for groupName, groupMetrics := range groups {
+	// Process each group in a stable order
+	groupNames := make([]string, 0, len(groups))
+	for groupName := range groups {
+		groupNames = append(groupNames, groupName)
+	}
+	sort.Strings(groupNames)
+	
+	for _, groupName := range groupNames {
+		groupMetrics := groups[groupName]
 		// Add a row header if enabled
 		if cfg.LabelGrouping.SeparateRows {
 			rowPanel := &Panel{
@@ -279,11 +343,95 @@ func (d *Dashboard) generateWithLabelGroups(registry *metrics.Registry, cfg *con
 	}
 }
 
+// generateWithSourceGroups creates a row for each input, or combination of
+// inputs, holding the metrics read from it
+func (d *Dashboard) generateWithSourceGroups(registry *metrics.Registry, cfg *config.Config, queryBuilder *query.Builder) {
+	groups := make(map[string][]*metrics.Metric)
+	registry.ForEach(func(name string, metric *metrics.Metric) {
+		source := strings.Join(metric.Sources(), ", ")
+		groups[source] = append(groups[source], metric)
+	})
+
+	sources := make([]string, 0, len(groups))
+	for source := range groups {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	yPos := 0
+	for _, source := range sources {
+		d.AddPanel(Panel{
+			Title:       source,
+			Type:        "row",
+			Description: "Metrics read from " + source,
+			GridPos:     PanelGridPos{X: 0, Y: yPos, W: 24, H: 1},
+		})
+		yPos = d.addPanelGrid(groups[source], yPos+1, cfg, queryBuilder)
+	}
+}
+
 // generateWithCorrelation creates a dashboard with panels grouped by auto-correlation
 func (d *Dashboard) generateWithCorrelation(registry *metrics.Registry, cfg *config.Config, queryBuilder *query.Builder) {
-	// This would implement auto-correlation using the correlation.go logic
-	// For now, just use standard generation
-	d.generateStandard(registry, cfg, queryBuilder)
+	engine := NewCorrelationEngine(cfg.AutoCorrelateThreshold)
+	clusters := engine.Cluster(registry)
+	
+	log.Info().Int("clusters", len(clusters)).
+		Float64("threshold", cfg.AutoCorrelateThreshold).
+		Msg("Correlated metrics into rows")
+	
+	yPos := 0
+	for _, cluster := range clusters {
+		description := fmt.Sprintf("%d correlated metrics", len(cluster.Metrics))
+		if cluster.Title == otherClusterTitle {
+			description = fmt.Sprintf("%d metrics without a correlated metric", len(cluster.Metrics))
+		}
+		
+		// Add a row header for this cluster
+		rowPanel := &Panel{
+			Title:       cluster.Title,
+			Type:        "row",
+			Description: description,
+			GridPos: PanelGridPos{
+				X: 0,
+				Y: yPos,
+				W: 24,
+				H: 1,
+			},
+		}
+		d.AddPanel(*rowPanel)
+		yPos += 1
+		
+		yPos = d.addPanelGrid(cluster.Metrics, yPos, cfg, queryBuilder)
+	}
+}
+
+// addPanelGrid lays out panels for the metrics starting at yPos and
+// returns the y position at the start of the next free row
+func (d *Dashboard) addPanelGrid(metricList []*metrics.Metric, yPos int, cfg *config.Config, queryBuilder *query.Builder) int {
+	panelsPerRow := 2
+	if cfg.LabelGrouping != nil && cfg.LabelGrouping.PanelsPerRow > 0 {
+		panelsPerRow = cfg.LabelGrouping.PanelsPerRow
+	}
+	panelWidth := 24 / panelsPerRow
+	
+	xPos := 0
+	for i, metric := range metricList {
+		panel := createPanelForMetric(metric, cfg, queryBuilder)
+		panel.SetGridPos(xPos, yPos, 8, panelWidth)
+		d.AddPanel(*panel)
+		
+		xPos += panelWidth
+		if (i+1)%panelsPerRow == 0 {
+			xPos = 0
+			yPos += 8
+		}
+	}
+	
+	// Ensure we're at the start of a row for whatever follows
+	if xPos > 0 {
+		yPos += 8
+	}
+	return yPos
 }
 
 // generateWithVendorGroups creates a dashboard with panels grouped by vendor
@@ -400,8 +548,15 @@ func (d *Dashboard) generateWithVendorGroups(registry *metrics.Registry, cfg *co
 		
 		// If we have categories, organize by them
 		if len(categories) > 1 {
-			// Process each category
-			for category, categoryMetrics := range categories {
+			// Process each category in a stable order
+			categoryNames := make([]string, 0, len(categories))
+			for category := range categories {
+				categoryNames = append(categoryNames, category)
+			}
+			sort.Strings(categoryNames)
+			
+			for _, category := range categoryNames {
+				categoryMetrics := categories[category]
 				if len(categoryMetrics) == 0 {
 					continue
 				}
@@ -509,12 +664,16 @@ func createPanelForMetric(metric *metrics.Metric, cfg *config.Config, queryBuild
 	
 	// Create base panel
 	panel := NewPanel(title)
+	panel.metric = metric.Name()
 	panel.SetDescription(metric.Help())
-	panel.SetUnit(metric.Unit())
+	if note := metric.TypeNote(); note != "" {
+		panel.SetDescription(strings.TrimSpace(metric.Help() + "\n\n" + note))
+	}
+	panel.SetUnit(queryBuilder.ResultUnit(metric))
 	
 	// Set up legend
 	if cfg.Table {
-		panel.Legend = PanelLegend{
+		panel.Legend = &PanelLegend{
 			Show:         true,
 			Current:      true,
 			Values:       true,
@@ -522,16 +681,14 @@ func createPanelForMetric(metric *metrics.Metric, cfg *config.Config, queryBuild
 		}
 	}
 	
-	// Build query
-	expr := queryBuilder.BuildQuery(metric)
-	panel.SetMetricExpr(expr)
-	
-	// Set legend format
-	panel.SetLegendFormat(queryBuilder.GetLegend(metric))
-	
-	// Determine and set visualization type
-	if cfg.Visualizations != nil {
-		visualType := getVisualizationType(metric, cfg)
+	// Determine and set visualization type, spec rules take precedence
+	rule := cfg.Spec.RuleFor(metric.Name())
+	visualType := config.VisualizationGraph
+	if rule.Visualization != "" {
+		visualType = rule.Visualization
+		configureVisualization(panel, metric, visualType)
+	} else if cfg.Visualizations != nil {
+		visualType = getVisualizationType(metric, cfg)
 		configureVisualization(panel, metric, visualType)
 	} else if cfg.Gauges && metric.Type() == "gauge" {
 		panel.SetType("gauge")
@@ -539,6 +696,22 @@ func createPanelForMetric(metric *metrics.Metric, cfg *config.Config, queryBuild
 		panel.SetType("graph")
 	}
 	
+	// Build queries, heatmaps chart the bucket distribution of histograms
+	if visualType == config.VisualizationHeatmap && query.IsHistogram(metric) {
+		panel.SetTargets(queryBuilder.BuildHeatmapTargets(metric))
+	} else {
+		panel.SetTargets(queryBuilder.BuildTargets(metric))
+	}
+	
+	// Show exemplars (e.g. trace IDs) alongside the series that expose them
+	if metric.HasExemplars() {
+		for i := range panel.Targets {
+			panel.Targets[i].Exemplar = true
+		}
+	}
+	applyRule(panel, metric, rule, queryBuilder)
+	expr := panel.Targets[0].Expr
+	
 	// Add alerts if enabled and applicable
 	if cfg.GenerateAlerts {
 		alertThreshold := generateAlertThreshold(metric)
@@ -554,5 +727,13 @@ func createPanelForMetric(metric *metrics.Metric, cfg *config.Config, queryBuild
 		}
 	}
 	
+	// Grafana 7.4 replaced graph panels with time series panels, and the
+	// field options of the others with the field config
+	if !cfg.LegacyPanels && panel.Type == "graph" {
+		convertToTimeseries(panel, metric, cfg)
+	} else if !cfg.LegacyPanels {
+		convertFieldOptions(panel, metric)
+	}
+	
 	return panel
 }
\ No newline at end of file
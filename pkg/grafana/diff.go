@@ -0,0 +1,353 @@
+package grafana
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Kinds of a PanelDiff
+const (
+	PanelAdded   = "added"
+	PanelRemoved = "removed"
+	PanelChanged = "changed"
+)
+
+// DashboardDiff lists the panels that differ between two dashboards
+type DashboardDiff struct {
+	Added   int         `json:"added"`
+	Removed int         `json:"removed"`
+	Changed int         `json:"changed"`
+	Panels  []PanelDiff `json:"panels"`
+}
+
+// PanelDiff is a panel added, removed or changed between two dashboards.
+// Added and removed panels list their targets as changes.
+type PanelDiff struct {
+	Kind    string        `json:"kind"`
+	Title   string        `json:"title"`
+	Changes []FieldChange `json:"changes,omitempty"`
+}
+
+// FieldChange is a panel setting that differs, e.g. its unit or the
+// expression of target A. Old or New is empty when the setting is unset.
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old,omitempty"`
+	New   string `json:"new,omitempty"`
+}
+
+// ParseDashboard reads a dashboard from its JSON model, either bare or as
+// returned by the Grafana API with its metadata. Only the settings the diff
+// compares are read, since dashboards saved by Grafana hold many more in
+// shapes that vary between versions, such as datasource reference objects.
+// The panels of collapsed rows follow their row.
+func ParseDashboard(data []byte) (*Dashboard, error) {
+	var envelope struct {
+		Dashboard *savedModel `json:"dashboard"`
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, fmt.Errorf("failed to parse dashboard: %w", err)
+	}
+	model := envelope.Dashboard
+	if model == nil {
+		model = &savedModel{}
+		if err := json.Unmarshal(data, model); err != nil {
+			return nil, fmt.Errorf("failed to parse dashboard: %w", err)
+		}
+	}
+
+	dashboard := &Dashboard{ID: model.ID, UID: model.UID, Title: model.Title, Version: model.Version, Panels: []Panel{}}
+	for _, panel := range model.Panels {
+		dashboard.Panels = append(dashboard.Panels, panel.panel())
+		for _, nested := range panel.Panels {
+			dashboard.Panels = append(dashboard.Panels, nested.panel())
+		}
+	}
+	return dashboard, nil
+}
+
+// savedModel is the part of a saved dashboard model the diff compares
+type savedModel struct {
+	ID      int          `json:"id"`
+	UID     string       `json:"uid"`
+	Title   string       `json:"title"`
+	Version int          `json:"version"`
+	Panels  []savedPanel `json:"panels"`
+}
+
+// savedPanel is the part of a saved panel the diff compares. Collapsed rows
+// hold their panels.
+type savedPanel struct {
+	ID      int    `json:"id"`
+	Type    string `json:"type"`
+	Title   string `json:"title"`
+	Targets []struct {
+		Expr         string `json:"expr"`
+		RefID        string `json:"refId"`
+		LegendFormat string `json:"legendFormat"`
+	} `json:"targets"`
+	FieldConfig *struct {
+		Defaults struct {
+			Unit       string           `json:"unit"`
+			Thresholds *FieldThresholds `json:"thresholds"`
+		} `json:"defaults"`
+	} `json:"fieldConfig"`
+	Options struct {
+		FieldOptions *struct {
+			Defaults struct {
+				Unit       string                       `json:"unit"`
+				Thresholds []PanelFieldOptionsThreshold `json:"thresholds"`
+			} `json:"defaults"`
+		} `json:"fieldOptions"`
+	} `json:"options"`
+	YAxes []struct {
+		Format string `json:"format"`
+	} `json:"yaxes"`
+	Panels []savedPanel `json:"panels"`
+}
+
+// panel converts a saved panel to the dashboard model, leaving out nested
+// panels
+func (p *savedPanel) panel() Panel {
+	panel := Panel{ID: p.ID, Type: p.Type, Title: p.Title}
+	for _, target := range p.Targets {
+		panel.Targets = append(panel.Targets, PanelTarget{Expr: target.Expr, RefID: target.RefID, LegendFormat: target.LegendFormat})
+	}
+	if p.FieldConfig != nil {
+		panel.FieldConfig = &FieldConfig{Defaults: FieldDefaults{Unit: p.FieldConfig.Defaults.Unit, Thresholds: p.FieldConfig.Defaults.Thresholds}}
+	}
+	if options := p.Options.FieldOptions; options != nil {
+		panel.Options.FieldOptions = &PanelFieldOptions{Defaults: PanelFieldOptionsDefaults{Unit: options.Defaults.Unit, Thresholds: options.Defaults.Thresholds}}
+	}
+	for _, axis := range p.YAxes {
+		panel.YAxes = append(panel.YAxes, PanelYAxes{Format: axis.Format})
+	}
+	return panel
+}
+
+// Diff compares the panels of an existing dashboard with a generated one.
+// Panels are matched by title and query expressions rather than by ID, so
+// renumbered or moved panels compare equal and a renamed panel is still
+// matched by its expressions. Rows are not compared.
+func Diff(old, new *Dashboard) *DashboardDiff {
+	oldPanels, newPanels := diffPanels(old), diffPanels(new)
+	matches := make([]int, len(newPanels)) // Index of the matched old panel, -1 if added
+	matched := make([]bool, len(oldPanels))
+	for i := range matches {
+		matches[i] = -1
+	}
+
+	// Best matches first: same title and expressions, then either one
+	passes := []func(o, n *Panel) bool{
+		func(o, n *Panel) bool { return o.Title == n.Title && exprKey(o) == exprKey(n) },
+		func(o, n *Panel) bool { return o.Title == n.Title },
+		func(o, n *Panel) bool { return exprKey(o) != "" && exprKey(o) == exprKey(n) },
+	}
+	for _, same := range passes {
+		for i, n := range newPanels {
+			if matches[i] >= 0 {
+				continue
+			}
+			for j, o := range oldPanels {
+				if !matched[j] && same(o, n) {
+					matches[i], matched[j] = j, true
+					break
+				}
+			}
+		}
+	}
+
+	diff := &DashboardDiff{Panels: []PanelDiff{}}
+	for i, n := range newPanels {
+		if matches[i] < 0 {
+			diff.Added++
+			diff.Panels = append(diff.Panels, PanelDiff{Kind: PanelAdded, Title: n.Title, Changes: targetChanges(nil, n)})
+			continue
+		}
+		if changes := panelChanges(oldPanels[matches[i]], n); len(changes) > 0 {
+			diff.Changed++
+			diff.Panels = append(diff.Panels, PanelDiff{Kind: PanelChanged, Title: n.Title, Changes: changes})
+		}
+	}
+	for j, o := range oldPanels {
+		if !matched[j] {
+			diff.Removed++
+			diff.Panels = append(diff.Panels, PanelDiff{Kind: PanelRemoved, Title: o.Title, Changes: targetChanges(o, nil)})
+		}
+	}
+	return diff
+}
+
+// Empty reports whether the dashboards have the same panels
+func (d *DashboardDiff) Empty() bool {
+	return len(d.Panels) == 0
+}
+
+// WriteJSON writes the diff as JSON
+func (d *DashboardDiff) WriteJSON(w io.Writer, pretty bool) error {
+	encoder := json.NewEncoder(w)
+	if pretty {
+		encoder.SetIndent("", "  ")
+	}
+	return encoder.Encode(d)
+}
+
+// WriteText writes the diff for humans, one panel per line marked +, - or ~
+// and followed by its changes
+func (d *DashboardDiff) WriteText(w io.Writer) error {
+	if d.Empty() {
+		_, err := fmt.Fprintln(w, "No differences")
+		return err
+	}
+
+	markers := map[string]string{PanelAdded: "+", PanelRemoved: "-", PanelChanged: "~"}
+	for _, panel := range d.Panels {
+		fmt.Fprintf(w, "%s %s\n", markers[panel.Kind], panel.Title)
+		for _, change := range panel.Changes {
+			switch panel.Kind {
+			case PanelAdded:
+				fmt.Fprintf(w, "    %s: %s\n", change.Field, change.New)
+			case PanelRemoved:
+				fmt.Fprintf(w, "    %s: %s\n", change.Field, change.Old)
+			default:
+				fmt.Fprintf(w, "    %s: %s -> %s\n", change.Field, orNone(change.Old), orNone(change.New))
+			}
+		}
+	}
+	_, err := fmt.Fprintf(w, "%d panels differ: %d added, %d removed, %d changed\n", len(d.Panels), d.Added, d.Removed, d.Changed)
+	return err
+}
+
+// diffPanels returns the panels of a dashboard other than rows
+func diffPanels(d *Dashboard) []*Panel {
+	var panels []*Panel
+	if d == nil {
+		return panels
+	}
+	for i := range d.Panels {
+		if d.Panels[i].Type != "row" {
+			panels = append(panels, &d.Panels[i])
+		}
+	}
+	return panels
+}
+
+// exprKey identifies a panel by the sorted expressions of its targets
+func exprKey(p *Panel) string {
+	exprs := make([]string, 0, len(p.Targets))
+	for _, target := range p.Targets {
+		if target.Expr != "" {
+			exprs = append(exprs, target.Expr)
+		}
+	}
+	sort.Strings(exprs)
+	return strings.Join(exprs, "\n")
+}
+
+// panelChanges compares the title, type, unit, thresholds and targets of
+// two matched panels
+func panelChanges(old, new *Panel) []FieldChange {
+	var changes []FieldChange
+	compare := func(field, o, n string) {
+		if o != n {
+			changes = append(changes, FieldChange{Field: field, Old: o, New: n})
+		}
+	}
+	compare("title", old.Title, new.Title)
+	compare("type", old.Type, new.Type)
+	compare("unit", panelUnit(old), panelUnit(new))
+	compare("thresholds", panelThresholds(old), panelThresholds(new))
+	return append(changes, targetChanges(old, new)...)
+}
+
+// targetChanges compares the expressions and legends of targets with the
+// same ref ID. Either panel may be nil to list the targets of the other.
+func targetChanges(old, new *Panel) []FieldChange {
+	targets := map[string][2]*PanelTarget{}
+	var refs []string
+	for side, panel := range []*Panel{old, new} {
+		if panel == nil {
+			continue
+		}
+		for i := range panel.Targets {
+			target := &panel.Targets[i]
+			pair, ok := targets[target.RefID]
+			if !ok {
+				refs = append(refs, target.RefID)
+			}
+			pair[side] = target
+			targets[target.RefID] = pair
+		}
+	}
+	sort.Strings(refs)
+
+	var changes []FieldChange
+	for _, ref := range refs {
+		pair := targets[ref]
+		var oldExpr, newExpr, oldLegend, newLegend string
+		if pair[0] != nil {
+			oldExpr, oldLegend = pair[0].Expr, pair[0].LegendFormat
+		}
+		if pair[1] != nil {
+			newExpr, newLegend = pair[1].Expr, pair[1].LegendFormat
+		}
+		if oldExpr != newExpr {
+			changes = append(changes, FieldChange{Field: "target " + ref, Old: oldExpr, New: newExpr})
+		}
+		if pair[0] != nil && pair[1] != nil && oldLegend != newLegend {
+			changes = append(changes, FieldChange{Field: "legend " + ref, Old: oldLegend, New: newLegend})
+		}
+	}
+	return changes
+}
+
+// panelUnit returns the unit of a panel from its field config, or the
+// options and y axis used by older panel types
+func panelUnit(p *Panel) string {
+	if p.FieldConfig != nil && p.FieldConfig.Defaults.Unit != "" {
+		return p.FieldConfig.Defaults.Unit
+	}
+	if p.Options.FieldOptions != nil && p.Options.FieldOptions.Defaults.Unit != "" {
+		return p.Options.FieldOptions.Defaults.Unit
+	}
+	if len(p.YAxes) > 0 {
+		return p.YAxes[0].Format
+	}
+	return ""
+}
+
+// panelThresholds formats the threshold steps of a panel, e.g.
+// "green, orange 80, red 90"
+func panelThresholds(p *Panel) string {
+	var steps []string
+	if p.FieldConfig != nil && p.FieldConfig.Defaults.Thresholds != nil {
+		for _, step := range p.FieldConfig.Defaults.Thresholds.Steps {
+			if step.Value == nil {
+				steps = append(steps, step.Color)
+				continue
+			}
+			steps = append(steps, step.Color+" "+strconv.FormatFloat(*step.Value, 'g', -1, 64))
+		}
+	} else if p.Options.FieldOptions != nil {
+		for i, step := range p.Options.FieldOptions.Defaults.Thresholds {
+			if i == 0 {
+				steps = append(steps, step.Color)
+				continue
+			}
+			steps = append(steps, step.Color+" "+strconv.FormatFloat(step.Value, 'g', -1, 64))
+		}
+	}
+	return strings.Join(steps, ", ")
+}
+
+// orNone shows an unset setting
+func orNone(s string) string {
+	if s == "" {
+		return "(none)"
+	}
+	return s
+}
@@ -0,0 +1,158 @@
+package grafana
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// diffPanel builds a time series panel with one target
+func diffPanel(id int, title, expr, unit string, thresholds ...float64) Panel {
+	panel := Panel{ID: id, Type: "timeseries", Title: title, Targets: []PanelTarget{{RefID: "A", Expr: expr, LegendFormat: "{{instance}}"}}}
+	panel.FieldConfig = &FieldConfig{Defaults: FieldDefaults{Unit: unit}}
+	if len(thresholds) > 0 {
+		steps := []PanelFieldOptionsThreshold{{Color: "green"}}
+		for _, value := range thresholds {
+			steps = append(steps, PanelFieldOptionsThreshold{Value: value, Color: "red"})
+		}
+		panel.FieldConfig.Defaults.Thresholds = fieldThresholds(steps)
+	}
+	return panel
+}
+
+func TestDiff(t *testing.T) {
+	old := &Dashboard{Panels: []Panel{
+		{ID: 1, Type: "row", Title: "Node"},
+		diffPanel(2, "CPU", "rate(cpu_seconds_total[1m])", "percentunit", 0.9),
+		diffPanel(3, "Memory", "memory_bytes", "bytes"),
+		diffPanel(4, "Disk", "disk_bytes", "bytes"),
+		diffPanel(5, "Load", "node_load1", "short"),
+	}}
+	new := &Dashboard{Panels: []Panel{
+		{ID: 1, Type: "row", Title: "Host"},
+		diffPanel(7, "Load", "node_load1", "short"),
+		diffPanel(8, "CPU", "rate(cpu_seconds_total[5m])", "percentunit", 0.8),
+		diffPanel(9, "Memory used", "memory_bytes", "decbytes"),
+		diffPanel(10, "Network", "network_bytes", "Bps"),
+	}}
+	new.Panels[2].Targets[0].LegendFormat = "{{cpu}}"
+
+	diff := Diff(old, new)
+	want := []PanelDiff{
+		{Kind: PanelChanged, Title: "CPU", Changes: []FieldChange{
+			{Field: "thresholds", Old: "green, red 0.9", New: "green, red 0.8"},
+			{Field: "target A", Old: "rate(cpu_seconds_total[1m])", New: "rate(cpu_seconds_total[5m])"},
+			{Field: "legend A", Old: "{{instance}}", New: "{{cpu}}"},
+		}},
+		{Kind: PanelChanged, Title: "Memory used", Changes: []FieldChange{
+			{Field: "title", Old: "Memory", New: "Memory used"},
+			{Field: "unit", Old: "bytes", New: "decbytes"},
+		}},
+		{Kind: PanelAdded, Title: "Network", Changes: []FieldChange{{Field: "target A", New: "network_bytes"}}},
+		{Kind: PanelRemoved, Title: "Disk", Changes: []FieldChange{{Field: "target A", Old: "disk_bytes"}}},
+	}
+	if !reflect.DeepEqual(diff.Panels, want) {
+		t.Errorf("Diff() panels = %+v; want %+v", diff.Panels, want)
+	}
+	if diff.Added != 1 || diff.Removed != 1 || diff.Changed != 2 || diff.Empty() {
+		t.Errorf("Expected 1 added, 1 removed and 2 changed, got %+v", diff)
+	}
+
+	if same := Diff(new, new); !same.Empty() {
+		t.Errorf("Expected no differences with itself, got %+v", same.Panels)
+	}
+}
+
+func TestDiffLegacyPanels(t *testing.T) {
+	panel := func(unit string, threshold float64) Panel {
+		return Panel{Type: "stat", Title: "Up", Options: PanelOptions{FieldOptions: &PanelFieldOptions{
+			Defaults: PanelFieldOptionsDefaults{Unit: unit, Thresholds: []PanelFieldOptionsThreshold{{Color: "red"}, {Value: threshold, Color: "green"}}},
+		}}}
+	}
+	graph := Panel{Type: "graph", Title: "Load", YAxes: []PanelYAxes{{Format: "short"}}}
+
+	diff := Diff(&Dashboard{Panels: []Panel{panel("none", 1), graph}}, &Dashboard{Panels: []Panel{panel("bool", 0.5), diffPanel(2, "Load", "", "short")}})
+	want := []PanelDiff{
+		{Kind: PanelChanged, Title: "Up", Changes: []FieldChange{
+			{Field: "unit", Old: "none", New: "bool"},
+			{Field: "thresholds", Old: "red, green 1", New: "red, green 0.5"},
+		}},
+		{Kind: PanelChanged, Title: "Load", Changes: []FieldChange{{Field: "type", Old: "graph", New: "timeseries"}}},
+	}
+	if !reflect.DeepEqual(diff.Panels, want) {
+		t.Errorf("Diff() panels = %+v; want %+v", diff.Panels, want)
+	}
+}
+
+func TestDashboardDiffWriteText(t *testing.T) {
+	old := &Dashboard{Panels: []Panel{diffPanel(1, "CPU", "cpu", ""), diffPanel(2, "Disk", "disk", "bytes")}}
+	new := &Dashboard{Panels: []Panel{diffPanel(1, "CPU", "cpu", "percent")}}
+
+	var buf bytes.Buffer
+	if err := Diff(old, new).WriteText(&buf); err != nil {
+		t.Fatalf("WriteText() error = %v", err)
+	}
+	want := "~ CPU\n    unit: (none) -> percent\n- Disk\n    target A: disk\n2 panels differ: 0 added, 1 removed, 1 changed\n"
+	if buf.String() != want {
+		t.Errorf("WriteText() = %q; want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	Diff(old, old).WriteText(&buf)
+	if buf.String() != "No differences\n" {
+		t.Errorf("WriteText() = %q for equal dashboards", buf.String())
+	}
+}
+
+func TestParseDashboard(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{"Dashboard model", `{"uid":"fleet","title":"Fleet","panels":[{"id":1,"title":"CPU"}]}`, false},
+		{"API response", `{"dashboard":{"uid":"fleet","title":"Fleet","panels":[{"id":1,"title":"CPU"}]},"meta":{"folderUid":"ops"}}`, false},
+		{"Invalid JSON", `{"dashboard":`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dashboard, err := ParseDashboard([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseDashboard() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (dashboard.UID != "fleet" || len(dashboard.Panels) != 1 || dashboard.Panels[0].Title != "CPU") {
+				t.Errorf("Unexpected dashboard %+v", dashboard)
+			}
+		})
+	}
+}
+
+func TestDiffGrafanaExport(t *testing.T) {
+	data, err := os.ReadFile("testdata/grafana10.json")
+	if err != nil {
+		t.Fatalf("Failed to read the export: %v", err)
+	}
+	exported, err := ParseDashboard(data)
+	if err != nil {
+		t.Fatalf("ParseDashboard() error = %v", err)
+	}
+	if exported.UID != "lazydash-node" || exported.Version != 7 || len(exported.Panels) != 5 {
+		t.Fatalf("Expected the dashboard with the panels of its collapsed row, got %+v", exported)
+	}
+
+	cpu := diffPanel(2, "CPU", `sum by (mode) (rate(node_cpu_seconds_total{job=~"$job",instance=~"$instance"}[1m]))`, "percentunit", 0.9)
+	cpu.Targets[0].LegendFormat = "{{mode}}"
+	up := diffPanel(3, "Up", `up{job=~"$job",instance=~"$instance"}`, "none")
+	up.Type, up.Targets[0].LegendFormat = "stat", "__auto"
+	up.FieldConfig.Defaults.Thresholds = fieldThresholds([]PanelFieldOptionsThreshold{{Color: "red"}, {Value: 1, Color: "green"}})
+	disk := diffPanel(5, "Disk read", `sum(rate(node_disk_read_bytes_total{job=~"$job",instance=~"$instance"}[1m]))`, "Bps")
+	disk.Targets[0].LegendFormat = "{{device}}"
+	disk.FieldConfig.Defaults.Thresholds = fieldThresholds([]PanelFieldOptionsThreshold{{Color: "green"}})
+	generated := &Dashboard{Panels: []Panel{{Type: "row", Title: "Node"}, cpu, up, {Type: "row", Title: "Disk"}, disk}}
+
+	if diff := Diff(exported, generated); !diff.Empty() {
+		t.Errorf("Expected no differences with the export, got %+v", diff.Panels)
+	}
+}
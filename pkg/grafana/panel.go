@@ -3,6 +3,7 @@ package grafana
 import (
 	"github.com/hemzaz/lazydash/internal/config"
 	"github.com/hemzaz/lazydash/pkg/metrics"
+	"github.com/hemzaz/lazydash/pkg/query"
 )
 
 // Panel represents a Grafana dashboard panel
@@ -15,7 +16,7 @@ type Panel struct {
 	Content       string        `json:"content,omitempty"`
 	Targets       []PanelTarget `json:"targets,omitempty"`
 	Description   string        `json:"description,omitempty"`
-	Legend        PanelLegend   `json:"legend,omitempty"`
+	Legend        *PanelLegend  `json:"legend,omitempty"`
 	Bars          bool          `json:"bars,omitempty"`
 	DashLength    int           `json:"dashLength,omitempty"`
 	Dashes        bool          `json:"dashes,omitempty"`
@@ -32,9 +33,10 @@ type Panel struct {
 	Stack         bool          `json:"stack,omitempty"`
 	SteppedLine   bool          `json:"steppedLine,omitempty"`
 	YAxes         []PanelYAxes  `json:"yaxes,omitempty"`
-	YAxis         PanelYAxis    `json:"yaxis,omitempty"`
-	XAxis         PanelXAxis    `json:"xaxis,omitempty"`
-	ToolTip       PanelToolTip  `json:"tooltip,omitempty"`
+	YAxis         *PanelYAxis   `json:"yaxis,omitempty"`
+	XAxis         *PanelXAxis   `json:"xaxis,omitempty"`
+	ToolTip       *PanelToolTip `json:"tooltip,omitempty"`
+	FieldConfig   *FieldConfig  `json:"fieldConfig,omitempty"`
 	Options       PanelOptions  `json:"options,omitempty"`
 	Datasource    string        `json:"datasource,omitempty"`
 	Alert         *AlertDefinition `json:"alert,omitempty"`
@@ -43,22 +45,27 @@ type Panel struct {
 	DataFormat      string        `json:"dataFormat,omitempty"`
 	HideZeroBuckets bool          `json:"hideZeroBuckets,omitempty"`
 	HighlightCards  bool          `json:"highlightCards,omitempty"`
-	Color           HeatmapColor  `json:"color,omitempty"`
+	Color           *HeatmapColor `json:"color,omitempty"`
 	Columns         []TableColumn `json:"columns,omitempty"`
 	Transform       string        `json:"transform,omitempty"`
-	Sort            TableSort     `json:"sort,omitempty"`
+	Sort            *TableSort    `json:"sort,omitempty"`
+	
+	metric string // Name of the charted metric, not part of the dashboard model
 }
 
 // PanelOptions contains options for different panel types
 type PanelOptions struct {
-	ShowThresholdMarkers bool              `json:"showThresholdMarkers,omitempty"`
-	ShowThresholdLabels  bool              `json:"showThresholdLabels,omitempty"`
-	FieldOptions         PanelFieldOptions `json:"fieldOptions,omitempty"`
-	Orientation          string            `json:"orientation,omitempty"`
-	ColorMode            string            `json:"colorMode,omitempty"`
-	GraphMode            string            `json:"graphMode,omitempty"`
-	TextMode             string            `json:"textMode,omitempty"`
-	DisplayMode          string            `json:"displayMode,omitempty"`
+	ShowThresholdMarkers bool               `json:"showThresholdMarkers,omitempty"`
+	ShowThresholdLabels  bool               `json:"showThresholdLabels,omitempty"`
+	FieldOptions         *PanelFieldOptions `json:"fieldOptions,omitempty"`
+	ReduceOptions        *ReduceOptions     `json:"reduceOptions,omitempty"`
+	Orientation          string             `json:"orientation,omitempty"`
+	ColorMode            string             `json:"colorMode,omitempty"`
+	GraphMode            string             `json:"graphMode,omitempty"`
+	TextMode             string             `json:"textMode,omitempty"`
+	DisplayMode          string             `json:"displayMode,omitempty"`
+	Legend               *VizLegendOptions  `json:"legend,omitempty"`
+	Tooltip              *VizTooltipOptions `json:"tooltip,omitempty"`
 }
 
 // PanelFieldOptions contains field configuration for panels
@@ -72,12 +79,22 @@ type PanelFieldOptions struct {
 type PanelFieldOptionsDefaults struct {
 	Thresholds []PanelFieldOptionsThreshold `json:"thresholds,omitempty"`
 	Unit       string                       `json:"unit,omitempty"`
+	Mappings   []PanelValueMapping          `json:"mappings,omitempty"`
 }
 
 // PanelFieldOptionsThreshold contains threshold settings
 type PanelFieldOptionsThreshold struct {
-	Value int    `json:"value,omitempty"`
-	Color string `json:"color,omitempty"`
+	Value float64 `json:"value,omitempty"`
+	Color string  `json:"color,omitempty"`
+}
+
+// PanelValueMapping maps a raw value to display text
+type PanelValueMapping struct {
+	ID       int    `json:"id"`
+	Operator string `json:"operator"`
+	Text     string `json:"text"`
+	Type     int    `json:"type"` // 1 maps a single value
+	Value    string `json:"value"`
 }
 
 // PanelGridPos represents the position of a panel
@@ -95,6 +112,7 @@ type PanelTarget struct {
 	LegendFormat string `json:"legendFormat,omitempty"`
 	Format       string `json:"format,omitempty"`
 	Datasource   string `json:"datasource,omitempty"`
+	Exemplar     bool   `json:"exemplar,omitempty"`
 }
 
 // PanelLegend contains legend configuration
@@ -218,17 +236,17 @@ func NewPanel(title string) *Panel {
 				Show: false,
 			},
 		},
-		XAxis: PanelXAxis{
+		XAxis: &PanelXAxis{
 			Mode: "time",
 			Show: true,
 		},
-		Legend: PanelLegend{
+		Legend: &PanelLegend{
 			Show:    true,
 			Current: false,
 			Values:  false,
 		},
 		Options: PanelOptions{
-			FieldOptions: PanelFieldOptions{
+			FieldOptions: &PanelFieldOptions{
 				Defaults: PanelFieldOptionsDefaults{
 					Unit: "short",
 				},
@@ -284,16 +302,45 @@ func (p *Panel) SetMetricExpr(expr string) {
 	}
 }
 
+// SetTargets replaces the panel's queries with the given targets
+func (p *Panel) SetTargets(targets []query.Target) {
+	p.Targets = make([]PanelTarget, 0, len(targets))
+	for _, target := range targets {
+		p.Targets = append(p.Targets, PanelTarget{
+			Expr:         target.Expr,
+			RefID:        target.RefID,
+			LegendFormat: target.LegendFormat,
+			Format:       target.Format,
+		})
+	}
+}
+
+// tableSeriesThreshold is the number of series above which a gauge is
+// shown as a table instead of one gauge or line per series
+const tableSeriesThreshold = 10
+
 // getVisualizationType determines the best visualization for a metric
 func getVisualizationType(metric *metrics.Metric, cfg *config.Config) config.VisualizationType {
 	// If there's a specific override in the config, use that
 	if cfg.Visualizations != nil {
+		// States and info metrics read best by their values, not their type
+		switch metric.ValueKind() {
+		case metrics.ValueBoolean:
+			return config.VisualizationStat
+		case metrics.ValueInfo:
+			return config.VisualizationTable
+		}
+		
 		switch metric.Type() {
 		case "counter":
 			if cfg.Visualizations.CounterType != "" {
 				return cfg.Visualizations.CounterType
 			}
 		case "gauge":
+			// Too many series to read as individual gauges or lines
+			if cfg.Visualizations.UseTableForMultiLabels && metric.SeriesCount() > tableSeriesThreshold {
+				return config.VisualizationTable
+			}
 			if cfg.Visualizations.GaugeType != "" {
 				return cfg.Visualizations.GaugeType
 			}
@@ -301,6 +348,10 @@ func getVisualizationType(metric *metrics.Metric, cfg *config.Config) config.Vis
 			if cfg.Visualizations.SummaryType != "" {
 				return cfg.Visualizations.SummaryType
 			}
+		case "histogram", "gaugehistogram":
+			if cfg.Visualizations.UseHeatmapForHistograms {
+				return config.VisualizationHeatmap
+			}
 		}
 		
 		// If there's a default type specified, use that
@@ -320,16 +371,16 @@ func getVisualizationType(metric *metrics.Metric, cfg *config.Config) config.Vis
 			return config.VisualizationGauge
 		}
 		
-		// If we should use stat panel for gauges and it has few labels
-		if cfg.Visualizations != nil && cfg.Visualizations.UseStatForGauges && metric.LabelCount() <= 1 {
+		// If we should use stat panel for gauges with a single series
+		if cfg.Visualizations != nil && cfg.Visualizations.UseStatForGauges && metric.SeriesCount() <= 1 {
 			return config.VisualizationStat
 		}
 		
 		return config.VisualizationGraph
 		
-	case "summary":
-		// If it's a histogram and we want to use heatmaps
-		if metric.Suffix() == "_bucket" && cfg.Visualizations != nil && cfg.Visualizations.UseHeatmapForHistograms {
+	case "histogram", "gaugehistogram":
+		// Show the bucket distribution when we want to use heatmaps
+		if cfg.Visualizations != nil && cfg.Visualizations.UseHeatmapForHistograms {
 			return config.VisualizationHeatmap
 		}
 		
@@ -394,7 +445,7 @@ func configureHeatmapPanel(panel *Panel, metric *metrics.Metric) {
 	panel.HighlightCards = true
 	
 	// Set an appropriate color scheme
-	panel.Color = HeatmapColor{
+	panel.Color = &HeatmapColor{
 		Mode:       "spectrum",
 		CardColor:  "#b4ff00",
 		ColorScale: "sqrt",
@@ -430,7 +481,7 @@ func configureTablePanel(panel *Panel, metric *metrics.Metric) {
 	
 	// Set table display options
 	panel.Transform = "timeseries_to_columns"
-	panel.Sort = TableSort{
+	panel.Sort = &TableSort{
 		Col:  0,
 		Desc: true,
 	}
@@ -440,31 +491,10 @@ func configureTablePanel(panel *Panel, metric *metrics.Metric) {
 func configureStatPanel(panel *Panel, metric *metrics.Metric) {
 	panel.Options.FieldOptions.Values = false
 	panel.Options.FieldOptions.Calcs = []string{"lastNotNull"}
-	panel.Options.FieldOptions.Defaults.Unit = metric.Unit()
 	
-	// Set thresholds based on metric type
-	switch metric.Type() {
-	case "counter":
-		panel.Options.FieldOptions.Defaults.Thresholds = []PanelFieldOptionsThreshold{
-			{Value: 0, Color: "green"},
-			{Value: 100, Color: "orange"},
-			{Value: 500, Color: "red"},
-		}
-	case "gauge":
-		if metric.Unit() == "percent" {
-			panel.Options.FieldOptions.Defaults.Thresholds = []PanelFieldOptionsThreshold{
-				{Value: 0, Color: "green"},
-				{Value: 80, Color: "orange"},
-				{Value: 90, Color: "red"},
-			}
-		} else {
-			panel.Options.FieldOptions.Defaults.Thresholds = []PanelFieldOptionsThreshold{
-				{Value: 0, Color: "green"},
-				{Value: 70, Color: "orange"},
-				{Value: 90, Color: "red"},
-			}
-		}
-	}
+	// Set thresholds from the observed values, states get readable names
+	panel.Options.FieldOptions.Defaults.Thresholds = thresholdSteps(metric)
+	panel.Options.FieldOptions.Defaults.Mappings = valueMappings(metric)
 	
 	// Set other stat panel options
 	panel.Options.ColorMode = "value"
@@ -476,31 +506,9 @@ func configureStatPanel(panel *Panel, metric *metrics.Metric) {
 func configureBarGaugePanel(panel *Panel, metric *metrics.Metric) {
 	panel.Options.FieldOptions.Values = false
 	panel.Options.FieldOptions.Calcs = []string{"lastNotNull"}
-	panel.Options.FieldOptions.Defaults.Unit = metric.Unit()
 	
-	// Set thresholds based on metric type (similar to stat panel)
-	switch metric.Type() {
-	case "counter":
-		panel.Options.FieldOptions.Defaults.Thresholds = []PanelFieldOptionsThreshold{
-			{Value: 0, Color: "green"},
-			{Value: 100, Color: "orange"},
-			{Value: 500, Color: "red"},
-		}
-	case "gauge":
-		if metric.Unit() == "percent" {
-			panel.Options.FieldOptions.Defaults.Thresholds = []PanelFieldOptionsThreshold{
-				{Value: 0, Color: "green"},
-				{Value: 80, Color: "orange"},
-				{Value: 90, Color: "red"},
-			}
-		} else {
-			panel.Options.FieldOptions.Defaults.Thresholds = []PanelFieldOptionsThreshold{
-				{Value: 0, Color: "green"},
-				{Value: 70, Color: "orange"},
-				{Value: 90, Color: "red"},
-			}
-		}
-	}
+	// Set thresholds from the observed values (same as stat panel)
+	panel.Options.FieldOptions.Defaults.Thresholds = thresholdSteps(metric)
 	
 	// Set orientation
 	panel.Options.Orientation = "horizontal"
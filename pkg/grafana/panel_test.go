@@ -0,0 +1,189 @@
+package grafana
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hemzaz/lazydash/internal/config"
+	"github.com/hemzaz/lazydash/pkg/metrics"
+)
+
+func TestHistogramPanels(t *testing.T) {
+	metric := metrics.New("http_request_duration_seconds", "Request latency", map[string]bool{"le": true, "code": true}, "histogram", "_count", "s")
+
+	t.Run("Heatmap of bucket rates", func(t *testing.T) {
+		cfg := testConfig()
+		panel := createPanelForMetric(metric, cfg, testQueryBuilder(cfg))
+
+		if panel.Type != "heatmap" {
+			t.Fatalf("Expected heatmap panel, got %q", panel.Type)
+		}
+		if len(panel.Targets) != 1 {
+			t.Fatalf("Expected 1 target, got %d", len(panel.Targets))
+		}
+		target := panel.Targets[0]
+		if target.Format != "heatmap" || target.LegendFormat != "{{le}}" {
+			t.Errorf("Expected heatmap format with {{le}} legend, got %q %q", target.Format, target.LegendFormat)
+		}
+		if !strings.HasPrefix(target.Expr, "sum by (le) (rate(http_request_duration_seconds_bucket{") {
+			t.Errorf("Unexpected heatmap query %q", target.Expr)
+		}
+	})
+
+	t.Run("Graph of quantiles, rate and average", func(t *testing.T) {
+		cfg := testConfig()
+		cfg.Visualizations.UseHeatmapForHistograms = false
+		panel := createPanelForMetric(metric, cfg, testQueryBuilder(cfg))
+
+		if panel.Type != "timeseries" {
+			t.Fatalf("Expected timeseries panel, got %q", panel.Type)
+		}
+		if len(panel.Targets) != 5 {
+			t.Fatalf("Expected 5 targets, got %d", len(panel.Targets))
+		}
+
+		legends := []string{"p50 code:[{{code}}]", "p90 code:[{{code}}]", "p99 code:[{{code}}]", "rate code:[{{code}}]", "avg code:[{{code}}]"}
+		for i, legend := range legends {
+			if panel.Targets[i].LegendFormat != legend {
+				t.Errorf("Target %s legend = %q; want %q", panel.Targets[i].RefID, panel.Targets[i].LegendFormat, legend)
+			}
+		}
+		if !strings.HasPrefix(panel.Targets[0].Expr, "histogram_quantile(0.5, sum by (le, code) (rate(") {
+			t.Errorf("Unexpected p50 query %q", panel.Targets[0].Expr)
+		}
+	})
+}
+
+func TestSummaryPanels(t *testing.T) {
+	registry := loadPromdata(t)
+	cfg := testConfig()
+
+	metric := registry.Get("go_gc_duration_seconds")
+	panel := createPanelForMetric(metric, cfg, testQueryBuilder(cfg))
+
+	// Five observed quantiles, then rate and average
+	legends := []string{"p0", "p25", "p50", "p75", "p100", "rate", "avg"}
+	if len(panel.Targets) != len(legends) {
+		t.Fatalf("Expected %d targets, got %d", len(legends), len(panel.Targets))
+	}
+	for i, legend := range legends {
+		if panel.Targets[i].LegendFormat != legend {
+			t.Errorf("Target %s legend = %q; want %q", panel.Targets[i].RefID, panel.Targets[i].LegendFormat, legend)
+		}
+	}
+	if !strings.HasPrefix(panel.Targets[4].Expr, "go_gc_duration_seconds{quantile=\"1\",") {
+		t.Errorf("Unexpected p100 query %q", panel.Targets[4].Expr)
+	}
+}
+
+func TestGaugeLayoutBySeriesCount(t *testing.T) {
+	newGauge := func(series int) *metrics.Metric {
+		metric := metrics.New("queue_length", "", nil, "gauge", "", "short")
+		for i := 0; i < series; i++ {
+			metric.AddLabelValue("queue", fmt.Sprint(i))
+			metric.AddSeries(map[string]string{"queue": fmt.Sprint(i)})
+		}
+		return metric
+	}
+
+	tests := []struct {
+		name     string
+		series   int
+		useTable bool
+		want     config.VisualizationType
+	}{
+		{"Single series", 1, true, config.VisualizationGauge},
+		{"Few series", tableSeriesThreshold, true, config.VisualizationGauge},
+		{"Many series", tableSeriesThreshold + 1, true, config.VisualizationTable},
+		{"Many series without tables", tableSeriesThreshold + 1, false, config.VisualizationGauge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.Visualizations.GaugeType = config.VisualizationGauge
+			cfg.Visualizations.UseTableForMultiLabels = tt.useTable
+			if got := getVisualizationType(newGauge(tt.series), cfg); got != tt.want {
+				t.Errorf("getVisualizationType() = %q; want %q", got, tt.want)
+			}
+		})
+	}
+
+	// Without a gauge override, a labelled gauge with one series is a stat
+	t.Run("Stat for a single labelled series", func(t *testing.T) {
+		cfg := testConfig()
+		cfg.Visualizations.GaugeType = ""
+		cfg.Visualizations.DefaultType = ""
+		metric := newGauge(1)
+		metric.AddLabelValue("host", "a")
+		if got := getVisualizationType(metric, cfg); got != config.VisualizationStat {
+			t.Errorf("getVisualizationType() = %q; want %q", got, config.VisualizationStat)
+		}
+	})
+}
+
+func TestExemplarTargets(t *testing.T) {
+	cfg := testConfig()
+	metric := metrics.New("http_requests", "", nil, "counter", "_total", "short")
+
+	panel := createPanelForMetric(metric, cfg, testQueryBuilder(cfg))
+	if panel.Targets[0].Exemplar {
+		t.Errorf("Expected exemplars to be off without exemplars in the input")
+	}
+
+	metric.SetExemplars(true)
+	panel = createPanelForMetric(metric, cfg, testQueryBuilder(cfg))
+	for _, target := range panel.Targets {
+		if !target.Exemplar {
+			t.Errorf("Expected exemplars on target %s", target.RefID)
+		}
+	}
+}
+
+func TestInferredTypePanels(t *testing.T) {
+	metric := metrics.New("jobs_processed_total", "Jobs processed.", nil, "", "", "short")
+	metric.InferType("counter", "name ends with _total", 0.8)
+	cfg := testConfig()
+	panel := createPanelForMetric(metric, cfg, testQueryBuilder(cfg))
+
+	if want := "Jobs processed.\n\nType inferred as counter: name ends with _total (80% confidence)"; panel.Description != want {
+		t.Errorf("Description = %q; want %q", panel.Description, want)
+	}
+	if expr := panel.Targets[0].Expr; !strings.Contains(expr, "rate(") {
+		t.Errorf("Expected a rate query for the inferred counter, got %q", expr)
+	}
+
+	declared := metrics.New("jobs_failed_total", "Jobs failed.", nil, "counter", "", "short")
+	if panel := createPanelForMetric(declared, cfg, testQueryBuilder(cfg)); panel.Description != "Jobs failed." {
+		t.Errorf("Expected no note for a declared type, got %q", panel.Description)
+	}
+}
+
+func TestRateUnitPanels(t *testing.T) {
+	registry := loadPromdata(t)
+	cfg := testConfig()
+
+	tests := map[string]string{
+		"go_memstats_alloc_bytes_total": "Bps",
+		"process_cpu_seconds_total":     "percentunit",
+		"go_gc_duration_seconds":        "s",
+	}
+	for name, unit := range tests {
+		t.Run(name, func(t *testing.T) {
+			panel := createPanelForMetric(registry.Get(name), cfg, testQueryBuilder(cfg))
+			if got := panel.FieldConfig.Defaults.Unit; got != unit {
+				t.Errorf("Expected unit %q, got %q", unit, got)
+			}
+		})
+	}
+
+	t.Run("Stat panels", func(t *testing.T) {
+		cfg := testConfig()
+		cfg.Visualizations.CounterType = config.VisualizationStat
+		panel := createPanelForMetric(registry.Get("process_cpu_seconds_total"), cfg, testQueryBuilder(cfg))
+		if got := panel.FieldConfig.Defaults.Unit; panel.Type != "stat" || got != "percentunit" {
+			t.Errorf("Expected a percentunit stat panel, got %q %q", panel.Type, got)
+		}
+	})
+}
@@ -0,0 +1,150 @@
+package grafana
+
+import (
+	"github.com/hemzaz/lazydash/internal/config"
+	"github.com/hemzaz/lazydash/pkg/alerting"
+	"github.com/hemzaz/lazydash/pkg/metrics"
+	"github.com/hemzaz/lazydash/pkg/query"
+)
+
+// applyRule applies the query, legend, unit and thresholds of a spec rule
+// to a generated panel
+func applyRule(panel *Panel, metric *metrics.Metric, rule *config.MetricRule, queryBuilder *query.Builder) {
+	if rule.Expr != "" {
+		panel.SetTargets([]query.Target{
+			{
+				Expr:         queryBuilder.BuildTemplateQuery(rule.Expr, metric),
+				LegendFormat: panel.Targets[0].LegendFormat,
+				RefID:        "A",
+				Format:       "time_series",
+			},
+		})
+	}
+	if rule.Legend != "" {
+		panel.SetLegendFormat(rule.Legend)
+	}
+	if rule.Unit != "" {
+		panel.SetUnit(rule.Unit)
+	} else if rule.Expr != "" {
+		panel.SetUnit(query.ExprUnit(rule.Expr, metric))
+	}
+	if steps := ruleThresholdSteps(rule); steps != nil && panel.Options.FieldOptions != nil {
+		panel.Options.FieldOptions.Defaults.Thresholds = steps
+	}
+}
+
+// ruleThresholdSteps returns the threshold steps of a spec rule, or nil
+func ruleThresholdSteps(rule *config.MetricRule) []PanelFieldOptionsThreshold {
+	if rule.Thresholds == nil {
+		return nil
+	}
+
+	steps := []PanelFieldOptionsThreshold{{Value: 0, Color: "green"}}
+	if rule.Thresholds.Warning != nil {
+		steps = append(steps, PanelFieldOptionsThreshold{Value: *rule.Thresholds.Warning, Color: "orange"})
+	}
+	if rule.Thresholds.Critical != nil {
+		steps = append(steps, PanelFieldOptionsThreshold{Value: *rule.Thresholds.Critical, Color: "red"})
+	}
+	return steps
+}
+
+// thresholdLines returns the thresholds drawn on a time series panel: those
+// of a spec rule, otherwise the alert thresholds of the metric
+func thresholdLines(metric *metrics.Metric, cfg *config.Config) []PanelFieldOptionsThreshold {
+	if steps := ruleThresholdSteps(cfg.Spec.RuleFor(metric.Name())); steps != nil {
+		return steps
+	}
+
+	threshold := alerting.Threshold(metric, cfg)
+	if threshold == nil {
+		return nil
+	}
+	steps := []PanelFieldOptionsThreshold{{Value: 0, Color: "green"}}
+	if threshold.Warning > 0 && threshold.Warning != threshold.Error {
+		steps = append(steps, PanelFieldOptionsThreshold{Value: threshold.Warning, Color: "orange"})
+	}
+	return append(steps, PanelFieldOptionsThreshold{Value: threshold.Error, Color: "red"})
+}
+
+// specRows returns the metrics that spec rules place in a row (inRow) or
+// the ones laid out as usual
+func specRows(registry *metrics.Registry, cfg *config.Config, inRow bool) *metrics.Registry {
+	if cfg.Spec == nil {
+		if inRow {
+			return metrics.NewRegistry()
+		}
+		return registry
+	}
+	return registry.Filter(func(name string, metric *metrics.Metric) bool {
+		return (cfg.Spec.RuleFor(name).Row != "") == inRow
+	})
+}
+
+// addSpecRows adds a row for every row title of the spec below the other
+// panels, holding the metrics placed in it
+func (d *Dashboard) addSpecRows(registry *metrics.Registry, cfg *config.Config, queryBuilder *query.Builder) {
+	yPos := d.bottom()
+	for _, title := range cfg.Spec.Rows() {
+		var rowMetrics []*metrics.Metric
+		registry.ForEach(func(name string, metric *metrics.Metric) {
+			if cfg.Spec.RuleFor(name).Row == title {
+				rowMetrics = append(rowMetrics, metric)
+			}
+		})
+		if len(rowMetrics) == 0 {
+			continue
+		}
+
+		d.AddPanel(Panel{
+			Title:   title,
+			Type:    "row",
+			GridPos: PanelGridPos{X: 0, Y: yPos, W: 24, H: 1},
+		})
+		yPos = d.addPanelGrid(rowMetrics, yPos+1, cfg, queryBuilder)
+	}
+}
+
+// bottom returns the y position below all panels
+func (d *Dashboard) bottom() int {
+	bottom := 0
+	for _, panel := range d.Panels {
+		if y := panel.GridPos.Y + panel.GridPos.H; y > bottom {
+			bottom = y
+		}
+	}
+	return bottom
+}
+
+// reflow lays the panels out again in order after spec rules changed their
+// widths. Panels fill each line left to right and rows start a new line.
+func (d *Dashboard) reflow(cfg *config.Config) {
+	x, y, lineHeight := 0, 0, 0
+	newLine := func() {
+		if x > 0 {
+			x, y, lineHeight = 0, y+lineHeight, 0
+		}
+	}
+
+	for i := range d.Panels {
+		panel := &d.Panels[i]
+		if panel.Type == "row" {
+			newLine()
+			panel.SetGridPos(0, y, 1, 24)
+			y++
+			continue
+		}
+
+		if width := cfg.Spec.RuleFor(panel.metric).Width; width > 0 && panel.metric != "" {
+			panel.GridPos.W = width
+		}
+		if x+panel.GridPos.W > 24 {
+			newLine()
+		}
+		panel.SetGridPos(x, y, panel.GridPos.H, panel.GridPos.W)
+		x += panel.GridPos.W
+		if panel.GridPos.H > lineHeight {
+			lineHeight = panel.GridPos.H
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package grafana
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hemzaz/lazydash/internal/config"
+	"github.com/hemzaz/lazydash/pkg/metrics"
+)
+
+// specRegistry returns metrics for two rows and one hidden metric
+func specRegistry() *metrics.Registry {
+	registry := metrics.NewRegistry()
+	registry.Set("http_errors", metrics.New("http_errors", "", map[string]bool{"code": true}, "counter", "_total", "short"))
+	registry.Set("node_load1", metrics.New("node_load1", "", nil, "gauge", "", "short"))
+	registry.Set("node_load5", metrics.New("node_load5", "", nil, "gauge", "", "short"))
+	registry.Set("node_boot_time_seconds", metrics.New("node_boot_time_seconds", "", nil, "gauge", "", "s"))
+	return registry
+}
+
+func TestCreatePanelWithRule(t *testing.T) {
+	warning, critical := 5.0, 20.0
+	cfg := testConfig()
+	cfg.Spec = &config.Spec{Rules: []*config.MetricRule{
+		{Match: "http_*", Unit: "reqps"},
+		{
+			Match:         "http_errors",
+			Expr:          "sum by (code) (increase(:METRIC: [1h]))",
+			Legend:        "{{code}}",
+			Visualization: config.VisualizationBarGauge,
+			Thresholds:    &config.SpecThresholds{Warning: &warning, Critical: &critical},
+		},
+	}}
+	metric := specRegistry().Get("http_errors")
+	panel := createPanelForMetric(metric, cfg, testQueryBuilder(cfg))
+
+	if panel.Type != "bargauge" {
+		t.Errorf("Expected bargauge panel, got %q", panel.Type)
+	}
+	if len(panel.Targets) != 1 || !strings.HasPrefix(panel.Targets[0].Expr, "sum by (code) (increase(http_errors_total{job=~\"$job\"") {
+		t.Errorf("Expected the rule query with template matchers, got %+v", panel.Targets)
+	}
+	if panel.Targets[0].LegendFormat != "{{code}}" {
+		t.Errorf("Expected legend {{code}}, got %q", panel.Targets[0].LegendFormat)
+	}
+
+	defaults := panel.FieldConfig.Defaults
+	if defaults.Unit != "reqps" {
+		t.Errorf("Expected unit from the glob rule, got %q", defaults.Unit)
+	}
+	if steps := defaults.Thresholds.Steps; len(steps) != 3 || *steps[1].Value != 5 || *steps[2].Value != 20 {
+		t.Errorf("Expected rule thresholds, got %+v", defaults.Thresholds)
+	}
+
+	t.Run("Time series threshold lines", func(t *testing.T) {
+		cfg.Spec.Rules[1].Visualization = config.VisualizationGraph
+		panel := createPanelForMetric(metric, cfg, testQueryBuilder(cfg))
+		defaults := panel.FieldConfig.Defaults
+		if defaults.Unit != "reqps" || defaults.Custom.ThresholdsStyle.Mode != "line" || *defaults.Thresholds.Steps[2].Value != 20 {
+			t.Errorf("Expected rule unit and threshold lines, got %+v", defaults)
+		}
+	})
+}
+
+func TestRuleExprUnit(t *testing.T) {
+	cfg := testConfig()
+	cfg.Spec = &config.Spec{Rules: []*config.MetricRule{
+		{Match: "rx_bytes", Expr: "sum(increase(:METRIC: [1h]))"},
+	}}
+	metric := metrics.New("rx_bytes", "", nil, "counter", "_total", "decbytes")
+
+	// The default query is a rate, the rule's a total
+	if unit := createPanelForMetric(metric, cfg, testQueryBuilder(cfg)).FieldConfig.Defaults.Unit; unit != "decbytes" {
+		t.Errorf("Expected the unit of the rule query, got %q", unit)
+	}
+}
+
+func TestGenerateWithSpec(t *testing.T) {
+	hide := true
+	cfg := testConfig()
+	cfg.Spec = &config.Spec{Rules: []*config.MetricRule{
+		{Match: "node_boot_time_seconds", Hide: &hide},
+		{Match: "node_load*", Row: "Load", Width: 8},
+	}}
+
+	dashboard := NewDashboard("spec")
+	dashboard.Generate(specRegistry(), cfg, testQueryBuilder(cfg))
+
+	var titles []string
+	for _, panel := range dashboard.Panels {
+		titles = append(titles, panel.Type+":"+panel.Title)
+	}
+	expected := []string{"timeseries:http errors", "row:Load", "gauge:node load1", "gauge:node load5"}
+	if strings.Join(titles, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Expected panels %v, got %v", expected, titles)
+	}
+
+	row, load1, load5 := dashboard.Panels[1].GridPos, dashboard.Panels[2].GridPos, dashboard.Panels[3].GridPos
+	if row.Y != 8 || row.W != 24 {
+		t.Errorf("Expected the row below the first line, got %+v", row)
+	}
+	if load1 != (PanelGridPos{X: 0, Y: 9, H: 8, W: 8}) || load5 != (PanelGridPos{X: 8, Y: 9, H: 8, W: 8}) {
+		t.Errorf("Expected 8 wide panels side by side, got %+v and %+v", load1, load5)
+	}
+
+	for _, variable := range dashboard.Templating.List {
+		if strings.Contains(variable.Query, "node_boot_time_seconds") {
+			t.Errorf("Expected hidden metrics to be left out of template variables, got %q", variable.Query)
+		}
+	}
+}
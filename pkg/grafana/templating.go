@@ -0,0 +1,101 @@
+package grafana
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hemzaz/lazydash/internal/config"
+	"github.com/hemzaz/lazydash/pkg/metrics"
+	"github.com/hemzaz/lazydash/pkg/query"
+	"github.com/rs/zerolog/log"
+)
+
+// targetMetric is queried for target label values since every scraped job has it
+const targetMetric = "up"
+
+// NewTemplating creates chained label_values() variables for the target
+// labels (job, instance) followed by the configured extra labels. Each
+// variable is filtered by all the variables before it, so picking a job
+// narrows the instances on offer. The values of an extra label are read
+// from every metric that has it.
+func NewTemplating(registry *metrics.Registry, cfg *config.Config) Templating {
+	if cfg.Templating == nil || !cfg.Templating.Enabled {
+		return Templating{}
+	}
+
+	templating := Templating{Enable: true}
+	var matchers []string
+
+	addVariable := func(label string, names []string) {
+		selector := seriesSelector(names, matchers)
+		templating.List = append(templating.List, newQueryVariable(label, fmt.Sprintf("label_values(%s, %s)", selector, label)))
+		matchers = append(matchers, fmt.Sprintf("%s=~\"$%s\"", label, label))
+	}
+
+	for _, label := range query.TargetLabels {
+		addVariable(label, []string{targetMetric})
+	}
+
+	seen := make(map[string]bool)
+	for _, label := range cfg.Templating.Labels {
+		if label == "" || seen[label] || query.IsTargetLabel(label) {
+			continue
+		}
+		seen[label] = true
+
+		names := seriesWithLabel(registry, label)
+		if len(names) == 0 {
+			log.Warn().Str("label", label).Msg("No metric has this label, skipping template variable")
+			continue
+		}
+		addVariable(label, names)
+	}
+
+	return templating
+}
+
+// newQueryVariable creates a multi-value query variable defaulting to All
+func newQueryVariable(label, query string) TemplateVar {
+	return TemplateVar{
+		Name:       label,
+		Label:      strings.ToUpper(label[:1]) + label[1:],
+		Type:       "query",
+		Query:      query,
+		Refresh:    2, // On time range change
+		Sort:       1, // Alphabetical ascending
+		IncludeAll: true,
+		Multi:      true,
+		AllValue:   ".*",
+		Current: TemplateVarState{
+			Text:  "All",
+			Value: "$__all",
+		},
+	}
+}
+
+// seriesWithLabel returns the series names of the metrics that have the
+// label, in name order
+func seriesWithLabel(registry *metrics.Registry, label string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, metric := range registry.ListByLabel(label) {
+		if name := metric.FullName(); !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// seriesSelector selects the series of any of the names that match the
+// matchers, e.g. {__name__=~"a|b",job=~"$job"}
+func seriesSelector(names, matchers []string) string {
+	if len(names) == 1 {
+		if len(matchers) == 0 {
+			return names[0]
+		}
+		return names[0] + "{" + strings.Join(matchers, ",") + "}"
+	}
+	all := append([]string{fmt.Sprintf("__name__=~\"%s\"", strings.Join(names, "|"))}, matchers...)
+	return "{" + strings.Join(all, ",") + "}"
+}
@@ -0,0 +1,92 @@
+package grafana
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewTemplating(t *testing.T) {
+	registry := loadPromdata(t)
+
+	t.Run("Target labels are chained", func(t *testing.T) {
+		cfg := testConfig()
+		templating := NewTemplating(registry, cfg)
+
+		if len(templating.List) != 2 {
+			t.Fatalf("Expected 2 variables, got %d", len(templating.List))
+		}
+
+		job := templating.List[0]
+		if job.Name != "job" || job.Query != "label_values(up, job)" {
+			t.Errorf("Unexpected job variable: %q %q", job.Name, job.Query)
+		}
+
+		instance := templating.List[1]
+		expected := "label_values(up{job=~\"$job\"}, instance)"
+		if instance.Name != "instance" || instance.Query != expected {
+			t.Errorf("Expected instance query %q, got %q", expected, instance.Query)
+		}
+
+		for _, v := range templating.List {
+			if v.Type != "query" || !v.Multi || !v.IncludeAll {
+				t.Errorf("Variable %q should be a multi-value query with All", v.Name)
+			}
+		}
+	})
+
+	t.Run("Extra labels depend on the target labels", func(t *testing.T) {
+		cfg := testConfig()
+		cfg.Templating.Labels = []string{"action", "instance", "action"}
+		templating := NewTemplating(registry, cfg)
+
+		if len(templating.List) != 3 {
+			t.Fatalf("Expected 3 variables, got %d", len(templating.List))
+		}
+
+		action := templating.List[2]
+		// Every metric with the label offers its values, not just the first
+		expected := "label_values({__name__=~\"engine_daemon_container_actions_seconds_count|engine_daemon_image_actions_seconds_count|engine_daemon_network_actions_seconds_count\",job=~\"$job\",instance=~\"$instance\"}, action)"
+		if action.Query != expected {
+			t.Errorf("Expected action query %q, got %q", expected, action.Query)
+		}
+		if action.Label != "Action" {
+			t.Errorf("Expected label %q, got %q", "Action", action.Label)
+		}
+	})
+
+	t.Run("Unknown labels are skipped", func(t *testing.T) {
+		cfg := testConfig()
+		cfg.Templating.Labels = []string{"no_such_label"}
+		if templating := NewTemplating(registry, cfg); len(templating.List) != 2 {
+			t.Errorf("Expected 2 variables, got %d", len(templating.List))
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		cfg := testConfig()
+		cfg.Templating.Enabled = false
+		if templating := NewTemplating(registry, cfg); len(templating.List) != 0 {
+			t.Errorf("Expected no variables, got %d", len(templating.List))
+		}
+	})
+}
+
+func TestGenerateInjectsTemplateMatchers(t *testing.T) {
+	registry := loadPromdata(t)
+	cfg := testConfig()
+
+	dashboard := NewDashboard("Templated")
+	dashboard.Generate(registry, cfg, testQueryBuilder(cfg))
+
+	if len(dashboard.Templating.List) == 0 {
+		t.Fatal("Expected the dashboard to have template variables")
+	}
+
+	for _, panel := range dashboard.Panels {
+		for _, target := range panel.Targets {
+			if !strings.Contains(target.Expr, "job=~\"$job\"") {
+				t.Errorf("Panel %q expression %q is not filtered by $job", panel.Title, target.Expr)
+			}
+		}
+	}
+}
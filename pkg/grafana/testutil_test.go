@@ -0,0 +1,58 @@
+package grafana
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hemzaz/lazydash/internal/config"
+	"github.com/hemzaz/lazydash/pkg/metrics"
+	"github.com/hemzaz/lazydash/pkg/prometheus"
+	"github.com/hemzaz/lazydash/pkg/query"
+)
+
+// loadPromdata parses the sample exposition shipped with the repository
+func loadPromdata(t *testing.T) *metrics.Registry {
+	t.Helper()
+	data, err := os.ReadFile("../../promdata.txt")
+	if err != nil {
+		t.Fatalf("Failed to read promdata.txt: %v", err)
+	}
+	registry, err := prometheus.ParseMetrics(data)
+	if err != nil {
+		t.Fatalf("Failed to parse promdata.txt: %v", err)
+	}
+	return registry
+}
+
+// testConfig returns a configuration with the same defaults as the command line
+func testConfig() *config.Config {
+	cfg := config.New()
+	cfg.LabelGrouping = &config.LabelGroupConfig{SeparateRows: true, PanelsPerRow: 2}
+	cfg.Templating = &config.TemplatingConfig{Enabled: true}
+	cfg.Visualizations = &config.AdvancedVisualizationConfig{
+		DefaultType:             config.VisualizationGraph,
+		CounterType:             config.VisualizationGraph,
+		GaugeType:               config.VisualizationGauge,
+		SummaryType:             config.VisualizationGraph,
+		UseHeatmapForHistograms: true,
+		UseStatForGauges:        true,
+		UseTableForMultiLabels:  true,
+	}
+	return cfg
+}
+
+// testQueryBuilder returns a query builder for the configuration
+func testQueryBuilder(cfg *config.Config) *query.Builder {
+	return query.NewBuilder(cfg)
+}
+
+// testClient returns a client authenticating with the token "secret"
+func testClient(t *testing.T, host string) *Client {
+	t.Helper()
+	client, err := NewClient(host, ClientOptions{Token: "secret", Backoff: time.Millisecond})
+	if err != nil {
+		t.Fatalf("NewClient(%q) error = %v", host, err)
+	}
+	return client
+}
@@ -0,0 +1,127 @@
+package grafana
+
+import (
+	"math"
+	"strings"
+
+	"github.com/hemzaz/lazydash/pkg/metrics"
+)
+
+// thresholdSteps returns the stat and bar gauge thresholds for a metric.
+// Ratios, percentages and states have fixed scales; other gauges are scaled
+// to the highest observed value. Metrics without samples keep the defaults.
+func thresholdSteps(metric *metrics.Metric) []PanelFieldOptionsThreshold {
+	switch metric.ValueKind() {
+	case metrics.ValueBoolean:
+		return []PanelFieldOptionsThreshold{
+			{Value: 0, Color: "red"},
+			{Value: 1, Color: "green"},
+		}
+	case metrics.ValueInfo:
+		return []PanelFieldOptionsThreshold{
+			{Value: 0, Color: "blue"},
+		}
+	case metrics.ValueRatio:
+		return []PanelFieldOptionsThreshold{
+			{Value: 0, Color: "green"},
+			{Value: 0.8, Color: "orange"},
+			{Value: 0.9, Color: "red"},
+		}
+	case metrics.ValuePercent:
+		return []PanelFieldOptionsThreshold{
+			{Value: 0, Color: "green"},
+			{Value: 80, Color: "orange"},
+			{Value: 90, Color: "red"},
+		}
+	case metrics.ValueNumber:
+		return observedThresholds(metric)
+	default:
+		return defaultThresholds(metric)
+	}
+}
+
+// observedThresholds warns once a gauge rises a quarter above the highest
+// value seen in the input and turns red at twice that value, rounded up to
+// nice numbers with red at least one step above the warning. Counter panels
+// chart a rate, which cannot be judged from a single total, so they only
+// get the base color.
+func observedThresholds(metric *metrics.Metric) []PanelFieldOptionsThreshold {
+	steps := []PanelFieldOptionsThreshold{{Value: 0, Color: "green"}}
+
+	_, max, _ := metric.ValueRange()
+	if metric.Type() == "counter" || max <= 0 {
+		return steps
+	}
+
+	warning, critical := niceCeil(max*1.25), niceCeil(max*2)
+	if critical <= warning {
+		critical = niceCeil(warning * 2)
+	}
+	return append(steps,
+		PanelFieldOptionsThreshold{Value: warning, Color: "orange"},
+		PanelFieldOptionsThreshold{Value: critical, Color: "red"},
+	)
+}
+
+// defaultThresholds returns the thresholds used when no values were observed
+func defaultThresholds(metric *metrics.Metric) []PanelFieldOptionsThreshold {
+	switch metric.Type() {
+	case "counter":
+		return []PanelFieldOptionsThreshold{
+			{Value: 0, Color: "green"},
+			{Value: 100, Color: "orange"},
+			{Value: 500, Color: "red"},
+		}
+	case "gauge":
+		if metric.Unit() == "percent" {
+			return []PanelFieldOptionsThreshold{
+				{Value: 0, Color: "green"},
+				{Value: 80, Color: "orange"},
+				{Value: 90, Color: "red"},
+			}
+		}
+		return []PanelFieldOptionsThreshold{
+			{Value: 0, Color: "green"},
+			{Value: 70, Color: "orange"},
+			{Value: 90, Color: "red"},
+		}
+	}
+	return nil
+}
+
+// valueMappings names the states of boolean metrics, e.g. 0 as Down for up
+func valueMappings(metric *metrics.Metric) []PanelValueMapping {
+	if metric.ValueKind() != metrics.ValueBoolean {
+		return nil
+	}
+
+	off, on := "No", "Yes"
+	if name := metric.Name(); name == "up" || strings.HasSuffix(name, "_up") {
+		off, on = "Down", "Up"
+	}
+
+	return []PanelValueMapping{
+		{ID: 0, Operator: "", Text: off, Type: 1, Value: "0"},
+		{ID: 1, Operator: "", Text: on, Type: 1, Value: "1"},
+	}
+}
+
+// niceCeil rounds up to the next 1, 2 or 5 times a power of ten
+func niceCeil(v float64) float64 {
+	if v <= 0 {
+		return 0
+	}
+
+	magnitude := math.Pow(10, math.Floor(math.Log10(v)))
+	for _, step := range []float64{1, 2, 5, 10} {
+		if nice := step * magnitude; nice >= v {
+			return roundFloat(nice)
+		}
+	}
+	return roundFloat(10 * magnitude)
+}
+
+// roundFloat removes floating point noise such as 0.30000000000000004
+func roundFloat(v float64) float64 {
+	return math.Round(v*1e9) / 1e9
+}
@@ -0,0 +1,126 @@
+package grafana
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/hemzaz/lazydash/pkg/metrics"
+)
+
+// newSampledMetric creates a metric with one series per value
+func newSampledMetric(name, mtype string, values ...float64) *metrics.Metric {
+	metric := metrics.New(name, "", nil, mtype, "", "short")
+	for i, value := range values {
+		metric.AddSample(map[string]string{"__name__": name, "series": string(rune('a' + i))}, value, 0)
+	}
+	return metric
+}
+
+func TestThresholdSteps(t *testing.T) {
+	tests := []struct {
+		name   string
+		metric *metrics.Metric
+		want   []PanelFieldOptionsThreshold
+	}{
+		{
+			name:   "Gauge scaled to the observed peak",
+			metric: newSampledMetric("queue_length", "gauge", 12, 37),
+			want:   []PanelFieldOptionsThreshold{{0, "green"}, {50, "orange"}, {100, "red"}},
+		},
+		{
+			name:   "Small gauge values",
+			metric: newSampledMetric("load_average", "gauge", 0.3),
+			want:   []PanelFieldOptionsThreshold{{0, "green"}, {0.5, "orange"}, {1, "red"}},
+		},
+		{
+			name:   "Red a step above the warning",
+			metric: newSampledMetric("queue_length", "gauge", 2.1),
+			want:   []PanelFieldOptionsThreshold{{0, "green"}, {5, "orange"}, {10, "red"}},
+		},
+		{
+			name:   "Gauge with NaN samples",
+			metric: newSampledMetric("queue_length", "gauge", math.NaN(), 150),
+			want:   []PanelFieldOptionsThreshold{{0, "green"}, {200, "orange"}, {500, "red"}},
+		},
+		{
+			name:   "Ratio",
+			metric: newSampledMetric("cpu_utilization", "gauge", 0.4),
+			want:   []PanelFieldOptionsThreshold{{0, "green"}, {0.8, "orange"}, {0.9, "red"}},
+		},
+		{
+			name:   "Percent",
+			metric: newSampledMetric("disk_used_percent", "gauge", 42),
+			want:   []PanelFieldOptionsThreshold{{0, "green"}, {80, "orange"}, {90, "red"}},
+		},
+		{
+			name:   "Boolean",
+			metric: newSampledMetric("up", "gauge", 1, 0),
+			want:   []PanelFieldOptionsThreshold{{0, "red"}, {1, "green"}},
+		},
+		{
+			name:   "Counter",
+			metric: newSampledMetric("requests_total", "counter", 12345),
+			want:   []PanelFieldOptionsThreshold{{0, "green"}},
+		},
+		{
+			name:   "Gauge without samples",
+			metric: newSampledMetric("queue_length", "gauge"),
+			want:   []PanelFieldOptionsThreshold{{0, "green"}, {70, "orange"}, {90, "red"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := thresholdSteps(tt.metric); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("thresholdSteps() = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValueMappings(t *testing.T) {
+	up := valueMappings(newSampledMetric("up", "gauge", 1))
+	if len(up) != 2 || up[0].Text != "Down" || up[1].Text != "Up" {
+		t.Errorf("Expected Down/Up mappings, got %+v", up)
+	}
+
+	ready := valueMappings(newSampledMetric("node_ready", "gauge", 0))
+	if len(ready) != 2 || ready[0].Value != "0" || ready[0].Text != "No" || ready[1].Text != "Yes" {
+		t.Errorf("Expected No/Yes mappings, got %+v", ready)
+	}
+
+	if mappings := valueMappings(newSampledMetric("queue_length", "gauge", 3)); mappings != nil {
+		t.Errorf("Expected no mappings for a plain gauge, got %+v", mappings)
+	}
+}
+
+func TestNiceCeil(t *testing.T) {
+	tests := map[float64]float64{0: 0, 0.3: 0.5, 1: 1, 1.2: 2, 3: 5, 46.25: 50, 50.5: 100, 1234: 2000}
+	for v, expected := range tests {
+		if got := niceCeil(v); got != expected {
+			t.Errorf("niceCeil(%v) = %v; want %v", v, got, expected)
+		}
+	}
+}
+
+func TestValueKindVisualizations(t *testing.T) {
+	registry := loadPromdata(t)
+	cfg := testConfig()
+
+	tests := map[string]string{
+		"swarm_node_manager":        "stat",
+		"engine_daemon_engine_info": "table",
+	}
+	for name, expected := range tests {
+		panel := createPanelForMetric(registry.Get(name), cfg, testQueryBuilder(cfg))
+		if panel.Type != expected {
+			t.Errorf("Expected %s panel for %s, got %q", expected, name, panel.Type)
+		}
+	}
+
+	manager := createPanelForMetric(registry.Get("swarm_node_manager"), cfg, testQueryBuilder(cfg))
+	if mappings := manager.FieldConfig.Defaults.Mappings; len(mappings) != 1 || len(mappings[0].Options) != 2 {
+		t.Errorf("Expected value mappings on the boolean stat panel")
+	}
+}
@@ -0,0 +1,318 @@
+package grafana
+
+import (
+	"github.com/hemzaz/lazydash/internal/config"
+	"github.com/hemzaz/lazydash/pkg/metrics"
+)
+
+// Dashboard schema versions. Grafana migrates older dashboards on load and
+// replaces graph panels, which it no longer ships, with time series panels.
+const (
+	SchemaVersion       = 39 // Grafana 10 and later
+	LegacySchemaVersion = 22 // Grafana 6.x and 7.0, before time series panels
+)
+
+// FieldConfig holds the display settings of the fields of a panel
+type FieldConfig struct {
+	Defaults  FieldDefaults   `json:"defaults"`
+	Overrides []FieldOverride `json:"overrides"`
+}
+
+// FieldDefaults are the display settings applied to every field
+type FieldDefaults struct {
+	Unit       string           `json:"unit,omitempty"`
+	Min        *float64         `json:"min,omitempty"`
+	Max        *float64         `json:"max,omitempty"`
+	Color      *FieldColor      `json:"color,omitempty"`
+	Thresholds *FieldThresholds `json:"thresholds,omitempty"`
+	Mappings   []ValueMapping   `json:"mappings,omitempty"`
+	Custom     *TimeseriesStyle `json:"custom,omitempty"`
+}
+
+// ValueMapping maps raw values of a field to display text
+type ValueMapping struct {
+	Type    string                        `json:"type"` // value maps single values
+	Options map[string]ValueMappingResult `json:"options"`
+}
+
+// ValueMappingResult is the display text of a mapped value
+type ValueMappingResult struct {
+	Text  string `json:"text"`
+	Index int    `json:"index"`
+}
+
+// ReduceOptions set how gauge, stat and bar gauge panels reduce each series
+// to a single value
+type ReduceOptions struct {
+	Values bool     `json:"values"`
+	Calcs  []string `json:"calcs"`
+	Fields string   `json:"fields"`
+}
+
+// FieldOverride changes the display settings of matching fields
+type FieldOverride struct {
+	Matcher    FieldMatcher    `json:"matcher"`
+	Properties []FieldProperty `json:"properties"`
+}
+
+// FieldMatcher selects the fields an override applies to
+type FieldMatcher struct {
+	ID      string `json:"id"`
+	Options string `json:"options"`
+}
+
+// FieldProperty is a single overridden setting
+type FieldProperty struct {
+	ID    string      `json:"id"`
+	Value interface{} `json:"value"`
+}
+
+// FieldColor sets how series are colored
+type FieldColor struct {
+	Mode string `json:"mode"`
+}
+
+// FieldThresholds are the threshold steps of a field
+type FieldThresholds struct {
+	Mode  string          `json:"mode"`
+	Steps []ThresholdStep `json:"steps"`
+}
+
+// ThresholdStep is a color starting at a value. The first step has no
+// value and covers everything below the second one.
+type ThresholdStep struct {
+	Color string   `json:"color"`
+	Value *float64 `json:"value"`
+}
+
+// TimeseriesStyle contains the custom field settings of time series panels
+type TimeseriesStyle struct {
+	DrawStyle         string             `json:"drawStyle"`
+	LineInterpolation string             `json:"lineInterpolation"`
+	LineWidth         int                `json:"lineWidth"`
+	FillOpacity       int                `json:"fillOpacity"`
+	GradientMode      string             `json:"gradientMode"`
+	ShowPoints        string             `json:"showPoints"`
+	PointSize         int                `json:"pointSize"`
+	SpanNulls         bool               `json:"spanNulls"`
+	AxisPlacement     string             `json:"axisPlacement"`
+	Stacking          TimeseriesStacking `json:"stacking"`
+	ThresholdsStyle   ThresholdsStyle    `json:"thresholdsStyle"`
+}
+
+// TimeseriesStacking sets whether series are stacked
+type TimeseriesStacking struct {
+	Mode  string `json:"mode"`
+	Group string `json:"group"`
+}
+
+// ThresholdsStyle sets how thresholds are drawn on the graph
+type ThresholdsStyle struct {
+	Mode string `json:"mode"`
+}
+
+// VizLegendOptions is the legend of time series panels
+type VizLegendOptions struct {
+	DisplayMode string   `json:"displayMode"`
+	Placement   string   `json:"placement"`
+	ShowLegend  bool     `json:"showLegend"`
+	Calcs       []string `json:"calcs"`
+}
+
+// VizTooltipOptions is the tooltip of time series panels
+type VizTooltipOptions struct {
+	Mode string `json:"mode"`
+	Sort string `json:"sort"`
+}
+
+// convertToTimeseries turns a graph panel into a time series panel. Line,
+// fill, stacking and legend settings carry over from the graph settings;
+// the graph-only fields are removed.
+func convertToTimeseries(panel *Panel, metric *metrics.Metric, cfg *config.Config) {
+	unit := metric.Unit()
+	if len(panel.YAxes) > 0 && panel.YAxes[0].Format != "" {
+		unit = panel.YAxes[0].Format
+	}
+
+	style := &TimeseriesStyle{
+		DrawStyle:         "line",
+		LineInterpolation: "linear",
+		LineWidth:         panel.LinesWidth,
+		FillOpacity:       panel.Fill * 10,
+		GradientMode:      "none",
+		ShowPoints:        "never",
+		PointSize:         5,
+		AxisPlacement:     "auto",
+		Stacking:          TimeseriesStacking{Mode: "none", Group: "A"},
+		ThresholdsStyle:   ThresholdsStyle{Mode: "off"},
+	}
+	switch {
+	case panel.Bars:
+		style.DrawStyle = "bars"
+	case panel.Points && !panel.Lines:
+		style.DrawStyle = "points"
+		style.ShowPoints = "always"
+	case panel.Points:
+		style.ShowPoints = "always"
+	}
+	if panel.SteppedLine {
+		style.LineInterpolation = "stepAfter"
+	}
+	if panel.Stack {
+		style.Stacking.Mode = "normal"
+		if panel.Percentage {
+			style.Stacking.Mode = "percent"
+		}
+	}
+
+	defaults := FieldDefaults{
+		Unit:   unit,
+		Color:  &FieldColor{Mode: "palette-classic"},
+		Custom: style,
+	}
+	defaults.Min, defaults.Max = axisRange(metric)
+
+	// Spec and alert thresholds are drawn as lines, others only color the values
+	if steps := thresholdLines(metric, cfg); steps != nil {
+		defaults.Thresholds = fieldThresholds(steps)
+		style.ThresholdsStyle.Mode = "line"
+	} else if steps := thresholdSteps(metric); len(steps) > 0 {
+		defaults.Thresholds = fieldThresholds(steps)
+	}
+
+	panel.Type = "timeseries"
+	panel.FieldConfig = &FieldConfig{Defaults: defaults, Overrides: []FieldOverride{}}
+	panel.Options = PanelOptions{
+		Legend:  legendOptions(panel.Legend),
+		Tooltip: &VizTooltipOptions{Mode: "single", Sort: "none"},
+	}
+
+	removeGraphFields(panel)
+}
+
+// convertFieldOptions moves the field options of the other panel types to
+// the field config of the current schema and removes the graph fields they
+// were created with. Gauge, stat and bar gauge panels without thresholds
+// color their value by the thresholds of the metric.
+func convertFieldOptions(panel *Panel, metric *metrics.Metric) {
+	options := panel.Options.FieldOptions
+	defaults := FieldDefaults{
+		Unit:     options.Defaults.Unit,
+		Mappings: fieldMappings(options.Defaults.Mappings),
+	}
+
+	steps := options.Defaults.Thresholds
+	switch panel.Type {
+	case "gauge", "stat", "bargauge":
+		if len(steps) == 0 {
+			steps = thresholdSteps(metric)
+		}
+		defaults.Color = &FieldColor{Mode: "thresholds"}
+		calcs := options.Calcs
+		if len(calcs) == 0 {
+			calcs = []string{"lastNotNull"}
+		}
+		panel.Options.ReduceOptions = &ReduceOptions{Values: options.Values, Calcs: calcs}
+	}
+	if len(steps) > 0 {
+		defaults.Thresholds = fieldThresholds(steps)
+	}
+
+	panel.FieldConfig = &FieldConfig{Defaults: defaults, Overrides: []FieldOverride{}}
+	panel.Options.FieldOptions = nil
+	removeGraphFields(panel)
+}
+
+// removeGraphFields clears the settings only graph panels read
+func removeGraphFields(panel *Panel) {
+	panel.Legend, panel.XAxis, panel.YAxis, panel.ToolTip = nil, nil, nil, nil
+	panel.YAxes = nil
+	panel.Bars, panel.Lines, panel.Points, panel.Dashes = false, false, false, false
+	panel.LinesWidth, panel.Fill = 0, 0
+	panel.Stack, panel.Percentage, panel.SteppedLine = false, false, false
+}
+
+// axisRange returns the fixed y axis range of a metric, if it has one.
+// Counters are charted as rates, which are never negative.
+func axisRange(metric *metrics.Metric) (*float64, *float64) {
+	zero := 0.0
+	if metric.Type() == "counter" {
+		return &zero, nil
+	}
+	if metric.Type() != "gauge" {
+		return nil, nil
+	}
+
+	switch metric.ValueKind() {
+	case metrics.ValueRatio:
+		one := 1.0
+		return &zero, &one
+	case metrics.ValuePercent:
+		hundred := 100.0
+		return &zero, &hundred
+	}
+	return nil, nil
+}
+
+// fieldThresholds converts threshold steps into absolute field thresholds
+func fieldThresholds(steps []PanelFieldOptionsThreshold) *FieldThresholds {
+	thresholds := &FieldThresholds{Mode: "absolute", Steps: make([]ThresholdStep, 0, len(steps))}
+	for i, step := range steps {
+		threshold := ThresholdStep{Color: step.Color}
+		if i > 0 {
+			value := step.Value
+			threshold.Value = &value
+		}
+		thresholds.Steps = append(thresholds.Steps, threshold)
+	}
+	return thresholds
+}
+
+// fieldMappings converts value mappings to the value map of the field
+// config
+func fieldMappings(mappings []PanelValueMapping) []ValueMapping {
+	if len(mappings) == 0 {
+		return nil
+	}
+	mapping := ValueMapping{Type: "value", Options: make(map[string]ValueMappingResult, len(mappings))}
+	for i, m := range mappings {
+		mapping.Options[m.Value] = ValueMappingResult{Text: m.Text, Index: i}
+	}
+	return []ValueMapping{mapping}
+}
+
+// legendOptions converts a graph legend, whose value columns become calcs
+func legendOptions(legend *PanelLegend) *VizLegendOptions {
+	options := &VizLegendOptions{DisplayMode: "list", Placement: "bottom", ShowLegend: true, Calcs: []string{}}
+	if legend == nil {
+		return options
+	}
+
+	options.ShowLegend = legend.Show
+	if legend.AlignAsTable {
+		options.DisplayMode = "table"
+	}
+	if legend.RightSide {
+		options.Placement = "right"
+	}
+	if !legend.Values {
+		return options
+	}
+
+	calcs := []struct {
+		enabled bool
+		calc    string
+	}{
+		{legend.Current, "lastNotNull"},
+		{legend.Min, "min"},
+		{legend.Max, "max"},
+		{legend.Avg, "mean"},
+		{legend.Total, "sum"},
+	}
+	for _, c := range calcs {
+		if c.enabled {
+			options.Calcs = append(options.Calcs, c.calc)
+		}
+	}
+	return options
+}
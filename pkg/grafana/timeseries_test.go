@@ -0,0 +1,170 @@
+package grafana
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/hemzaz/lazydash/pkg/metrics"
+)
+
+func TestTimeseriesPanels(t *testing.T) {
+	counter := metrics.New("http_errors", "", map[string]bool{"code": true}, "counter", "_total", "short")
+
+	t.Run("Replaces graph panels", func(t *testing.T) {
+		cfg := testConfig()
+		panel := createPanelForMetric(counter, cfg, testQueryBuilder(cfg))
+
+		if panel.Type != "timeseries" || panel.FieldConfig == nil {
+			t.Fatalf("Expected timeseries panel with field config, got %q", panel.Type)
+		}
+		if panel.Legend != nil || panel.XAxis != nil || panel.YAxes != nil || panel.Options.FieldOptions != nil {
+			t.Errorf("Expected graph-only fields to be removed, got %+v", panel)
+		}
+
+		defaults := panel.FieldConfig.Defaults
+		if defaults.Unit != "short" || defaults.Min == nil || *defaults.Min != 0 || defaults.Max != nil {
+			t.Errorf("Expected short unit from 0, got %q min %v max %v", defaults.Unit, defaults.Min, defaults.Max)
+		}
+		if defaults.Custom.DrawStyle != "line" || defaults.Custom.FillOpacity != 10 || defaults.Custom.LineWidth != 1 {
+			t.Errorf("Unexpected line style %+v", defaults.Custom)
+		}
+	})
+
+	t.Run("Alert thresholds as lines", func(t *testing.T) {
+		cfg := testConfig()
+		defaults := createPanelForMetric(counter, cfg, testQueryBuilder(cfg)).FieldConfig.Defaults
+
+		steps := defaults.Thresholds.Steps
+		if len(steps) != 3 || steps[0].Value != nil || *steps[1].Value != 0.1 || *steps[2].Value != 1 {
+			t.Errorf("Expected base, warning and critical steps, got %+v", steps)
+		}
+		if defaults.Custom.ThresholdsStyle.Mode != "line" {
+			t.Errorf("Expected thresholds drawn as lines, got %q", defaults.Custom.ThresholdsStyle.Mode)
+		}
+	})
+
+	t.Run("Percent gauge range", func(t *testing.T) {
+		gauge := metrics.New("cache_usage_percent", "", nil, "gauge", "", "percent")
+		gauge.AddSample(nil, 42, 0)
+		cfg := testConfig()
+		cfg.Visualizations.GaugeType = "graph"
+		cfg.Visualizations.UseStatForGauges = false
+		defaults := createPanelForMetric(gauge, cfg, testQueryBuilder(cfg)).FieldConfig.Defaults
+
+		if defaults.Min == nil || defaults.Max == nil || *defaults.Min != 0 || *defaults.Max != 100 {
+			t.Errorf("Expected a 0-100 axis, got min %v max %v", defaults.Min, defaults.Max)
+		}
+		if defaults.Custom.ThresholdsStyle.Mode != "off" || len(defaults.Thresholds.Steps) != 3 {
+			t.Errorf("Expected value thresholds without lines, got %+v", defaults.Thresholds)
+		}
+	})
+
+	t.Run("Table legend", func(t *testing.T) {
+		cfg := testConfig()
+		cfg.Table = true
+		legend := createPanelForMetric(counter, cfg, testQueryBuilder(cfg)).Options.Legend
+
+		if legend.DisplayMode != "table" || strings.Join(legend.Calcs, ",") != "lastNotNull" {
+			t.Errorf("Expected table legend with the last value, got %+v", legend)
+		}
+	})
+
+	t.Run("Legacy graph panels", func(t *testing.T) {
+		cfg := testConfig()
+		cfg.LegacyPanels = true
+		panel := createPanelForMetric(counter, cfg, testQueryBuilder(cfg))
+
+		if panel.Type != "graph" || panel.FieldConfig != nil || len(panel.YAxes) == 0 {
+			t.Errorf("Expected graph panel with y axes, got %q", panel.Type)
+		}
+
+		dashboard := NewDashboard("legacy")
+		dashboard.Generate(metrics.NewRegistry(), cfg, testQueryBuilder(cfg))
+		if dashboard.SchemaVersion != LegacySchemaVersion {
+			t.Errorf("Expected schema version %d, got %d", LegacySchemaVersion, dashboard.SchemaVersion)
+		}
+	})
+}
+
+func TestFieldConfigPanels(t *testing.T) {
+	registry := metrics.NewRegistry()
+	temp := metrics.New("temp_celsius", "", nil, "gauge", "", "celsius")
+	temp.AddSample(nil, 21.5, 0)
+	up := metrics.New("up", "", nil, "gauge", "", "short")
+	up.AddSample(map[string]string{"instance": "a"}, 0, 0)
+	up.AddSample(map[string]string{"instance": "b"}, 1, 0)
+	registry.Set("temp_celsius", temp)
+	registry.Set("up", up)
+
+	t.Run("Current schema", func(t *testing.T) {
+		cfg := testConfig()
+		dashboard := NewDashboard("fields")
+		dashboard.Generate(registry, cfg, testQueryBuilder(cfg))
+
+		var buf bytes.Buffer
+		if err := dashboard.WriteJSON(&buf, false); err != nil {
+			t.Fatalf("WriteJSON() error = %v", err)
+		}
+		for _, field := range []string{`"fieldOptions"`, `"yaxes"`, `"xaxis"`, `"lines"`, `"fill"`} {
+			if strings.Contains(buf.String(), field) {
+				t.Errorf("Expected no %s in schema version %d panels:\n%s", field, SchemaVersion, buf.String())
+			}
+		}
+
+		gauge := dashboard.PanelForMetric("temp_celsius")
+		if gauge.Type != "gauge" || gauge.FieldConfig.Defaults.Unit != "celsius" || gauge.Options.ReduceOptions == nil {
+			t.Errorf("Expected a celsius gauge reducing series, got %q %+v %+v", gauge.Type, gauge.FieldConfig, gauge.Options)
+		}
+		if thresholds := gauge.FieldConfig.Defaults.Thresholds; thresholds == nil || thresholds.Mode != "absolute" || thresholds.Steps[0].Value != nil {
+			t.Errorf("Expected absolute threshold steps, got %+v", thresholds)
+		}
+
+		stat := dashboard.PanelForMetric("up")
+		mappings := stat.FieldConfig.Defaults.Mappings
+		if stat.Type != "stat" || len(mappings) != 1 || mappings[0].Type != "value" || mappings[0].Options["1"].Text != "Up" {
+			t.Errorf("Expected an Up/Down value map on the stat panel, got %q %+v", stat.Type, mappings)
+		}
+	})
+
+	t.Run("Legacy field options", func(t *testing.T) {
+		cfg := testConfig()
+		cfg.LegacyPanels = true
+		panel := createPanelForMetric(up, cfg, testQueryBuilder(cfg))
+
+		if panel.FieldConfig != nil || panel.Options.FieldOptions == nil || len(panel.Options.FieldOptions.Defaults.Mappings) != 2 {
+			t.Errorf("Expected field options with legacy panels, got %+v", panel.Options)
+		}
+	})
+}
+
+func TestConvertToTimeseriesStyle(t *testing.T) {
+	metric := metrics.New("queue_length", "", nil, "gauge", "", "short")
+
+	tests := []struct {
+		name          string
+		configure     func(*Panel)
+		drawStyle     string
+		interpolation string
+		stacking      string
+	}{
+		{"Lines", func(p *Panel) {}, "line", "linear", "none"},
+		{"Bars", func(p *Panel) { p.Bars = true }, "bars", "linear", "none"},
+		{"Points", func(p *Panel) { p.Points, p.Lines = true, false }, "points", "linear", "none"},
+		{"Stepped", func(p *Panel) { p.SteppedLine = true }, "line", "stepAfter", "none"},
+		{"Stacked percent", func(p *Panel) { p.Stack, p.Percentage = true, true }, "line", "linear", "percent"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			panel := NewPanel("queue")
+			tt.configure(panel)
+			convertToTimeseries(panel, metric, testConfig())
+
+			style := panel.FieldConfig.Defaults.Custom
+			if style.DrawStyle != tt.drawStyle || style.LineInterpolation != tt.interpolation || style.Stacking.Mode != tt.stacking {
+				t.Errorf("Got draw style %q, interpolation %q, stacking %q", style.DrawStyle, style.LineInterpolation, style.Stacking.Mode)
+			}
+		})
+	}
+}
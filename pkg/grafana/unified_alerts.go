@@ -0,0 +1,243 @@
+package grafana
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/hemzaz/lazydash/internal/config"
+	"github.com/hemzaz/lazydash/pkg/alerting"
+	"github.com/hemzaz/lazydash/pkg/metrics"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultAlertFolder is the folder of alert rules when no --folder is given,
+// unified alert rules must always belong to a folder
+const DefaultAlertFolder = "Generated alerts"
+
+// expressionDatasource is the UID of Grafana's server side expressions
+const expressionDatasource = "__expr__"
+
+// Annotations linking an alert rule to its dashboard panel
+const (
+	dashboardUIDAnnotation = "__dashboardUid__"
+	panelIDAnnotation      = "__panelId__"
+)
+
+// AlertRule is a Grafana unified alert rule as used by the provisioning API
+// and provisioning files. Folder and group are set on the group in files.
+type AlertRule struct {
+	UID          string            `json:"uid" yaml:"uid"`
+	OrgID        int               `json:"orgID" yaml:"-"`
+	FolderUID    string            `json:"folderUID" yaml:"-"`
+	RuleGroup    string            `json:"ruleGroup" yaml:"-"`
+	Title        string            `json:"title" yaml:"title"`
+	Condition    string            `json:"condition" yaml:"condition"`
+	Data         []AlertQuery      `json:"data" yaml:"data"`
+	NoDataState  string            `json:"noDataState" yaml:"noDataState"`
+	ExecErrState string            `json:"execErrState" yaml:"execErrState"`
+	For          string            `json:"for" yaml:"for"`
+	Annotations  map[string]string `json:"annotations,omitempty" yaml:"annotations,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+}
+
+// AlertQuery is a data query or expression of an alert rule
+type AlertQuery struct {
+	RefID             string            `json:"refId" yaml:"refId"`
+	RelativeTimeRange RelativeTimeRange `json:"relativeTimeRange" yaml:"relativeTimeRange"`
+	DatasourceUID     string            `json:"datasourceUid" yaml:"datasourceUid"`
+	Model             AlertQueryModel   `json:"model" yaml:"model"`
+}
+
+// RelativeTimeRange is the queried range in seconds before evaluation
+type RelativeTimeRange struct {
+	From int `json:"from" yaml:"from"`
+	To   int `json:"to" yaml:"to"`
+}
+
+// AlertQueryModel is the datasource specific part of an alert query.
+// Prometheus queries set Expr, expressions set Type and Expression.
+type AlertQueryModel struct {
+	RefID         string               `json:"refId" yaml:"refId"`
+	Datasource    AlertDatasource      `json:"datasource" yaml:"datasource"`
+	Expr          string               `json:"expr,omitempty" yaml:"expr,omitempty"`
+	Instant       bool                 `json:"instant,omitempty" yaml:"instant,omitempty"`
+	IntervalMs    int                  `json:"intervalMs,omitempty" yaml:"intervalMs,omitempty"`
+	MaxDataPoints int                  `json:"maxDataPoints,omitempty" yaml:"maxDataPoints,omitempty"`
+	Type          string               `json:"type,omitempty" yaml:"type,omitempty"`
+	Expression    string               `json:"expression,omitempty" yaml:"expression,omitempty"`
+	Reducer       string               `json:"reducer,omitempty" yaml:"reducer,omitempty"`
+	Conditions    []AlertExprCondition `json:"conditions,omitempty" yaml:"conditions,omitempty"`
+}
+
+// AlertDatasource references the datasource of an alert query
+type AlertDatasource struct {
+	Type string `json:"type" yaml:"type"`
+	UID  string `json:"uid" yaml:"uid"`
+}
+
+// AlertExprCondition is the condition of a threshold expression
+type AlertExprCondition struct {
+	Evaluator AlertEvaluator `json:"evaluator" yaml:"evaluator"`
+}
+
+// AlertProvisioning is a Grafana alerting provisioning file
+type AlertProvisioning struct {
+	APIVersion int              `yaml:"apiVersion"`
+	Groups     []AlertRuleGroup `yaml:"groups"`
+}
+
+// AlertRuleGroup is a group of alert rules evaluated together
+type AlertRuleGroup struct {
+	OrgID    int         `yaml:"orgId"`
+	Name     string      `yaml:"name"`
+	Folder   string      `yaml:"folder"`
+	Interval string      `yaml:"interval"`
+	Rules    []AlertRule `yaml:"rules"`
+}
+
+// GenerateAlertRules creates unified alert rules for the metrics with alert
+// thresholds. Each rule queries Prometheus (A), reduces the series to their
+// last value (B) and compares it to the threshold (C). Rules link to the
+// panel of their metric once the dashboard has a UID.
+func GenerateAlertRules(registry *metrics.Registry, cfg *config.Config, dashboard *Dashboard) []AlertRule {
+	rules := []AlertRule{}
+	for _, alert := range alerting.Alerts(registry, cfg) {
+		rule := AlertRule{
+			UID:       alertRuleUID(cfg.Title, alert),
+			OrgID:     1,
+			RuleGroup: cfg.Title,
+			Title:     fmt.Sprintf("%s (%s)", alert.Name, alert.Severity),
+			Condition: "C",
+			Data: []AlertQuery{
+				{
+					RefID:             "A",
+					RelativeTimeRange: RelativeTimeRange{From: 600, To: 0},
+					DatasourceUID:     cfg.DatasourceUID,
+					Model: AlertQueryModel{
+						RefID:         "A",
+						Datasource:    AlertDatasource{Type: "prometheus", UID: cfg.DatasourceUID},
+						Expr:          alert.Query,
+						Instant:       true,
+						IntervalMs:    1000,
+						MaxDataPoints: 43200,
+					},
+				},
+				expressionQuery(AlertQueryModel{RefID: "B", Type: "reduce", Expression: "A", Reducer: "last"}),
+				expressionQuery(AlertQueryModel{
+					RefID:      "C",
+					Type:       "threshold",
+					Expression: "B",
+					Conditions: []AlertExprCondition{
+						{Evaluator: AlertEvaluator{Type: "gt", Params: []float64{alert.Threshold}}},
+					},
+				}),
+			},
+			NoDataState:  "NoData",
+			ExecErrState: "Error",
+			For:          alerting.PendingFor,
+			Labels: map[string]string{
+				"severity": alert.Severity,
+			},
+			Annotations: map[string]string{
+				"summary":     alert.Summary(),
+				"description": alert.Description(),
+			},
+		}
+
+		if panel := dashboard.PanelForMetric(alert.Metric.Name()); panel != nil && dashboard.UID != "" {
+			rule.Annotations[dashboardUIDAnnotation] = dashboard.UID
+			rule.Annotations[panelIDAnnotation] = strconv.Itoa(panel.ID)
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// expressionQuery wraps a server side expression as an alert query
+func expressionQuery(model AlertQueryModel) AlertQuery {
+	model.Datasource = AlertDatasource{Type: expressionDatasource, UID: expressionDatasource}
+	return AlertQuery{
+		RefID:         model.RefID,
+		DatasourceUID: expressionDatasource,
+		Model:         model,
+	}
+}
+
+// alertRuleUID returns a stable UID so regenerated rules replace earlier ones
+func alertRuleUID(group string, alert alerting.Alert) string {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s\x00%s\x00%s", group, alert.Name, alert.Severity)
+	return fmt.Sprintf("lazydash-%x", h.Sum64())
+}
+
+// alertFolder returns the folder alert rules are created in
+func alertFolder(cfg *config.Config) *config.FolderConfig {
+	if cfg.FolderConfig != nil && cfg.FolderConfig.Name != "" {
+		return cfg.FolderConfig
+	}
+	return &config.FolderConfig{Name: DefaultAlertFolder, Create: true}
+}
+
+// NewAlertProvisioning creates a provisioning file with the rules in one group
+func NewAlertProvisioning(rules []AlertRule, cfg *config.Config) *AlertProvisioning {
+	return &AlertProvisioning{
+		APIVersion: 1,
+		Groups: []AlertRuleGroup{
+			{
+				OrgID:    1,
+				Name:     cfg.Title,
+				Folder:   alertFolder(cfg).Name,
+				Interval: "1m",
+				Rules:    rules,
+			},
+		},
+	}
+}
+
+// WriteYAML writes the provisioning file read from Grafana's provisioning/alerting directory
+func (p *AlertProvisioning) WriteYAML(w io.Writer) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(p); err != nil {
+		return fmt.Errorf("failed to encode alert provisioning: %w", err)
+	}
+	return encoder.Close()
+}
+
+// PostAlertRules creates or updates alert rules via the provisioning API in
+// the configured folder. Rules stay editable in the Grafana UI.
+func (c *Client) PostAlertRules(ctx context.Context, rules []AlertRule, cfg *config.Config) error {
+	folder, err := c.GetOrCreateFolder(ctx, alertFolder(cfg))
+	if err != nil {
+		return fmt.Errorf("error getting alert folder: %w", err)
+	}
+
+	// Without it provisioned rules can only be changed through the API
+	headers := map[string]string{"X-Disable-Provenance": "true"}
+	const path = "/api/v1/provisioning/alert-rules"
+	for _, rule := range rules {
+		rule.FolderUID = folder.UID
+
+		// Rules that were posted before are updated in place
+		method, url := http.MethodPut, path+"/"+rule.UID
+		err := c.do(ctx, http.MethodGet, url, nil, nil, headers)
+		if errors.Is(err, ErrNotFound) {
+			method, url = http.MethodPost, path
+		} else if err != nil {
+			return err
+		}
+
+		if err := c.do(ctx, method, url, rule, nil, headers); err != nil {
+			return fmt.Errorf("saving alert rule %s failed: %w", rule.Title, err)
+		}
+	}
+
+	log.Info().Int("rules", len(rules)).Str("folder", folder.Title).Msg("Alert rules saved")
+	return nil
+}
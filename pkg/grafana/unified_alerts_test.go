@@ -0,0 +1,315 @@
+package grafana
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hemzaz/lazydash/internal/config"
+	"github.com/hemzaz/lazydash/pkg/metrics"
+	"gopkg.in/yaml.v3"
+)
+
+// fakeGrafana stands in for the folder, dashboard and alert rule
+// provisioning APIs of a Grafana server
+type fakeGrafana struct {
+	t       *testing.T
+	mu      sync.Mutex
+	folders []GrafanaFolder
+	rules   map[string]AlertRule
+	methods []string // Methods of the alert rule requests that wrote rules
+}
+
+func newFakeGrafana(t *testing.T, folders ...GrafanaFolder) (*fakeGrafana, *httptest.Server) {
+	t.Helper()
+	g := &fakeGrafana{t: t, folders: folders, rules: map[string]AlertRule{}}
+	server := httptest.NewServer(g)
+	t.Cleanup(server.Close)
+	return g, server
+}
+
+func (g *fakeGrafana) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		g.t.Errorf("%s %s called with Authorization %q", r.Method, r.URL.Path, r.Header.Get("Authorization"))
+	}
+	body, _ := io.ReadAll(r.Body)
+	const rules = "/api/v1/provisioning/alert-rules"
+
+	switch {
+	case r.URL.Path == "/api/folders" && r.Method == http.MethodGet:
+		json.NewEncoder(w).Encode(g.folders)
+
+	case r.URL.Path == "/api/folders" && r.Method == http.MethodPost:
+		var folder GrafanaFolder
+		json.Unmarshal(body, &folder)
+		folder.ID, folder.UID = len(g.folders)+1, "created-folder"
+		g.folders = append(g.folders, folder)
+		json.NewEncoder(w).Encode(folder)
+
+	case r.URL.Path == "/api/dashboards/db":
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": 1, "uid": "dash-uid", "url": "/d/dash-uid", "status": "success"})
+
+	case strings.HasPrefix(r.URL.Path, rules):
+		if r.Header.Get("X-Disable-Provenance") != "true" {
+			g.t.Errorf("%s %s called without X-Disable-Provenance", r.Method, r.URL.Path)
+		}
+		uid := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, rules), "/")
+		if r.Method == http.MethodGet {
+			if _, ok := g.rules[uid]; !ok {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode(g.rules[uid])
+			return
+		}
+
+		var rule AlertRule
+		if err := json.Unmarshal(body, &rule); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if r.Method == http.MethodPut && rule.UID != uid {
+			g.t.Errorf("PUT %s with rule UID %s", r.URL.Path, rule.UID)
+		}
+		g.rules[rule.UID] = rule
+		g.methods = append(g.methods, r.Method)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(rule)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// alertRegistry returns a gauge with default warning and critical thresholds
+func alertRegistry() *metrics.Registry {
+	registry := metrics.NewRegistry()
+	registry.Set("node_cpu_usage", metrics.New("node_cpu_usage", "CPU in use.", nil, "gauge", "", "percent"))
+	registry.Set("http_requests", metrics.New("http_requests", "", nil, "counter", "_total", "short"))
+	return registry
+}
+
+func TestGenerateAlertRules(t *testing.T) {
+	registry := alertRegistry()
+	cfg := testConfig()
+	cfg.DatasourceUID = "prom-uid"
+	dashboard := NewDashboard(cfg.Title)
+	dashboard.Generate(registry, cfg, testQueryBuilder(cfg))
+
+	rules := GenerateAlertRules(registry, cfg, dashboard)
+	if len(rules) != 2 {
+		t.Fatalf("Expected warning and critical rule, got %d rules", len(rules))
+	}
+
+	t.Run("Queries and expressions", func(t *testing.T) {
+		rule := rules[1]
+		if rule.Title != "NodeCpuUsageHigh (critical)" || rule.Condition != "C" {
+			t.Errorf("Unexpected title %q or condition %q", rule.Title, rule.Condition)
+		}
+		if len(rule.Data) != 3 {
+			t.Fatalf("Expected query, reduce and threshold, got %d queries", len(rule.Data))
+		}
+
+		query, reduce, threshold := rule.Data[0], rule.Data[1], rule.Data[2]
+		if query.DatasourceUID != "prom-uid" || query.Model.Expr != "node_cpu_usage" || !query.Model.Instant {
+			t.Errorf("Unexpected query %+v", query)
+		}
+		if reduce.DatasourceUID != expressionDatasource || reduce.Model.Type != "reduce" || reduce.Model.Expression != "A" {
+			t.Errorf("Unexpected reduce expression %+v", reduce)
+		}
+		if threshold.Model.Type != "threshold" || threshold.Model.Expression != "B" {
+			t.Errorf("Unexpected threshold expression %+v", threshold)
+		}
+		if evaluator := threshold.Model.Conditions[0].Evaluator; evaluator.Type != "gt" || evaluator.Params[0] != 95 {
+			t.Errorf("Expected gt 95, got %+v", evaluator)
+		}
+	})
+
+	t.Run("Labels and annotations", func(t *testing.T) {
+		rule := rules[0]
+		if rule.Labels["severity"] != "warning" || rule.For != "5m" {
+			t.Errorf("Expected pending warning, got %v for %s", rule.Labels, rule.For)
+		}
+		if !strings.HasPrefix(rule.Annotations["description"], "CPU in use.") {
+			t.Errorf("Expected description from HELP, got %q", rule.Annotations["description"])
+		}
+		if _, ok := rule.Annotations[dashboardUIDAnnotation]; ok {
+			t.Errorf("Expected no panel link before the dashboard has a UID")
+		}
+	})
+
+	t.Run("Stable UIDs", func(t *testing.T) {
+		again := GenerateAlertRules(registry, cfg, dashboard)
+		if rules[0].UID != again[0].UID || rules[0].UID == rules[1].UID {
+			t.Errorf("Expected stable distinct UIDs, got %s %s and %s", rules[0].UID, rules[1].UID, again[0].UID)
+		}
+	})
+
+	t.Run("Panel link", func(t *testing.T) {
+		dashboard.UID = "dash-uid"
+		panel := dashboard.PanelForMetric("node_cpu_usage")
+		if panel == nil {
+			t.Fatalf("Expected a panel for node_cpu_usage")
+		}
+
+		rule := GenerateAlertRules(registry, cfg, dashboard)[0]
+		if rule.Annotations[dashboardUIDAnnotation] != "dash-uid" {
+			t.Errorf("Expected dashboard UID annotation, got %v", rule.Annotations)
+		}
+		if rule.Annotations[panelIDAnnotation] != strconv.Itoa(panel.ID) {
+			t.Errorf("Expected panel ID %d, got %q", panel.ID, rule.Annotations[panelIDAnnotation])
+		}
+	})
+}
+
+func TestPostAlertRules(t *testing.T) {
+	registry := alertRegistry()
+	cfg := testConfig()
+
+	t.Run("Creates then updates", func(t *testing.T) {
+		g, server := newFakeGrafana(t)
+		dashboard := NewDashboard(cfg.Title)
+		dashboard.Generate(registry, cfg, testQueryBuilder(cfg))
+		client := testClient(t, server.URL)
+		if _, err := client.PostDashboard(context.Background(), dashboard, SaveOptions{Folder: cfg.FolderConfig}); err != nil {
+			t.Fatalf("PostDashboard() error = %v", err)
+		}
+		rules := GenerateAlertRules(registry, cfg, dashboard)
+
+		if err := client.PostAlertRules(context.Background(), rules, cfg); err != nil {
+			t.Fatalf("PostAlertRules() error = %v", err)
+		}
+		if err := client.PostAlertRules(context.Background(), rules, cfg); err != nil {
+			t.Fatalf("PostAlertRules() error = %v", err)
+		}
+
+		if strings.Join(g.methods, ",") != "POST,POST,PUT,PUT" {
+			t.Errorf("Expected rules to be created then updated, got %v", g.methods)
+		}
+		if len(g.folders) != 1 || g.folders[0].Title != DefaultAlertFolder {
+			t.Fatalf("Expected the default alert folder to be created once, got %+v", g.folders)
+		}
+		for _, rule := range g.rules {
+			if rule.FolderUID != "created-folder" || rule.RuleGroup != cfg.Title || rule.OrgID != 1 {
+				t.Errorf("Unexpected folder, group or org of %s: %s %s %d", rule.Title, rule.FolderUID, rule.RuleGroup, rule.OrgID)
+			}
+			if rule.Annotations[dashboardUIDAnnotation] != "dash-uid" {
+				t.Errorf("Expected %s to link the posted dashboard, got %v", rule.Title, rule.Annotations)
+			}
+		}
+	})
+
+	t.Run("Existing folder", func(t *testing.T) {
+		g, server := newFakeGrafana(t, GrafanaFolder{ID: 7, UID: "ops", Title: "Ops"})
+		folderCfg := *cfg
+		folderCfg.FolderConfig = &config.FolderConfig{Name: "Ops"}
+
+		if err := testClient(t, server.URL).PostAlertRules(context.Background(), GenerateAlertRules(registry, &folderCfg, NewDashboard("")), &folderCfg); err != nil {
+			t.Fatalf("PostAlertRules() error = %v", err)
+		}
+		for _, rule := range g.rules {
+			if rule.FolderUID != "ops" {
+				t.Errorf("Expected folder ops, got %s", rule.FolderUID)
+			}
+		}
+	})
+
+	t.Run("Latency in seconds", func(t *testing.T) {
+		g, server := newFakeGrafana(t)
+		latency := metrics.NewRegistry()
+		latency.Set("rpc_duration_seconds", metrics.New("rpc_duration_seconds", "", map[string]bool{"le": true}, "histogram", "_count", "s"))
+
+		if err := testClient(t, server.URL).PostAlertRules(context.Background(), GenerateAlertRules(latency, cfg, NewDashboard("")), cfg); err != nil {
+			t.Fatalf("PostAlertRules() error = %v", err)
+		}
+		if len(g.rules) != 2 {
+			t.Fatalf("Expected warning and critical rule, got %d rules", len(g.rules))
+		}
+		thresholds := map[string]float64{"warning": 1, "critical": 2}
+		for _, rule := range g.rules {
+			want := thresholds[rule.Labels["severity"]]
+			if params := rule.Data[2].Model.Conditions[0].Evaluator.Params; len(params) != 1 || params[0] != want {
+				t.Errorf("Expected %s to fire above %v seconds, got %v", rule.Title, want, params)
+			}
+			if expr := rule.Data[0].Model.Expr; expr != "histogram_quantile(0.99, sum by (le) (rate(rpc_duration_seconds_bucket[5m])))" {
+				t.Errorf("Expected %s to query the 99th percentile, got %q", rule.Title, expr)
+			}
+			if summary := rule.Annotations["summary"]; summary != fmt.Sprintf("rpc_duration_seconds is above %vs", want) {
+				t.Errorf("Expected the summary of %s in seconds, got %q", rule.Title, summary)
+			}
+		}
+	})
+
+	t.Run("Rejected rule", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.URL.Path == "/api/folders":
+				json.NewEncoder(w).Encode([]GrafanaFolder{{UID: "f", Title: DefaultAlertFolder}})
+			case r.Method == http.MethodGet:
+				http.NotFound(w, r)
+			default:
+				http.Error(w, `{"message":"invalid rule"}`, http.StatusBadRequest)
+			}
+		}))
+		defer server.Close()
+
+		err := testClient(t, server.URL).PostAlertRules(context.Background(), GenerateAlertRules(registry, cfg, NewDashboard("")), cfg)
+		if err == nil || !strings.Contains(err.Error(), "invalid rule") {
+			t.Errorf("Expected the rejection, got %v", err)
+		}
+	})
+}
+
+func TestAlertProvisioningYAML(t *testing.T) {
+	registry := alertRegistry()
+	cfg := testConfig()
+	rules := GenerateAlertRules(registry, cfg, NewDashboard(cfg.Title))
+
+	var buf bytes.Buffer
+	if err := NewAlertProvisioning(rules, cfg).WriteYAML(&buf); err != nil {
+		t.Fatalf("WriteYAML() error = %v", err)
+	}
+
+	var file struct {
+		APIVersion int `yaml:"apiVersion"`
+		Groups     []struct {
+			OrgID  int    `yaml:"orgId"`
+			Name   string `yaml:"name"`
+			Folder string `yaml:"folder"`
+			Rules  []map[string]interface{}
+		}
+	}
+	if err := yaml.Unmarshal(buf.Bytes(), &file); err != nil {
+		t.Fatalf("Provisioning file is not valid YAML: %v\n%s", err, buf.String())
+	}
+
+	if file.APIVersion != 1 || len(file.Groups) != 1 {
+		t.Fatalf("Expected apiVersion 1 with one group, got %+v", file)
+	}
+	group := file.Groups[0]
+	if group.OrgID != 1 || group.Name != cfg.Title || group.Folder != DefaultAlertFolder {
+		t.Errorf("Unexpected group %+v", group)
+	}
+	if len(group.Rules) != 2 {
+		t.Fatalf("Expected 2 rules, got %d", len(group.Rules))
+	}
+	for _, key := range []string{"folderUID", "ruleGroup", "orgID"} {
+		if _, ok := group.Rules[0][key]; ok {
+			t.Errorf("Rule sets %s, which provisioning files set on the group", key)
+		}
+	}
+	if !strings.Contains(buf.String(), "datasourceUid: __expr__") {
+		t.Errorf("Expected server side expressions in\n%s", buf.String())
+	}
+}
@@ -0,0 +1,163 @@
+package metrics
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Filter fields. A filter without a field matches the metric name.
+const (
+	FilterName   = "name"
+	FilterType   = "type"
+	FilterVendor = "vendor"
+	FilterLabel  = "label"
+	FilterHelp   = "help"
+)
+
+// Filter matches a metric field against a glob, or a regex written as /re/
+type Filter struct {
+	Field   string
+	Pattern string
+	re      *regexp.Regexp
+}
+
+// metricNamePattern matches the characters a metric name may hold
+var metricNamePattern = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// ParseFilter parses a filter written as [field:]pattern, e.g. node_*,
+// type:untyped, label:cpu or help:/(?i)deprecated/. Metric names hold
+// colons too, so an unknown field is read as part of a name pattern when it
+// could start a metric name and the rest is a glob, a regex or a recording
+// rule name such as namespace:job:rate5m. Otherwise it is a misspelt field.
+func ParseFilter(s string) (*Filter, error) {
+	f := &Filter{Field: FilterName, Pattern: s}
+	if field, pattern, ok := strings.Cut(s, ":"); ok {
+		switch {
+		case field == FilterName, field == FilterType, field == FilterVendor, field == FilterLabel, field == FilterHelp:
+			f.Field, f.Pattern = field, pattern
+		case !metricNamePattern.MatchString(field) || !strings.ContainsAny(pattern, "*?[/:"):
+			return nil, fmt.Errorf("filter %q: unknown filter field %q, use name, type, vendor, label or help", s, field)
+		}
+	}
+	if f.Pattern == "" {
+		return nil, fmt.Errorf("filter %q has no pattern", s)
+	}
+
+	if len(f.Pattern) > 1 && strings.HasPrefix(f.Pattern, "/") && strings.HasSuffix(f.Pattern, "/") {
+		re, err := regexp.Compile(f.Pattern[1 : len(f.Pattern)-1])
+		if err != nil {
+			return nil, fmt.Errorf("filter %q: %w", s, err)
+		}
+		f.re = re
+	} else if _, err := path.Match(f.Pattern, ""); err != nil {
+		return nil, fmt.Errorf("filter %q: invalid glob: %w", s, err)
+	}
+	return f, nil
+}
+
+// String returns the filter as written
+func (f *Filter) String() string {
+	return f.Field + ":" + f.Pattern
+}
+
+// Match reports whether the metric's field matches. Label filters match
+// if any label name matches; regexes match anywhere unless anchored.
+func (f *Filter) Match(m *Metric) bool {
+	switch f.Field {
+	case FilterType:
+		return f.matchString(m.Type())
+	case FilterVendor:
+		return f.matchString(m.Vendor())
+	case FilterHelp:
+		return f.matchString(m.Help())
+	case FilterLabel:
+		for _, label := range m.Labels() {
+			if f.matchString(label) {
+				return true
+			}
+		}
+		return false
+	default:
+		return f.matchString(m.Name())
+	}
+}
+
+// matchString matches a single value against the pattern
+func (f *Filter) matchString(s string) bool {
+	if f.re != nil {
+		return f.re.MatchString(s)
+	}
+	matched, _ := path.Match(f.Pattern, s)
+	return matched
+}
+
+// FilterSet keeps the metrics matching any include filter, or all metrics
+// without include filters, unless an exclude filter matches them
+type FilterSet struct {
+	Include []*Filter
+	Exclude []*Filter
+}
+
+// FilterDecision records whether a metric was kept and why
+type FilterDecision struct {
+	Metric *Metric
+	Kept   bool
+	Reason string
+}
+
+// NewFilterSet parses include and exclude filters
+func NewFilterSet(include, exclude []string) (*FilterSet, error) {
+	set := &FilterSet{}
+	for _, s := range include {
+		f, err := ParseFilter(s)
+		if err != nil {
+			return nil, err
+		}
+		set.Include = append(set.Include, f)
+	}
+	for _, s := range exclude {
+		f, err := ParseFilter(s)
+		if err != nil {
+			return nil, err
+		}
+		set.Exclude = append(set.Exclude, f)
+	}
+	return set, nil
+}
+
+// Empty reports whether the set keeps every metric
+func (s *FilterSet) Empty() bool {
+	return s == nil || len(s.Include)+len(s.Exclude) == 0
+}
+
+// Decide returns whether a metric is kept and the filter deciding it
+func (s *FilterSet) Decide(m *Metric) FilterDecision {
+	for _, f := range s.Exclude {
+		if f.Match(m) {
+			return FilterDecision{Metric: m, Reason: "excluded by " + f.String()}
+		}
+	}
+	if len(s.Include) == 0 {
+		return FilterDecision{Metric: m, Kept: true}
+	}
+	for _, f := range s.Include {
+		if f.Match(m) {
+			return FilterDecision{Metric: m, Kept: true, Reason: "included by " + f.String()}
+		}
+	}
+	return FilterDecision{Metric: m, Reason: "matches no include filter"}
+}
+
+// Apply returns the registry of kept metrics and the decision for every
+// metric in name order
+func (s *FilterSet) Apply(registry *Registry) (*Registry, []FilterDecision) {
+	decisions := make([]FilterDecision, 0, registry.Count())
+	kept := registry.Filter(func(name string, metric *Metric) bool {
+		decision := s.Decide(metric)
+		decisions = append(decisions, decision)
+		return decision.Kept
+	})
+	return kept, decisions
+}
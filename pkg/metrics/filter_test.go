@@ -0,0 +1,142 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		input   string
+		field   string
+		pattern string
+		wantErr bool
+	}{
+		{"node_*", FilterName, "node_*", false},
+		{"type:gauge", FilterType, "gauge", false},
+		{"label:/^cpu$/", FilterLabel, "/^cpu$/", false},
+		{"help:*deprecated*", FilterHelp, "*deprecated*", false},
+		{"namespace:job:rate5m", FilterName, "namespace:job:rate5m", false},
+		{"namespace:job_*", FilterName, "namespace:job_*", false},
+		{"tipe:counter", "", "", true},
+		{"my field:node_*", "", "", true},
+		{"", "", "", true},
+		{"type:", "", "", true},
+		{"/(unclosed/", "", "", true},
+		{"node_[", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			f, err := ParseFilter(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseFilter(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if f.Field != tt.field || f.Pattern != tt.pattern {
+				t.Errorf("ParseFilter(%q) = %s; want %s:%s", tt.input, f, tt.field, tt.pattern)
+			}
+		})
+	}
+}
+
+func TestParseFilterUnknownField(t *testing.T) {
+	_, err := ParseFilter("tipe:counter")
+	if err == nil || !strings.Contains(err.Error(), `unknown filter field "tipe"`) {
+		t.Errorf("Expected an unknown filter field error, got %v", err)
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	metric := New("node_cpu_seconds", "Seconds the CPUs spent in each mode (deprecated)", map[string]bool{"cpu": true, "mode": true}, "counter", "_total", "s")
+	metric.SetVendor("node")
+
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{"node_*", true},
+		{"node_cpu", false},
+		{"/cpu/", true},
+		{"/^cpu/", false},
+		{"type:counter", true},
+		{"type:gauge", false},
+		{"vendor:node", true},
+		{"vendor:go", false},
+		{"label:mode", true},
+		{"label:/^dev/", false},
+		{"help:/(?i)DEPRECATED/", true},
+		{"help:Seconds", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			f, err := ParseFilter(tt.filter)
+			if err != nil {
+				t.Fatalf("ParseFilter(%q) error = %v", tt.filter, err)
+			}
+			if got := f.Match(metric); got != tt.want {
+				t.Errorf("Match() = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterSetApply(t *testing.T) {
+	registry := NewRegistry()
+	for _, m := range []*Metric{
+		New("go_goroutines", "", nil, "gauge", "", ""),
+		New("go_memstats_frees", "", nil, "counter", "_total", ""),
+		New("node_load1", "", nil, "gauge", "", ""),
+		New("process_open_fds", "", nil, "gauge", "", ""),
+	} {
+		registry.Set(m.Name(), m)
+	}
+
+	t.Run("No filters", func(t *testing.T) {
+		set, _ := NewFilterSet(nil, nil)
+		kept, decisions := set.Apply(registry)
+		if !set.Empty() || kept.Count() != 4 || len(decisions) != 4 {
+			t.Errorf("Expected every metric kept, got %d of %d", kept.Count(), len(decisions))
+		}
+	})
+
+	t.Run("Exclude wins over include", func(t *testing.T) {
+		set, err := NewFilterSet([]string{"go_*", "type:gauge"}, []string{"process_*"})
+		if err != nil {
+			t.Fatalf("NewFilterSet() error = %v", err)
+		}
+		kept, decisions := set.Apply(registry)
+
+		want := map[string]string{
+			"go_goroutines":     "included by name:go_*",
+			"go_memstats_frees": "included by name:go_*",
+			"node_load1":        "included by type:gauge",
+			"process_open_fds":  "excluded by name:process_*",
+		}
+		if kept.Count() != 3 || kept.Has("process_open_fds") {
+			t.Errorf("Expected process_open_fds dropped, kept %v", kept.List())
+		}
+		for _, decision := range decisions {
+			if reason := want[decision.Metric.Name()]; decision.Reason != reason {
+				t.Errorf("%s: reason %q; want %q", decision.Metric.Name(), decision.Reason, reason)
+			}
+		}
+	})
+
+	t.Run("Unmatched include", func(t *testing.T) {
+		set, _ := NewFilterSet([]string{"node_*"}, nil)
+		kept, decisions := set.Apply(registry)
+		if kept.Count() != 1 || decisions[0].Kept || decisions[0].Reason != "matches no include filter" {
+			t.Errorf("Expected only node_load1 kept, got %v and %+v", kept.List(), decisions[0])
+		}
+	})
+
+	t.Run("Invalid filter", func(t *testing.T) {
+		if _, err := NewFilterSet(nil, []string{"/[/"}); err == nil {
+			t.Errorf("Expected an error for an invalid regex")
+		}
+	})
+}
@@ -0,0 +1,154 @@
+package metrics
+
+import (
+	"sort"
+)
+
+// changed records a change to a field registries index the metric by.
+// Filtered registries share their metrics, so rather than tracking the
+// registries of a metric a change bumps its version, and each registry
+// holding it rebuilds its indexes the next time they are used after it.
+func (m *Metric) changed() {
+	m.version.Add(1)
+}
+
+// versionSum adds up the versions of metrics. Versions only grow, so the
+// sum changes whenever one of the metrics does.
+func versionSum(metrics []*Metric) uint64 {
+	var sum uint64
+	for _, metric := range metrics {
+		sum += metric.version.Load()
+	}
+	return sum
+}
+
+// registryIndex groups the metrics of a registry by the fields they are
+// listed by. Groups hold positions in the name order, so they stay sorted.
+type registryIndex struct {
+	versions    uint64 // versionSum of the metrics when the index was built
+	names       []string
+	sorted      []*Metric
+	byType      map[string][]int
+	byVendor    map[string][]int
+	bySubsystem map[string][]int
+	byCategory  map[string][]int
+	byLabel     map[string][]int
+	vendors     []string // Sorted vendors other than ""
+}
+
+// newRegistryIndex indexes metrics given in name order. The metrics must not
+// change while they are indexed.
+func newRegistryIndex(names []string, sorted []*Metric) *registryIndex {
+	index := &registryIndex{
+		versions:    versionSum(sorted),
+		names:       names,
+		sorted:      sorted,
+		byType:      make(map[string][]int),
+		byVendor:    make(map[string][]int),
+		bySubsystem: make(map[string][]int),
+		byCategory:  make(map[string][]int),
+		byLabel:     make(map[string][]int),
+	}
+	for i, metric := range sorted {
+		index.byType[metric.mtype] = append(index.byType[metric.mtype], i)
+		index.byVendor[metric.vendor] = append(index.byVendor[metric.vendor], i)
+		index.bySubsystem[metric.subsystem] = append(index.bySubsystem[metric.subsystem], i)
+		index.byCategory[metric.category] = append(index.byCategory[metric.category], i)
+		for label := range metric.labels {
+			index.byLabel[label] = append(index.byLabel[label], i)
+		}
+	}
+
+	for vendor := range index.byVendor {
+		if vendor != "" {
+			index.vendors = append(index.vendors, vendor)
+		}
+	}
+	sort.Strings(index.vendors)
+	return index
+}
+
+// metrics returns the metrics of a group
+func (x *registryIndex) metrics(group []int) []*Metric {
+	if len(group) == 0 {
+		return nil
+	}
+	result := make([]*Metric, len(group))
+	for i, position := range group {
+		result[i] = x.sorted[position]
+	}
+	return result
+}
+
+// subset returns a new registry of the metrics at positions of the name
+// order, which is already sorted for it
+func subset(names []string, sorted []*Metric, positions []int) *Registry {
+	result := NewRegistry()
+	result.names = make([]string, len(positions))
+	result.sorted = make([]*Metric, len(positions))
+	for i, position := range positions {
+		result.metrics[names[position]] = sorted[position]
+		result.names[i], result.sorted[i] = names[position], sorted[position]
+	}
+	return result
+}
+
+// invalidate drops the cached order and indexes after the set of metrics
+// changed. The caller holds the write lock.
+func (r *Registry) invalidate() {
+	r.names, r.sorted, r.index = nil, nil, nil
+}
+
+// ordered returns the names and metrics of the registry in name order. The
+// slices are shared until the next change and must not be modified.
+func (r *Registry) ordered() ([]string, []*Metric) {
+	r.mu.RLock()
+	names, sorted := r.names, r.sorted
+	r.mu.RUnlock()
+	if names != nil {
+		return names, sorted
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sortLocked()
+	return r.names, r.sorted
+}
+
+// sortLocked caches the order of the metrics unless it is cached already.
+// The caller holds the write lock.
+func (r *Registry) sortLocked() {
+	if r.names != nil {
+		return
+	}
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	sorted := make([]*Metric, len(names))
+	for i, name := range names {
+		sorted[i] = r.metrics[name]
+	}
+	r.names, r.sorted = names, sorted
+}
+
+// indexed returns the indexes of the registry, rebuilding them after the
+// registry or one of its metrics changed
+func (r *Registry) indexed() *registryIndex {
+	r.mu.RLock()
+	index := r.index
+	r.mu.RUnlock()
+	if index != nil && index.versions == versionSum(index.sorted) {
+		return index
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.index == nil || r.index.versions != versionSum(r.index.sorted) {
+		r.sortLocked()
+		r.index = newRegistryIndex(r.names, r.sorted)
+	}
+	return r.index
+}
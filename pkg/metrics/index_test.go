@@ -0,0 +1,214 @@
+package metrics
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// names returns the names of metrics
+func names(metrics []*Metric) []string {
+	var result []string
+	for _, metric := range metrics {
+		result = append(result, metric.Name())
+	}
+	return result
+}
+
+func TestRegistryIndexes(t *testing.T) {
+	registry := NewRegistry()
+	add := func(name, mtype, vendor, subsystem, category string, labels ...string) *Metric {
+		metric := New(name, "", nil, mtype, "", "")
+		metric.SetVendor(vendor)
+		metric.SetSubsystem(subsystem)
+		metric.SetCategory(category)
+		for _, label := range labels {
+			metric.AddLabel(label)
+		}
+		registry.Set(name, metric)
+		return metric
+	}
+	add("junos_cpu", "gauge", "juniper", "components", "cpu", "slot")
+	add("cisco_bgp_peers", "gauge", "cisco", "routing", "bgp", "peer")
+	add("junos_ifaces", "counter", "juniper", "interfaces", "ethernet", "interface", "slot")
+	up := add("up", "gauge", "", "", "", "instance")
+
+	tests := []struct {
+		name string
+		got  []*Metric
+		want []string
+	}{
+		{"Type", registry.ListByType("gauge"), []string{"cisco_bgp_peers", "junos_cpu", "up"}},
+		{"Vendor", registry.ListByVendor("juniper"), []string{"junos_cpu", "junos_ifaces"}},
+		{"Subsystem", registry.ListBySubsystem("routing"), []string{"cisco_bgp_peers"}},
+		{"Category", registry.ListByCategory("ethernet"), []string{"junos_ifaces"}},
+		{"Label", registry.ListByLabel("slot"), []string{"junos_cpu", "junos_ifaces"}},
+		{"Unknown label", registry.ListByLabel("job"), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := names(tt.got); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Got %v; want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("Metric changes", func(t *testing.T) {
+		up.SetType("counter")
+		up.AddLabel("job")
+		if got := names(registry.ListByType("gauge")); !reflect.DeepEqual(got, []string{"cisco_bgp_peers", "junos_cpu"}) {
+			t.Errorf("Expected the retyped metric to leave the gauges, got %v", got)
+		}
+		if got := names(registry.ListByLabel("job")); !reflect.DeepEqual(got, []string{"up"}) {
+			t.Errorf("Expected the new label to be indexed, got %v", got)
+		}
+	})
+
+	t.Run("Registry changes", func(t *testing.T) {
+		registry.Delete("junos_cpu")
+		add("arista_temp", "gauge", "arista", "", "", "sensor")
+		if got := registry.ListVendors(); !reflect.DeepEqual(got, []string{"arista", "cisco", "juniper"}) {
+			t.Errorf("ListVendors() = %v", got)
+		}
+		if got := registry.List(); !reflect.DeepEqual(got, []string{"arista_temp", "cisco_bgp_peers", "junos_ifaces", "up"}) {
+			t.Errorf("List() = %v", got)
+		}
+	})
+
+	t.Run("Filtered registries", func(t *testing.T) {
+		juniper := registry.FilterByVendor("juniper")
+		registry.Get("junos_ifaces").SetCategory("lag")
+		if got := names(juniper.ListByCategory("lag")); !reflect.DeepEqual(got, []string{"junos_ifaces"}) {
+			t.Errorf("Expected a change to a shared metric to reach the filtered registry, got %v", got)
+		}
+		juniper.Set("junos_temp", New("junos_temp", "", nil, "gauge", "", ""))
+		if registry.Has("junos_temp") || !reflect.DeepEqual(juniper.List(), []string{"junos_ifaces", "junos_temp"}) {
+			t.Errorf("Expected the filtered registry to change on its own, got %v", juniper.List())
+		}
+	})
+
+	t.Run("Lists are copies", func(t *testing.T) {
+		list := registry.List()
+		list[0] = "changed"
+		gauges := registry.ListByType("gauge")
+		gauges[0] = nil
+		if registry.List()[0] != "arista_temp" || registry.ListByType("gauge")[0] == nil {
+			t.Errorf("Expected the cached order to be unaffected")
+		}
+	})
+}
+
+func TestRegistryIndexVersions(t *testing.T) {
+	a, b := NewRegistry(), NewRegistry()
+	a.Set("up", New("up", "", nil, "gauge", "", ""))
+	b.Set("jobs", New("jobs", "", nil, "gauge", "", ""))
+
+	index := a.indexed()
+	b.Get("jobs").SetType("counter")
+	if a.indexed() != index {
+		t.Error("Expected a change to a metric of another registry to keep the indexes")
+	}
+	a.Get("up").SetHelp("Whether the target is up")
+	if a.indexed() != index {
+		t.Error("Expected a change to an unindexed field to keep the indexes")
+	}
+	a.Get("up").SetType("counter")
+	if a.indexed() == index || len(a.ListByType("counter")) != 1 {
+		t.Error("Expected a change to an indexed field to rebuild the indexes")
+	}
+}
+
+func TestRegistryConcurrentUse(t *testing.T) {
+	registry := NewRegistry()
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				name := fmt.Sprintf("metric_%d_%d", w, i)
+				registry.Set(name, New(name, "", nil, "gauge", "", ""))
+				if i%10 == 0 {
+					registry.Delete(name)
+				}
+			}
+		}(w)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				registry.ListByType("gauge")
+				registry.ForEach(func(name string, metric *Metric) {})
+				registry.Filter(func(name string, metric *Metric) bool { return true })
+			}
+		}()
+	}
+	wg.Wait()
+
+	if registry.Count() != 4*180 || len(registry.ListByType("gauge")) != 4*180 || len(registry.List()) != 4*180 {
+		t.Errorf("Expected %d metrics, got %d", 4*180, registry.Count())
+	}
+}
+
+// benchmarkRegistry returns a registry of n metrics spread over types,
+// vendors, subsystems, categories and labels
+func benchmarkRegistry(n int) *Registry {
+	types := []string{"counter", "gauge", "histogram", "summary"}
+	vendors := []string{"", "juniper", "cisco"}
+	registry := NewRegistry()
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("metric_%06d", i)
+		metric := New(name, "", nil, types[i%len(types)], "", "")
+		metric.SetVendor(vendors[i%len(vendors)])
+		metric.SetSubsystem(fmt.Sprintf("subsystem_%d", i%50))
+		metric.SetCategory(fmt.Sprintf("category_%d", i%20))
+		metric.AddLabel("instance")
+		metric.AddLabel(fmt.Sprintf("label_%d", i%100))
+		registry.Set(name, metric)
+	}
+	return registry
+}
+
+// BenchmarkRegistry measures the lookups dashboard generation repeats on a
+// registry of 100k metrics
+func BenchmarkRegistry(b *testing.B) {
+	registry := benchmarkRegistry(100000)
+	benchmarks := []struct {
+		name string
+		fn   func()
+	}{
+		{"List", func() { registry.List() }},
+		{"ListByType", func() { registry.ListByType("gauge") }},
+		{"ListByVendor", func() { registry.ListByVendor("juniper") }},
+		{"ListByLabel", func() { registry.ListByLabel("label_7") }},
+		{"ListVendors", func() { registry.ListVendors() }},
+		{"ForEach", func() { registry.ForEach(func(name string, metric *Metric) {}) }},
+		{"Filter", func() { registry.Filter(func(name string, metric *Metric) bool { return metric.Type() == "counter" }) }},
+		{"FilterByVendor", func() { registry.FilterByVendor("cisco") }},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			bm.fn()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				bm.fn()
+			}
+		})
+	}
+
+	// Every Set drops the cached order, which the next List rebuilds
+	b.Run("SetAndList", func(b *testing.B) {
+		metric := New("metric_new", "", nil, "gauge", "", "")
+		for i := 0; i < b.N; i++ {
+			registry.Set("metric_new", metric)
+			registry.List()
+		}
+	})
+	b.Run("ConcurrentListByType", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				registry.ListByType("gauge")
+			}
+		})
+	})
+}
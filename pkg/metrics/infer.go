@@ -0,0 +1,136 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Confidence in a type inferred from each kind of evidence
+const (
+	confidenceSeries   = 0.9  // Histogram and summary series are unambiguous
+	confidenceName     = 0.8  // A _total name usually marks a counter
+	confidenceScrapes  = 0.7  // Values that only rose may still be a gauge
+	confidenceBoth     = 0.95 // A _total name whose values only rose
+	confidenceDecrease = 0.8  // Counters only decrease when reset
+	confidenceConflict = 0.6  // A _total name whose values fell
+)
+
+// InferType sets the type of a metric exposed without one, along with the
+// evidence it was inferred from and the confidence in it from 0 to 1
+func (m *Metric) InferType(mtype, reason string, confidence float64) {
+	m.SetType(mtype)
+	m.typeReason = reason
+	m.confidence = confidence
+}
+
+// TypeInferred reports whether the type was inferred rather than declared
+func (m *Metric) TypeInferred() bool {
+	return m.typeReason != ""
+}
+
+// TypeConfidence returns the confidence in the type from 0 to 1, which is 1
+// for declared types
+func (m *Metric) TypeConfidence() float64 {
+	if !m.TypeInferred() {
+		return 1
+	}
+	return m.confidence
+}
+
+// TypeReason returns the evidence an inferred type is based on, e.g. "name
+// ends with _total"
+func (m *Metric) TypeReason() string {
+	return m.typeReason
+}
+
+// TypeNote describes an inferred type for panel descriptions, e.g.
+// "Type inferred as counter: name ends with _total (80% confidence)"
+func (m *Metric) TypeNote() string {
+	if !m.TypeInferred() {
+		return ""
+	}
+	return fmt.Sprintf("Type inferred as %s: %s (%.0f%% confidence)", m.mtype, m.typeReason, m.confidence*100)
+}
+
+// InferTypes infers the type of untyped metrics from their series: _bucket
+// series with an le label make a histogram, a quantile label a summary and
+// a _total name a counter. It returns the metrics it typed.
+func InferTypes(registry *Registry) []*Metric {
+	var inferred []*Metric
+	registry.ForEach(func(name string, metric *Metric) {
+		if !isUntyped(metric.mtype) {
+			return
+		}
+		switch {
+		case metric.HasLabel("le") && metric.hasSeries("_bucket"):
+			metric.InferType("histogram", "_bucket series with an le label", confidenceSeries)
+		case metric.HasLabel("quantile"):
+			metric.InferType("summary", "quantile label", confidenceSeries)
+		case strings.HasSuffix(metric.name, "_total"):
+			metric.InferType("counter", "name ends with _total", confidenceName)
+		default:
+			return
+		}
+		inferred = append(inferred, metric)
+	})
+	return inferred
+}
+
+// InferFromScrapes infers the type of untyped metrics from how their values
+// changed since an earlier scrape of the same target. Metrics whose series
+// only rose become counters and metrics with a falling series gauges; a
+// counter inferred from its name is confirmed or corrected. It returns the
+// metrics it typed.
+func InferFromScrapes(registry, earlier *Registry) []*Metric {
+	var inferred []*Metric
+	registry.ForEach(func(name string, metric *Metric) {
+		named := metric.TypeInferred() && metric.mtype == "counter"
+		if !isUntyped(metric.mtype) && !named {
+			return
+		}
+		previous := earlier.Get(name)
+		if previous == nil {
+			return
+		}
+
+		rose, fell := metric.changedSince(previous)
+		switch {
+		case fell && named:
+			metric.InferType("gauge", "fell between scrapes despite its _total name", confidenceConflict)
+		case fell:
+			metric.InferType("gauge", "fell between scrapes", confidenceDecrease)
+		case rose && named:
+			metric.InferType("counter", "name ends with _total and only rose between scrapes", confidenceBoth)
+		case rose:
+			metric.InferType("counter", "only rose between scrapes", confidenceScrapes)
+		default:
+			return
+		}
+		inferred = append(inferred, metric)
+	})
+	return inferred
+}
+
+// hasSeries reports whether a series of the metric is named with suffix
+func (m *Metric) hasSeries(suffix string) bool {
+	return m.suffix == suffix || m.names[m.name+suffix]
+}
+
+// changedSince reports whether any series rose or fell since the earlier
+// reading of the metric. Both readings keep the samples of the series with
+// the smallest signatures, so they are compared on the same series.
+func (m *Metric) changedSince(earlier *Metric) (rose, fell bool) {
+	for key, sample := range m.samples {
+		before, ok := earlier.samples[key]
+		if !ok {
+			continue
+		}
+		switch {
+		case sample.Last > before.Last:
+			rose = true
+		case sample.Last < before.Last:
+			fell = true
+		}
+	}
+	return rose, fell
+}
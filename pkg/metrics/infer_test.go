@@ -0,0 +1,132 @@
+package metrics
+
+import (
+	"testing"
+)
+
+// untyped builds a metric without a type from samples of its series
+func untyped(name string, samples ...map[string]string) *Metric {
+	metric := New(name, "", nil, "", "", "")
+	for _, labels := range samples {
+		for label, value := range labels {
+			if label != "__name__" {
+				metric.AddLabelValue(label, value)
+			}
+		}
+		metric.AddSample(labels, 1, 0)
+	}
+	return metric
+}
+
+func TestInferTypes(t *testing.T) {
+	registry := NewRegistry()
+	for _, metric := range []*Metric{
+		untyped("request_duration_seconds",
+			map[string]string{"__name__": "request_duration_seconds_bucket", "le": "0.1"},
+			map[string]string{"__name__": "request_duration_seconds_count"}),
+		untyped("rpc_latency_seconds", map[string]string{"__name__": "rpc_latency_seconds", "quantile": "0.99"}),
+		untyped("jobs_processed_total", map[string]string{"__name__": "jobs_processed_total"}),
+		untyped("queue_depth", map[string]string{"__name__": "queue_depth"}),
+		untyped("threshold", map[string]string{"__name__": "threshold", "le": "5"}),
+		New("errors_total", "", nil, "gauge", "", ""),
+	} {
+		registry.Set(metric.Name(), metric)
+	}
+
+	inferred := InferTypes(registry)
+	if len(inferred) != 3 {
+		t.Errorf("Expected 3 inferred types, got %d", len(inferred))
+	}
+
+	tests := []struct {
+		name       string
+		mtype      string
+		inferred   bool
+		confidence float64
+	}{
+		{"request_duration_seconds", "histogram", true, confidenceSeries},
+		{"rpc_latency_seconds", "summary", true, confidenceSeries},
+		{"jobs_processed_total", "counter", true, confidenceName},
+		{"queue_depth", "", false, 1},
+		{"threshold", "", false, 1},
+		{"errors_total", "gauge", false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metric := registry.Get(tt.name)
+			if metric.Type() != tt.mtype || metric.TypeInferred() != tt.inferred || metric.TypeConfidence() != tt.confidence {
+				t.Errorf("Got type %q, inferred %v with confidence %v; want %q, %v, %v",
+					metric.Type(), metric.TypeInferred(), metric.TypeConfidence(), tt.mtype, tt.inferred, tt.confidence)
+			}
+		})
+	}
+
+	if got := registry.ListByType("counter"); len(got) != 1 || got[0].Name() != "jobs_processed_total" {
+		t.Errorf("Expected the inferred counter to be indexed, got %v", names(got))
+	}
+}
+
+func TestInferFromScrapes(t *testing.T) {
+	scrapes := func(name string, before, after float64) (*Metric, *Metric) {
+		labels := map[string]string{"__name__": name, "instance": "a"}
+		earlier, later := New(name, "", nil, "", "", ""), New(name, "", nil, "", "", "")
+		earlier.AddSample(labels, before, 0)
+		later.AddSample(labels, after, 0)
+		return earlier, later
+	}
+
+	tests := []struct {
+		name          string
+		before, after float64
+		mtype, reason string
+		confidence    float64
+	}{
+		{"events", 10, 12, "counter", "only rose between scrapes", confidenceScrapes},
+		{"temperature", 30, 28, "gauge", "fell between scrapes", confidenceDecrease},
+		{"requests_total", 5, 9, "counter", "name ends with _total and only rose between scrapes", confidenceBoth},
+		{"connections_total", 5, 3, "gauge", "fell between scrapes despite its _total name", confidenceConflict},
+		{"idle", 1, 1, "", "", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			earlier, later := scrapes(tt.name, tt.before, tt.after)
+			registry, previous := NewRegistry(), NewRegistry()
+			registry.Set(tt.name, later)
+			previous.Set(tt.name, earlier)
+			InferTypes(registry)
+
+			InferFromScrapes(registry, previous)
+			if later.Type() != tt.mtype || later.TypeReason() != tt.reason || later.TypeConfidence() != tt.confidence {
+				t.Errorf("Got %q (%q, %v); want %q (%q, %v)", later.Type(), later.TypeReason(), later.TypeConfidence(), tt.mtype, tt.reason, tt.confidence)
+			}
+		})
+	}
+
+	t.Run("Declared types", func(t *testing.T) {
+		earlier, later := scrapes("queue_depth", 5, 9)
+		later.SetType("gauge")
+		registry, previous := NewRegistry(), NewRegistry()
+		registry.Set("queue_depth", later)
+		previous.Set("queue_depth", earlier)
+		if inferred := InferFromScrapes(registry, previous); len(inferred) != 0 || later.Type() != "gauge" {
+			t.Errorf("Expected a declared type to be kept, got %q", later.Type())
+		}
+	})
+}
+
+func TestTypeNote(t *testing.T) {
+	metric := New("jobs_total", "", nil, "", "", "")
+	if note := metric.TypeNote(); note != "" {
+		t.Errorf("Expected no note without an inferred type, got %q", note)
+	}
+
+	metric.InferType("counter", "name ends with _total", 0.8)
+	if want := "Type inferred as counter: name ends with _total (80% confidence)"; metric.TypeNote() != want {
+		t.Errorf("TypeNote() = %q; want %q", metric.TypeNote(), want)
+	}
+
+	metric.SetType("counter")
+	if metric.TypeInferred() || metric.TypeConfidence() != 1 {
+		t.Errorf("Expected a declared type to replace the inferred one")
+	}
+}
@@ -0,0 +1,157 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Conflict is metadata on which the sources of a metric disagree. The value
+// read first is kept.
+type Conflict struct {
+	Metric  string
+	Field   string // type or help
+	Kept    string
+	Dropped string
+	Source  string // Source of the dropped value
+}
+
+// String describes the conflict
+func (c Conflict) String() string {
+	return fmt.Sprintf("%s: %s %q from %s differs from %q", c.Metric, c.Field, c.Dropped, c.Source, c.Kept)
+}
+
+// TagSource records source as the input of every metric in the registry
+func (r *Registry) TagSource(source string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, metric := range r.metrics {
+		metric.AddSource(source)
+	}
+}
+
+// Merge adds the metrics of other to the registry. Metrics in both combine
+// their labels, series and samples; an untyped metric or one with an
+// inferred type takes the type declared by the other source and an empty
+// HELP the other text. Differing types and HELP
+// texts keep the value already in the registry and are returned. Several
+// registries may be merged into one concurrently.
+func (r *Registry) Merge(other *Registry) []Conflict {
+	var conflicts []Conflict
+	other.ForEach(func(name string, metric *Metric) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		existing, ok := r.metrics[name]
+		if !ok {
+			r.metrics[name] = metric
+			r.invalidate()
+			return
+		}
+		conflicts = append(conflicts, existing.merge(metric)...)
+	})
+	return conflicts
+}
+
+// merge combines another reading of the same metric into m
+func (m *Metric) merge(other *Metric) []Conflict {
+	var conflicts []Conflict
+	conflict := func(field, kept, dropped string) {
+		conflicts = append(conflicts, Conflict{
+			Metric:  m.name,
+			Field:   field,
+			Kept:    kept,
+			Dropped: dropped,
+			Source:  strings.Join(other.sources, ", "),
+		})
+	}
+
+	// Declared types take precedence over inferred ones
+	declared := m.TypeInferred() && !other.TypeInferred() && !isUntyped(other.mtype)
+	switch {
+	case other.mtype == m.mtype && declared:
+		m.typeReason, m.confidence = "", 0
+	case other.mtype == m.mtype || isUntyped(other.mtype):
+	case isUntyped(m.mtype) || declared:
+		m.mtype, m.suffix = other.mtype, other.suffix
+		m.typeReason, m.confidence = other.typeReason, other.confidence
+		m.changed()
+	default:
+		conflict("type", m.mtype, other.mtype)
+	}
+
+	switch {
+	case other.help == m.help || other.help == "":
+	case m.help == "":
+		m.help = other.help
+	default:
+		conflict("help", m.help, other.help)
+	}
+
+	if m.unit == "" {
+		m.unit = other.unit
+	}
+	if m.vendor == "" && other.vendor != "" {
+		m.vendor = other.vendor
+		m.changed()
+	}
+
+	for label := range other.labels {
+		m.AddLabel(label)
+	}
+	for label, values := range other.values {
+		m.AddLabel(label)
+		m.labelValues(label).merge(values)
+	}
+	m.series.merge(&other.series)
+	for quantile := range other.quantiles {
+		m.AddQuantile(quantile)
+	}
+	m.exemplars = m.exemplars || other.exemplars
+	m.native = m.native || other.native
+
+	m.stats.merge(other.stats)
+	for name := range other.names {
+		if m.names == nil {
+			m.names = make(map[string]bool)
+		}
+		m.names[name] = true
+	}
+	for key, sample := range other.samples {
+		m.mergeSample(key, sample)
+	}
+	for _, source := range other.sources {
+		m.AddSource(source)
+	}
+	return conflicts
+}
+
+// mergeSample combines a sample of the same series read from another source.
+// The newer value becomes the last one.
+func (m *Metric) mergeSample(key uint64, sample *Sample) {
+	kept, evicted, ok := m.sampled.add(key)
+	if ok {
+		delete(m.samples, evicted)
+	}
+	if !kept {
+		return
+	}
+	if m.samples == nil {
+		m.samples = make(map[uint64]*Sample)
+	}
+	existing, ok := m.samples[key]
+	if !ok {
+		copied := *sample
+		m.samples[key] = &copied
+		return
+	}
+
+	if sample.Timestamp >= existing.Timestamp {
+		existing.Last, existing.Timestamp = sample.Last, sample.Timestamp
+	}
+	existing.widen(sample.Min)
+	existing.widen(sample.Max)
+}
+
+// isUntyped reports whether a type carries no information
+func isUntyped(mtype string) bool {
+	return mtype == "" || mtype == "untyped" || mtype == "unknown"
+}
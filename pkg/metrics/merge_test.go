@@ -0,0 +1,124 @@
+package metrics
+
+import (
+	"reflect"
+	"testing"
+)
+
+// scrape builds a registry holding one metric read from source
+func scrape(source string, metric *Metric) *Registry {
+	registry := NewRegistry()
+	registry.Set(metric.Name(), metric)
+	registry.TagSource(source)
+	return registry
+}
+
+func TestRegistryMerge(t *testing.T) {
+	t.Run("Combines labels, series and samples", func(t *testing.T) {
+		a := New("http_requests", "Requests served", nil, "counter", "_total", "")
+		a.AddLabelValue("code", "200")
+		a.AddSeries(map[string]string{"code": "200"})
+		a.AddSample(map[string]string{"code": "200"}, 10, 1000)
+
+		b := New("http_requests", "Requests served", nil, "counter", "_total", "")
+		b.AddLabelValue("code", "500")
+		b.AddLabelValue("instance", "b:9100")
+		b.AddSeries(map[string]string{"code": "500", "instance": "b:9100"})
+		b.AddSample(map[string]string{"code": "200"}, 4, 2000)
+
+		registry := scrape("a.txt", a)
+		if conflicts := registry.Merge(scrape("b.txt", b)); len(conflicts) != 0 {
+			t.Errorf("Expected no conflicts, got %v", conflicts)
+		}
+
+		merged := registry.Get("http_requests")
+		if !reflect.DeepEqual(merged.Labels(), []string{"code", "instance"}) {
+			t.Errorf("Expected the label union, got %v", merged.Labels())
+		}
+		if !reflect.DeepEqual(merged.LabelValues("code"), []string{"200", "500"}) || merged.SeriesCount() != 2 {
+			t.Errorf("Expected values 200 and 500 in 2 series, got %v in %d", merged.LabelValues("code"), merged.SeriesCount())
+		}
+		samples := merged.Samples()
+		if len(samples) != 1 || samples[0].Last != 4 || samples[0].Min != 4 || samples[0].Max != 10 {
+			t.Errorf("Expected the newer sample with the combined range, got %+v", samples)
+		}
+		if !reflect.DeepEqual(merged.Sources(), []string{"a.txt", "b.txt"}) {
+			t.Errorf("Expected both sources, got %v", merged.Sources())
+		}
+	})
+
+	t.Run("Conflicting type and help", func(t *testing.T) {
+		registry := scrape("a.txt", New("queue_depth", "Jobs waiting", nil, "gauge", "", ""))
+		conflicts := registry.Merge(scrape("b.txt", New("queue_depth", "Queued jobs", nil, "counter", "", "")))
+
+		want := []Conflict{
+			{Metric: "queue_depth", Field: "type", Kept: "gauge", Dropped: "counter", Source: "b.txt"},
+			{Metric: "queue_depth", Field: "help", Kept: "Jobs waiting", Dropped: "Queued jobs", Source: "b.txt"},
+		}
+		if !reflect.DeepEqual(conflicts, want) {
+			t.Errorf("Merge() conflicts = %+v; want %+v", conflicts, want)
+		}
+		if metric := registry.Get("queue_depth"); metric.Type() != "gauge" || metric.Help() != "Jobs waiting" {
+			t.Errorf("Expected the first type and help to be kept, got %s %q", metric.Type(), metric.Help())
+		}
+	})
+
+	t.Run("Untyped and missing help are filled in", func(t *testing.T) {
+		registry := scrape("a.txt", New("jobs", "", nil, "untyped", "", ""))
+		conflicts := registry.Merge(scrape("b.txt", New("jobs", "Jobs run", nil, "counter", "_total", "")))
+
+		metric := registry.Get("jobs")
+		if len(conflicts) != 0 || metric.Type() != "counter" || metric.Suffix() != "_total" || metric.Help() != "Jobs run" {
+			t.Errorf("Expected counter type and help from the second source, got %s%s %q and %v", metric.Type(), metric.Suffix(), metric.Help(), conflicts)
+		}
+	})
+
+	t.Run("Declared types replace inferred ones", func(t *testing.T) {
+		inferred := New("jobs_total", "", nil, "", "", "")
+		inferred.InferType("counter", "name ends with _total", 0.8)
+		registry := scrape("a.txt", inferred)
+		conflicts := registry.Merge(scrape("b.txt", New("jobs_total", "", nil, "gauge", "", "")))
+
+		metric := registry.Get("jobs_total")
+		if len(conflicts) != 0 || metric.Type() != "gauge" || metric.TypeInferred() {
+			t.Errorf("Expected the declared gauge type, got %s (inferred %v) and %v", metric.Type(), metric.TypeInferred(), conflicts)
+		}
+	})
+
+	t.Run("New metrics are added", func(t *testing.T) {
+		registry := scrape("a.txt", New("up", "", nil, "gauge", "", ""))
+		registry.Merge(scrape("b.txt", New("node_load1", "", nil, "gauge", "", "")))
+
+		if registry.Count() != 2 || !reflect.DeepEqual(registry.Get("node_load1").Sources(), []string{"b.txt"}) {
+			t.Errorf("Expected node_load1 from b.txt to be added, got %v", registry.List())
+		}
+	})
+}
+
+func TestConflictString(t *testing.T) {
+	c := Conflict{Metric: "jobs", Field: "type", Kept: "gauge", Dropped: "counter", Source: "b.txt"}
+	if got, want := c.String(), `jobs: type "counter" from b.txt differs from "gauge"`; got != want {
+		t.Errorf("String() = %q; want %q", got, want)
+	}
+}
+
+func TestRegistryMergeConcurrent(t *testing.T) {
+	registry := NewRegistry()
+	done := make(chan bool)
+	for i := 0; i < 8; i++ {
+		go func(i int) {
+			metric := New("up", "", nil, "gauge", "", "")
+			metric.AddSample(map[string]string{"instance": string(rune('a' + i))}, 1, 0)
+			registry.Merge(scrape("target", metric))
+			registry.Count()
+			done <- true
+		}(i)
+	}
+	for i := 0; i < 8; i++ {
+		<-done
+	}
+
+	if samples := registry.Get("up").Samples(); len(samples) != 8 {
+		t.Errorf("Expected the samples of all 8 targets, got %d", len(samples))
+	}
+}
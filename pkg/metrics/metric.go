@@ -3,20 +3,35 @@ package metrics
 
 import (
 	"sort"
+	"strconv"
+	"sync/atomic"
 )
 
 // Metric represents a single metric with metadata
 type Metric struct {
 	help        string
 	mtype       string
+	typeReason  string                     // Evidence of an inferred type, "" when declared
+	confidence  float64                    // Confidence in an inferred type from 0 to 1
 	name        string
 	suffix      string
 	labels      map[string]bool
+	values      map[string]*labelValues    // Observed values of each label
+	series      sketch                     // Signatures of the observed series
+	samples     map[uint64]*Sample         // Observed values of the series kept by sampled
+	sampled     sketch                     // Full series signatures of the kept samples
+	stats       valueStats                 // Values observed across all series
+	names       map[string]bool            // Names of the observed series, e.g. foo_bucket
+	quantiles   map[string]bool            // Observed quantile label values of a summary
+	exemplars   bool                       // Whether any sample carries an exemplar
+	native      bool                       // Whether the metric is a native histogram
 	unit        string
 	vendor      string        // Identified vendor prefix (juniper, cisco, etc.)
 	subsystem   string        // Subsystem identified from metric name
 	category    string        // Category for grouping related metrics
 	displayName string        // Optional display name for the metric (used for better UI)
+	sources     []string      // Inputs the metric was read from, in order
+	version     atomic.Uint64 // Bumped by changes to the fields registries index
 }
 
 // New creates a new metric with initial values
@@ -56,9 +71,13 @@ func (m *Metric) Type() string {
 	return m.mtype
 }
 
-// SetType sets the metric type
+// SetType sets the metric type as declared by the exposition
 func (m *Metric) SetType(mtype string) {
-	m.mtype = mtype
+	m.typeReason, m.confidence = "", 0
+	if m.mtype != mtype {
+		m.mtype = mtype
+		m.changed()
+	}
 }
 
 // Name returns the metric name
@@ -109,6 +128,7 @@ func (m *Metric) Labels() []string {
 // SetLabels sets the entire labels map
 func (m *Metric) SetLabels(labels map[string]bool) {
 	m.labels = labels
+	m.changed()
 }
 
 // AddLabel adds a new label
@@ -116,7 +136,10 @@ func (m *Metric) AddLabel(label string) {
 	if m.labels == nil {
 		m.labels = make(map[string]bool)
 	}
-	m.labels[label] = true
+	if !m.labels[label] {
+		m.labels[label] = true
+		m.changed()
+	}
 }
 
 // HasLabel checks if a specific label exists
@@ -128,6 +151,157 @@ func (m *Metric) HasLabel(label string) bool {
 	return exists
 }
 
+// maxLabelValues bounds the values kept per label. Values beyond it are
+// counted like the series of a metric, but not listed.
+const maxLabelValues = 1024
+
+// labelValues are the observed values of a label
+type labelValues struct {
+	kept map[string]bool // Up to maxLabelValues of the values
+	seen sketch          // Signatures of all values
+}
+
+// add records a value
+func (v *labelValues) add(value string) {
+	if v.kept[value] {
+		return
+	}
+	v.seen.add(hashString(offset64, value))
+	if len(v.kept) < maxLabelValues {
+		v.kept[value] = true
+	}
+}
+
+// merge adds the values of another reading of the label
+func (v *labelValues) merge(other *labelValues) {
+	for value := range other.kept {
+		if len(v.kept) < maxLabelValues {
+			v.kept[value] = true
+		}
+	}
+	v.seen.merge(&other.seen)
+}
+
+// AddLabelValue records an observed value of a label, adding the label if needed
+func (m *Metric) AddLabelValue(label, value string) {
+	m.AddLabel(label)
+	m.labelValues(label).add(value)
+}
+
+// labelValues returns the observed values of a label, creating them on
+// first use
+func (m *Metric) labelValues(label string) *labelValues {
+	if m.values == nil {
+		m.values = make(map[string]*labelValues)
+	}
+	if m.values[label] == nil {
+		m.values[label] = &labelValues{kept: make(map[string]bool)}
+	}
+	return m.values[label]
+}
+
+// LabelValues returns a sorted list of the observed values of a label, at
+// most maxLabelValues of them
+func (m *Metric) LabelValues(label string) []string {
+	if m.values[label] == nil || len(m.values[label].kept) == 0 {
+		return nil
+	}
+	
+	values := make([]string, 0, len(m.values[label].kept))
+	for value := range m.values[label].kept {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	
+	return values
+}
+
+// LabelCardinality returns the number of distinct values observed for a
+// label, estimated beyond maxTrackedSeries
+func (m *Metric) LabelCardinality(label string) int {
+	if m.values[label] == nil {
+		return 0
+	}
+	return m.values[label].seen.count()
+}
+
+// AddSeries records an observed series by its label set. The le and quantile
+// labels are ignored so a histogram or summary series is counted once.
+func (m *Metric) AddSeries(labels map[string]string) {
+	series, _ := signatures(labels)
+	m.series.add(series)
+}
+
+// Observe records a sample like AddLabelValue for each label, AddSeries and
+// AddSample together, signing the series only once
+func (m *Metric) Observe(labels map[string]string, value float64, timestamp int64) {
+	for label, v := range labels {
+		if label != "__name__" && label != "" {
+			m.AddLabelValue(label, v)
+		}
+	}
+	series, full := signatures(labels)
+	m.series.add(series)
+	m.addSample(labels["__name__"], full, value, timestamp)
+}
+
+// SeriesCount returns the number of distinct series observed for this metric,
+// estimated beyond maxTrackedSeries
+func (m *Metric) SeriesCount() int {
+	return m.series.count()
+}
+
+// Quantiles returns the observed quantile label values in ascending order
+func (m *Metric) Quantiles() []string {
+	if len(m.quantiles) == 0 {
+		return nil
+	}
+	
+	quantiles := make([]string, 0, len(m.quantiles))
+	for quantile := range m.quantiles {
+		quantiles = append(quantiles, quantile)
+	}
+	sort.Slice(quantiles, func(i, j int) bool {
+		a, errA := strconv.ParseFloat(quantiles[i], 64)
+		b, errB := strconv.ParseFloat(quantiles[j], 64)
+		if errA != nil || errB != nil || a == b {
+			return quantiles[i] < quantiles[j]
+		}
+		return a < b
+	})
+	
+	return quantiles
+}
+
+// AddQuantile records an observed quantile label value
+func (m *Metric) AddQuantile(quantile string) {
+	if m.quantiles == nil {
+		m.quantiles = make(map[string]bool)
+	}
+	m.quantiles[quantile] = true
+}
+
+// HasExemplars reports whether samples of this metric carry exemplars
+func (m *Metric) HasExemplars() bool {
+	return m.exemplars
+}
+
+// SetExemplars sets whether samples of this metric carry exemplars
+func (m *Metric) SetExemplars(exemplars bool) {
+	m.exemplars = exemplars
+}
+
+// IsNativeHistogram reports whether this metric is a native histogram,
+// whose buckets are part of each sample instead of separate _bucket series
+func (m *Metric) IsNativeHistogram() bool {
+	return m.native
+}
+
+// SetNativeHistogram sets whether this metric is a native histogram
+func (m *Metric) SetNativeHistogram(native bool) {
+	m.native = native
+}
+
 // LabelCount returns the number of labels
 func (m *Metric) LabelCount() int {
 	return len(m.labels)
@@ -148,7 +322,10 @@ func (m *Metric) Vendor() string {
 
 // SetVendor sets the vendor prefix
 func (m *Metric) SetVendor(vendor string) {
-	m.vendor = vendor
+	if m.vendor != vendor {
+		m.vendor = vendor
+		m.changed()
+	}
 }
 
 // Subsystem returns the identified metric subsystem
@@ -158,7 +335,10 @@ func (m *Metric) Subsystem() string {
 
 // SetSubsystem sets the metric subsystem
 func (m *Metric) SetSubsystem(subsystem string) {
-	m.subsystem = subsystem
+	if m.subsystem != subsystem {
+		m.subsystem = subsystem
+		m.changed()
+	}
 }
 
 // Category returns the metric category
@@ -168,7 +348,10 @@ func (m *Metric) Category() string {
 
 // SetCategory sets the metric category
 func (m *Metric) SetCategory(category string) {
-	m.category = category
+	if m.category != category {
+		m.category = category
+		m.changed()
+	}
 }
 
 // DisplayName returns the display name for this metric
@@ -182,4 +365,19 @@ func (m *Metric) DisplayName() string {
 // SetDisplayName sets a custom display name for this metric
 func (m *Metric) SetDisplayName(displayName string) {
 	m.displayName = displayName
-}
\ No newline at end of file
+}
+
+// Sources returns the inputs the metric was read from
+func (m *Metric) Sources() []string {
+	return m.sources
+}
+
+// AddSource records an input the metric was read from
+func (m *Metric) AddSource(source string) {
+	for _, s := range m.sources {
+		if s == source {
+			return
+		}
+	}
+	m.sources = append(m.sources, source)
+}
@@ -2,6 +2,7 @@ package metrics
 
 import (
 	"reflect"
+	"strconv"
 	"testing"
 )
 
@@ -157,4 +158,99 @@ func TestMetricDisplayName(t *testing.T) {
 	if metric.DisplayName() != "Test Metric" {
 		t.Errorf("Expected display name 'Test Metric', got %q", metric.DisplayName())
 	}
-}
\ No newline at end of file
+}
+func TestMetricQuantiles(t *testing.T) {
+	metric := New("rpc_duration_seconds", "", nil, "summary", "", "s")
+	if metric.Quantiles() != nil {
+		t.Errorf("Expected no quantiles, got %v", metric.Quantiles())
+	}
+
+	for _, quantile := range []string{"0.99", "0.5", "1", "0.5", "0.9", "0"} {
+		metric.AddQuantile(quantile)
+	}
+
+	expected := []string{"0", "0.5", "0.9", "0.99", "1"}
+	quantiles := metric.Quantiles()
+	if len(quantiles) != len(expected) {
+		t.Fatalf("Expected %d quantiles, got %d", len(expected), len(quantiles))
+	}
+	for i, quantile := range expected {
+		if quantiles[i] != quantile {
+			t.Errorf("Expected quantile %q at index %d, got %q", quantile, i, quantiles[i])
+		}
+	}
+}
+
+func TestMetricLabelValues(t *testing.T) {
+	metric := New("http_requests_total", "", nil, "counter", "", "")
+	
+	metric.AddLabelValue("code", "500")
+	metric.AddLabelValue("code", "200")
+	metric.AddLabelValue("code", "200")
+	
+	if !metric.HasLabel("code") {
+		t.Error("Expected AddLabelValue to add the label")
+	}
+	if values := metric.LabelValues("code"); !reflect.DeepEqual(values, []string{"200", "500"}) {
+		t.Errorf("Expected values [200 500], got %v", values)
+	}
+	if cardinality := metric.LabelCardinality("code"); cardinality != 2 {
+		t.Errorf("Expected cardinality 2, got %d", cardinality)
+	}
+	if values := metric.LabelValues("missing"); values != nil {
+		t.Errorf("Expected no values for missing label, got %v", values)
+	}
+}
+
+func TestMetricLabelValuesCapped(t *testing.T) {
+	metric := New("http_requests_total", "", nil, "counter", "", "")
+	unique := maxLabelValues * 3
+	for i := 0; i < unique; i++ {
+		metric.AddLabelValue("request_id", strconv.Itoa(i))
+	}
+	
+	// Values beyond the cap are counted, not kept
+	if values := metric.LabelValues("request_id"); len(values) != maxLabelValues {
+		t.Errorf("Expected %d kept values, got %d", maxLabelValues, len(values))
+	}
+	if cardinality := metric.LabelCardinality("request_id"); cardinality != unique {
+		t.Errorf("Expected cardinality %d, got %d", unique, cardinality)
+	}
+	
+	other := New("http_requests_total", "", nil, "counter", "", "")
+	other.AddLabelValue("request_id", "extra")
+	metric.merge(other)
+	if cardinality := metric.LabelCardinality("request_id"); cardinality != unique+1 {
+		t.Errorf("Expected merged cardinality %d, got %d", unique+1, cardinality)
+	}
+}
+
+func TestMetricSeriesCount(t *testing.T) {
+	metric := New("rpc_duration_seconds", "", nil, "histogram", "", "s")
+	if metric.SeriesCount() != 0 {
+		t.Errorf("Expected 0 series, got %d", metric.SeriesCount())
+	}
+	
+	// Buckets of the same series are counted once
+	for _, le := range []string{"0.1", "1", "+Inf"} {
+		metric.AddSeries(map[string]string{"__name__": "rpc_duration_seconds_bucket", "method": "get", "le": le})
+	}
+	metric.AddSeries(map[string]string{"__name__": "rpc_duration_seconds_count", "method": "get"})
+	metric.AddSeries(map[string]string{"__name__": "rpc_duration_seconds_count", "method": "put"})
+	
+	if metric.SeriesCount() != 2 {
+		t.Errorf("Expected 2 series, got %d", metric.SeriesCount())
+	}
+}
+
+func TestMetricNativeHistogram(t *testing.T) {
+	metric := New("rpc_duration_seconds", "", nil, "histogram", "", "s")
+	if metric.IsNativeHistogram() {
+		t.Errorf("Expected a classic histogram by default")
+	}
+
+	metric.SetNativeHistogram(true)
+	if !metric.IsNativeHistogram() {
+		t.Errorf("Expected a native histogram")
+	}
+}
@@ -2,11 +2,26 @@ package metrics
 
 import (
 	"sort"
+	"sync"
 )
 
-// Registry represents a collection of metrics indexed by name
+// LabelCardinality is the number of distinct values of a label across a registry
+type LabelCardinality struct {
+	Label  string
+	Values int
+}
+
+// Registry represents a collection of metrics indexed by name. It is safe
+// for concurrent use, but its metrics are not: a metric must not be changed
+// while a lookup of a registry holding it runs. The name order and the
+// indexes by type, vendor, subsystem, category and label are built when
+// first needed and cached until the registry or one of its metrics changes.
 type Registry struct {
+	mu      sync.RWMutex
 	metrics map[string]*Metric
+	names   []string       // Sorted names, nil until needed
+	sorted  []*Metric      // Metrics in the order of names
+	index   *registryIndex // Secondary indexes, nil until needed
 }
 
 // NewRegistry creates a new metric registry
@@ -18,97 +33,151 @@ func NewRegistry() *Registry {
 
 // Set adds or replaces a metric in the registry
 func (r *Registry) Set(name string, metric *Metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	r.metrics[name] = metric
+	r.invalidate()
 }
 
 // Get retrieves a metric from the registry
 func (r *Registry) Get(name string) *Metric {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	return r.metrics[name]
 }
 
+// Delete removes a metric from the registry
+func (r *Registry) Delete(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.metrics[name]; exists {
+		delete(r.metrics, name)
+		r.invalidate()
+	}
+}
+
 // Has checks if a metric exists in the registry
 func (r *Registry) Has(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	_, exists := r.metrics[name]
 	return exists
 }
 
 // List returns a sorted list of all metric names
 func (r *Registry) List() []string {
-	list := make([]string, 0, len(r.metrics))
-	for k := range r.metrics {
-		list = append(list, k)
-	}
-	sort.Strings(list)
-	return list
+	names, _ := r.ordered()
+	return append([]string(nil), names...)
 }
 
 // ListByType returns metrics filtered by type
 func (r *Registry) ListByType(metricType string) []*Metric {
-	var result []*Metric
-	for _, name := range r.List() {
-		metric := r.Get(name)
-		if metric.Type() == metricType {
-			result = append(result, metric)
-		}
-	}
-	return result
+	index := r.indexed()
+	return index.metrics(index.byType[metricType])
 }
 
 // Count returns the number of metrics in the registry
 func (r *Registry) Count() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	return len(r.metrics)
 }
 
-// ForEach executes a function for each metric in the registry
+// ForEach executes a function for each metric in the registry in name
+// order. It runs on a snapshot, so fn may modify the registry.
 func (r *Registry) ForEach(fn func(name string, metric *Metric)) {
-	for _, name := range r.List() {
-		fn(name, r.metrics[name])
+	names, sorted := r.ordered()
+	for i, name := range names {
+		fn(name, sorted[i])
 	}
 }
 
 // Filter returns a new registry with metrics that match the filter function
 func (r *Registry) Filter(fn func(name string, metric *Metric) bool) *Registry {
-	result := NewRegistry()
-	r.ForEach(func(name string, metric *Metric) {
-		if fn(name, metric) {
-			result.Set(name, metric)
+	names, sorted := r.ordered()
+	var kept []int
+	for i, name := range names {
+		if fn(name, sorted[i]) {
+			kept = append(kept, i)
 		}
-	})
-	return result
+	}
+	return subset(names, sorted, kept)
 }
 
 // ListByVendor returns metrics filtered by vendor
 func (r *Registry) ListByVendor(vendor string) []*Metric {
-	var result []*Metric
-	for _, name := range r.List() {
-		metric := r.Get(name)
-		if metric.Vendor() == vendor {
-			result = append(result, metric)
-		}
-	}
-	return result
+	index := r.indexed()
+	return index.metrics(index.byVendor[vendor])
+}
+
+// ListBySubsystem returns metrics filtered by subsystem
+func (r *Registry) ListBySubsystem(subsystem string) []*Metric {
+	index := r.indexed()
+	return index.metrics(index.bySubsystem[subsystem])
+}
+
+// ListByCategory returns metrics filtered by category
+func (r *Registry) ListByCategory(category string) []*Metric {
+	index := r.indexed()
+	return index.metrics(index.byCategory[category])
+}
+
+// ListByLabel returns the metrics that have a label
+func (r *Registry) ListByLabel(label string) []*Metric {
+	index := r.indexed()
+	return index.metrics(index.byLabel[label])
 }
 
 // ListVendors returns a list of all vendors found in the metrics
 func (r *Registry) ListVendors() []string {
-	vendors := make(map[string]bool)
-	r.ForEach(func(name string, metric *Metric) {
-		if metric.Vendor() != "" {
-			vendors[metric.Vendor()] = true
-		}
-	})
-	
-	result := make([]string, 0, len(vendors))
-	for vendor := range vendors {
-		result = append(result, vendor)
-	}
-	sort.Strings(result)
-	return result
+	return append([]string{}, r.indexed().vendors...)
 }
 
 // FilterByVendor returns a new registry with metrics that match the vendor
 func (r *Registry) FilterByVendor(vendor string) *Registry {
-	return r.Filter(func(name string, metric *Metric) bool {
-		return metric.Vendor() == vendor
+	index := r.indexed()
+	return subset(index.names, index.sorted, index.byVendor[vendor])
+}
+
+// SeriesCount returns the number of distinct series across all metrics
+func (r *Registry) SeriesCount() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	count := 0
+	for _, metric := range r.metrics {
+		count += metric.SeriesCount()
+	}
+	return count
+}
+
+// HighCardinalityLabels returns the labels with more than n distinct values
+// across all metrics, ordered from the most to the least values. Counts are
+// estimated beyond maxTrackedSeries values.
+func (r *Registry) HighCardinalityLabels(n int) []LabelCardinality {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	values := make(map[string]*sketch)
+	for _, metric := range r.metrics {
+		for label, labelValues := range metric.values {
+			if values[label] == nil {
+				values[label] = &sketch{}
+			}
+			values[label].merge(&labelValues.seen)
+		}
+	}
+	
+	var result []LabelCardinality
+	for label, seen := range values {
+		if count := seen.count(); count > n {
+			result = append(result, LabelCardinality{Label: label, Values: count})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Values != result[j].Values {
+			return result[i].Values > result[j].Values
+		}
+		return result[i].Label < result[j].Label
 	})
-}
\ No newline at end of file
+	
+	return result
+}
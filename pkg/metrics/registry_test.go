@@ -206,4 +206,44 @@ func TestRegistryVendorFunctions(t *testing.T) {
 	if !vendor2Registry.Has("vendor2_metric1") {
 		t.Errorf("FilterByVendor missing expected metric")
 	}
-}
\ No newline at end of file
+}
+func TestRegistryCardinality(t *testing.T) {
+	registry := NewRegistry()
+	
+	m1 := New("requests_total", "", nil, "counter", "", "")
+	for _, code := range []string{"200", "404", "500"} {
+		m1.AddLabelValue("code", code)
+		m1.AddLabelValue("path", "/")
+		m1.AddSeries(map[string]string{"code": code, "path": "/"})
+	}
+	
+	m2 := New("errors_total", "", nil, "counter", "", "")
+	for _, code := range []string{"500", "503"} {
+		m2.AddLabelValue("code", code)
+		m2.AddSeries(map[string]string{"code": code})
+	}
+	
+	registry.Set(m1.Name(), m1)
+	registry.Set(m2.Name(), m2)
+	
+	// Test SeriesCount
+	if count := registry.SeriesCount(); count != 5 {
+		t.Errorf("Expected 5 series, got %d", count)
+	}
+	
+	// Values are merged across metrics
+	expected := []LabelCardinality{{Label: "code", Values: 4}}
+	if labels := registry.HighCardinalityLabels(1); !reflect.DeepEqual(labels, expected) {
+		t.Errorf("Expected high cardinality labels %v, got %v", expected, labels)
+	}
+	
+	// Ordered from the most values
+	expected = []LabelCardinality{{Label: "code", Values: 4}, {Label: "path", Values: 1}}
+	if labels := registry.HighCardinalityLabels(0); !reflect.DeepEqual(labels, expected) {
+		t.Errorf("Expected high cardinality labels %v, got %v", expected, labels)
+	}
+	
+	if labels := registry.HighCardinalityLabels(4); labels != nil {
+		t.Errorf("Expected no high cardinality labels, got %v", labels)
+	}
+}
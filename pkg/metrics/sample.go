@@ -0,0 +1,241 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// Sample holds the values observed for a single series
+type Sample struct {
+	Last      float64 // Most recently observed value
+	Min       float64 // Lowest observed value
+	Max       float64 // Highest observed value
+	Timestamp int64   // Timestamp of the last value in milliseconds, 0 if not exposed
+}
+
+// valueStats summarizes the values observed across all series of a metric
+type valueStats struct {
+	observed  bool    // Whether any value was observed
+	ranged    bool    // Whether any value other than NaN was observed
+	min, max  float64 // Range of the values other than NaN
+	nonBinary bool    // Whether any value was other than 0 or 1
+}
+
+// add records an observed value
+func (s *valueStats) add(value float64) {
+	s.observed = true
+	s.nonBinary = s.nonBinary || (value != 0 && value != 1)
+	if math.IsNaN(value) {
+		return
+	}
+	if !s.ranged {
+		s.min, s.max, s.ranged = value, value, true
+		return
+	}
+	s.min = math.Min(s.min, value)
+	s.max = math.Max(s.max, value)
+}
+
+// merge combines the values observed by another reading of the metric
+func (s *valueStats) merge(other valueStats) {
+	if other.ranged {
+		s.add(other.min)
+		s.add(other.max)
+	}
+	s.observed = s.observed || other.observed
+	s.nonBinary = s.nonBinary || other.nonBinary
+}
+
+// ValueKind describes what the sample values of a metric represent
+type ValueKind int
+
+// Value kinds detected from sample values
+const (
+	ValueUnknown ValueKind = iota // No samples were observed
+	ValueNumber                   // Arbitrary numeric values
+	ValueRatio                    // A 0-1 ratio
+	ValuePercent                  // A 0-100 percentage
+	ValueBoolean                  // An up-style 0/1 state
+	ValueInfo                     // A constant 1 carrying information in its labels
+)
+
+// String returns a readable name for the value kind
+func (k ValueKind) String() string {
+	switch k {
+	case ValueNumber:
+		return "number"
+	case ValueRatio:
+		return "ratio"
+	case ValuePercent:
+		return "percent"
+	case ValueBoolean:
+		return "boolean"
+	case ValueInfo:
+		return "info"
+	default:
+		return "unknown"
+	}
+}
+
+// booleanSuffixes are metric name endings of 0/1 state gauges
+var booleanSuffixes = []string{"_up", "_ready", "_healthy", "_enabled", "_available", "_success", "_reachable", "_connected"}
+
+// ratioHints are metric name parts of gauges that may hold a 0-1 ratio
+var ratioHints = []string{"ratio", "fraction", "percent", "utilization"}
+
+// percentHints are metric name parts of gauges that may hold a 0-100 percentage
+var percentHints = []string{"percent", "pct", "utilization"}
+
+// AddSample records a value of the series with the given labels.
+// The timestamp is in milliseconds and 0 when the exposition has none.
+// Values count towards the value range of the metric, but only the samples
+// of up to maxTrackedSeries series are kept and no labels are retained, so
+// parsers may reuse the map.
+func (m *Metric) AddSample(labels map[string]string, value float64, timestamp int64) {
+	_, key := signatures(labels)
+	m.addSample(labels["__name__"], key, value, timestamp)
+}
+
+// addSample records a value of the series with the given name and full
+// signature
+func (m *Metric) addSample(name string, key uint64, value float64, timestamp int64) {
+	m.stats.add(value)
+	if name != "" && !m.names[name] {
+		if m.names == nil {
+			m.names = make(map[string]bool)
+		}
+		m.names[name] = true
+	}
+
+	kept, evicted, ok := m.sampled.add(key)
+	if ok {
+		delete(m.samples, evicted)
+	}
+	if !kept {
+		return
+	}
+
+	if m.samples == nil {
+		m.samples = make(map[uint64]*Sample)
+	}
+	sample, exists := m.samples[key]
+	if !exists {
+		m.samples[key] = &Sample{
+			Last:      value,
+			Min:       value,
+			Max:       value,
+			Timestamp: timestamp,
+		}
+		return
+	}
+
+	sample.Last = value
+	sample.Timestamp = timestamp
+	sample.widen(value)
+}
+
+// widen extends the range of a sample by a value. NaN is skipped, so the
+// range is only NaN while nothing but NaN was observed.
+func (s *Sample) widen(value float64) {
+	if math.IsNaN(value) {
+		return
+	}
+	if math.IsNaN(s.Min) || value < s.Min {
+		s.Min = value
+	}
+	if math.IsNaN(s.Max) || value > s.Max {
+		s.Max = value
+	}
+}
+
+// Samples returns the kept samples ordered by series signature
+func (m *Metric) Samples() []Sample {
+	if len(m.samples) == 0 {
+		return nil
+	}
+
+	keys := make([]uint64, 0, len(m.samples))
+	for key := range m.samples {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	samples := make([]Sample, 0, len(keys))
+	for _, key := range keys {
+		samples = append(samples, *m.samples[key])
+	}
+	return samples
+}
+
+// ValueRange returns the lowest and highest value observed across all
+// series, ok is false when no finite values were observed
+func (m *Metric) ValueRange() (min, max float64, ok bool) {
+	if !m.stats.ranged || math.IsInf(m.stats.min, 0) || math.IsInf(m.stats.max, 0) {
+		return 0, 0, false
+	}
+	return m.stats.min, m.stats.max, true
+}
+
+// ValueKind classifies the metric from its type, name and observed values.
+// OpenMetrics info and stateset types are classified by type alone, other
+// than those only gauges and untyped metrics are more than a plain number.
+func (m *Metric) ValueKind() ValueKind {
+	min, max, ok := m.ValueRange()
+	if !ok {
+		return ValueUnknown
+	}
+
+	switch m.mtype {
+	case "info":
+		return ValueInfo
+	case "stateset":
+		return ValueBoolean
+	case "gauge", "untyped", "unknown", "":
+	default:
+		return ValueNumber
+	}
+
+	name := strings.ToLower(m.name)
+	switch {
+	case strings.HasSuffix(name, "_info") && min == 1 && max == 1:
+		return ValueInfo
+	case m.onlyBinaryValues() && m.looksBoolean(name):
+		return ValueBoolean
+	case containsAny(name, ratioHints) && min >= 0 && max <= 1:
+		return ValueRatio
+	case containsAny(name, percentHints) && min >= 0 && max <= 100:
+		return ValuePercent
+	}
+	return ValueNumber
+}
+
+// onlyBinaryValues reports whether every observed value is 0 or 1
+func (m *Metric) onlyBinaryValues() bool {
+	return m.stats.observed && !m.stats.nonBinary
+}
+
+// looksBoolean reports whether the name or help describes a 0/1 state
+func (m *Metric) looksBoolean(name string) bool {
+	if name == "up" {
+		return true
+	}
+	for _, suffix := range booleanSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+
+	help := strings.ToLower(m.help)
+	return strings.HasPrefix(help, "whether") || strings.Contains(help, "1 if") || strings.Contains(help, "boolean")
+}
+
+// containsAny reports whether s contains any of the substrings
+func containsAny(s string, substrings []string) bool {
+	for _, sub := range substrings {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestMetricAddSample(t *testing.T) {
+	metric := New("queue_length", "", nil, "gauge", "", "")
+	labels := map[string]string{"__name__": "queue_length", "queue": "a"}
+
+	metric.AddSample(labels, 5, 1000)
+	metric.AddSample(labels, 2, 2000)
+	metric.AddSample(labels, 3, 3000)
+	metric.AddSample(map[string]string{"__name__": "queue_length", "queue": "b"}, 10, 0)
+
+	samples := metric.Samples()
+	if len(samples) != 2 {
+		t.Fatalf("Expected 2 samples, got %d", len(samples))
+	}
+
+	a := samples[0]
+	if a.Last == 10 {
+		a = samples[1]
+	}
+	if a.Last != 3 || a.Min != 2 || a.Max != 5 || a.Timestamp != 3000 {
+		t.Errorf("Unexpected sample %+v", a)
+	}
+
+	// Parsers reuse their label map
+	labels["queue"] = "c"
+	metric.AddSample(labels, 1, 0)
+	if len(metric.Samples()) != 3 {
+		t.Errorf("Expected a new sample for the reused label map")
+	}
+
+	min, max, ok := metric.ValueRange()
+	if !ok || min != 1 || max != 10 {
+		t.Errorf("ValueRange() = %v, %v, %v; want 1, 10, true", min, max, ok)
+	}
+}
+
+func TestMetricAddSampleNaN(t *testing.T) {
+	metric := New("queue_length", "", nil, "gauge", "", "")
+	a := map[string]string{"__name__": "queue_length", "queue": "a"}
+	b := map[string]string{"__name__": "queue_length", "queue": "b"}
+	metric.AddSample(a, 5, 0)
+	metric.AddSample(a, math.NaN(), 0)
+	metric.AddSample(a, 3, 0)
+	metric.AddSample(b, math.NaN(), 0)
+	metric.AddSample(b, 4, 0)
+
+	want := map[float64]Sample{
+		3: {Last: 3, Min: 3, Max: 5},
+		4: {Last: 4, Min: 4, Max: 4},
+	}
+	for _, sample := range metric.Samples() {
+		if sample != want[sample.Last] {
+			t.Errorf("Expected NaN to be left out of the range, got %+v", sample)
+		}
+	}
+}
+
+func TestMetricAddSampleManySeries(t *testing.T) {
+	metric := New("requests_total", "", nil, "counter", "", "")
+	n := maxTrackedSeries * 4
+	for i := 0; i < n; i++ {
+		labels := map[string]string{"__name__": "requests_total", "path": fmt.Sprintf("/api/%d", i)}
+		metric.AddSeries(labels)
+		metric.AddSample(labels, float64(i), 0)
+	}
+
+	if samples := metric.Samples(); len(samples) != maxTrackedSeries {
+		t.Errorf("Expected %d kept samples, got %d", maxTrackedSeries, len(samples))
+	}
+	if min, max, ok := metric.ValueRange(); !ok || min != 0 || max != float64(n-1) {
+		t.Errorf("ValueRange() = %v, %v, %v; want the range of all series", min, max, ok)
+	}
+	if count := metric.SeriesCount(); math.Abs(float64(count-n)) > 0.1*float64(n) {
+		t.Errorf("SeriesCount() = %d; want about %d", count, n)
+	}
+}
+
+func TestMetricValueRangeWithoutSamples(t *testing.T) {
+	metric := New("queue_length", "", nil, "gauge", "", "")
+	if _, _, ok := metric.ValueRange(); ok {
+		t.Error("Expected no value range without samples")
+	}
+
+	metric.AddSample(map[string]string{"__name__": "queue_length"}, math.NaN(), 0)
+	if _, _, ok := metric.ValueRange(); ok {
+		t.Error("Expected NaN values to be ignored")
+	}
+}
+
+func TestMetricValueKind(t *testing.T) {
+	tests := []struct {
+		name   string
+		help   string
+		mtype  string
+		values []float64
+		want   ValueKind
+	}{
+		{"no_samples", "", "gauge", nil, ValueUnknown},
+		{"up", "", "gauge", []float64{1, 0}, ValueBoolean},
+		{"node_ready", "", "gauge", []float64{1}, ValueBoolean},
+		{"node_manager", "Whether this node is a manager or not", "gauge", []float64{0}, ValueBoolean},
+		{"node_ready", "", "gauge", []float64{2}, ValueNumber},
+		{"build_info", "", "gauge", []float64{1}, ValueInfo},
+		{"cpu_utilization", "", "gauge", []float64{0.25, 0.75}, ValueRatio},
+		{"disk_used_percent", "", "gauge", []float64{12, 87.5}, ValuePercent},
+		{"compression_ratio", "", "gauge", []float64{3.2}, ValueNumber},
+		{"requests_total", "", "counter", []float64{1}, ValueNumber},
+		{"temperature_celsius", "", "gauge", []float64{0, 1}, ValueNumber},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metric := New(tt.name, tt.help, nil, tt.mtype, "", "")
+			for i, value := range tt.values {
+				metric.AddSample(map[string]string{"__name__": tt.name, "i": string(rune('a' + i))}, value, 0)
+			}
+			if kind := metric.ValueKind(); kind != tt.want {
+				t.Errorf("ValueKind() = %v; want %v", kind, tt.want)
+			}
+		})
+	}
+}
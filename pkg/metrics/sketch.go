@@ -0,0 +1,134 @@
+package metrics
+
+import (
+	"container/heap"
+	"math"
+	"slices"
+	"sync"
+)
+
+// maxTrackedSeries bounds the series signatures and samples kept per metric,
+// so memory stays flat however many series an exposition has. Up to this
+// many series are counted exactly, beyond it the count is estimated.
+const maxTrackedSeries = 4096
+
+// FNV-1a parameters of the signatures
+const (
+	offset64 = 14695981039346656037
+	prime64  = 1099511628211
+)
+
+// namesPool holds the buffers label names are sorted in while signing
+var namesPool = sync.Pool{New: func() any { return new([]string) }}
+
+// signatures hashes a label set in full and as a series, without its name
+// and the labels that split a single histogram or summary observation. The
+// labels are sorted once in a pooled buffer, so signing doesn't allocate.
+func signatures(labels map[string]string) (series, full uint64) {
+	buf := namesPool.Get().(*[]string)
+	names := (*buf)[:0]
+	for name := range labels {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	series, full = offset64, offset64
+	for _, name := range names {
+		value := labels[name]
+		full = hashLabel(full, name, value)
+		if name != "__name__" && name != "le" && name != "quantile" {
+			series = hashLabel(series, name, value)
+		}
+	}
+
+	clear(names)
+	*buf = names
+	namesPool.Put(buf)
+	return series, full
+}
+
+// hashLabel adds a label to a signature
+func hashLabel(h uint64, name, value string) uint64 {
+	h = hashString(h, name)
+	h = (h ^ '=') * prime64
+	h = hashString(h, value)
+	return (h ^ 0xff) * prime64
+}
+
+// hashString adds the bytes of a string to a signature
+func hashString(h uint64, s string) uint64 {
+	for i := 0; i < len(s); i++ {
+		h = (h ^ uint64(s[i])) * prime64
+	}
+	return h
+}
+
+// sketch keeps the smallest of the signatures added to it, at most
+// maxTrackedSeries. The smallest hashes are a uniform sample of all of
+// them, which makes the kept series the same from one scrape to the next
+// and gives an estimate of the number of distinct signatures.
+type sketch struct {
+	kept    map[uint64]bool
+	top     hashHeap // The kept signatures, largest first
+	dropped bool     // Whether any signature was dropped or evicted
+}
+
+// add records a signature. It returns whether the signature is kept and the
+// signature it evicted, if any.
+func (s *sketch) add(sig uint64) (kept bool, evicted uint64, ok bool) {
+	if s.kept == nil {
+		s.kept = make(map[uint64]bool)
+	}
+	if s.kept[sig] {
+		return true, 0, false
+	}
+	if len(s.top) < maxTrackedSeries {
+		s.kept[sig] = true
+		heap.Push(&s.top, sig)
+		return true, 0, false
+	}
+	s.dropped = true
+	if sig >= s.top[0] {
+		return false, 0, false
+	}
+
+	evicted = s.top[0]
+	delete(s.kept, evicted)
+	s.kept[sig] = true
+	s.top[0] = sig
+	heap.Fix(&s.top, 0)
+	return true, evicted, true
+}
+
+// merge adds the signatures kept by another sketch. The smallest of both
+// are the smallest of the union, so the estimate stays valid.
+func (s *sketch) merge(other *sketch) {
+	for sig := range other.kept {
+		s.add(sig)
+	}
+	s.dropped = s.dropped || other.dropped
+}
+
+// count returns the number of distinct signatures added, exact up to
+// maxTrackedSeries and estimated from the largest kept one beyond
+func (s *sketch) count() int {
+	if !s.dropped {
+		return len(s.top)
+	}
+	estimate := float64(maxTrackedSeries-1) / (float64(s.top[0]) / math.MaxUint64)
+	return int(math.Min(math.Max(estimate, maxTrackedSeries), math.MaxInt32))
+}
+
+// hashHeap is a max-heap of signatures
+type hashHeap []uint64
+
+func (h hashHeap) Len() int           { return len(h) }
+func (h hashHeap) Less(i, j int) bool { return h[i] > h[j] }
+func (h hashHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *hashHeap) Push(x any)        { *h = append(*h, x.(uint64)) }
+func (h *hashHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"testing"
+)
+
+func TestSketch(t *testing.T) {
+	var s sketch
+	for i := uint64(0); i < maxTrackedSeries; i++ {
+		if kept, _, evicted := s.add(i + 10); !kept || evicted {
+			t.Fatalf("Expected signature %d to be kept without eviction", i+10)
+		}
+	}
+	if kept, _, _ := s.add(10); !kept || s.count() != maxTrackedSeries {
+		t.Errorf("Expected a repeated signature to be counted once, got %d", s.count())
+	}
+
+	// Larger signatures than all kept ones are dropped
+	if kept, _, _ := s.add(1 << 62); kept {
+		t.Error("Expected a large signature to be dropped")
+	}
+
+	// Smaller ones replace the largest
+	kept, evicted, ok := s.add(1)
+	if !kept || !ok || evicted != maxTrackedSeries+9 {
+		t.Errorf("add(1) = %v, %d, %v; want the largest signature evicted", kept, evicted, ok)
+	}
+	if len(s.kept) != maxTrackedSeries || s.kept[maxTrackedSeries+9] {
+		t.Errorf("Expected %d kept signatures without the evicted one", maxTrackedSeries)
+	}
+}
+
+func TestSignatures(t *testing.T) {
+	a := map[string]string{"__name__": "up", "job": "node", "instance": "a"}
+	b := map[string]string{"instance": "a", "job": "node", "__name__": "up"}
+	aSeries, aFull := signatures(a)
+	bSeries, bFull := signatures(b)
+	if aSeries != bSeries || aFull != bFull {
+		t.Error("Expected the same signatures regardless of map order")
+	}
+	if _, full := signatures(map[string]string{"__name__": "up", "job": "nodei", "instance": ""}); full == aFull {
+		t.Error("Expected label boundaries to be part of the signature")
+	}
+	if series, full := signatures(map[string]string{"__name__": "up_bucket", "job": "node", "instance": "a", "le": "1"}); series != aSeries || full == aFull {
+		t.Error("Expected the series signature to skip the name and le")
+	}
+	if allocs := testing.AllocsPerRun(100, func() { signatures(a) }); allocs != 0 {
+		t.Errorf("Expected signing not to allocate, got %v allocations", allocs)
+	}
+}
@@ -0,0 +1,167 @@
+package metrics
+
+import (
+	"regexp"
+	"strings"
+)
+
+// unitWords maps unit words of metric names, OpenMetrics # UNIT lines and
+// help text to Grafana units. Prometheus base units are listed with the
+// prefixes Grafana has a unit for, plus common abbreviations.
+var unitWords = map[string]string{
+	// Time
+	"seconds":      "s",
+	"milliseconds": "ms",
+	"microseconds": "µs",
+	"nanoseconds":  "ns",
+	"minutes":      "m",
+	"hours":        "h",
+	"days":         "d",
+	"secs":         "s",
+	"ms":           "ms",
+
+	// Data
+	"bytes":     "decbytes",
+	"kilobytes": "deckbytes",
+	"megabytes": "decmbytes",
+	"gigabytes": "decgbytes",
+	"terabytes": "dectbytes",
+	"kibibytes": "kbytes",
+	"mebibytes": "mbytes",
+	"gibibytes": "gbytes",
+	"tebibytes": "tbytes",
+	"bits":      "decbits",
+	"packets":   "suffix: packets",
+
+	// Energy and electricity
+	"joules":       "joule",
+	"watts":        "watt",
+	"kilowatts":    "kwatt",
+	"milliwatts":   "mwatt",
+	"volts":        "volt",
+	"millivolts":   "mvolt",
+	"kilovolts":    "kvolt",
+	"amperes":      "amp",
+	"amps":         "amp",
+	"milliamperes": "mamp",
+	"hertz":        "hertz",
+	"kilohertz":    "khertz",
+	"megahertz":    "mhertz",
+	"gigahertz":    "ghertz",
+	"celsius":      "celsius",
+	"fahrenheit":   "fahrenheit",
+	"kelvin":       "kelvin",
+	"meters":       "lengthm",
+	"millimeters":  "lengthmm",
+	"kilometers":   "lengthkm",
+	"grams":        "massg",
+	"milligrams":   "massmg",
+	"kilograms":    "masskg",
+
+	// Dimensionless
+	"ratio":      "percentunit",
+	"percent":    "percent",
+	"percentage": "percent",
+	"pct":        "percent",
+	"info":       "none",
+}
+
+// rateUnits maps Grafana units to the unit of their per-second rate. Time
+// spent per second is the fraction of time busy, and energy per second is
+// power. Units missing here keep their unit when rated.
+var rateUnits = map[string]string{
+	"s":               "percentunit",
+	"ms":              "suffix: ms/s",
+	"µs":              "suffix: µs/s",
+	"ns":              "suffix: ns/s",
+	"decbytes":        "Bps",
+	"deckbytes":       "KBs",
+	"decmbytes":       "MBs",
+	"decgbytes":       "GBs",
+	"dectbytes":       "TBs",
+	"bytes":           "binBps",
+	"kbytes":          "KiBs",
+	"mbytes":          "MiBs",
+	"gbytes":          "GiBs",
+	"tbytes":          "TiBs",
+	"decbits":         "bps",
+	"bits":            "binbps",
+	"suffix: packets": "pps",
+	"joule":           "watt",
+	"watth":           "watt",
+	"kwatth":          "kwatt",
+}
+
+// nameTrailers are name tokens following the unit of a metric, e.g. the
+// _total of counters
+var nameTrailers = map[string]bool{"total": true, "created": true}
+
+// helpUnitPattern matches phrases of help text stating a unit, e.g.
+// "Resident memory size in bytes." or "Time spent (seconds)"
+var helpUnitPattern = regexp.MustCompile(`(?i)(?:\b(?:in|measured in|in units of|number of|unit:)\s+|\()([a-zµ]+)\b`)
+
+// unitWord returns the Grafana unit of a unit word, accepting singular
+// words like "second"
+func unitWord(word string) (string, bool) {
+	word = strings.ToLower(word)
+	if unit, ok := unitWords[word]; ok {
+		return unit, true
+	}
+	if len(word) < 3 {
+		return "", false
+	}
+	unit, ok := unitWords[word+"s"]
+	return unit, ok
+}
+
+// GrafanaUnit converts an OpenMetrics unit, e.g. from # UNIT, to a Grafana
+// unit
+func GrafanaUnit(unit string) (string, bool) {
+	if unit == "" {
+		return "", false
+	}
+	return unitWord(unit)
+}
+
+// UnitFromName derives the Grafana unit of a metric from its name, which by
+// Prometheus convention ends with the unit before any _total, e.g.
+// node_network_receive_bytes_total. A name ending with _per_second is a rate
+// of the unit before it.
+func UnitFromName(name string) (string, bool) {
+	tokens := strings.Split(strings.Trim(name, "_"), "_")
+	for len(tokens) > 1 && nameTrailers[tokens[len(tokens)-1]] {
+		tokens = tokens[:len(tokens)-1]
+	}
+	if n := len(tokens); n > 3 && tokens[n-2] == "per" && strings.HasPrefix(tokens[n-1], "second") {
+		if unit, ok := unitWord(tokens[n-3]); ok {
+			if rate, ok := rateUnits[unit]; ok {
+				return rate, true
+			}
+		}
+		return "", false
+	}
+	if len(tokens) < 2 {
+		return "", false
+	}
+	return unitWord(tokens[len(tokens)-1])
+}
+
+// UnitFromHelp derives the Grafana unit of a metric from phrases of its help
+// text, e.g. "in bytes", "number of packets" or "(seconds)"
+func UnitFromHelp(help string) (string, bool) {
+	for _, match := range helpUnitPattern.FindAllStringSubmatch(help, -1) {
+		if unit, ok := unitWord(match[1]); ok {
+			return unit, true
+		}
+	}
+	return "", false
+}
+
+// RateUnit returns the unit of the per-second rate of values in unit, e.g.
+// Bps for decbytes and percentunit for seconds
+func RateUnit(unit string) string {
+	if rate, ok := rateUnits[unit]; ok {
+		return rate
+	}
+	return unit
+}
@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"testing"
+)
+
+func TestUnitFromName(t *testing.T) {
+	tests := []struct {
+		name string
+		unit string
+	}{
+		{"process_cpu_seconds_total", "s"},
+		{"request_duration_milliseconds_total", "ms"},
+		{"gc_pause_nanoseconds", "ns"},
+		{"node_network_receive_bytes_total", "decbytes"},
+		{"disk_size_kibibytes", "kbytes"},
+		{"transfer_gigabytes", "decgbytes"},
+		{"link_speed_bits", "decbits"},
+		{"interface_packets_total", "suffix: packets"},
+		{"rapl_energy_joules_total", "joule"},
+		{"psu_input_volts", "volt"},
+		{"psu_output_amperes", "amp"},
+		{"cpu_frequency_megahertz", "mhertz"},
+		{"node_hwmon_temp_celsius", "celsius"},
+		{"memory_usage_ratio", "percentunit"},
+		{"disk_used_percent", "percent"},
+		{"build_info", "none"},
+		{"network_bytes_per_second", "Bps"},
+		{"uptime_second", "s"},
+		{"jobs_total", ""},
+		{"thread_count", ""},
+		{"seconds", ""},
+		{"load_m", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unit, ok := UnitFromName(tt.name)
+			if unit != tt.unit || ok != (tt.unit != "") {
+				t.Errorf("UnitFromName(%q) = %q, %v; want %q", tt.name, unit, ok, tt.unit)
+			}
+		})
+	}
+}
+
+func TestUnitFromHelp(t *testing.T) {
+	tests := []struct {
+		help string
+		unit string
+	}{
+		{"Resident memory size in bytes.", "decbytes"},
+		{"Total user and system CPU time spent in seconds.", "s"},
+		{"Number of packets received.", "suffix: packets"},
+		{"Time waiting for the lock (milliseconds).", "ms"},
+		{"Board temperature in Celsius.", "celsius"},
+		{"Connections in use.", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.help, func(t *testing.T) {
+			unit, ok := UnitFromHelp(tt.help)
+			if unit != tt.unit || ok != (tt.unit != "") {
+				t.Errorf("UnitFromHelp(%q) = %q, %v; want %q", tt.help, unit, ok, tt.unit)
+			}
+		})
+	}
+}
+
+func TestGrafanaUnit(t *testing.T) {
+	for unit, want := range map[string]string{"seconds": "s", "bytes": "decbytes", "ratio": "percentunit", "kilowatts": "kwatt"} {
+		if got, ok := GrafanaUnit(unit); !ok || got != want {
+			t.Errorf("GrafanaUnit(%q) = %q, %v; want %q", unit, got, ok, want)
+		}
+	}
+	if _, ok := GrafanaUnit("furlongs"); ok {
+		t.Errorf("Expected no Grafana unit for an unknown unit")
+	}
+}
+
+func TestRateUnit(t *testing.T) {
+	tests := map[string]string{
+		"decbytes":        "Bps",
+		"kbytes":          "KiBs",
+		"decbits":         "bps",
+		"s":               "percentunit",
+		"ms":              "suffix: ms/s",
+		"suffix: packets": "pps",
+		"joule":           "watt",
+		"short":           "short",
+		"celsius":         "celsius",
+	}
+	for unit, want := range tests {
+		if got := RateUnit(unit); got != want {
+			t.Errorf("RateUnit(%q) = %q; want %q", unit, got, want)
+		}
+	}
+}
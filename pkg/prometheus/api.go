@@ -0,0 +1,198 @@
+package prometheus
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hemzaz/lazydash/internal/config"
+	"github.com/hemzaz/lazydash/internal/util"
+	"github.com/hemzaz/lazydash/pkg/metrics"
+	"github.com/rs/zerolog/log"
+)
+
+// AllSeries is the selector used when no --match selector is given
+const AllSeries = `{__name__=~".+"}`
+
+// SeriesWindow is how far back series are listed. Without a window
+// Prometheus searches every block of its TSDB, which on large servers is
+// slow and lists series that stopped long ago.
+const SeriesWindow = time.Hour
+
+// apiResponse is the envelope of every Prometheus HTTP API response
+type apiResponse struct {
+	Status    string          `json:"status"`
+	Data      json.RawMessage `json:"data"`
+	ErrorType string          `json:"errorType"`
+	Error     string          `json:"error"`
+}
+
+// apiMetadata is the metadata of a metric family from /api/v1/metadata
+type apiMetadata struct {
+	Type string `json:"type"`
+	Help string `json:"help"`
+	Unit string `json:"unit"`
+}
+
+// APISource builds a metric registry from a Prometheus server's HTTP API
+type APISource struct {
+	baseURL            string
+	match              string
+	insecureSkipVerify bool
+}
+
+// NewAPISource creates a source for the Prometheus server at baseURL, e.g.
+// http://prometheus:9090, limited to the series matching the selector
+func NewAPISource(baseURL, match string, insecureSkipVerify bool) *APISource {
+	if match == "" {
+		log.Warn().Str("match", AllSeries).Msg("No --match selector, listing every series of the last hour")
+		match = AllSeries
+	}
+	return &APISource{
+		baseURL:            strings.TrimSuffix(baseURL, "/"),
+		match:              match,
+		insecureSkipVerify: insecureSkipVerify,
+	}
+}
+
+// LoadFromAPI builds a metric registry from the Prometheus server configured with --prometheus-url
+func LoadFromAPI(cfg *config.Config) (*metrics.Registry, error) {
+	return NewAPISource(cfg.PrometheusURL, cfg.Match, cfg.InsecureSkipVerify).Registry(cfg)
+}
+
+// Registry fetches the metric names, labels, series and metadata matching
+// the selector and describes them like parsed exposition. The API exposes
+// no sample values, so value-based units and thresholds are not derived.
+func (s *APISource) Registry(cfg *config.Config) (*metrics.Registry, error) {
+	var labels []string
+	if err := s.get("/api/v1/labels", s.matchParams(), &labels); err != nil {
+		return nil, err
+	}
+	if len(labels) == 0 {
+		return nil, fmt.Errorf("no series match %s", s.match)
+	}
+
+	var names []string
+	if err := s.get("/api/v1/label/__name__/values", s.matchParams(), &names); err != nil {
+		return nil, err
+	}
+
+	var metadata map[string][]apiMetadata
+	if err := s.get("/api/v1/metadata", nil, &metadata); err != nil {
+		return nil, err
+	}
+
+	var series []map[string]string
+	if err := s.get("/api/v1/series", s.matchParams(), &series); err != nil {
+		return nil, err
+	}
+
+	log.Info().Str("match", s.match).
+		Int("metrics", len(names)).
+		Int("labels", len(labels)).
+		Int("series", len(series)).
+		Msg("Loaded metrics from Prometheus API")
+
+	registry := metrics.NewRegistry()
+	annotator := newAnnotator(cfg)
+
+	for _, name := range names {
+		family, suffix := apiFamily(registry, metadata, name)
+		metric := familyMetric(registry, family)
+		if meta, ok := metadata[family]; ok && len(meta) > 0 && metric.Type() == "" {
+			typ := meta[0].Type
+			if typ == "unknown" {
+				typ = "untyped"
+			}
+			metric.SetType(typ)
+			metric.SetHelp(meta[0].Help)
+			annotator.declareUnit(metric, meta[0].Unit)
+		}
+		if suffix != "_created" {
+			metric.SetSuffix(suffix)
+		}
+		annotator.annotate(metric)
+	}
+
+	for _, labelmap := range series {
+		name, suffix := apiFamily(registry, metadata, labelmap["__name__"])
+		metric := registry.Get(name)
+		if metric == nil {
+			continue
+		}
+
+		for k, v := range labelmap {
+			if k != "__name__" && k != "" {
+				metric.AddLabelValue(k, v)
+			}
+		}
+		if suffix == "_created" {
+			continue
+		}
+		metric.AddSeries(labelmap)
+		if quantile, ok := labelmap["quantile"]; ok && suffix == "" {
+			metric.AddQuantile(quantile)
+		}
+	}
+
+	annotator.finish(registry)
+	return registry, nil
+}
+
+// apiFamily returns the metric family of a series name and the suffix removed
+// to find it. Metadata names the families of OpenMetrics targets without
+// their _total suffix, so suffixes are only removed for known families.
+func apiFamily(registry *metrics.Registry, metadata map[string][]apiMetadata, name string) (string, string) {
+	if _, ok := metadata[name]; ok {
+		return name, ""
+	}
+
+	suffixes := append([]string{"_bucket", "_sum", "_count"}, familySuffixes...)
+	for _, suffix := range suffixes {
+		family := strings.TrimSuffix(name, suffix)
+		if _, ok := metadata[family]; ok && family != name {
+			return family, suffix
+		}
+	}
+
+	return resolveFamily(registry, name, false)
+}
+
+// matchParams returns the query parameters limiting a request to the
+// selector and the series of the last SeriesWindow
+func (s *APISource) matchParams() url.Values {
+	end := time.Now()
+	return url.Values{
+		"match[]": []string{s.match},
+		"start":   []string{strconv.FormatInt(end.Add(-SeriesWindow).Unix(), 10)},
+		"end":     []string{strconv.FormatInt(end.Unix(), 10)},
+	}
+}
+
+// get calls an API endpoint and decodes the data of a successful response
+func (s *APISource) get(path string, params url.Values, data interface{}) error {
+	endpoint := s.baseURL + path
+	if len(params) > 0 {
+		endpoint += "?" + params.Encode()
+	}
+
+	body, err := util.FetchURL(endpoint, s.insecureSkipVerify)
+	if err != nil {
+		return err
+	}
+
+	var resp apiResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return fmt.Errorf("failed to decode response from %s: %w", path, err)
+	}
+	if resp.Status != "success" {
+		return fmt.Errorf("prometheus API %s failed: %s: %s", path, resp.ErrorType, resp.Error)
+	}
+	if err := json.Unmarshal(resp.Data, data); err != nil {
+		return fmt.Errorf("failed to decode data from %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package prometheus
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hemzaz/lazydash/internal/config"
+)
+
+// prometheusAPI stands in for a Prometheus server. Every endpoint that
+// accepts match[] must be given the expected selector and the series window.
+func prometheusAPI(t *testing.T, match string, data map[string]interface{}) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result, ok := data[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Path != "/api/v1/metadata" && r.URL.Query().Get("match[]") != match {
+			t.Errorf("%s called with match[] %q; want %q", r.URL.Path, r.URL.Query().Get("match[]"), match)
+		}
+		if r.URL.Path != "/api/v1/metadata" {
+			start, _ := strconv.ParseInt(r.URL.Query().Get("start"), 10, 64)
+			end, _ := strconv.ParseInt(r.URL.Query().Get("end"), 10, 64)
+			if end-start != int64(SeriesWindow/time.Second) || time.Since(time.Unix(end, 0)) > time.Minute {
+				t.Errorf("%s called with start %q and end %q; want the last %s", r.URL.Path, r.URL.Query().Get("start"), r.URL.Query().Get("end"), SeriesWindow)
+			}
+		}
+
+		body, _ := json.Marshal(map[string]interface{}{"status": "success", "data": result})
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// nodeAPIData is the API data of a job exposing a counter, a histogram and a summary
+var nodeAPIData = map[string]interface{}{
+	"/api/v1/labels": []string{"__name__", "code", "job", "le", "quantile"},
+	"/api/v1/label/__name__/values": []string{
+		"http_requests_total",
+		"request_duration_seconds_bucket", "request_duration_seconds_count", "request_duration_seconds_sum",
+		"rpc_latency_seconds", "rpc_latency_seconds_count", "rpc_latency_seconds_sum",
+	},
+	"/api/v1/metadata": map[string][]apiMetadata{
+		"http_requests_total":      {{Type: "counter", Help: "Requests handled by the server."}},
+		"request_duration_seconds": {{Type: "histogram", Help: "Request duration."}},
+		"rpc_latency_seconds":      {{Type: "summary", Help: "RPC latency."}},
+		"unrelated":                {{Type: "gauge", Help: "Exposed by another job."}},
+	},
+	"/api/v1/series": []map[string]string{
+		{"__name__": "http_requests_total", "job": "node", "code": "200"},
+		{"__name__": "http_requests_total", "job": "node", "code": "500"},
+		{"__name__": "request_duration_seconds_bucket", "job": "node", "le": "0.1"},
+		{"__name__": "request_duration_seconds_bucket", "job": "node", "le": "+Inf"},
+		{"__name__": "request_duration_seconds_count", "job": "node"},
+		{"__name__": "request_duration_seconds_sum", "job": "node"},
+		{"__name__": "rpc_latency_seconds", "job": "node", "quantile": "0.5"},
+		{"__name__": "rpc_latency_seconds", "job": "node", "quantile": "0.99"},
+		{"__name__": "rpc_latency_seconds_count", "job": "node"},
+		{"__name__": "rpc_latency_seconds_sum", "job": "node"},
+	},
+}
+
+func TestAPISourceRegistry(t *testing.T) {
+	match := `{job="node"}`
+	server := prometheusAPI(t, match, nodeAPIData)
+
+	registry, err := NewAPISource(server.URL+"/", match, false).Registry(config.New())
+	if err != nil {
+		t.Fatalf("Registry() error = %v", err)
+	}
+
+	expected := []string{"http_requests_total", "request_duration_seconds", "rpc_latency_seconds"}
+	names := registry.List()
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Expected metrics %v, got %v", expected, names)
+	}
+
+	t.Run("Counter", func(t *testing.T) {
+		metric := registry.Get("http_requests_total")
+		if metric.Type() != "counter" || metric.Help() != "Requests handled by the server." {
+			t.Errorf("Expected described counter, got %s %q", metric.Type(), metric.Help())
+		}
+		if metric.SeriesCount() != 2 {
+			t.Errorf("Expected 2 series, got %d", metric.SeriesCount())
+		}
+		if values := metric.LabelValues("code"); len(values) != 2 || values[0] != "200" || values[1] != "500" {
+			t.Errorf("Expected code values [200 500], got %v", values)
+		}
+	})
+
+	t.Run("Histogram", func(t *testing.T) {
+		metric := registry.Get("request_duration_seconds")
+		if metric.Type() != "histogram" || !metric.HasLabel("le") {
+			t.Errorf("Expected histogram with le buckets, got %s %v", metric.Type(), metric.Labels())
+		}
+		if metric.Unit() != "s" {
+			t.Errorf("Expected unit s, got %s", metric.Unit())
+		}
+	})
+
+	t.Run("Summary", func(t *testing.T) {
+		quantiles := registry.Get("rpc_latency_seconds").Quantiles()
+		if len(quantiles) != 2 || quantiles[0] != "0.5" || quantiles[1] != "0.99" {
+			t.Errorf("Expected quantiles [0.5 0.99], got %v", quantiles)
+		}
+	})
+}
+
+func TestAPISourceOpenMetricsFamilies(t *testing.T) {
+	// Metadata of OpenMetrics targets names counters without _total
+	server := prometheusAPI(t, AllSeries, map[string]interface{}{
+		"/api/v1/labels":                []string{"__name__", "job"},
+		"/api/v1/label/__name__/values": []string{"events_created", "events_total"},
+		"/api/v1/metadata": map[string][]apiMetadata{
+			"events": {{Type: "counter", Help: "Events seen.", Unit: ""}},
+		},
+		"/api/v1/series": []map[string]string{
+			{"__name__": "events_total", "job": "app"},
+			{"__name__": "events_created", "job": "app"},
+		},
+	})
+
+	registry, err := NewAPISource(server.URL, "", false).Registry(config.New())
+	if err != nil {
+		t.Fatalf("Registry() error = %v", err)
+	}
+
+	metric := registry.Get("events")
+	if metric == nil || registry.Count() != 1 {
+		t.Fatalf("Expected only the events family, got %v", registry.List())
+	}
+	if metric.Type() != "counter" || metric.FullName() != "events_total" {
+		t.Errorf("Expected counter events_total, got %s %s", metric.Type(), metric.FullName())
+	}
+	if metric.SeriesCount() != 1 {
+		t.Errorf("Expected _created to add no series, got %d", metric.SeriesCount())
+	}
+}
+
+func TestAPISourceErrors(t *testing.T) {
+	t.Run("No matching series", func(t *testing.T) {
+		server := prometheusAPI(t, `{job="none"}`, map[string]interface{}{
+			"/api/v1/labels": []string{},
+		})
+		if _, err := NewAPISource(server.URL, `{job="none"}`, false).Registry(config.New()); err == nil {
+			t.Errorf("Expected an error when no series match")
+		}
+	})
+
+	t.Run("API error", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"status":"error","errorType":"bad_data","error":"parse error"}`))
+		}))
+		defer server.Close()
+
+		_, err := NewAPISource(server.URL, "{", false).Registry(config.New())
+		if err == nil || !strings.Contains(err.Error(), "parse error") {
+			t.Errorf("Expected the API error, got %v", err)
+		}
+	})
+
+	t.Run("Server error", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		defer server.Close()
+
+		if _, err := NewAPISource(server.URL, "", false).Registry(config.New()); err == nil {
+			t.Errorf("Expected an error for a missing API")
+		}
+	})
+}
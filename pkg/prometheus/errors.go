@@ -0,0 +1,177 @@
+package prometheus
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ParseError is malformed input found by the parser
+type ParseError struct {
+	Line    int    // Line of the text formats, counted from 1
+	Column  int    // Column on the line where parsing stopped, counted from 1
+	Message int    // Protobuf message, counted from 1, as protobuf has no lines
+	Text    string // The offending line
+	Family  string // Metric family of the line, skipped in lenient mode
+	Err     error
+}
+
+// Error describes where parsing failed
+func (e *ParseError) Error() string {
+	if e.Message > 0 {
+		return fmt.Sprintf("protobuf message %d: %v", e.Message, e.Err)
+	}
+	return fmt.Sprintf("line %d, column %d: %v: %q", e.Line, e.Column, e.Err, e.Text)
+}
+
+// Unwrap returns the error of the underlying parser
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// ParseReport is returned along with the registry by a lenient parse that
+// skipped malformed metric families. The registry holds everything else.
+type ParseReport struct {
+	Errors []*ParseError
+}
+
+// Error summarizes the skipped input
+func (r *ParseReport) Error() string {
+	if len(r.Errors) == 1 {
+		return "skipped malformed input: " + r.Errors[0].Error()
+	}
+	return fmt.Sprintf("skipped malformed input in %d places, first at %v", len(r.Errors), r.Errors[0])
+}
+
+// Unwrap returns the parse errors
+func (r *ParseReport) Unwrap() []error {
+	errs := make([]error, len(r.Errors))
+	for i, err := range r.Errors {
+		errs[i] = err
+	}
+	return errs
+}
+
+// lineCursor tracks the line the text parser is on. Each entry returned by
+// the parser is one line, blank lines are skipped without an entry.
+type lineCursor struct {
+	data []byte
+	pos  int // Offset of the next line
+	line int // Number of the next line
+}
+
+// newLineCursor starts at the first line of data
+func newLineCursor(data []byte) *lineCursor {
+	return &lineCursor{data: data, line: 1}
+}
+
+// skipBlank moves past blank lines to the next line with content
+func (c *lineCursor) skipBlank() {
+	for c.pos < len(c.data) && len(bytes.TrimSpace(c.current())) == 0 {
+		c.advance()
+	}
+}
+
+// current returns the next line without its line break
+func (c *lineCursor) current() []byte {
+	rest := c.data[c.pos:]
+	if end := bytes.IndexByte(rest, '\n'); end >= 0 {
+		return rest[:end]
+	}
+	return rest
+}
+
+// advance moves to the following line
+func (c *lineCursor) advance() {
+	rest := c.data[c.pos:]
+	if end := bytes.IndexByte(rest, '\n'); end >= 0 {
+		c.pos += end + 1
+	} else {
+		c.pos = len(c.data)
+	}
+	c.line++
+}
+
+// entry moves past the line of an entry returned by the parser
+func (c *lineCursor) entry() {
+	c.skipBlank()
+	c.advance()
+}
+
+// fail returns the parse error of the line the parser failed on
+func (c *lineCursor) fail(err error, openMetrics bool) *ParseError {
+	c.skipBlank()
+	text := string(bytes.TrimRight(c.current(), "\r"))
+	line := c.line
+	if c.pos >= len(c.data) {
+		// The input ended early, e.g. without # EOF
+		line = max(c.line-1, 1)
+	}
+	return &ParseError{
+		Line:   line,
+		Column: errorColumn(err, text),
+		Text:   text,
+		Family: lineFamily(text, openMetrics),
+		Err:    err,
+	}
+}
+
+// parsedText matches the text a parser error reports having read on the
+// line, and quoted text in other errors
+var (
+	parsedText = regexp.MustCompile(`while parsing: ("(?:[^"\\]|\\.)*")$`)
+	quotedText = regexp.MustCompile(`"(?:[^"\\]|\\.)*"`)
+)
+
+// errorColumn estimates the column a parser error occurred at, from the
+// text read on the line before failing or the quoted text of the error.
+// It falls back to the start of the line.
+func errorColumn(err error, line string) int {
+	message := err.Error()
+	if match := parsedText.FindStringSubmatch(message); match != nil {
+		if parsed, uerr := strconv.Unquote(match[1]); uerr == nil && parsed != "" && strings.HasPrefix(line, parsed) {
+			return len(parsed)
+		}
+	}
+	if match := quotedText.FindString(message); match != "" {
+		if quoted, uerr := strconv.Unquote(match); uerr == nil && quoted != "" {
+			if i := strings.Index(line, quoted); i >= 0 {
+				return i + 1
+			}
+		}
+	}
+	return 1
+}
+
+// lineFamily returns the metric family of an exposition line, or "" for
+// lines naming none
+func lineFamily(line string, openMetrics bool) string {
+	name := line
+	if strings.HasPrefix(line, "#") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || (fields[1] != "HELP" && fields[1] != "TYPE" && fields[1] != "UNIT") {
+			return ""
+		}
+		name = fields[2]
+	} else if end := strings.IndexAny(line, "{ \t"); end >= 0 {
+		name = line[:end]
+	}
+	return familyOf(strings.TrimSpace(name), openMetrics)
+}
+
+// familyOf returns the family of a metric or sample name, removing the
+// suffixes resolveFamily removes when the family is declared
+func familyOf(name string, openMetrics bool) string {
+	suffixes := []string{"_bucket", "_sum", "_count"}
+	if openMetrics {
+		suffixes = append(suffixes, familySuffixes...)
+	}
+	for _, suffix := range suffixes {
+		if family := strings.TrimSuffix(name, suffix); family != name && family != "" {
+			return family
+		}
+	}
+	return name
+}
@@ -0,0 +1,110 @@
+package prometheus
+
+import (
+	"bytes"
+	"mime"
+	"strings"
+
+	"github.com/hemzaz/lazydash/pkg/metrics"
+	"github.com/prometheus/prometheus/model/exemplar"
+	PromLabel "github.com/prometheus/prometheus/model/labels"
+	PromParse "github.com/prometheus/prometheus/model/textparse"
+)
+
+// Input formats accepted by --format
+const (
+	FormatAuto        = "auto"
+	FormatPrometheus  = "prometheus"
+	FormatOpenMetrics = "openmetrics"
+)
+
+// OpenMetricsContentType is the media type of the OpenMetrics text format
+const OpenMetricsContentType = "application/openmetrics-text"
+
+// familySuffixes are sample name suffixes that belong to a metric family
+// declared without them, e.g. foo_total and foo_created of counter foo
+var familySuffixes = []string{"_total", "_created", "_gcount", "_gsum", "_info"}
+
+// ContentTypeFormat returns the input format named by the Content-Type of a
+// scrape response, or "" when it names none. A text/plain response is only
+// the Prometheus format with its version=0.0.4 parameter, since plain web
+// servers send every text file as text/plain.
+func ContentTypeFormat(contentType string) string {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	switch {
+	case mediaType == OpenMetricsContentType:
+		return FormatOpenMetrics
+	case mediaType == "application/vnd.google.protobuf" && params["proto"] == "io.prometheus.client.MetricFamily":
+		return FormatProtobuf
+	case mediaType == "text/plain" && params["version"] == "0.0.4":
+		return FormatPrometheus
+	}
+	return ""
+}
+
+// DetectFormat guesses the exposition format of the data. OpenMetrics
+// exposition must end with # EOF, which the Prometheus format never needs.
+func DetectFormat(data []byte) string {
+	if bytes.HasSuffix(bytes.TrimRight(data, " \t\r\n"), []byte("# EOF")) {
+		return FormatOpenMetrics
+	}
+	return FormatPrometheus
+}
+
+// newFormatParser returns a parser for a text input format
+func newFormatParser(data []byte, format string) PromParse.Parser {
+	contentType := "text/plain"
+	if format == FormatOpenMetrics {
+		contentType = OpenMetricsContentType
+	}
+	// Errors are only returned for malformed content types, not ours
+	p, _ := PromParse.New(data, contentType, false, PromLabel.NewSymbolTable())
+	return p
+}
+
+// resolveFamily returns the metric family a sample belongs to and the
+// suffix that was removed from the sample name to find it
+func resolveFamily(registry *metrics.Registry, name string, openMetrics bool) (string, string) {
+	// Histogram and summary series are always unified under one metric
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		if strings.HasSuffix(name, suffix) {
+			return strings.TrimSuffix(name, suffix), suffix
+		}
+	}
+
+	// OpenMetrics families are declared without their sample suffixes
+	if !openMetrics || registry.Has(name) {
+		return name, ""
+	}
+	for _, suffix := range familySuffixes {
+		family := strings.TrimSuffix(name, suffix)
+		if family != name && registry.Has(family) {
+			return family, suffix
+		}
+	}
+
+	return name, ""
+}
+
+// hasExemplars reports whether a sample at the start of an input carries an
+// exemplar when read as OpenMetrics. Reading stops at the first line that
+// is not valid OpenMetrics.
+func hasExemplars(start []byte) bool {
+	if i := bytes.LastIndexByte(start, '\n'); i >= 0 {
+		start = start[:i+1]
+	}
+	p := newFormatParser(start, FormatOpenMetrics)
+	var e exemplar.Exemplar
+	for {
+		et, err := p.Next()
+		if err != nil {
+			return false
+		}
+		if et == PromParse.EntrySeries && p.Exemplar(&e) {
+			return true
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package prometheus
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/hemzaz/lazydash/internal/config"
+	"github.com/hemzaz/lazydash/pkg/metrics"
+)
+
+// loadOpenMetrics parses the OpenMetrics fixture
+func loadOpenMetrics(t *testing.T) *metrics.Registry {
+	t.Helper()
+	data, err := os.ReadFile("testdata/openmetrics.txt")
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+	registry, err := ParseMetrics(data)
+	if err != nil {
+		t.Fatalf("Failed to parse fixture: %v", err)
+	}
+	return registry
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"OpenMetrics", "# TYPE up gauge\nup 1\n# EOF\n", FormatOpenMetrics},
+		{"OpenMetrics without final newline", "up 1\n# EOF", FormatOpenMetrics},
+		{"Prometheus", "# TYPE up gauge\nup 1\n", FormatPrometheus},
+		{"Empty", "", FormatPrometheus},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectFormat([]byte(tt.data)); got != tt.want {
+				t.Errorf("DetectFormat() = %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseOpenMetrics(t *testing.T) {
+	registry := loadOpenMetrics(t)
+
+	expected := []string{"build", "feature", "http_requests", "memory_usage_ratio", "queue_wait_seconds", "request_latency_seconds", "temperature_celsius"}
+	if names := registry.List(); len(names) != len(expected) {
+		t.Fatalf("Expected metrics %v, got %v", expected, names)
+	}
+
+	t.Run("Counter family", func(t *testing.T) {
+		metric := registry.Get("http_requests")
+		if metric.Type() != "counter" || metric.FullName() != "http_requests_total" {
+			t.Errorf("Expected counter http_requests_total, got %s %s", metric.Type(), metric.FullName())
+		}
+		if metric.Help() != "Requests handled by the server." {
+			t.Errorf("Unexpected help %q", metric.Help())
+		}
+		// _created timestamps are not values
+		if _, max, _ := metric.ValueRange(); max != 1027 {
+			t.Errorf("Expected max value 1027, got %v", max)
+		}
+		if metric.SeriesCount() != 2 {
+			t.Errorf("Expected 2 series, got %d", metric.SeriesCount())
+		}
+	})
+
+	t.Run("Declared units", func(t *testing.T) {
+		tests := map[string]string{
+			"request_latency_seconds": "s",
+			"memory_usage_ratio":      "percentunit",
+			"queue_wait_seconds":      "s",
+		}
+		for name, unit := range tests {
+			if got := registry.Get(name).Unit(); got != unit {
+				t.Errorf("Expected unit %q for %s, got %q", unit, name, got)
+			}
+		}
+	})
+
+	t.Run("Exemplars", func(t *testing.T) {
+		for name, expected := range map[string]bool{"http_requests": true, "request_latency_seconds": true, "memory_usage_ratio": false} {
+			if got := registry.Get(name).HasExemplars(); got != expected {
+				t.Errorf("HasExemplars() for %s = %v; want %v", name, got, expected)
+			}
+		}
+	})
+
+	t.Run("Types", func(t *testing.T) {
+		tests := map[string]string{
+			"build":                   "info",
+			"feature":                 "stateset",
+			"queue_wait_seconds":      "gaugehistogram",
+			"request_latency_seconds": "histogram",
+			"temperature_celsius":     "untyped",
+		}
+		for name, mtype := range tests {
+			if got := registry.Get(name).Type(); got != mtype {
+				t.Errorf("Expected type %q for %s, got %q", mtype, name, got)
+			}
+		}
+
+		if build := registry.Get("build"); build.FullName() != "build_info" || build.ValueKind() != metrics.ValueInfo {
+			t.Errorf("Expected info metric build_info, got %s (%v)", build.FullName(), build.ValueKind())
+		}
+		if feature := registry.Get("feature"); feature.ValueKind() != metrics.ValueBoolean {
+			t.Errorf("Expected stateset to be boolean, got %v", feature.ValueKind())
+		}
+	})
+}
+
+func TestParseFormatOverride(t *testing.T) {
+	data := []byte("# TYPE up gauge\nup 1\n")
+
+	// Forcing OpenMetrics on input without # EOF reports the missing # EOF
+	// and keeps what was parsed before it
+	cfg := config.New()
+	cfg.Format = FormatOpenMetrics
+	registry, err := ParseMetricsWithConfig(data, cfg)
+	var report *ParseReport
+	if !errors.As(err, &report) || report.Errors[0].Line != 2 {
+		t.Errorf("Expected the missing # EOF to be reported after line 2, got %v", err)
+	}
+	if !registry.Has("up") || registry.Get("up").Type() != "gauge" {
+		t.Errorf("Expected up to be parsed before the missing # EOF")
+	}
+
+	cfg.Format = FormatPrometheus
+	if registry, err := ParseMetricsWithConfig(data, cfg); err != nil || registry.Get("up") == nil || registry.Get("up").SeriesCount() != 1 {
+		t.Errorf("Expected up to be parsed as Prometheus text, got %v", err)
+	}
+}
+
+func TestHasExemplars(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want bool
+	}{
+		{"Exemplar-like help and label", "# HELP a_total Not an exemplar # {x=\"y\"} 1\na_total{path=\"/ # {\"} 1\n", false},
+		{"Counter", "# TYPE b counter\nb_total 1 # {trace_id=\"1\"} 1\n", true},
+		{"Bucket with timestamp", "# TYPE c histogram\nc_bucket{le=\"1\"} 2 1700000000 # {trace_id=\"2\"} 0.5\n", true},
+		{"Partial last line", "# TYPE b counter\nb_total 1\nb_total{x=\"1\"} 1 # {trace_id=\"1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasExemplars([]byte(tt.data)); got != tt.want {
+				t.Errorf("hasExemplars() = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestContentTypeFormat(t *testing.T) {
+	tests := map[string]string{
+		"application/openmetrics-text; version=1.0.0; charset=utf-8":                                   FormatOpenMetrics,
+		"application/vnd.google.protobuf; proto=io.prometheus.client.MetricFamily; encoding=delimited": FormatProtobuf,
+		"text/plain; version=0.0.4; charset=utf-8":                                                     FormatPrometheus,
+		"text/plain; charset=utf-8":                                                                    "",
+		"application/json":                                                                             "",
+		"":                                                                                             "",
+	}
+	for contentType, want := range tests {
+		if got := ContentTypeFormat(contentType); got != want {
+			t.Errorf("ContentTypeFormat(%q) = %q; want %q", contentType, got, want)
+		}
+	}
+}
+
+func TestParseResponse(t *testing.T) {
+	// Prometheus text may end with any comment, and its timestamps are in
+	// milliseconds while OpenMetrics has seconds
+	data := []byte("up 1 1700000000000\n# EOF\n")
+	timestamp := func(registry *metrics.Registry) int64 {
+		return registry.Get("up").Samples()[0].Timestamp
+	}
+
+	registry, err := ParseResponse(data, "text/plain; version=0.0.4", nil)
+	if err != nil || timestamp(registry) != 1700000000000 {
+		t.Errorf("Expected the Prometheus format of the Content-Type, got %v", err)
+	}
+	registry, err = ParseResponse(data, "text/plain", nil)
+	if err != nil || timestamp(registry) == 1700000000000 {
+		t.Errorf("Expected OpenMetrics detected from # EOF without a format in the Content-Type, got %v", err)
+	}
+
+	cfg := config.New()
+	cfg.Format = FormatOpenMetrics
+	if registry, err := ParseResponse(data, "text/plain; version=0.0.4", cfg); err != nil || timestamp(registry) == 1700000000000 {
+		t.Errorf("Expected --format to take precedence over the Content-Type, got %v", err)
+	}
+}
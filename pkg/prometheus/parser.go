@@ -2,131 +2,358 @@
 package prometheus
 
 import (
+	"bytes"
 	"io"
 	"strings"
 
 	"github.com/hemzaz/lazydash/internal/config"
 	"github.com/hemzaz/lazydash/pkg/metrics"
-	PromLabel "github.com/prometheus/prometheus/pkg/labels"
-	PromParse "github.com/prometheus/prometheus/pkg/textparse"
+	PromModel "github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/exemplar"
+	PromLabel "github.com/prometheus/prometheus/model/labels"
+	PromParse "github.com/prometheus/prometheus/model/textparse"
 	"github.com/rs/zerolog/log"
 )
 
-// ParseMetrics parses Prometheus text metrics into a metric registry
-func ParseMetrics(data []byte) *metrics.Registry {
+// ParseMetrics parses Prometheus text metrics into a metric registry,
+// skipping malformed metric families
+func ParseMetrics(data []byte) (*metrics.Registry, error) {
 	return ParseMetricsWithConfig(data, nil)
 }
 
-// ParseMetricsWithConfig parses Prometheus text metrics with vendor-specific config
-func ParseMetricsWithConfig(data []byte, cfg *config.Config) *metrics.Registry {
-	p := PromParse.NewPromParser(data)
-	registry := metrics.NewRegistry()
-	
-	// Determine if we should detect vendor prefixes
-	detectVendors := false
-	var knownPrefixes []string
-	var customPrefixes []string
-	detectJuniper := false
-	detectCisco := false
-	
-	if cfg != nil && cfg.VendorConfig != nil && cfg.VendorConfig.Enabled {
-		detectVendors = true
-		knownPrefixes = cfg.VendorConfig.KnownPrefixes
-		customPrefixes = cfg.VendorConfig.CustomPrefixes
-		detectJuniper = cfg.VendorConfig.JuniperEnabled
-		detectCisco = cfg.VendorConfig.CiscoEnabled
-		
-		log.Info().Bool("vendor_detection", true).
-			Int("known_prefixes", len(knownPrefixes)).
-			Int("custom_prefixes", len(customPrefixes)).
-			Bool("juniper", detectJuniper).
-			Bool("cisco", detectCisco).
-			Msg("Vendor-specific metric detection enabled")
+// ParseMetricsWithConfig parses Prometheus text, OpenMetrics or protobuf metrics with vendor-specific config.
+// With --strict-parse the first malformed line fails the parse with a
+// *ParseError. Otherwise the metric family of a malformed line is skipped
+// and parsing resumes after it; the registry is then returned along with a
+// *ParseReport listing what was skipped.
+func ParseMetricsWithConfig(data []byte, cfg *config.Config) (*metrics.Registry, error) {
+	return parseFormat(data, formatOf(data, cfg), cfg)
+}
+
+// ParseResponse parses a scraped response body like ParseMetricsWithConfig
+// in the format named by its Content-Type, which is detected from the body
+// when the Content-Type names no format. --format takes precedence.
+func ParseResponse(data []byte, contentType string, cfg *config.Config) (*metrics.Registry, error) {
+	format := formatOf(data, cfg)
+	if declared := ContentTypeFormat(contentType); declared != "" && !formatConfigured(cfg) {
+		format = declared
+	}
+	return parseFormat(data, format, cfg)
+}
+
+// parseFormat parses data in a known input format
+func parseFormat(data []byte, format string, cfg *config.Config) (*metrics.Registry, error) {
+	if format == FormatProtobuf {
+		return parseProtobuf(bytes.NewReader(data), cfg)
+	}
+
+	t := newTextParser(cfg, format)
+	if err := t.parseChunk(data, 1); err != nil {
+		return nil, err
 	}
+	return t.finish()
+}
+
+// textParser fills a registry from the text formats. A malformed line
+// stops the underlying parser, which is then restarted after the family.
+type textParser struct {
+	registry    *metrics.Registry
+	annotator   *annotator
+	format      string
+	openMetrics bool
+	strict      bool
+	skipped     map[string]bool // Malformed families left out of the registry
+	report      ParseReport
+
+	// Reused for every sample, metrics copy the labels they keep
+	labels   PromLabel.Labels
+	labelmap map[string]string
+	exemplar exemplar.Exemplar
+}
+
+// newTextParser creates a parser of a text format filling a new registry
+func newTextParser(cfg *config.Config, format string) *textParser {
+	return &textParser{
+		registry:    metrics.NewRegistry(),
+		annotator:   newAnnotator(cfg),
+		format:      format,
+		openMetrics: format == FormatOpenMetrics,
+		strict:      cfg != nil && cfg.StrictParse,
+		skipped:     make(map[string]bool),
+		labelmap:    make(map[string]string),
+	}
+}
 
+// parseChunk parses complete lines of the input starting at line firstLine.
+// Malformed families are skipped and reported unless parsing is strict.
+func (t *textParser) parseChunk(data []byte, firstLine int) error {
+	cursor := &lineCursor{data: data, line: firstLine}
+	for {
+		perr := t.parse(newFormatParser(data[cursor.pos:], t.format), cursor)
+		if perr == nil {
+			return nil
+		}
+		if t.strict {
+			return perr
+		}
+		t.report.Errors = append(t.report.Errors, perr)
+		t.skip(perr.Family, cursor)
+		if cursor.pos >= len(data) {
+			return nil
+		}
+	}
+}
+
+// finish refines the parsed metrics and returns the registry, with the
+// report of skipped families if there are any
+func (t *textParser) finish() (*metrics.Registry, error) {
+	t.annotator.finish(t.registry)
+	if len(t.report.Errors) > 0 {
+		return t.registry, &t.report
+	}
+	return t.registry, nil
+}
+
+// parse adds the entries of p to the registry until the input ends or a
+// line is malformed, keeping the cursor on the line of each entry
+func (t *textParser) parse(p PromParse.Parser, cursor *lineCursor) *ParseError {
 	for {
 		et, err := p.Next()
 		if err == io.EOF {
-			break
+			return nil
 		}
+		if err != nil {
+			return cursor.fail(err, t.openMetrics)
+		}
+		cursor.entry()
 
 		//May be parsed out of order
 		switch et {
 		case PromParse.EntryHelp:
 			m, h := p.Help()
-			registry.Set(string(m), metrics.New(string(m), string(h), nil, "", "", "short"))
+			if !t.skipped[familyOf(string(m), t.openMetrics)] {
+				familyMetric(t.registry, string(m)).SetHelp(string(h))
+			}
 
 		case PromParse.EntryType:
 			m, typ := p.Type()
-			if metric := registry.Get(string(m)); metric != nil {
-				metric.SetType(string(typ))
+			if typ == PromModel.MetricTypeUnknown {
+				typ = "untyped"
 			}
-
-		case PromParse.EntrySeries:
-			labels := &PromLabel.Labels{}
-			p.Metric(labels)
-
-			labelmap := labels.Map()
-			name := labelmap["__name__"]
-
-			// Unify metrics key for simple access
-			var suffix string
-			if strings.HasSuffix(name, "_bucket") {
-				suffix = "_bucket"
-				name = strings.TrimSuffix(name, "_bucket")
-			} else if strings.HasSuffix(name, "_sum") {
-				suffix = "_sum"
-				name = strings.TrimSuffix(name, "_sum")
-			} else if strings.HasSuffix(name, "_count") {
-				suffix = "_count"
-				name = strings.TrimSuffix(name, "_count")
+			if !t.skipped[familyOf(string(m), t.openMetrics)] {
+				familyMetric(t.registry, string(m)).SetType(string(typ))
 			}
 
-			// Create metric if it doesn't exist
-			if !registry.Has(name) {
-				registry.Set(name, metrics.New(name, "", nil, "", suffix, "short"))
-			}
-			
-			metric := registry.Get(name)
-			metric.SetSuffix(suffix)
-
-			// Try to detect appropriate unit type based on metric name
-			if strings.Contains(name, "_seconds") {
-				metric.SetUnit("s")
-			} else if strings.Contains(name, "_milliseconds") {
-				metric.SetUnit("ms")
-			} else if strings.Contains(name, "_bytes") {
-				metric.SetUnit("decbytes")
-			} else if strings.Contains(name, "_percent") || strings.Contains(name, "_ratio") {
-				metric.SetUnit("percent")
-			} else if strings.Contains(name, "_count") {
-				metric.SetUnit("short")
+		case PromParse.EntryUnit:
+			m, u := p.Unit()
+			if !t.skipped[familyOf(string(m), t.openMetrics)] {
+				t.annotator.declareUnit(familyMetric(t.registry, string(m)), string(u))
 			}
-			
-			// Detect vendor prefixes if enabled
-			if detectVendors {
-				detectVendorPrefix(metric, knownPrefixes, customPrefixes)
-				
-				// Special handling for specific vendors
-				if detectJuniper && metric.Vendor() == "juniper" {
-					parseJuniperMetric(metric)
-				}
-				
-				if detectCisco && metric.Vendor() == "cisco" {
-					parseCiscoMetric(metric)
-				}
+
+		case PromParse.EntrySeries:
+			p.Metric(&t.labels)
+			if t.skipped[familyOf(t.labels.Get("__name__"), t.openMetrics)] {
+				continue
 			}
+			clear(t.labelmap)
+			t.labels.Range(func(l PromLabel.Label) {
+				t.labelmap[l.Name] = l.Value
+			})
+			t.addSeries(p, t.labelmap)
+		}
+	}
+}
 
-			// Add all labels to the metric
-			for k := range labelmap {
-				if k != "__name__" && k != "" {
-					metric.AddLabel(k)
-				}
+// addSeries adds a sample of the text formats to its metric family
+func (t *textParser) addSeries(p PromParse.Parser, labelmap map[string]string) {
+	registry := t.registry
+	series := labelmap["__name__"]
+
+	// Unify metrics key for simple access
+	name, suffix := resolveFamily(registry, series, t.openMetrics)
+
+	// Create metric if it doesn't exist
+	if !registry.Has(name) {
+		registry.Set(name, metrics.New(name, "", nil, "", suffix, "short"))
+	}
+	
+	metric := registry.Get(name)
+	if t.openMetrics && p.Exemplar(&t.exemplar) {
+		metric.SetExemplars(true)
+	}
+
+	// _created series hold creation timestamps, not values of the metric
+	if suffix == "_created" {
+		for k := range labelmap {
+			if k != "__name__" && k != "" {
+				metric.AddLabel(k)
 			}
 		}
+		return
+	}
+	metric.SetSuffix(suffix)
+	t.annotator.annotate(metric)
+
+	_, ts, value := p.Series()
+	var timestamp int64
+	if ts != nil {
+		timestamp = *ts
+	}
+	metric.Observe(labelmap, value, timestamp)
+
+	// Summaries are charted per quantile, so keep the values too
+	if quantile, ok := labelmap["quantile"]; ok && suffix == "" {
+		metric.AddQuantile(quantile)
+	}
+}
+
+// skip drops a malformed family from the registry and moves the cursor
+// past the failed line and the lines of the family following it
+func (t *textParser) skip(family string, cursor *lineCursor) {
+	cursor.skipBlank()
+	cursor.advance()
+	if family == "" {
+		return
+	}
+
+	t.skipped[family] = true
+	t.registry.Delete(family)
+	for cursor.skipBlank(); cursor.pos < len(cursor.data); cursor.skipBlank() {
+		if lineFamily(string(cursor.current()), t.openMetrics) != family {
+			break
+		}
+		cursor.advance()
+	}
+}
+
+// annotator derives units and vendors of parsed metrics. It is shared by
+// the text and protobuf parsers so both describe metrics the same way.
+type annotator struct {
+	declaredUnits  map[string]bool // Units declared by the exposition
+	detectVendors  bool
+	knownPrefixes  []string
+	customPrefixes []string
+	detectJuniper  bool
+	detectCisco    bool
+}
+
+// newAnnotator creates an annotator for the vendor-specific config
+func newAnnotator(cfg *config.Config) *annotator {
+	a := &annotator{declaredUnits: make(map[string]bool)}
+	
+	// Determine if we should detect vendor prefixes
+	if cfg != nil && cfg.VendorConfig != nil && cfg.VendorConfig.Enabled {
+		a.detectVendors = true
+		a.knownPrefixes = cfg.VendorConfig.KnownPrefixes
+		a.customPrefixes = cfg.VendorConfig.CustomPrefixes
+		a.detectJuniper = cfg.VendorConfig.JuniperEnabled
+		a.detectCisco = cfg.VendorConfig.CiscoEnabled
+		
+		log.Info().Bool("vendor_detection", true).
+			Int("known_prefixes", len(a.knownPrefixes)).
+			Int("custom_prefixes", len(a.customPrefixes)).
+			Bool("juniper", a.detectJuniper).
+			Bool("cisco", a.detectCisco).
+			Msg("Vendor-specific metric detection enabled")
 	}
+	
+	return a
+}
 
-	return registry
+// declareUnit sets a unit declared by the exposition, e.g. with # UNIT.
+// Declared units take precedence over name and value hints.
+func (a *annotator) declareUnit(metric *metrics.Metric, unit string) {
+	if grafana, ok := metrics.GrafanaUnit(unit); ok {
+		metric.SetUnit(grafana)
+		a.declaredUnits[metric.Name()] = true
+	} else if unit != "" {
+		log.Debug().Str("metric", metric.Name()).Str("unit", unit).Msg("No Grafana unit for OpenMetrics unit")
+	}
+}
+
+// annotate derives the unit and vendor of a metric from its name
+func (a *annotator) annotate(metric *metrics.Metric) {
+	name := metric.Name()
+	
+	// Derive the unit from the unit word the metric name ends with,
+	// unless the exposition declared it
+	if !a.declaredUnits[name] {
+		if unit, ok := metrics.UnitFromName(name); ok {
+			metric.SetUnit(unit)
+		}
+	}
+	
+	// Detect vendor prefixes if enabled
+	if a.detectVendors {
+		detectVendorPrefix(metric, a.knownPrefixes, a.customPrefixes)
+		
+		// Special handling for specific vendors
+		if a.detectJuniper && metric.Vendor() == "juniper" {
+			parseJuniperMetric(metric)
+		}
+		
+		if a.detectCisco && metric.Vendor() == "cisco" {
+			parseCiscoMetric(metric)
+		}
+	}
+}
+
+// finish infers the types of untyped metrics and refines the units once the
+// help texts and sample values are known
+func (a *annotator) finish(registry *metrics.Registry) {
+	for _, metric := range metrics.InferTypes(registry) {
+		log.Debug().Str("metric", metric.Name()).Str("type", metric.Type()).Str("reason", metric.TypeReason()).
+			Float64("confidence", metric.TypeConfidence()).Msg("Inferred metric type")
+	}
+	registry.ForEach(func(name string, metric *metrics.Metric) {
+		if !a.declaredUnits[name] {
+			applyHelpUnit(metric)
+			applyValueUnit(metric)
+		}
+	})
+}
+
+// applyHelpUnit sets the unit stated by the help text of metrics whose name
+// has no unit, e.g. "Memory in use in bytes."
+func applyHelpUnit(metric *metrics.Metric) {
+	switch metric.Unit() {
+	case "", "short":
+	default:
+		return
+	}
+	if unit, ok := metrics.UnitFromHelp(metric.Help()); ok {
+		metric.SetUnit(unit)
+	}
+}
+
+// familyMetric returns the metric of a family declared by HELP, TYPE or
+// UNIT, creating it on first use since the order of these lines varies
+func familyMetric(registry *metrics.Registry, name string) *metrics.Metric {
+	if metric := registry.Get(name); metric != nil {
+		return metric
+	}
+	metric := metrics.New(name, "", nil, "", "", "short")
+	registry.Set(name, metric)
+	return metric
+}
+
+// applyValueUnit sets the unit of ratio, percent and state metrics detected
+// from their values, keeping units already derived from the metric name
+func applyValueUnit(metric *metrics.Metric) {
+	switch metric.Unit() {
+	case "", "short", "percent":
+	default:
+		return
+	}
+
+	switch metric.ValueKind() {
+	case metrics.ValueRatio:
+		metric.SetUnit("percentunit")
+	case metrics.ValuePercent:
+		metric.SetUnit("percent")
+	case metrics.ValueBoolean, metrics.ValueInfo:
+		metric.SetUnit("none")
+	}
 }
 
 // detectVendorPrefix identifies the vendor from metric prefixes
@@ -0,0 +1,183 @@
+package prometheus
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/hemzaz/lazydash/internal/config"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+// malformed has a broken sample in family b and a broken TYPE of family d
+const malformed = `# HELP a_total Requests
+# TYPE a_total counter
+a_total 1
+# TYPE b gauge
+b{x="1"} 1
+b{x=2} 2
+b{x="3"} 3
+
+c 4
+# TYPE d foo
+d 5
+e 6
+b{x="4"} 4
+`
+
+func TestParseErrors(t *testing.T) {
+	t.Run("Lenient", func(t *testing.T) {
+		registry, err := ParseMetrics([]byte(malformed))
+
+		var report *ParseReport
+		if !errors.As(err, &report) {
+			t.Fatalf("Expected a parse report, got %v", err)
+		}
+		want := []ParseError{
+			{Line: 6, Column: 5, Text: `b{x=2} 2`, Family: "b"},
+			{Line: 10, Column: 10, Text: "# TYPE d foo", Family: "d"},
+		}
+		if len(report.Errors) != len(want) {
+			t.Fatalf("Expected %d errors, got %v", len(want), report.Errors)
+		}
+		for i, w := range want {
+			got := *report.Errors[i]
+			got.Err = nil
+			if got != w {
+				t.Errorf("Error %d = %+v; want %+v", i, got, w)
+			}
+		}
+
+		if names := registry.List(); !reflect.DeepEqual(names, []string{"a_total", "c", "e"}) {
+			t.Errorf("Expected the malformed families to be skipped, got %v", names)
+		}
+		if registry.Get("a_total").Type() != "counter" || registry.Get("a_total").Help() != "Requests" {
+			t.Errorf("Expected a_total to keep its metadata")
+		}
+	})
+
+	t.Run("Strict", func(t *testing.T) {
+		cfg := config.New()
+		cfg.StrictParse = true
+		registry, err := ParseMetricsWithConfig([]byte(malformed), cfg)
+
+		var perr *ParseError
+		if !errors.As(err, &perr) || perr.Line != 6 || registry != nil {
+			t.Fatalf("Expected the parse to fail on line 6, got %v", err)
+		}
+		if want := `line 6, column 5: `; len(err.Error()) < len(want) || err.Error()[:len(want)] != want {
+			t.Errorf("Expected the error to start with %q, got %q", want, err.Error())
+		}
+	})
+
+	t.Run("OpenMetrics", func(t *testing.T) {
+		data := "# TYPE a counter\na_total 1\n# TYPE b gauge\nb{x=\"1\"} one\n# TYPE c gauge\nc 3\n# EOF\n"
+		registry, err := ParseMetrics([]byte(data))
+
+		var report *ParseReport
+		if !errors.As(err, &report) || len(report.Errors) != 1 || report.Errors[0].Line != 4 || report.Errors[0].Family != "b" {
+			t.Fatalf("Expected an error in family b on line 4, got %v", err)
+		}
+		if names := registry.List(); !reflect.DeepEqual(names, []string{"a", "c"}) {
+			t.Errorf("Expected parsing to resume after family b, got %v", names)
+		}
+	})
+
+	t.Run("Valid input", func(t *testing.T) {
+		if _, err := ParseMetrics([]byte("# TYPE up gauge\n\nup 1\n")); err != nil {
+			t.Errorf("ParseMetrics() error = %v", err)
+		}
+	})
+}
+
+func TestParseProtobufErrors(t *testing.T) {
+	gauge := func(name string) *dto.MetricFamily {
+		return &dto.MetricFamily{Name: proto.String(name), Type: dto.MetricType_GAUGE.Enum(), Metric: []*dto.Metric{{Gauge: &dto.Gauge{Value: proto.Float64(1)}}}}
+	}
+	// A message claiming a 255 byte name in 2 bytes
+	data := append(encodeFamilies(t, gauge("a")), 3, 0x0a, 0xff, 0x01)
+	data = append(data, encodeFamilies(t, gauge("c"))...)
+
+	registry, err := ParseMetrics(data)
+	var report *ParseReport
+	if !errors.As(err, &report) || len(report.Errors) != 1 || report.Errors[0].Message != 2 {
+		t.Fatalf("Expected the second message to be reported, got %v", err)
+	}
+	if names := registry.List(); !reflect.DeepEqual(names, []string{"a", "c"}) {
+		t.Errorf("Expected the messages around the broken one, got %v", names)
+	}
+
+	cfg := config.New()
+	cfg.StrictParse = true
+	if _, err := ParseMetricsWithConfig(data, cfg); err == nil {
+		t.Errorf("Expected a strict parse to fail")
+	}
+}
+
+func TestParseUntypedMetrics(t *testing.T) {
+	data := `jobs_processed_total{queue="mail"} 42
+job_duration_seconds_bucket{le="1"} 3
+job_duration_seconds_bucket{le="+Inf"} 4
+job_duration_seconds_sum 2.5
+job_duration_seconds_count 4
+rpc_latency_seconds{quantile="0.5"} 0.1
+rpc_latency_seconds_sum 9
+rpc_latency_seconds_count 70
+queue_depth 7
+`
+	registry, err := ParseMetrics([]byte(data))
+	if err != nil {
+		t.Fatalf("ParseMetrics() error = %v", err)
+	}
+
+	want := map[string]string{
+		"jobs_processed_total": "counter",
+		"job_duration_seconds": "histogram",
+		"rpc_latency_seconds":  "summary",
+		"queue_depth":          "",
+	}
+	for name, mtype := range want {
+		metric := registry.Get(name)
+		if metric == nil || metric.Type() != mtype || metric.TypeInferred() != (mtype != "") {
+			t.Errorf("Expected %s to be inferred as %q, got %+v", name, mtype, metric)
+		}
+	}
+}
+
+func TestParseUnits(t *testing.T) {
+	data := `# TYPE request_time_milliseconds_total counter
+request_time_milliseconds_total 120
+# TYPE rx_packets_total counter
+rx_packets_total 7
+# HELP heap_used Heap in use in bytes.
+# TYPE heap_used gauge
+heap_used 1024
+# HELP uptime Uptime of the process (seconds).
+uptime 60
+# HELP cache_hit_ratio Cache hits.
+cache_hit_ratio 0.75
+# HELP gc_pause_seconds Last pause, reported in milliseconds by older versions.
+gc_pause_seconds 0.002
+`
+	registry, err := ParseMetrics([]byte(data))
+	if err != nil {
+		t.Fatalf("ParseMetrics() error = %v", err)
+	}
+
+	want := map[string]string{
+		"request_time_milliseconds_total": "ms",
+		"rx_packets_total":                "suffix: packets",
+		"heap_used":                       "decbytes",
+		"uptime":                          "s",
+		"cache_hit_ratio":                 "percentunit",
+		"gc_pause_seconds":                "s",
+	}
+	for name, unit := range want {
+		t.Run(name, func(t *testing.T) {
+			if got := registry.Get(name).Unit(); got != unit {
+				t.Errorf("Expected unit %q, got %q", unit, got)
+			}
+		})
+	}
+}
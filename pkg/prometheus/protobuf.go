@@ -0,0 +1,233 @@
+package prometheus
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/hemzaz/lazydash/internal/config"
+	"github.com/hemzaz/lazydash/pkg/metrics"
+	dto "github.com/prometheus/client_model/go"
+	PromModel "github.com/prometheus/common/model"
+	"google.golang.org/protobuf/proto"
+)
+
+// FormatProtobuf is the length-delimited protobuf exposition format
+const FormatProtobuf = "protobuf"
+
+// protobufTypes maps protobuf metric types to the names used by the text formats
+var protobufTypes = map[dto.MetricType]string{
+	dto.MetricType_COUNTER:         "counter",
+	dto.MetricType_GAUGE:           "gauge",
+	dto.MetricType_SUMMARY:         "summary",
+	dto.MetricType_UNTYPED:         "untyped",
+	dto.MetricType_HISTOGRAM:       "histogram",
+	dto.MetricType_GAUGE_HISTOGRAM: "gaugehistogram",
+}
+
+// isProtobuf reports whether data starts with a length-delimited
+// MetricFamily, i.e. a varint length followed by the name field tag
+func isProtobuf(data []byte) bool {
+	length, n := binary.Uvarint(data)
+	if n <= 0 || length == 0 || uint64(len(data)-n) < length {
+		return false
+	}
+	return data[n] == 0x0a
+}
+
+// formatConfigured reports whether --format names a format rather than auto
+func formatConfigured(cfg *config.Config) bool {
+	return cfg != nil && cfg.Format != "" && cfg.Format != FormatAuto
+}
+
+// formatOf returns the configured input format, detecting it when set to auto
+func formatOf(data []byte, cfg *config.Config) string {
+	if formatConfigured(cfg) {
+		return cfg.Format
+	}
+	if isProtobuf(data) {
+		return FormatProtobuf
+	}
+	return DetectFormat(data)
+}
+
+// parseProtobuf parses length-delimited protobuf metric families. Only this
+// format carries native histograms, which have buckets but no _bucket series.
+// Messages are read one at a time into a reused buffer. A message that fails
+// to decode fails the parse in strict mode and is skipped otherwise, as its
+// length prefix tells where the next one starts.
+func parseProtobuf(r io.Reader, cfg *config.Config) (*metrics.Registry, error) {
+	registry := metrics.NewRegistry()
+	annotator := newAnnotator(cfg)
+	strict := cfg != nil && cfg.StrictParse
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		buffered := bufio.NewReader(r)
+		r, br = buffered, buffered
+	}
+
+	var report ParseReport
+	var buf []byte
+	for message := 1; ; message++ {
+		length, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			break
+		}
+		if err == nil && length > maxProtobufMessage {
+			err = fmt.Errorf("message of %d bytes exceeds the limit of %d", length, maxProtobufMessage)
+		}
+		if err == nil {
+			if uint64(cap(buf)) < length {
+				buf = make([]byte, length)
+			}
+			buf = buf[:length]
+			_, err = io.ReadFull(r, buf)
+		}
+		if err != nil {
+			// The rest of the input cannot be framed anymore
+			perr := &ParseError{Message: message, Err: fmt.Errorf("truncated protobuf message: %w", err)}
+			if strict {
+				return nil, perr
+			}
+			report.Errors = append(report.Errors, perr)
+			break
+		}
+
+		family, err := decodeFamily(buf)
+		if err != nil {
+			perr := &ParseError{Message: message, Family: family.GetName(), Err: err}
+			if strict {
+				return nil, perr
+			}
+			report.Errors = append(report.Errors, perr)
+			continue
+		}
+		addFamily(registry, annotator, family)
+	}
+
+	annotator.finish(registry)
+	if len(report.Errors) > 0 {
+		return registry, &report
+	}
+	return registry, nil
+}
+
+// maxProtobufMessage bounds the buffer of a single protobuf message
+const maxProtobufMessage = 64 << 20
+
+// decodeFamily decodes a metric family message. Strings are copied, so the
+// message buffer may be reused.
+func decodeFamily(message []byte) (*dto.MetricFamily, error) {
+	family := &dto.MetricFamily{}
+	if err := proto.Unmarshal(message, family); err != nil {
+		return nil, fmt.Errorf("invalid protobuf message: %w", err)
+	}
+	if !PromModel.IsValidMetricName(PromModel.LabelValue(family.GetName())) {
+		return family, fmt.Errorf("invalid metric name %q", family.GetName())
+	}
+	return family, nil
+}
+
+// addFamily adds a decoded metric family and its samples to the registry
+func addFamily(registry *metrics.Registry, annotator *annotator, family *dto.MetricFamily) {
+	metric := familyMetric(registry, family.GetName())
+	metric.SetHelp(family.GetHelp())
+	metric.SetType(protobufTypes[family.GetType()])
+	annotator.declareUnit(metric, family.GetUnit())
+	annotator.annotate(metric)
+
+	for _, m := range family.GetMetric() {
+		labelmap := map[string]string{"__name__": family.GetName()}
+		for _, pair := range m.GetLabel() {
+			labelmap[pair.GetName()] = pair.GetValue()
+		}
+		timestamp := m.GetTimestampMs()
+
+		switch {
+		case m.Counter != nil:
+			metric.Observe(labelmap, m.GetCounter().GetValue(), timestamp)
+			if m.GetCounter().GetExemplar() != nil {
+				metric.SetExemplars(true)
+			}
+		case m.Gauge != nil:
+			metric.Observe(labelmap, m.GetGauge().GetValue(), timestamp)
+		case m.Untyped != nil:
+			metric.Observe(labelmap, m.GetUntyped().GetValue(), timestamp)
+		case m.Summary != nil:
+			addSummary(metric, labelmap, m.GetSummary(), timestamp)
+		case m.Histogram != nil:
+			addHistogram(metric, labelmap, m.GetHistogram(), timestamp)
+		}
+	}
+}
+
+// addSummary records the quantiles of a summary like their text series
+func addSummary(metric *metrics.Metric, labelmap map[string]string, summary *dto.Summary, timestamp int64) {
+	metric.SetSuffix("_count")
+	for _, q := range summary.GetQuantile() {
+		quantile := strconv.FormatFloat(q.GetQuantile(), 'g', -1, 64)
+		metric.Observe(withLabel(labelmap, "quantile", quantile), q.GetValue(), timestamp)
+		metric.AddQuantile(quantile)
+	}
+	if len(summary.GetQuantile()) == 0 {
+		metric.Observe(labelmap, float64(summary.GetSampleCount()), timestamp)
+	}
+}
+
+// addHistogram records the classic buckets of a histogram and marks it as
+// a native histogram when it also has native buckets. A histogram may carry
+// both, the classic _bucket series then remain queryable as well.
+func addHistogram(metric *metrics.Metric, labelmap map[string]string, histogram *dto.Histogram, timestamp int64) {
+	if isNativeHistogram(histogram) {
+		metric.SetNativeHistogram(true)
+		metric.SetSuffix("")
+		metric.Observe(labelmap, histogramCount(histogram), timestamp)
+	} else if !metric.IsNativeHistogram() {
+		metric.SetSuffix("_count")
+	}
+
+	for _, bucket := range histogram.GetBucket() {
+		le := strconv.FormatFloat(bucket.GetUpperBound(), 'g', -1, 64)
+		count := float64(bucket.GetCumulativeCount())
+		if bucket.CumulativeCountFloat != nil {
+			count = bucket.GetCumulativeCountFloat()
+		}
+		metric.Observe(withLabel(labelmap, "le", le), count, timestamp)
+		if bucket.GetExemplar() != nil {
+			metric.SetExemplars(true)
+		}
+	}
+	if len(histogram.GetExemplars()) > 0 {
+		metric.SetExemplars(true)
+	}
+}
+
+// isNativeHistogram reports whether a histogram has native buckets. An
+// empty native histogram still has a zero bucket or a no-op span.
+func isNativeHistogram(histogram *dto.Histogram) bool {
+	return histogram.GetZeroThreshold() > 0 ||
+		histogram.GetZeroCount() > 0 ||
+		histogram.GetZeroCountFloat() > 0 ||
+		len(histogram.GetPositiveSpan()) > 0 ||
+		len(histogram.GetNegativeSpan()) > 0
+}
+
+// histogramCount returns the observation count of a histogram
+func histogramCount(histogram *dto.Histogram) float64 {
+	if histogram.SampleCountFloat != nil {
+		return histogram.GetSampleCountFloat()
+	}
+	return float64(histogram.GetSampleCount())
+}
+
+// withLabel returns a copy of labelmap with an additional label
+func withLabel(labelmap map[string]string, name, value string) map[string]string {
+	labels := make(map[string]string, len(labelmap)+1)
+	for k, v := range labelmap {
+		labels[k] = v
+	}
+	labels[name] = value
+	return labels
+}
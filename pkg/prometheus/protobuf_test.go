@@ -0,0 +1,208 @@
+package prometheus
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/hemzaz/lazydash/internal/config"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"google.golang.org/protobuf/proto"
+)
+
+// encodeFamilies encodes metric families in the length-delimited protobuf format
+func encodeFamilies(t *testing.T, families ...*dto.MetricFamily) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	encoder := expfmt.NewEncoder(&buf, expfmt.NewFormat(expfmt.TypeProtoDelim))
+	for _, family := range families {
+		if err := encoder.Encode(family); err != nil {
+			t.Fatalf("Failed to encode %s: %v", family.GetName(), err)
+		}
+	}
+	return buf.Bytes()
+}
+
+// protobufFixture returns a counter, a summary, a classic and a native histogram
+func protobufFixture(t *testing.T) []byte {
+	t.Helper()
+	label := func(name, value string) *dto.LabelPair {
+		return &dto.LabelPair{Name: proto.String(name), Value: proto.String(value)}
+	}
+
+	return encodeFamilies(t,
+		&dto.MetricFamily{
+			Name: proto.String("http_requests_total"),
+			Help: proto.String("Requests handled by the server."),
+			Type: dto.MetricType_COUNTER.Enum(),
+			Metric: []*dto.Metric{
+				{Label: []*dto.LabelPair{label("code", "200")}, Counter: &dto.Counter{Value: proto.Float64(1027)}},
+				{
+					Label: []*dto.LabelPair{label("code", "500")},
+					Counter: &dto.Counter{
+						Value:    proto.Float64(3),
+						Exemplar: &dto.Exemplar{Label: []*dto.LabelPair{label("trace_id", "abc")}, Value: proto.Float64(1)},
+					},
+				},
+			},
+		},
+		&dto.MetricFamily{
+			Name: proto.String("rpc_latency_seconds"),
+			Type: dto.MetricType_SUMMARY.Enum(),
+			Metric: []*dto.Metric{{
+				Summary: &dto.Summary{
+					SampleCount: proto.Uint64(10),
+					SampleSum:   proto.Float64(2.5),
+					Quantile: []*dto.Quantile{
+						{Quantile: proto.Float64(0.5), Value: proto.Float64(0.2)},
+						{Quantile: proto.Float64(0.99), Value: proto.Float64(0.9)},
+					},
+				},
+			}},
+		},
+		&dto.MetricFamily{
+			Name: proto.String("response_size_bytes"),
+			Type: dto.MetricType_HISTOGRAM.Enum(),
+			Metric: []*dto.Metric{{
+				Histogram: &dto.Histogram{
+					SampleCount: proto.Uint64(4),
+					SampleSum:   proto.Float64(2048),
+					Bucket: []*dto.Bucket{
+						{UpperBound: proto.Float64(512), CumulativeCount: proto.Uint64(2)},
+						{UpperBound: proto.Float64(1024), CumulativeCount: proto.Uint64(4)},
+					},
+				},
+			}},
+		},
+		&dto.MetricFamily{
+			Name: proto.String("request_duration_seconds"),
+			Help: proto.String("Request duration."),
+			Type: dto.MetricType_HISTOGRAM.Enum(),
+			Metric: []*dto.Metric{{
+				Label: []*dto.LabelPair{label("method", "GET")},
+				Histogram: &dto.Histogram{
+					SampleCount:   proto.Uint64(3),
+					SampleSum:     proto.Float64(0.6),
+					Schema:        proto.Int32(3),
+					ZeroThreshold: proto.Float64(1e-128),
+					PositiveSpan:  []*dto.BucketSpan{{Offset: proto.Int32(-20), Length: proto.Uint32(2)}},
+					PositiveDelta: []int64{1, 1},
+				},
+			}},
+		},
+	)
+}
+
+func TestDetectProtobuf(t *testing.T) {
+	data := protobufFixture(t)
+
+	tests := []struct {
+		name string
+		data []byte
+		cfg  *config.Config
+		want string
+	}{
+		{"Protobuf", data, nil, FormatProtobuf},
+		{"Prometheus", []byte("# TYPE up gauge\nup 1\n"), nil, FormatPrometheus},
+		{"OpenMetrics", []byte("up 1\n# EOF\n"), nil, FormatOpenMetrics},
+		{"Truncated", data[:3], nil, FormatPrometheus},
+		{"Configured", []byte("up 1\n"), &config.Config{Format: FormatOpenMetrics}, FormatOpenMetrics},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatOf(tt.data, tt.cfg); got != tt.want {
+				t.Errorf("formatOf() = %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseProtobuf(t *testing.T) {
+	registry, err := ParseMetrics(protobufFixture(t))
+	if err != nil {
+		t.Fatalf("ParseMetrics() error = %v", err)
+	}
+
+	expected := []string{"http_requests_total", "request_duration_seconds", "response_size_bytes", "rpc_latency_seconds"}
+	names := registry.List()
+	if len(names) != len(expected) {
+		t.Fatalf("Expected metrics %v, got %v", expected, names)
+	}
+	for i, name := range expected {
+		if names[i] != name {
+			t.Errorf("Expected metric %d to be %s, got %s", i, name, names[i])
+		}
+	}
+
+	t.Run("Counter", func(t *testing.T) {
+		metric := registry.Get("http_requests_total")
+		if metric.Type() != "counter" || metric.FullName() != "http_requests_total" {
+			t.Errorf("Expected counter http_requests_total, got %s %s", metric.Type(), metric.FullName())
+		}
+		if metric.Help() != "Requests handled by the server." {
+			t.Errorf("Unexpected help %q", metric.Help())
+		}
+		if metric.SeriesCount() != 2 || !metric.HasExemplars() {
+			t.Errorf("Expected 2 series with exemplars, got %d series", metric.SeriesCount())
+		}
+	})
+
+	t.Run("Summary", func(t *testing.T) {
+		metric := registry.Get("rpc_latency_seconds")
+		quantiles := metric.Quantiles()
+		if len(quantiles) != 2 || quantiles[0] != "0.5" || quantiles[1] != "0.99" {
+			t.Errorf("Expected quantiles [0.5 0.99], got %v", quantiles)
+		}
+		if metric.Unit() != "s" {
+			t.Errorf("Expected unit s, got %s", metric.Unit())
+		}
+	})
+
+	t.Run("Classic histogram", func(t *testing.T) {
+		metric := registry.Get("response_size_bytes")
+		if metric.IsNativeHistogram() {
+			t.Errorf("Expected a classic histogram")
+		}
+		if !metric.HasLabel("le") || metric.FullName() != "response_size_bytes_count" {
+			t.Errorf("Expected le buckets of response_size_bytes_count, got %s %v", metric.FullName(), metric.Labels())
+		}
+	})
+
+	t.Run("Native histogram", func(t *testing.T) {
+		metric := registry.Get("request_duration_seconds")
+		if !metric.IsNativeHistogram() {
+			t.Fatalf("Expected a native histogram")
+		}
+		if metric.Type() != "histogram" || metric.FullName() != "request_duration_seconds" {
+			t.Errorf("Expected histogram request_duration_seconds, got %s %s", metric.Type(), metric.FullName())
+		}
+		if metric.HasLabel("le") || !metric.HasLabel("method") {
+			t.Errorf("Expected only the method label, got %v", metric.Labels())
+		}
+		if _, max, _ := metric.ValueRange(); max != 3 {
+			t.Errorf("Expected the observation count 3, got %v", max)
+		}
+	})
+}
+
+func TestParseProtobufUnit(t *testing.T) {
+	data := encodeFamilies(t, &dto.MetricFamily{
+		Name:   proto.String("disk_usage"),
+		Type:   dto.MetricType_GAUGE.Enum(),
+		Unit:   proto.String("bytes"),
+		Metric: []*dto.Metric{{Gauge: &dto.Gauge{Value: proto.Float64(0.5)}}},
+	})
+
+	registry, err := ParseMetrics(data)
+	if err != nil {
+		t.Fatalf("ParseMetrics() error = %v", err)
+	}
+	metric := registry.Get("disk_usage")
+	if metric == nil {
+		t.Fatalf("Expected metric disk_usage")
+	}
+	if metric.Unit() != "decbytes" {
+		t.Errorf("Expected declared unit decbytes, got %s", metric.Unit())
+	}
+}
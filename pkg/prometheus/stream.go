@@ -0,0 +1,143 @@
+package prometheus
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/hemzaz/lazydash/internal/config"
+	"github.com/hemzaz/lazydash/pkg/metrics"
+)
+
+// chunkSize is the amount of text input parsed at a time by ParseReader.
+// Chunks end at a line break, so a longer line makes a longer chunk.
+var chunkSize = 1 << 20
+
+// peekSize is the start of a stream looked at to detect its format
+const peekSize = 64 << 10
+
+// eofLine ends OpenMetrics chunks other than the last one, which the parser
+// requires
+var eofLine = []byte("# EOF\n")
+
+// ParseReader parses metrics like ParseMetrics while reading r in bounded
+// chunks, skipping malformed metric families
+func ParseReader(r io.Reader) (*metrics.Registry, error) {
+	return ParseReaderWithConfig(r, nil)
+}
+
+// ParseReaderWithConfig parses metrics like ParseMetricsWithConfig while
+// reading r in chunks of 1 MiB, and protobuf one message at a time. The
+// input is never held in memory as a whole, so memory use depends on the
+// metrics found rather than on the size of the input.
+func ParseReaderWithConfig(r io.Reader, cfg *config.Config) (*metrics.Registry, error) {
+	br := bufio.NewReaderSize(r, peekSize)
+	format, err := streamFormat(br, r, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metrics: %w", err)
+	}
+	if format == FormatProtobuf {
+		return parseProtobuf(br, cfg)
+	}
+
+	t := newTextParser(cfg, format)
+	chunk := make([]byte, 0, chunkSize+len(eofLine))
+	for line := 1; ; {
+		var last bool
+		chunk, last, err = readChunk(br, chunk[:0])
+		if err != nil {
+			return nil, fmt.Errorf("failed to read metrics: %w", err)
+		}
+
+		data := chunk
+		if t.openMetrics && !last {
+			data = append(chunk, eofLine...)
+		}
+		if err := t.parseChunk(data, line); err != nil {
+			return nil, err
+		}
+		if last {
+			break
+		}
+		line += bytes.Count(chunk, []byte("\n"))
+	}
+	return t.finish()
+}
+
+// readChunk appends whole lines from br to chunk until it holds chunkSize
+// bytes. last is true when the input has no more data after the chunk.
+// Metric families may span chunks, as the registry keeps their metadata.
+func readChunk(br *bufio.Reader, chunk []byte) ([]byte, bool, error) {
+	for len(chunk) < chunkSize {
+		line, err := br.ReadSlice('\n')
+		chunk = append(chunk, line...)
+		// Lines longer than the buffer are read in parts
+		for errors.Is(err, bufio.ErrBufferFull) {
+			line, err = br.ReadSlice('\n')
+			chunk = append(chunk, line...)
+		}
+		if err == io.EOF {
+			return chunk, true, nil
+		}
+		if err != nil {
+			return chunk, false, err
+		}
+	}
+
+	_, err := br.Peek(1)
+	if err == io.EOF {
+		return chunk, true, nil
+	}
+	return chunk, false, err
+}
+
+// streamFormat returns the configured input format or detects it from the
+// start of the stream. OpenMetrics is told by its final # EOF: when the
+// input is longer than the peeked start, the end of a regular file is read
+// for it, otherwise # UNIT lines and exemplars at the start give it away.
+func streamFormat(br *bufio.Reader, r io.Reader, cfg *config.Config) (string, error) {
+	if formatConfigured(cfg) {
+		return cfg.Format, nil
+	}
+
+	start, err := br.Peek(peekSize)
+	if err != nil && err != io.EOF && !errors.Is(err, bufio.ErrBufferFull) {
+		return "", err
+	}
+	if length, n := binary.Uvarint(start); n > 0 && length > 0 && len(start) > n && start[n] == 0x0a {
+		return FormatProtobuf, nil
+	}
+	if err == io.EOF {
+		return DetectFormat(start), nil
+	}
+
+	if end, ok := fileEnd(r); ok {
+		return DetectFormat(end), nil
+	}
+	if bytes.Contains(start, []byte("\n# UNIT ")) || hasExemplars(start) {
+		return FormatOpenMetrics, nil
+	}
+	return FormatPrometheus, nil
+}
+
+// fileEnd reads the last bytes of a regular file without moving its offset
+func fileEnd(r io.Reader) ([]byte, bool) {
+	f, ok := r.(*os.File)
+	if !ok {
+		return nil, false
+	}
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return nil, false
+	}
+
+	end := make([]byte, min(info.Size(), 64))
+	if _, err := f.ReadAt(end, info.Size()-int64(len(end))); err != nil {
+		return nil, false
+	}
+	return end, true
+}
@@ -0,0 +1,260 @@
+package prometheus
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/hemzaz/lazydash/internal/config"
+	"github.com/hemzaz/lazydash/pkg/metrics"
+)
+
+// withChunkSize parses in small chunks for the rest of the test
+func withChunkSize(t *testing.T, size int) {
+	t.Helper()
+	previous := chunkSize
+	chunkSize = size
+	t.Cleanup(func() { chunkSize = previous })
+}
+
+func TestParseReader(t *testing.T) {
+	openMetrics, err := os.ReadFile("testdata/openmetrics.txt")
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+	prometheus, err := os.ReadFile("../../promdata.txt")
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"Prometheus", prometheus},
+		{"OpenMetrics", openMetrics},
+		{"Protobuf", protobufFixture(t)},
+		{"Malformed", []byte(malformed)},
+	}
+
+	for _, tt := range tests {
+		want, wantErr := ParseMetrics(tt.data)
+		for _, size := range []int{1, 64, 1 << 20} {
+			t.Run(fmt.Sprintf("%s in %d byte chunks", tt.name, size), func(t *testing.T) {
+				withChunkSize(t, size)
+				got, err := ParseReader(bytes.NewReader(tt.data))
+				if fmt.Sprint(err) != fmt.Sprint(wantErr) {
+					t.Errorf("ParseReader() error = %v; want %v", err, wantErr)
+				}
+				if g, w := dumpRegistry(got), dumpRegistry(want); g != w {
+					t.Errorf("ParseReader() registry differs from ParseMetrics():\n%s\nwant:\n%s", g, w)
+				}
+			})
+		}
+	}
+}
+
+// dumpRegistry formats everything parsed into a registry, comparing NaN
+// sample values as equal unlike reflect.DeepEqual
+func dumpRegistry(registry *metrics.Registry) string {
+	var buf strings.Builder
+	for _, name := range registry.List() {
+		m := registry.Get(name)
+		fmt.Fprintf(&buf, "%s %s %q %s %s %v %d %v %v %v\n", name, m.Type(), m.Help(), m.Unit(), m.Suffix(),
+			m.Labels(), m.SeriesCount(), m.Quantiles(), m.HasExemplars(), m.IsNativeHistogram())
+		for _, sample := range m.Samples() {
+			fmt.Fprintf(&buf, "  %v\n", sample)
+		}
+	}
+	return buf.String()
+}
+
+func TestParseReaderErrors(t *testing.T) {
+	withChunkSize(t, 32)
+
+	t.Run("Lines across chunks", func(t *testing.T) {
+		_, err := ParseReader(strings.NewReader(malformed))
+		var report *ParseReport
+		if !errors.As(err, &report) || len(report.Errors) != 2 {
+			t.Fatalf("Expected two parse errors, got %v", err)
+		}
+		if report.Errors[0].Line != 6 || report.Errors[1].Line != 10 {
+			t.Errorf("Expected errors on lines 6 and 10, got %v", report.Errors)
+		}
+	})
+
+	t.Run("Strict", func(t *testing.T) {
+		cfg := config.New()
+		cfg.StrictParse = true
+		registry, err := ParseReaderWithConfig(strings.NewReader(malformed), cfg)
+		var perr *ParseError
+		if !errors.As(err, &perr) || perr.Line != 6 || registry != nil {
+			t.Errorf("Expected the parse to fail on line 6, got %v", err)
+		}
+	})
+
+	t.Run("Read failure", func(t *testing.T) {
+		r := io.MultiReader(strings.NewReader(malformed), iotest.ErrReader(errors.New("connection reset")))
+		if _, err := ParseReader(r); err == nil || !strings.Contains(err.Error(), "failed to read metrics") {
+			t.Errorf("Expected the read error, got %v", err)
+		}
+	})
+}
+
+func TestStreamFormat(t *testing.T) {
+	long := strings.Repeat("# HELP up Whether the target is up\n", 2*peekSize/35)
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"Short Prometheus", "up 1\n", FormatPrometheus},
+		{"Short OpenMetrics", "up 1\n# EOF\n", FormatOpenMetrics},
+		{"Long Prometheus", long + "up 1\n", FormatPrometheus},
+		{"Long OpenMetrics with units", "# TYPE up gauge\n# UNIT up seconds\n" + long + "up 1\n# EOF\n", FormatOpenMetrics},
+		{"Long OpenMetrics with exemplars", "# TYPE jobs counter\njobs_total 1 # {trace_id=\"1\"} 1\n" + long + "# EOF\n", FormatOpenMetrics},
+		{"Protobuf", string(protobufFixture(t)), FormatProtobuf},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := strings.NewReader(tt.data)
+			got, err := streamFormat(bufio.NewReaderSize(r, peekSize), r, nil)
+			if err != nil || got != tt.want {
+				t.Errorf("streamFormat() = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+
+	t.Run("Long OpenMetrics file", func(t *testing.T) {
+		path := t.TempDir() + "/metrics.txt"
+		if err := os.WriteFile(path, []byte(long+"up 1\n# EOF\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if got, _ := streamFormat(bufio.NewReaderSize(f, peekSize), f, nil); got != FormatOpenMetrics {
+			t.Errorf("streamFormat() = %q; want %q", got, FormatOpenMetrics)
+		}
+	})
+}
+
+// exposition generates a Prometheus exposition of at least size bytes in
+// blocks of 1000 series of another instance each, so every series is new.
+// With unique set every series also has a request_id no other series has.
+type exposition struct {
+	size, read int
+	blocks     int
+	unique     bool
+	pending    []byte
+}
+
+func (e *exposition) Read(p []byte) (int, error) {
+	if len(e.pending) == 0 {
+		if e.read >= e.size {
+			return 0, io.EOF
+		}
+		var buf bytes.Buffer
+		buf.WriteString("# HELP requests_total Requests handled.\n# TYPE requests_total counter\n")
+		for i := 0; i < 1000; i++ {
+			if e.unique {
+				fmt.Fprintf(&buf, "requests_total{instance=\"host-%d\",path=\"/api/%d\",request_id=\"%d-%d\"} %d\n", e.blocks, i, e.blocks, i, i)
+				continue
+			}
+			fmt.Fprintf(&buf, "requests_total{instance=\"host-%d\",path=\"/api/%d\"} %d\n", e.blocks, i, i)
+		}
+		e.blocks++
+		e.pending = buf.Bytes()
+	}
+	n := copy(p, e.pending)
+	e.pending = e.pending[n:]
+	e.read += n
+	return n, nil
+}
+
+// peakHeap samples the heap in use every millisecond while f runs and
+// returns the peak in MB
+func peakHeap(f func()) float64 {
+	done := make(chan struct{})
+	peak := make(chan uint64)
+	go func() {
+		ticker := time.NewTicker(time.Millisecond)
+		defer ticker.Stop()
+		var stats runtime.MemStats
+		var max uint64
+		for {
+			runtime.ReadMemStats(&stats)
+			if stats.HeapInuse > max {
+				max = stats.HeapInuse
+			}
+			select {
+			case <-done:
+				peak <- max
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	f()
+	close(done)
+	return float64(<-peak) / (1 << 20)
+}
+
+// BenchmarkParseReader reports the peak heap of parsing growing inputs,
+// which stays flat when streaming and grows with the input otherwise:
+//
+//	go test ./pkg/prometheus -run ^$ -bench Parse -benchtime 3x
+func BenchmarkParseReader(b *testing.B) {
+	for _, mb := range []int{1, 16, 64} {
+		size := mb << 20
+		b.Run(fmt.Sprintf("Stream %dMB", mb), func(b *testing.B) {
+			benchmarkPeakHeap(b, &exposition{size: size}, func(r io.Reader) {
+				if _, err := ParseReader(r); err != nil {
+					b.Fatal(err)
+				}
+			})
+		})
+		b.Run(fmt.Sprintf("Stream unique label values %dMB", mb), func(b *testing.B) {
+			benchmarkPeakHeap(b, &exposition{size: size, unique: true}, func(r io.Reader) {
+				if _, err := ParseReader(r); err != nil {
+					b.Fatal(err)
+				}
+			})
+		})
+		b.Run(fmt.Sprintf("ReadAll %dMB", mb), func(b *testing.B) {
+			benchmarkPeakHeap(b, &exposition{size: size}, func(r io.Reader) {
+				data, err := io.ReadAll(r)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := ParseMetrics(data); err != nil {
+					b.Fatal(err)
+				}
+			})
+		})
+	}
+}
+
+// benchmarkPeakHeap runs parse on fresh copies of an exposition and reports
+// the largest peak heap of the runs
+func benchmarkPeakHeap(b *testing.B, input *exposition, parse func(io.Reader)) {
+	b.SetBytes(int64(input.size))
+	b.ReportAllocs()
+	var peak float64
+	for i := 0; i < b.N; i++ {
+		runtime.GC()
+		peak = max(peak, peakHeap(func() { parse(&exposition{size: input.size, unique: input.unique}) }))
+	}
+	b.ReportMetric(peak, "peak-MB")
+}
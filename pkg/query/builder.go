@@ -136,10 +136,7 @@ func BuildGaugeQuery(metric string, labels map[string]string, groupBy []string)
 }
 
 func BuildHistogramQuery(metric string, percentile float64, timeRange string) string {
-	return NewPromQLBuilder(metric + "_bucket").
-		WithRate(timeRange).
-		WithFunction("histogram_quantile", percentile).
-		Build()
+	return histogramQuantileExpr(percentile, fmt.Sprintf("rate(%s_bucket[%s])", metric, timeRange), []string{"le"})
 }
 
 func BuildErrorRateQuery(errorMetric, totalMetric, timeRange string) string {
@@ -284,7 +284,7 @@ func TestHelperFunctions(t *testing.T) {
 	t.Run("BuildHistogramQuery", func(t *testing.T) {
 		query := BuildHistogramQuery("http_request_duration_seconds", 0.95, "5m")
 		
-		expected := "rate(histogram_quantile(0.95(http_request_duration_seconds_bucket{}[5m])))"
+		expected := "histogram_quantile(0.95, sum by (le) (rate(http_request_duration_seconds_bucket[5m])))"
 		if query != expected {
 			t.Errorf("Expected query %q, got %q", expected, query)
 		}
@@ -0,0 +1,236 @@
+package query
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/hemzaz/lazydash/pkg/metrics"
+)
+
+// HistogramQuantiles are the quantiles charted for histogram metrics
+var HistogramQuantiles = []float64{0.5, 0.9, 0.99}
+
+// rateInterval is the range used when rating histogram and summary series
+const rateInterval = "5m"
+
+// buildHistogramQuery builds the primary query for histogram metrics
+func (b *Builder) buildHistogramQuery(metric *metrics.Metric) string {
+	return b.histogramQuantileQuery(metric, HistogramQuantiles[0])
+}
+
+// TailQuery returns a query of the slowest observations of a histogram or
+// summary: the 99th percentile of a histogram or the highest quantile a
+// summary exposes. It returns "" for other metrics and for summaries
+// without quantiles.
+func (b *Builder) TailQuery(metric *metrics.Metric) string {
+	if IsHistogram(metric) {
+		return b.histogramQuantileQuery(metric, HistogramQuantiles[len(HistogramQuantiles)-1])
+	}
+	quantiles := metric.Quantiles()
+	if metric.Type() != "summary" || len(quantiles) == 0 {
+		return ""
+	}
+	return b.quantileSelector(metric, quantiles[len(quantiles)-1])
+}
+
+// IsHistogram reports whether a metric is exposed as le buckets
+func IsHistogram(metric *metrics.Metric) bool {
+	return metric.Type() == "histogram" || metric.Type() == "gaugehistogram"
+}
+
+// histogramQuantileQuery builds a histogram_quantile() query that keeps the
+// metric's own labels so each labelled series gets its own quantile
+func (b *Builder) histogramQuantileQuery(metric *metrics.Metric, quantile float64) string {
+	grouping := seriesLabels(metric)
+	if !metric.IsNativeHistogram() {
+		grouping = append([]string{"le"}, grouping...)
+	}
+	return histogramQuantileExpr(quantile, b.bucketSeries(metric), grouping)
+}
+
+// bucketSeries returns the bucket counts of a histogram. Histogram buckets
+// are counters and need a rate, gauge histogram buckets are current values.
+// Native histograms carry their buckets in the series of the base name.
+func (b *Builder) bucketSeries(metric *metrics.Metric) string {
+	name := metric.Name() + "_bucket"
+	if metric.IsNativeHistogram() {
+		name = metric.Name()
+	}
+
+	bucket := b.Selector(metric, name)
+	if metric.Type() == "gaugehistogram" {
+		return bucket
+	}
+	return fmt.Sprintf("rate(%s[%s])", bucket, rateInterval)
+}
+
+// buildHistogramTargets creates quantile, request rate and average targets
+func (b *Builder) buildHistogramTargets(metric *metrics.Metric) []Target {
+	legend := b.seriesLegend(metric)
+	targets := make([]Target, 0, len(HistogramQuantiles)+2)
+
+	for _, quantile := range HistogramQuantiles {
+		targets = append(targets, Target{
+			Expr:         b.histogramQuantileQuery(metric, quantile),
+			LegendFormat: prefixLegend(quantileLabel(quantile), legend),
+		})
+	}
+
+	targets = append(targets, b.rateAndAverageTargets(metric, legend)...)
+	return assignRefIDs(targets)
+}
+
+// rateAndAverageTargets creates the observation rate and average targets
+// from the _count and _sum series shared by histograms and summaries
+func (b *Builder) rateAndAverageTargets(metric *metrics.Metric, legend string) []Target {
+	by := aggregation(seriesLabels(metric))
+	if metric.IsNativeHistogram() {
+		return b.nativeCountAndAverageTargets(metric, legend, by)
+	}
+	if metric.Type() == "gaugehistogram" {
+		return b.gaugeCountAndAverageTargets(metric, legend, by)
+	}
+
+	count := b.Selector(metric, metric.Name()+"_count")
+	sum := b.Selector(metric, metric.Name()+"_sum")
+
+	return []Target{
+		{
+			Expr:         fmt.Sprintf("sum%s(rate(%s[%s]))", by, count, rateInterval),
+			LegendFormat: prefixLegend("rate", legend),
+		},
+		{
+			Expr: fmt.Sprintf("sum%s(rate(%s[%s])) / sum%s(rate(%s[%s]))",
+				by, sum, rateInterval, by, count, rateInterval),
+			LegendFormat: prefixLegend("avg", legend),
+		},
+	}
+}
+
+// gaugeCountAndAverageTargets creates the current count and average targets
+// from the _gcount and _gsum gauges of a gauge histogram
+func (b *Builder) gaugeCountAndAverageTargets(metric *metrics.Metric, legend, by string) []Target {
+	count := b.Selector(metric, metric.Name()+"_gcount")
+	sum := b.Selector(metric, metric.Name()+"_gsum")
+
+	return []Target{
+		{
+			Expr:         fmt.Sprintf("sum%s(%s)", by, count),
+			LegendFormat: prefixLegend("count", legend),
+		},
+		{
+			Expr:         fmt.Sprintf("sum%s(%s) / sum%s(%s)", by, sum, by, count),
+			LegendFormat: prefixLegend("avg", legend),
+		},
+	}
+}
+
+// nativeCountAndAverageTargets creates the observation rate and average
+// targets of a native histogram, which has no _count and _sum series
+func (b *Builder) nativeCountAndAverageTargets(metric *metrics.Metric, legend, by string) []Target {
+	series := b.bucketSeries(metric)
+
+	return []Target{
+		{
+			Expr:         fmt.Sprintf("sum%s(histogram_count(%s))", by, series),
+			LegendFormat: prefixLegend("rate", legend),
+		},
+		{
+			Expr: fmt.Sprintf("sum%s(histogram_sum(%s)) / sum%s(histogram_count(%s))",
+				by, series, by, series),
+			LegendFormat: prefixLegend("avg", legend),
+		},
+	}
+}
+
+// BuildHeatmapTargets creates a bucket distribution target for heatmap panels
+// Native histograms are summed as whole histograms, Grafana splits the
+// resulting histogram samples into buckets itself.
+func (b *Builder) BuildHeatmapTargets(metric *metrics.Metric) []Target {
+	if metric.IsNativeHistogram() {
+		return assignRefIDs([]Target{
+			{
+				Expr:   fmt.Sprintf("sum(%s)", b.bucketSeries(metric)),
+				Format: "heatmap",
+			},
+		})
+	}
+
+	return assignRefIDs([]Target{
+		{
+			Expr:         fmt.Sprintf("sum by (le) (%s)", b.bucketSeries(metric)),
+			LegendFormat: "{{le}}",
+			Format:       "heatmap",
+		},
+	})
+}
+
+// histogramQuantileExpr builds histogram_quantile() over bucket counts
+func histogramQuantileExpr(quantile float64, buckets string, grouping []string) string {
+	return fmt.Sprintf("histogram_quantile(%s, sum%s(%s))",
+		strconv.FormatFloat(quantile, 'f', -1, 64), aggregation(grouping), buckets)
+}
+
+// aggregation returns a " by (...) " clause, or an empty string without labels
+func aggregation(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	return " by (" + strings.Join(labels, ", ") + ") "
+}
+
+// seriesLabels returns the metric labels that identify a series, without
+// the bucket and quantile labels that split a single observation
+func seriesLabels(metric *metrics.Metric) []string {
+	var labels []string
+	for _, label := range metric.Labels() {
+		if label != "le" && label != "quantile" {
+			labels = append(labels, label)
+		}
+	}
+	return labels
+}
+
+// seriesLegend formats a legend from the series labels of a metric
+func (b *Builder) seriesLegend(metric *metrics.Metric) string {
+	parts := []string{}
+	for _, label := range seriesLabels(metric) {
+		parts = append(parts, fmt.Sprintf("%s:[{{%s}}]", label, label))
+	}
+	return strings.Join(parts, " ")
+}
+
+// prefixLegend prepends a series role such as p99 to a legend
+func prefixLegend(prefix, legend string) string {
+	if legend == "" {
+		return prefix
+	}
+	return prefix + " " + legend
+}
+
+// quantileLabel formats a quantile as a percentile name, e.g. 0.99 -> p99
+func quantileLabel(quantile float64) string {
+	percentile := math.Round(quantile*100*1e6) / 1e6
+	return "p" + strconv.FormatFloat(percentile, 'f', -1, 64)
+}
+
+// assignRefIDs gives targets sequential RefIDs (A, B, C, ...) and a default format
+func assignRefIDs(targets []Target) []Target {
+	for i := range targets {
+		targets[i].RefID = refID(i)
+		if targets[i].Format == "" {
+			targets[i].Format = "time_series"
+		}
+	}
+	return targets
+}
+
+// refID returns the Grafana RefID for the i-th target
+func refID(i int) string {
+	if i < 26 {
+		return string(rune('A' + i))
+	}
+	return refID(i/26-1) + refID(i%26)
+}
@@ -0,0 +1,254 @@
+package query
+
+import (
+	"testing"
+
+	"github.com/hemzaz/lazydash/internal/config"
+	"github.com/hemzaz/lazydash/pkg/metrics"
+)
+
+func TestBuildHistogramTargets(t *testing.T) {
+	cfg := config.New()
+	builder := NewBuilder(cfg)
+
+	// Histogram without labels
+	t.Run("Without labels", func(t *testing.T) {
+		metric := metrics.New("http_request_duration_seconds", "", map[string]bool{"le": true}, "histogram", "_count", "s")
+		targets := builder.BuildTargets(metric)
+
+		expected := []Target{
+			{
+				Expr:         "histogram_quantile(0.5, sum by (le) (rate(http_request_duration_seconds_bucket[5m])))",
+				LegendFormat: "p50",
+				RefID:        "A",
+				Format:       "time_series",
+			},
+			{
+				Expr:         "histogram_quantile(0.9, sum by (le) (rate(http_request_duration_seconds_bucket[5m])))",
+				LegendFormat: "p90",
+				RefID:        "B",
+				Format:       "time_series",
+			},
+			{
+				Expr:         "histogram_quantile(0.99, sum by (le) (rate(http_request_duration_seconds_bucket[5m])))",
+				LegendFormat: "p99",
+				RefID:        "C",
+				Format:       "time_series",
+			},
+			{
+				Expr:         "sum(rate(http_request_duration_seconds_count[5m]))",
+				LegendFormat: "rate",
+				RefID:        "D",
+				Format:       "time_series",
+			},
+			{
+				Expr:         "sum(rate(http_request_duration_seconds_sum[5m])) / sum(rate(http_request_duration_seconds_count[5m]))",
+				LegendFormat: "avg",
+				RefID:        "E",
+				Format:       "time_series",
+			},
+		}
+
+		if len(targets) != len(expected) {
+			t.Fatalf("Expected %d targets, got %d", len(expected), len(targets))
+		}
+		for i := range expected {
+			if targets[i] != expected[i] {
+				t.Errorf("Target %d = %+v; want %+v", i, targets[i], expected[i])
+			}
+		}
+	})
+
+	// Histogram labels are kept in the aggregation and legend
+	t.Run("With labels", func(t *testing.T) {
+		metric := metrics.New("engine_daemon_container_actions_seconds", "", map[string]bool{"le": true, "action": true}, "histogram", "", "s")
+		targets := builder.BuildTargets(metric)
+
+		expectedExpr := "histogram_quantile(0.99, sum by (le, action) (rate(engine_daemon_container_actions_seconds_bucket[5m])))"
+		if targets[2].Expr != expectedExpr {
+			t.Errorf("Expected p99 query %q, got %q", expectedExpr, targets[2].Expr)
+		}
+		if targets[2].LegendFormat != "p99 action:[{{action}}]" {
+			t.Errorf("Expected legend %q, got %q", "p99 action:[{{action}}]", targets[2].LegendFormat)
+		}
+
+		expectedRate := "sum by (action) (rate(engine_daemon_container_actions_seconds_count[5m]))"
+		if targets[3].Expr != expectedRate {
+			t.Errorf("Expected rate query %q, got %q", expectedRate, targets[3].Expr)
+		}
+	})
+
+	// BuildQuery returns the first quantile rather than a raw series
+	t.Run("BuildQuery", func(t *testing.T) {
+		metric := metrics.New("rpc_latency_seconds", "", nil, "histogram", "_bucket", "s")
+		query := builder.BuildQuery(metric)
+
+		expected := "histogram_quantile(0.5, sum by (le) (rate(rpc_latency_seconds_bucket[5m])))"
+		if query != expected {
+			t.Errorf("Expected query %q, got %q", expected, query)
+		}
+	})
+
+	// Template variable matchers apply to every series
+	t.Run("With template variables", func(t *testing.T) {
+		cfg := config.New()
+		cfg.Templating = &config.TemplatingConfig{Enabled: true}
+		builder := NewBuilder(cfg)
+
+		metric := metrics.New("rpc_latency_seconds", "", nil, "histogram", "", "s")
+		targets := builder.BuildTargets(metric)
+
+		expected := "sum(rate(rpc_latency_seconds_sum{job=~\"$job\",instance=~\"$instance\"}[5m])) / sum(rate(rpc_latency_seconds_count{job=~\"$job\",instance=~\"$instance\"}[5m]))"
+		if targets[4].Expr != expected {
+			t.Errorf("Expected average query %q, got %q", expected, targets[4].Expr)
+		}
+	})
+}
+
+func TestBuildHeatmapTargets(t *testing.T) {
+	cfg := config.New()
+	builder := NewBuilder(cfg)
+
+	metric := metrics.New("rpc_latency_seconds", "", map[string]bool{"le": true, "method": true}, "histogram", "", "s")
+	targets := builder.BuildHeatmapTargets(metric)
+
+	if len(targets) != 1 {
+		t.Fatalf("Expected 1 target, got %d", len(targets))
+	}
+
+	expected := Target{
+		Expr:         "sum by (le) (rate(rpc_latency_seconds_bucket[5m]))",
+		LegendFormat: "{{le}}",
+		RefID:        "A",
+		Format:       "heatmap",
+	}
+	if targets[0] != expected {
+		t.Errorf("Heatmap target = %+v; want %+v", targets[0], expected)
+	}
+}
+
+func TestBuildTargetsSingleQuery(t *testing.T) {
+	cfg := config.New()
+	builder := NewBuilder(cfg)
+
+	metric := metrics.New("go_goroutines", "", nil, "gauge", "", "")
+	targets := builder.BuildTargets(metric)
+
+	if len(targets) != 1 {
+		t.Fatalf("Expected 1 target, got %d", len(targets))
+	}
+	if targets[0].Expr != "go_goroutines" || targets[0].RefID != "A" || targets[0].Format != "time_series" {
+		t.Errorf("Unexpected target %+v", targets[0])
+	}
+	if targets[0].LegendFormat != cfg.GaugeLegend {
+		t.Errorf("Expected legend %q, got %q", cfg.GaugeLegend, targets[0].LegendFormat)
+	}
+}
+
+func TestQuantileLabel(t *testing.T) {
+	tests := map[float64]string{0.5: "p50", 0.9: "p90", 0.99: "p99", 0.999: "p99.9"}
+	for quantile, expected := range tests {
+		if label := quantileLabel(quantile); label != expected {
+			t.Errorf("quantileLabel(%v) = %q; want %q", quantile, label, expected)
+		}
+	}
+}
+
+func TestRefID(t *testing.T) {
+	tests := map[int]string{0: "A", 4: "E", 25: "Z", 26: "AA", 27: "AB"}
+	for i, expected := range tests {
+		if id := refID(i); id != expected {
+			t.Errorf("refID(%d) = %q; want %q", i, id, expected)
+		}
+	}
+}
+
+func TestBuildGaugeHistogramTargets(t *testing.T) {
+	cfg := config.New()
+	builder := NewBuilder(cfg)
+
+	metric := metrics.New("queue_wait_seconds", "", map[string]bool{"le": true}, "gaugehistogram", "_gsum", "s")
+	targets := builder.BuildTargets(metric)
+
+	// Gauge histogram buckets are current values and are not rated
+	expected := []string{
+		"histogram_quantile(0.5, sum by (le) (queue_wait_seconds_bucket))",
+		"histogram_quantile(0.9, sum by (le) (queue_wait_seconds_bucket))",
+		"histogram_quantile(0.99, sum by (le) (queue_wait_seconds_bucket))",
+		"sum(queue_wait_seconds_gcount)",
+		"sum(queue_wait_seconds_gsum) / sum(queue_wait_seconds_gcount)",
+	}
+	if len(targets) != len(expected) {
+		t.Fatalf("Expected %d targets, got %d", len(expected), len(targets))
+	}
+	for i, expr := range expected {
+		if targets[i].Expr != expr {
+			t.Errorf("Target %d query = %q; want %q", i, targets[i].Expr, expr)
+		}
+	}
+	if targets[3].LegendFormat != "count" {
+		t.Errorf("Expected legend %q, got %q", "count", targets[3].LegendFormat)
+	}
+
+	heatmap := builder.BuildHeatmapTargets(metric)
+	if heatmap[0].Expr != "sum by (le) (queue_wait_seconds_bucket)" {
+		t.Errorf("Unexpected heatmap query %q", heatmap[0].Expr)
+	}
+}
+
+func TestBuildNativeHistogramTargets(t *testing.T) {
+	cfg := config.New()
+	builder := NewBuilder(cfg)
+
+	metric := metrics.New("rpc_duration_seconds", "", map[string]bool{"method": true}, "histogram", "", "s")
+	metric.SetNativeHistogram(true)
+	targets := builder.BuildTargets(metric)
+
+	// Native histograms are queried by their base name without le grouping
+	expected := []string{
+		"histogram_quantile(0.5, sum by (method) (rate(rpc_duration_seconds[5m])))",
+		"histogram_quantile(0.9, sum by (method) (rate(rpc_duration_seconds[5m])))",
+		"histogram_quantile(0.99, sum by (method) (rate(rpc_duration_seconds[5m])))",
+		"sum by (method) (histogram_count(rate(rpc_duration_seconds[5m])))",
+		"sum by (method) (histogram_sum(rate(rpc_duration_seconds[5m]))) / sum by (method) (histogram_count(rate(rpc_duration_seconds[5m])))",
+	}
+	if len(targets) != len(expected) {
+		t.Fatalf("Expected %d targets, got %d", len(expected), len(targets))
+	}
+	for i, expr := range expected {
+		if targets[i].Expr != expr {
+			t.Errorf("Target %d query = %q; want %q", i, targets[i].Expr, expr)
+		}
+	}
+
+	if query := builder.BuildQuery(metric); query != expected[0] {
+		t.Errorf("BuildQuery() = %q; want %q", query, expected[0])
+	}
+
+	heatmap := builder.BuildHeatmapTargets(metric)
+	if heatmap[0].Expr != "sum(rate(rpc_duration_seconds[5m]))" || heatmap[0].Format != "heatmap" {
+		t.Errorf("Unexpected heatmap target %+v", heatmap[0])
+	}
+}
+
+func TestTailQuery(t *testing.T) {
+	builder := NewBuilder(config.New())
+	tests := []struct {
+		name   string
+		metric *metrics.Metric
+		want   string
+	}{
+		{"Histogram", metrics.New("http_request_duration_seconds", "", map[string]bool{"le": true}, "histogram", "_count", "s"),
+			"histogram_quantile(0.99, sum by (le) (rate(http_request_duration_seconds_bucket[5m])))"},
+		{"Summary", newSummary("rpc_duration_seconds", nil, "0", "0.99", "0.5"), "rpc_duration_seconds{quantile=\"0.99\"}"},
+		{"Summary without quantiles", newSummary("rpc_duration_seconds", nil), ""},
+		{"Gauge", metrics.New("queue_length", "", nil, "gauge", "", ""), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := builder.TailQuery(tt.metric); got != tt.want {
+				t.Errorf("TailQuery() = %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,17 +3,30 @@ package query
 
 import (
 	"fmt"
+	"regexp"
 	"strings"
 
 	"github.com/hemzaz/lazydash/internal/config"
 	"github.com/hemzaz/lazydash/pkg/metrics"
 )
 
+// TargetLabels are the labels Prometheus attaches to every scraped series.
+// They never appear in exposition text but always exist once scraped.
+var TargetLabels = []string{"job", "instance"}
+
 // Builder creates PromQL queries for different metric types
 type Builder struct {
 	config *config.Config
 }
 
+// Target is a single query of a panel
+type Target struct {
+	Expr         string
+	LegendFormat string
+	RefID        string
+	Format       string // time_series or heatmap
+}
+
 // NewBuilder creates a new PromQL query builder with configuration
 func NewBuilder(cfg *config.Config) *Builder {
 	return &Builder{
@@ -24,7 +37,7 @@ func NewBuilder(cfg *config.Config) *Builder {
 // BuildQuery creates a PromQL query for a metric based on its type
 func (b *Builder) BuildQuery(metric *metrics.Metric) string {
 	// Special handling for JTIMON metrics from Juniper devices
-	if metric.Vendor() == "juniper" && strings.HasPrefix(metric.Name(), "_") {
+	if isJuniperJtimon(metric) {
 		return b.buildJuniperJtimonQuery(metric)
 	}
 
@@ -35,29 +48,134 @@ func (b *Builder) BuildQuery(metric *metrics.Metric) string {
 		return b.buildGaugeQuery(metric)
 	case "summary":
 		return b.buildSummaryQuery(metric)
+	case "histogram", "gaugehistogram":
+		return b.buildHistogramQuery(metric)
 	default:
-		return metric.FullName()
+		return b.Selector(metric, metric.FullName())
+	}
+}
+
+// BuildTargets creates the panel targets for a metric. Most metrics get a
+// single target; histograms and summaries get quantile, rate and average targets.
+func (b *Builder) BuildTargets(metric *metrics.Metric) []Target {
+	if !isJuniperJtimon(metric) {
+		switch metric.Type() {
+		case "histogram", "gaugehistogram":
+			return b.buildHistogramTargets(metric)
+		case "summary":
+			if b.usesQuantileTargets() {
+				return b.buildSummaryTargets(metric)
+			}
+		}
 	}
+	
+	return assignRefIDs([]Target{
+		{
+			Expr:         b.BuildQuery(metric),
+			LegendFormat: b.GetLegend(metric),
+		},
+	})
+}
+
+// ResultUnit returns the unit of the values BuildQuery charts for a metric.
+// Histogram and summary quantiles keep the unit of the observations.
+func (b *Builder) ResultUnit(metric *metrics.Metric) string {
+	switch metric.Type() {
+	case "histogram", "gaugehistogram", "summary":
+		return metric.Unit()
+	}
+	return ExprUnit(b.BuildQuery(metric), metric)
+}
+
+// ExprUnit returns the unit of the values of an expression over a metric.
+// Rates are per second, so rate(x_bytes_total[1m]) is in Bps and the rate
+// of a seconds counter is the fraction of time spent.
+func ExprUnit(expr string, metric *metrics.Metric) string {
+	if strings.Contains(expr, "rate(") && !strings.Contains(expr, "histogram_quantile(") {
+		return metrics.RateUnit(metric.Unit())
+	}
+	return metric.Unit()
 }
 
 // buildCounterQuery builds a rate-based query for counter metrics
 func (b *Builder) buildCounterQuery(metric *metrics.Metric) string {
 	tmpl := b.config.CounterExprTmpl
 	metricName := metric.Name() + metric.Suffix()
-	return strings.Replace(tmpl, b.config.Delimiter, metricName, -1)
+	return b.substitute(tmpl, metric, metricName)
 }
 
 // buildGaugeQuery builds a query for gauge metrics
 func (b *Builder) buildGaugeQuery(metric *metrics.Metric) string {
 	tmpl := b.config.GaugeExprTmpl
 	metricName := metric.Name() + metric.Suffix()
-	return strings.Replace(tmpl, b.config.Delimiter, metricName, -1)
+	return b.substitute(tmpl, metric, metricName)
+}
+
+// BuildTemplateQuery creates a query from an expression template, e.g. a
+// spec rule, inserting the metric's series at the delimiter
+func (b *Builder) BuildTemplateQuery(tmpl string, metric *metrics.Metric) string {
+	return b.substitute(tmpl, metric, metric.Name()+metric.Suffix())
+}
+
+// substitute inserts the series selector into an expression template.
+// Matchers go after the name the delimiter starts, so ":METRIC:_sum" selects
+// foo_sum{...}. A template that already adds matchers
+// (":METRIC:{code=\"200\"}") has the template variable matchers merged
+// into its braces.
+func (b *Builder) substitute(tmpl string, metric *metrics.Metric, series string) string {
+	matchers := b.labelMatchers(metric)
+	if matchers == "" {
+		return strings.Replace(tmpl, b.config.Delimiter, series, -1)
+	}
+	
+	selector := regexp.MustCompile(regexp.QuoteMeta(b.config.Delimiter) + `([a-zA-Z0-9_:]*)(\{?)`)
+	return selector.ReplaceAllStringFunc(tmpl, func(match string) string {
+		parts := selector.FindStringSubmatch(match)
+		if parts[2] == "{" {
+			return series + parts[1] + "{" + matchers + ","
+		}
+		return series + parts[1] + "{" + matchers + "}"
+	})
 }
 
-// buildSummaryQuery builds a query for summary metrics
-func (b *Builder) buildSummaryQuery(metric *metrics.Metric) string {
-	tmpl := b.config.SummaryExprTmpl
-	return strings.Replace(tmpl, b.config.Delimiter, metric.Name(), -1)
+// Selector returns a selector for the series of a metric filtered by the
+// dashboard template variables, e.g. foo_total{job=~"$job",instance=~"$instance"}
+func (b *Builder) Selector(metric *metrics.Metric, series string) string {
+	matchers := b.labelMatchers(metric)
+	if matchers == "" {
+		return series
+	}
+	return series + "{" + matchers + "}"
+}
+
+// labelMatchers returns the template variable matchers that apply to a metric
+func (b *Builder) labelMatchers(metric *metrics.Metric) string {
+	templating := b.config.Templating
+	if templating == nil || !templating.Enabled {
+		return ""
+	}
+	
+	parts := make([]string, 0, len(TargetLabels)+len(templating.Labels))
+	for _, label := range TargetLabels {
+		parts = append(parts, fmt.Sprintf("%s=~\"$%s\"", label, label))
+	}
+	for _, label := range templating.Labels {
+		if metric.HasLabel(label) && !IsTargetLabel(label) {
+			parts = append(parts, fmt.Sprintf("%s=~\"$%s\"", label, label))
+		}
+	}
+	
+	return strings.Join(parts, ",")
+}
+
+// IsTargetLabel reports whether a label is attached by Prometheus at scrape time
+func IsTargetLabel(label string) bool {
+	for _, target := range TargetLabels {
+		if label == target {
+			return true
+		}
+	}
+	return false
 }
 
 // FormatLegend creates a legend format string based on metric labels
@@ -79,6 +197,11 @@ func (b *Builder) FormatLegend(metric *metrics.Metric, fallback string) string {
 	return strings.Join(parts, " ")
 }
 
+// isJuniperJtimon reports whether a metric was exported by Juniper JTIMON
+func isJuniperJtimon(metric *metrics.Metric) bool {
+	return metric.Vendor() == "juniper" && strings.HasPrefix(metric.Name(), "_")
+}
+
 // buildJuniperJtimonQuery builds special queries for Juniper JTIMON metrics
 func (b *Builder) buildJuniperJtimonQuery(metric *metrics.Metric) string {
 	metricName := metric.Name()
@@ -90,7 +213,7 @@ func (b *Builder) buildJuniperJtimonQuery(metric *metrics.Metric) string {
 		   strings.Contains(metricName, "_frames_") || strings.Contains(metricName, "_drops_") ||
 		   strings.Contains(metricName, "_errors_") || strings.Contains(metricName, "_packets_") {
 			// Use rate for counter metrics
-			return fmt.Sprintf("rate(%s[5m])", metricName)
+			return fmt.Sprintf("rate(%s[5m])", b.Selector(metric, metricName))
 		}
 	}
 	
@@ -98,17 +221,17 @@ func (b *Builder) buildJuniperJtimonQuery(metric *metrics.Metric) string {
 	if strings.Contains(metricName, "_cpu_") || strings.Contains(metricName, "_memory_") ||
 	   strings.Contains(metricName, "_utilization_") || strings.Contains(metricName, "_temperature_") {
 		// For certain metrics, filter on device to get per-device values
-		return fmt.Sprintf("%s", metricName)
+		return b.Selector(metric, metricName)
 	}
 	
 	// Default query for other JTIMON metrics
-	return metricName
+	return b.Selector(metric, metricName)
 }
 
 // GetLegend returns the appropriate legend format for a metric type
 func (b *Builder) GetLegend(metric *metrics.Metric) string {
 	// Special legend format for Juniper JTIMON metrics
-	if metric.Vendor() == "juniper" && strings.HasPrefix(metric.Name(), "_") {
+	if isJuniperJtimon(metric) {
 		// Build a legend format using the most relevant labels
 		labels := metric.Labels()
 		if len(labels) > 0 {
@@ -142,6 +265,8 @@ func (b *Builder) GetLegend(metric *metrics.Metric) string {
 		return b.FormatLegend(metric, b.config.GaugeLegend)
 	case "summary":
 		return b.FormatLegend(metric, b.config.SummaryLegend)
+	case "histogram", "gaugehistogram":
+		return b.seriesLegend(metric)
 	default:
 		return b.FormatLegend(metric, "")
 	}
@@ -256,4 +256,138 @@ func TestGetLegend(t *testing.T) {
 			t.Errorf("Expected legend %q, got %q", expected, legend)
 		}
 	})
-}
\ No newline at end of file
+}
+func TestTemplateMatchers(t *testing.T) {
+	cfg := config.New()
+	cfg.Templating = &config.TemplatingConfig{Enabled: true, Labels: []string{"method", "job"}}
+	builder := NewBuilder(cfg)
+	
+	// Target labels are always injected
+	t.Run("Counter metric", func(t *testing.T) {
+		metric := metrics.New("http_requests", "", nil, "counter", "_total", "")
+		query := builder.BuildQuery(metric)
+		
+		expected := "sum(rate(http_requests_total{job=~\"$job\",instance=~\"$instance\"} [1m]))"
+		if query != expected {
+			t.Errorf("Expected query %q, got %q", expected, query)
+		}
+	})
+	
+	// Extra labels are only injected when the metric has them
+	t.Run("Metric with extra label", func(t *testing.T) {
+		metric := metrics.New("http_requests", "", map[string]bool{"method": true}, "gauge", "", "")
+		query := builder.BuildQuery(metric)
+		
+		expected := "http_requests{job=~\"$job\",instance=~\"$instance\",method=~\"$method\"}"
+		if query != expected {
+			t.Errorf("Expected query %q, got %q", expected, query)
+		}
+	})
+	
+	// Matchers merge into braces already present in the template
+	t.Run("Template with matchers", func(t *testing.T) {
+		cfg := config.New()
+		cfg.Templating = &config.TemplatingConfig{Enabled: true}
+		cfg.GaugeExprTmpl = ":METRIC:{code=\"200\"}"
+		builder := NewBuilder(cfg)
+		
+		metric := metrics.New("http_responses", "", nil, "gauge", "", "")
+		query := builder.BuildQuery(metric)
+		
+		expected := "http_responses{job=~\"$job\",instance=~\"$instance\",code=\"200\"}"
+		if query != expected {
+			t.Errorf("Expected query %q, got %q", expected, query)
+		}
+	})
+	
+	// Matchers follow the name suffixed to the delimiter
+	t.Run("Suffixed template", func(t *testing.T) {
+		cfg := config.New()
+		cfg.Templating = &config.TemplatingConfig{Enabled: true}
+		builder := NewBuilder(cfg)
+		
+		metric := metrics.New("rpc_duration_seconds", "", nil, "summary", "", "s")
+		query := builder.BuildTemplateQuery("rate(:METRIC:_sum[5m]) / rate(:METRIC:_count[5m])", metric)
+		
+		expected := "rate(rpc_duration_seconds_sum{job=~\"$job\",instance=~\"$instance\"}[5m]) / rate(rpc_duration_seconds_count{job=~\"$job\",instance=~\"$instance\"}[5m])"
+		if query != expected {
+			t.Errorf("Expected query %q, got %q", expected, query)
+		}
+	})
+	
+	// Suffixed names merge their matchers into the braces of the template
+	t.Run("Suffixed template with matchers", func(t *testing.T) {
+		cfg := config.New()
+		cfg.Templating = &config.TemplatingConfig{Enabled: true}
+		builder := NewBuilder(cfg)
+		
+		metric := metrics.New("rpc_duration_seconds", "", nil, "histogram", "", "s")
+		query := builder.BuildTemplateQuery("sum(rate(:METRIC:_bucket{le=\"+Inf\"}[5m]))", metric)
+		
+		expected := "sum(rate(rpc_duration_seconds_bucket{job=~\"$job\",instance=~\"$instance\",le=\"+Inf\"}[5m]))"
+		if query != expected {
+			t.Errorf("Expected query %q, got %q", expected, query)
+		}
+	})
+	
+	// Juniper queries use the selector too
+	t.Run("Juniper metric", func(t *testing.T) {
+		metric := metrics.New("_juniper_interfaces_counters_in_octets", "", nil, "counter", "", "")
+		metric.SetVendor("juniper")
+		query := builder.BuildQuery(metric)
+		
+		expected := "rate(_juniper_interfaces_counters_in_octets{job=~\"$job\",instance=~\"$instance\"}[5m])"
+		if query != expected {
+			t.Errorf("Expected query %q, got %q", expected, query)
+		}
+	})
+	
+	// Disabled templating leaves queries untouched
+	t.Run("Disabled", func(t *testing.T) {
+		cfg := config.New()
+		cfg.Templating = &config.TemplatingConfig{Enabled: false}
+		builder := NewBuilder(cfg)
+		
+		metric := metrics.New("go_goroutines", "", nil, "gauge", "", "")
+		if query := builder.BuildQuery(metric); query != "go_goroutines" {
+			t.Errorf("Expected query %q, got %q", "go_goroutines", query)
+		}
+	})
+}
+
+func TestResultUnit(t *testing.T) {
+	builder := NewBuilder(config.New())
+	tests := []struct {
+		name, mtype, unit string
+		want              string
+	}{
+		{"rx_bytes_total", "counter", "decbytes", "Bps"},
+		{"process_cpu_seconds_total", "counter", "s", "percentunit"},
+		{"rx_packets_total", "counter", "suffix: packets", "pps"},
+		{"requests_total", "counter", "short", "short"},
+		{"heap_bytes", "gauge", "decbytes", "decbytes"},
+		{"request_duration_seconds", "histogram", "s", "s"},
+		{"rpc_latency_seconds", "summary", "s", "s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metric := metrics.New(tt.name, "", nil, tt.mtype, "", tt.unit)
+			if got := builder.ResultUnit(metric); got != tt.want {
+				t.Errorf("ResultUnit() = %q; want %q", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("Expressions", func(t *testing.T) {
+		metric := metrics.New("rx_bytes_total", "", nil, "counter", "", "decbytes")
+		for expr, want := range map[string]string{
+			"irate(rx_bytes_total[5m])":    "Bps",
+			"increase(rx_bytes_total[1h])": "decbytes",
+			"rx_bytes_total":               "decbytes",
+		} {
+			if got := ExprUnit(expr, metric); got != want {
+				t.Errorf("ExprUnit(%q) = %q; want %q", expr, got, want)
+			}
+		}
+	})
+}
@@ -0,0 +1,59 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/hemzaz/lazydash/pkg/metrics"
+)
+
+// buildSummaryQuery builds the primary query for summary metrics
+func (b *Builder) buildSummaryQuery(metric *metrics.Metric) string {
+	quantiles := metric.Quantiles()
+	if !b.usesQuantileTargets() || len(quantiles) == 0 {
+		return b.substitute(b.config.SummaryExprTmpl, metric, metric.Name())
+	}
+	return b.quantileSelector(metric, quantiles[0])
+}
+
+// usesQuantileTargets reports whether summaries are split per quantile.
+// A custom --set-summary-expr keeps the single templated target instead.
+func (b *Builder) usesQuantileTargets() bool {
+	return b.config.SummaryExprTmpl == b.config.Delimiter
+}
+
+// buildSummaryTargets creates one target per observed quantile followed by
+// the observation rate and average targets
+func (b *Builder) buildSummaryTargets(metric *metrics.Metric) []Target {
+	legend := b.seriesLegend(metric)
+	quantiles := metric.Quantiles()
+	targets := make([]Target, 0, len(quantiles)+2)
+
+	for _, quantile := range quantiles {
+		targets = append(targets, Target{
+			Expr:         b.quantileSelector(metric, quantile),
+			LegendFormat: prefixLegend(summaryQuantileLabel(quantile), legend),
+		})
+	}
+
+	targets = append(targets, b.rateAndAverageTargets(metric, legend)...)
+	return assignRefIDs(targets)
+}
+
+// quantileSelector selects a single precomputed quantile of a summary
+func (b *Builder) quantileSelector(metric *metrics.Metric, quantile string) string {
+	matchers := fmt.Sprintf("quantile=%q", quantile)
+	if extra := b.labelMatchers(metric); extra != "" {
+		matchers += "," + extra
+	}
+	return metric.Name() + "{" + matchers + "}"
+}
+
+// summaryQuantileLabel formats a quantile label value as a percentile name
+func summaryQuantileLabel(quantile string) string {
+	value, err := strconv.ParseFloat(quantile, 64)
+	if err != nil {
+		return "q" + quantile
+	}
+	return quantileLabel(value)
+}
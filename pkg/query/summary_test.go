@@ -0,0 +1,124 @@
+package query
+
+import (
+	"testing"
+
+	"github.com/hemzaz/lazydash/internal/config"
+	"github.com/hemzaz/lazydash/pkg/metrics"
+)
+
+// newSummary creates a summary metric with the given observed quantiles
+func newSummary(name string, labels map[string]bool, quantiles ...string) *metrics.Metric {
+	metric := metrics.New(name, "", labels, "summary", "", "s")
+	for _, quantile := range quantiles {
+		metric.AddQuantile(quantile)
+	}
+	return metric
+}
+
+func TestBuildSummaryTargets(t *testing.T) {
+	cfg := config.New()
+	builder := NewBuilder(cfg)
+
+	// One target per quantile, then rate and average
+	t.Run("Quantile targets", func(t *testing.T) {
+		metric := newSummary("rpc_duration_seconds", map[string]bool{"quantile": true, "service": true}, "0.99", "0.5", "0.9")
+		targets := builder.BuildTargets(metric)
+
+		expected := []Target{
+			{
+				Expr:         "rpc_duration_seconds{quantile=\"0.5\"}",
+				LegendFormat: "p50 service:[{{service}}]",
+				RefID:        "A",
+				Format:       "time_series",
+			},
+			{
+				Expr:         "rpc_duration_seconds{quantile=\"0.9\"}",
+				LegendFormat: "p90 service:[{{service}}]",
+				RefID:        "B",
+				Format:       "time_series",
+			},
+			{
+				Expr:         "rpc_duration_seconds{quantile=\"0.99\"}",
+				LegendFormat: "p99 service:[{{service}}]",
+				RefID:        "C",
+				Format:       "time_series",
+			},
+			{
+				Expr:         "sum by (service) (rate(rpc_duration_seconds_count[5m]))",
+				LegendFormat: "rate service:[{{service}}]",
+				RefID:        "D",
+				Format:       "time_series",
+			},
+			{
+				Expr:         "sum by (service) (rate(rpc_duration_seconds_sum[5m])) / sum by (service) (rate(rpc_duration_seconds_count[5m]))",
+				LegendFormat: "avg service:[{{service}}]",
+				RefID:        "E",
+				Format:       "time_series",
+			},
+		}
+
+		if len(targets) != len(expected) {
+			t.Fatalf("Expected %d targets, got %d", len(expected), len(targets))
+		}
+		for i := range expected {
+			if targets[i] != expected[i] {
+				t.Errorf("Target %d = %+v; want %+v", i, targets[i], expected[i])
+			}
+		}
+	})
+
+	// Summaries exposing only _sum and _count still get rate and average
+	t.Run("Without quantiles", func(t *testing.T) {
+		metric := newSummary("rpc_duration_seconds", nil)
+		targets := builder.BuildTargets(metric)
+
+		if len(targets) != 2 {
+			t.Fatalf("Expected 2 targets, got %d", len(targets))
+		}
+		if targets[0].LegendFormat != "rate" || targets[1].LegendFormat != "avg" {
+			t.Errorf("Expected rate and avg targets, got %q and %q", targets[0].LegendFormat, targets[1].LegendFormat)
+		}
+	})
+
+	// Quantile matchers are combined with the template variable matchers
+	t.Run("With template variables", func(t *testing.T) {
+		cfg := config.New()
+		cfg.Templating = &config.TemplatingConfig{Enabled: true}
+		builder := NewBuilder(cfg)
+
+		metric := newSummary("rpc_duration_seconds", map[string]bool{"quantile": true}, "0.5")
+		query := builder.BuildQuery(metric)
+
+		expected := "rpc_duration_seconds{quantile=\"0.5\",job=~\"$job\",instance=~\"$instance\"}"
+		if query != expected {
+			t.Errorf("Expected query %q, got %q", expected, query)
+		}
+	})
+
+	// A custom summary expression keeps the single templated target
+	t.Run("Custom expression", func(t *testing.T) {
+		cfg := config.New()
+		cfg.SummaryExprTmpl = "max(:METRIC:)"
+		builder := NewBuilder(cfg)
+
+		metric := newSummary("rpc_duration_seconds", map[string]bool{"quantile": true}, "0.5", "0.9")
+		targets := builder.BuildTargets(metric)
+
+		if len(targets) != 1 {
+			t.Fatalf("Expected 1 target, got %d", len(targets))
+		}
+		if targets[0].Expr != "max(rpc_duration_seconds)" {
+			t.Errorf("Expected query %q, got %q", "max(rpc_duration_seconds)", targets[0].Expr)
+		}
+	})
+}
+
+func TestSummaryQuantileLabel(t *testing.T) {
+	tests := map[string]string{"0": "p0", "0.5": "p50", "0.999": "p99.9", "1": "p100", "max": "qmax"}
+	for quantile, expected := range tests {
+		if label := summaryQuantileLabel(quantile); label != expected {
+			t.Errorf("summaryQuantileLabel(%q) = %q; want %q", quantile, label, expected)
+		}
+	}
+}